@@ -48,6 +48,78 @@ type V3ioConfig struct {
 	MaxBehind int `json:"maxBehind"`
 	// Override last chunk (by default on restart it will append from the last point if possible)
 	OverrideOld bool `json:"overrideOld"`
+	// Maintain the label index table (label=value to series), used by queries to resolve label equality matchers
+	LabelIndex bool `json:"labelIndex,omitempty"`
+	// Include the unflushed samples of the appender (in this process) in queries, i.e. read your own writes
+	ReadYourWrites bool `json:"readYourWrites,omitempty"`
+	// Query limits (per Select), max series returned, samples decoded and bytes read (0 for no limit)
+	MaxQuerySeries  int   `json:"maxQuerySeries,omitempty"`
+	MaxQuerySamples int64 `json:"maxQuerySamples,omitempty"`
+	MaxQueryBytes   int64 `json:"maxQueryBytes,omitempty"`
+	// Number of pipelined decoders per query, decode and aggregate the next items while the current series is
+	// processed (0 to decode on the caller goroutine)
+	QryPipelineWorkers int `json:"qryPipelineWorkers,omitempty"`
+	// Query timeout in seconds, cancels the outstanding requests of the querier (0 for no timeout)
+	QryTimeoutSec int `json:"qryTimeoutSec,omitempty"`
+	// Mapping of the InfluxDB line protocol points to metrics (tsdbctl serve /write endpoint)
+	Influx *InfluxConfig `json:"influx,omitempty"`
+	// Mapping of the Graphite plaintext paths to metrics (tsdbctl serve --graphite listener)
+	Graphite *GraphiteConfig `json:"graphite,omitempty"`
+	// StatsD aggregation (tsdbctl serve --statsd listener)
+	Statsd *StatsdConfig `json:"statsd,omitempty"`
+	// Mapping of the OpenTelemetry metrics to series (tsdbctl serve /v1/metrics endpoint)
+	Otlp *OtlpConfig `json:"otlp,omitempty"`
+}
+
+type InfluxConfig struct {
+	// Prefix added to the metric names
+	Prefix string `json:"prefix,omitempty"`
+	// Separator between the measurement and the field in the metric name ("_" by default)
+	Separator string `json:"separator,omitempty"`
+	// Field which is stored under the measurement name alone ("value" by default)
+	ValueField string `json:"valueField,omitempty"`
+	// Store the field name in this label, the metric name is the measurement (instead of measurement + field)
+	FieldLabel string `json:"fieldLabel,omitempty"`
+	// Tags which are not stored as labels
+	DropTags []string `json:"dropTags,omitempty"`
+	// Tags stored under a different label name (tag to label)
+	RenameTags map[string]string `json:"renameTags,omitempty"`
+}
+
+type GraphiteConfig struct {
+	// Templates which map a dotted path to a metric name and labels, "[filter] template [label=value,...]"
+	// e.g. "servers.* .host.measurement*", the first template whose filter matches the path is used
+	Templates []string `json:"templates,omitempty"`
+	// Separator used to join the measurement parts of the path ("_" by default)
+	Separator string `json:"separator,omitempty"`
+}
+
+type StatsdConfig struct {
+	// Aggregation (flush) interval in seconds (10 by default)
+	FlushIntervalSec int `json:"flushIntervalSec,omitempty"`
+	// Timer quantiles (0.5, 0.9, 0.99 by default)
+	Quantiles []float64 `json:"quantiles,omitempty"`
+	// Prefix added to the metric names
+	Prefix string `json:"prefix,omitempty"`
+	// Flush intervals after which a counter or gauge that was not updated is dropped (60 by default), a dropped
+	// counter starts again from zero
+	ExpireIntervals int `json:"expireIntervals,omitempty"`
+}
+
+type OtlpConfig struct {
+	// Prefix added to the metric names
+	Prefix string `json:"prefix,omitempty"`
+	// Resource attributes stored as labels, "*" for all (service.name and service.instance.id by default)
+	ResourceAttributes []string `json:"resourceAttributes,omitempty"`
+	// Instrumentation scope attributes stored as labels, "*" for all (none by default)
+	ScopeAttributes []string `json:"scopeAttributes,omitempty"`
+	// Store the instrumentation scope name and version as the otel_scope_name and otel_scope_version labels
+	ScopeInfo bool `json:"scopeInfo,omitempty"`
+	// Attributes stored under a different label name, service.name as job and service.instance.id as instance
+	// by default
+	RenameAttributes map[string]string `json:"renameAttributes,omitempty"`
+	// Data point attributes which are not stored as labels
+	DropAttributes []string `json:"dropAttributes,omitempty"`
 }
 
 type DBPartConfig struct {
@@ -140,4 +212,44 @@ func InitDefaults(cfg *V3ioConfig) {
 			cfg.QryWorkers = 8
 		}
 	}
+
+	if cfg.Influx == nil {
+		cfg.Influx = &InfluxConfig{}
+	}
+	if cfg.Influx.Separator == "" {
+		cfg.Influx.Separator = "_"
+	}
+	if cfg.Influx.ValueField == "" {
+		cfg.Influx.ValueField = "value"
+	}
+
+	if cfg.Graphite == nil {
+		cfg.Graphite = &GraphiteConfig{}
+	}
+	if cfg.Graphite.Separator == "" {
+		cfg.Graphite.Separator = "_"
+	}
+
+	if cfg.Statsd == nil {
+		cfg.Statsd = &StatsdConfig{}
+	}
+	if cfg.Statsd.FlushIntervalSec == 0 {
+		cfg.Statsd.FlushIntervalSec = 10
+	}
+	if cfg.Statsd.Quantiles == nil {
+		cfg.Statsd.Quantiles = []float64{0.5, 0.9, 0.99}
+	}
+	if cfg.Statsd.ExpireIntervals == 0 {
+		cfg.Statsd.ExpireIntervals = 60
+	}
+
+	if cfg.Otlp == nil {
+		cfg.Otlp = &OtlpConfig{}
+	}
+	if cfg.Otlp.ResourceAttributes == nil {
+		cfg.Otlp.ResourceAttributes = []string{"service.name", "service.instance.id"}
+	}
+	if cfg.Otlp.RenameAttributes == nil {
+		cfg.Otlp.RenameAttributes = map[string]string{"service.name": "job", "service.instance.id": "instance"}
+	}
 }
@@ -22,6 +22,9 @@ package aggregate
 
 import (
 	"fmt"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
 	"strings"
 )
 
@@ -35,25 +38,62 @@ const (
 	aggrTypeMax   AggrType = 8
 	aggrTypeMin   AggrType = 16
 	aggrTypeLast  AggrType = 32
+	aggrTypeFirst AggrType = 64
+
+	// quantile sketch per bucket (stored in a string attribute per bucket, not in an array)
+	aggrTypeSketch AggrType = 128
 
 	// derived aggregators
 	aggrTypeAvg    AggrType = aggrTypeCount | aggrTypeSum
-	aggrTypeRate   AggrType = aggrTypeLast | 0x8000
 	aggrTypeStddev AggrType = aggrTypeCount | aggrTypeSum | aggrTypeSqr
 	aggrTypeStdvar AggrType = aggrTypeCount | aggrTypeSum | aggrTypeSqr | 0x8000
-	aggrTypeAll    AggrType = 0xffff
+	// the first value and the sketch are stored only when listed, the arrays of "*" are the same as in older DBs
+	aggrTypeAll AggrType = 0xffff &^ (aggrTypeFirst | aggrTypeSketch)
+
+	// counter functions (Prometheus style, per step), the high byte identifies the function
+	aggrTypeRate     AggrType = aggrTypeCount | aggrTypeFirst | aggrTypeLast | 0x8100
+	aggrTypeIrate    AggrType = aggrTypeLast | 0x8200 // uses the last 2 samples, calculated from raw chunks only
+	aggrTypeIncrease AggrType = aggrTypeCount | aggrTypeFirst | aggrTypeLast | 0x8400
+	aggrTypeDelta    AggrType = aggrTypeCount | aggrTypeFirst | aggrTypeLast | 0x8800
+	counterFuncMask  AggrType = 0x0f00
+
+	// quantile functions (estimated from the sketches), the bits 12-14 identify the quantile
+	aggrTypeP50    AggrType = aggrTypeCount | aggrTypeSketch | 0x9000
+	aggrTypeP75    AggrType = aggrTypeCount | aggrTypeSketch | 0xa000
+	aggrTypeP90    AggrType = aggrTypeCount | aggrTypeSketch | 0xb000
+	aggrTypeP95    AggrType = aggrTypeCount | aggrTypeSketch | 0xc000
+	aggrTypeP99    AggrType = aggrTypeCount | aggrTypeSketch | 0xd000
+	aggrTypeP999   AggrType = aggrTypeCount | aggrTypeSketch | 0xe000
+	storedAggrMask AggrType = 0xff
 )
 
-var rawAggregators = []AggrType{aggrTypeCount, aggrTypeSum, aggrTypeSqr, aggrTypeMax, aggrTypeMin, aggrTypeLast}
+var quantileFunctions = map[AggrType]float64{
+	aggrTypeP50: 0.5, aggrTypeP75: 0.75, aggrTypeP90: 0.9, aggrTypeP95: 0.95, aggrTypeP99: 0.99, aggrTypeP999: 0.999}
+
+// functions of merged aggregate sets which equal the function of all their samples, unlike count, first, last and
+// the counter functions which depend on the number (or order) of the merged sets
+var mergeableFunctions = map[AggrType]bool{
+	aggrTypeSum: true, aggrTypeSqr: true, aggrTypeMax: true, aggrTypeMin: true, aggrTypeAvg: true,
+	aggrTypeStddev: true, aggrTypeStdvar: true, aggrTypeP50: true, aggrTypeP75: true, aggrTypeP90: true,
+	aggrTypeP95: true, aggrTypeP99: true, aggrTypeP999: true}
+
+var rawAggregators = []AggrType{
+	aggrTypeCount, aggrTypeSum, aggrTypeSqr, aggrTypeMax, aggrTypeMin, aggrTypeLast, aggrTypeFirst}
+
+var counterFunctions = []AggrType{aggrTypeRate, aggrTypeIrate, aggrTypeIncrease, aggrTypeDelta}
 
 var aggrTypeString = map[string]AggrType{
 	"count": aggrTypeCount, "sum": aggrTypeSum, "sqr": aggrTypeSqr, "max": aggrTypeMax, "min": aggrTypeMin,
-	"last": aggrTypeLast, "avg": aggrTypeAvg, "rate": aggrTypeRate,
+	"last": aggrTypeLast, "first": aggrTypeFirst, "avg": aggrTypeAvg, "rate": aggrTypeRate, "irate": aggrTypeIrate,
+	"increase": aggrTypeIncrease, "delta": aggrTypeDelta, "p50": aggrTypeP50, "p75": aggrTypeP75, "p90": aggrTypeP90,
+	"p95": aggrTypeP95, "p99": aggrTypeP99, "p999": aggrTypeP999,
 	"stddev": aggrTypeStddev, "stdvar": aggrTypeStdvar, "*": aggrTypeAll}
 
 var aggrToString = map[AggrType]string{
 	aggrTypeCount: "count", aggrTypeSum: "sum", aggrTypeSqr: "sqr", aggrTypeMin: "min", aggrTypeMax: "max",
-	aggrTypeLast: "last", aggrTypeAvg: "avg", aggrTypeRate: "rate",
+	aggrTypeLast: "last", aggrTypeFirst: "first", aggrTypeAvg: "avg", aggrTypeRate: "rate", aggrTypeIrate: "irate",
+	aggrTypeIncrease: "increase", aggrTypeDelta: "delta", aggrTypeSketch: "sketch", aggrTypeP50: "p50",
+	aggrTypeP75: "p75", aggrTypeP90: "p90", aggrTypeP95: "p95", aggrTypeP99: "p99", aggrTypeP999: "p999",
 	aggrTypeStddev: "stddev", aggrTypeStdvar: "stdvar", aggrTypeAll: "*",
 }
 
@@ -73,6 +113,16 @@ func AggrsFromString(list string) (AggrType, error) {
 	return aggrList, nil
 }
 
+// return true if the function (or window function) of merged aggregate sets is the function of all their samples
+func IsMergeable(function string) bool {
+	if IsWindowFunction(function) {
+		wf, err := ParseWindowFunction(function, 1)
+		return err == nil && mergeableFunctions[wf.aggr]
+	}
+	aggr, err := AggrsFromString(function)
+	return err == nil && mergeableFunctions[aggr]
+}
+
 // create list of aggregator objects from aggregator mask
 func NewAggregatorList(aggrType AggrType) *AggregatorList {
 	list := AggregatorList{}
@@ -94,6 +144,12 @@ func NewAggregatorList(aggrType AggrType) *AggregatorList {
 	if (aggrType & aggrTypeLast) != 0 {
 		list = append(list, &LastAggregator{FloatAggregator{attr: "last"}, 0})
 	}
+	if (aggrType & aggrTypeFirst) != 0 {
+		list = append(list, &FirstAggregator{FloatAggregator{attr: "first", val: math.NaN()}, math.MaxInt64})
+	}
+	if (aggrType & aggrTypeSketch) != 0 {
+		list = append(list, NewSketchAggregator())
+	}
 	return &list
 }
 
@@ -102,7 +158,20 @@ type AggregatorList []Aggregator
 
 // append value to all aggregators
 func (a AggregatorList) Aggregate(t int64, val interface{}) {
+	if h, ok := val.(*chunkenc.Histogram); ok {
+		for _, aggr := range a {
+			if hAggr, ok := aggr.(*HistogramAggregator); ok {
+				hAggr.AggregateHistogram(t, h)
+			}
+		}
+		return
+	}
+
 	v := val.(float64)
+	// staleness markers are not samples
+	if utils.IsStaleNaN(v) {
+		return
+	}
 	for _, aggr := range a {
 		aggr.Aggregate(t, v)
 	}
@@ -142,6 +211,35 @@ func (a AggregatorList) InitExpr(col string, buckets int) string {
 	return expr
 }
 
+// the attributes holding the stored state of a bucket which the aggregators merge into (the bucket sketch)
+func (a AggregatorList) StateAttrs(col string, bucket int) []string {
+	names := []string{}
+	for _, aggr := range a {
+		if _, ok := aggr.(*SketchAggregator); ok {
+			names = append(names, SketchAttrName(col, bucket))
+		}
+	}
+	return names
+}
+
+// restore the stored state of a bucket (read from the StateAttrs), so the following updates don't overwrite it
+func (a AggregatorList) RestoreState(col string, bucket int, attrs map[string]interface{}) error {
+	for _, aggr := range a {
+		if sk, ok := aggr.(*SketchAggregator); ok {
+			str, ok := attrs[SketchAttrName(col, bucket)].(string)
+			if !ok {
+				continue
+			}
+			s, err := DecodeSketch(str)
+			if err != nil {
+				return err
+			}
+			sk.Restore(bucket, s)
+		}
+	}
+	return nil
+}
+
 // clear all aggregators
 func (a AggregatorList) Clear() {
 	for _, aggr := range a {
@@ -2,6 +2,10 @@ package aggregate
 
 import (
 	"fmt"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -17,4 +21,242 @@ func TestAggragators(t *testing.T) {
 	aggrList.Aggregate(2, 3.3)
 	fmt.Println(aggrList.UpdateExpr("v", 1))
 	fmt.Println(aggrList.SetExpr("v", 1))
+
+	// the sketch is not part of "*", only stored when a quantile is listed
+	if aggr&aggrTypeSketch != 0 || strings.Contains(aggrList.SetExpr("v", 1), SketchAttrName("v", 1)) {
+		t.Fatalf("sketch included in %q", aggrString)
+	}
+
+	// DBs created with "*" before the first aggregator have no first array, the update expression must not use it
+	// and the counter functions are calculated from the raw chunks
+	if aggr&aggrTypeFirst != 0 || strings.Contains(aggrList.UpdateExpr("v", 1), "_v_first") {
+		t.Fatalf("first included in %q", aggrString)
+	}
+	rate, err := NewAggregateSeries("rate", "v", 10, 3600000, 3600000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.CanAggregate(aggr) {
+		t.Fatalf("rate aggregated from the arrays of %q", aggrString)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	bounds := []float64{1, 2, 4, math.Inf(1)}
+
+	prev := &chunkenc.Histogram{Bounds: bounds, Counts: []uint64{10, 20, 30, 30}, Count: 30}
+	cur := &chunkenc.Histogram{Bounds: bounds, Counts: []uint64{10, 60, 110, 110}, Count: 110}
+
+	aggrList := NewHistogramAggregatorList(len(bounds))
+	aggrList.Aggregate(1, prev)
+	aggrList.Aggregate(2, cur)
+	// the bucket, sum and count arrays hold the increase of the interval
+	expected := "_h_bkt[12]=0.000000;_h_bkt[13]=40.000000;_h_bkt[14]=80.000000;_h_bkt[15]=80.000000;" +
+		"_h_sum[3]=0.000000;_h_count[3]=80.000000;"
+	if expr := aggrList.SetExpr("h", 3); expr != expected {
+		t.Fatalf("wrong histogram set expression %q", expr)
+	}
+
+	hset := NewHistogramSet(bounds, 1, 0, 10)
+	hset.AppendHistogram(1, prev)
+	hset.AppendHistogram(2, cur)
+
+	// increase is 0,40,80,80 -> median (rank 40) is the top of the 2nd bucket, p75 (rank 60) is in the middle of the 3rd
+	if q := hset.Quantile(0.5, 0); q != 2 {
+		t.Fatalf("bad median %f", q)
+	}
+	if q := hset.Quantile(0.75, 0); q != 3 {
+		t.Fatalf("bad p75 %f", q)
+	}
+	if q := BucketQuantile(0.5, bounds, []float64{0, 0, 0, 0}); !math.IsNaN(q) {
+		t.Fatalf("expected NaN for no observations, got %f", q)
+	}
+}
+
+func TestAggregateSetMerge(t *testing.T) {
+	as, err := NewAggregateSeries("sum,min,max,avg", "v", 10, 10, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set1 := as.NewSetFromChunks(2, 0)
+	set1.AppendAllCells(0, 1, 3)
+	set1.AppendAllCells(1, 11, 5)
+	set2 := as.NewSetFromChunks(2, 0)
+	set2.AppendAllCells(0, 1, 1)
+	set2.AppendAllCells(0, 2, 9)
+
+	set1.Merge(set2)
+	if v := set1.GetCellValue(aggrTypeSum, 0); v != 13 {
+		t.Fatalf("wrong sum %f", v)
+	}
+	if v := set1.GetCellValue(aggrTypeAvg, 0); math.IsNaN(v) || math.Abs(v-13.0/3) > 1e-9 {
+		t.Fatalf("wrong avg %f", v)
+	}
+	if set1.GetCellValue(aggrTypeMin, 0) != 1 || set1.GetCellValue(aggrTypeMax, 0) != 9 {
+		t.Fatal("wrong min/max")
+	}
+	if set1.GetCellValue(aggrTypeMin, 1) != 5 || set1.GetCellValue(aggrTypeMax, 1) != 5 {
+		t.Fatal("wrong min/max after merging an empty cell")
+	}
+}
+
+func TestStaleMarkers(t *testing.T) {
+	as, err := NewAggregateSeries("count,last", "v", 10, 10, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := as.NewSetFromChunks(2, 0)
+	set.AppendAllCells(0, 1, 3)
+	set.AppendAllCells(0, 2, utils.StaleNaN)
+	set.AppendAllCells(1, 11, utils.StaleNaN)
+	if set.GetCellValue(aggrTypeCount, 0) != 1 || set.GetCellValue(aggrTypeLast, 0) != 3 || set.HasData(1) {
+		t.Fatal("staleness markers were aggregated")
+	}
+}
+
+func TestCanAggregate(t *testing.T) {
+	// irate is calculated from raw chunks only when it is requested, the bits of "*" include it (of a "*,first" DB)
+	for functions, exp := range map[string]bool{"*": true, "rate,avg": true, "irate": false, "rate,irate": false} {
+		as, err := NewAggregateSeries(functions, "v", 10, 60000, 10000, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if can := as.CanAggregate(aggrTypeAll | aggrTypeFirst); can != exp {
+			t.Fatalf("%s: CanAggregate returned %v", functions, can)
+		}
+	}
+}
+
+func TestCounterFunctions(t *testing.T) {
+	as, err := NewAggregateSeries("rate,irate,increase,delta", "v", 10, 60000, 10000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// samples every 10 sec in a 60 sec step, counter reset after 30 (40 -> 5)
+	set := as.NewSetFromChunks(1, 0)
+	for i, v := range []float64{0, 10, 20, 40, 5, 15} {
+		set.AppendAllCells(0, int64(i)*10000, v)
+	}
+
+	// increase is 40+15=55 over 50 sec, extrapolated to the step end (60 sec)
+	if v := set.GetCellValue(aggrTypeIncrease, 0); math.IsNaN(v) || math.Abs(v-66) > 1e-9 {
+		t.Fatalf("wrong increase %f", v)
+	}
+	if v := set.GetCellValue(aggrTypeRate, 0); math.IsNaN(v) || math.Abs(v-1.1) > 1e-9 {
+		t.Fatalf("wrong rate %f", v)
+	}
+	if v := set.GetCellValue(aggrTypeIrate, 0); v != 1 {
+		t.Fatalf("wrong irate %f", v)
+	}
+	if v := set.GetCellValue(aggrTypeDelta, 0); math.IsNaN(v) || math.Abs(v-18) > 1e-9 {
+		t.Fatalf("wrong delta %f", v)
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s1, s2 := NewSketch(), NewSketch()
+	for i := 1; i <= 1000; i++ {
+		s1.Add(int64(i), float64(i))
+		s2.Add(int64(i), float64(i+1000))
+	}
+
+	// merge a decoded sketch (as read from the DB)
+	decoded, err := DecodeSketch(s2.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1.Merge(decoded)
+
+	if s1.Count() != 2000 {
+		t.Fatalf("wrong count %d", s1.Count())
+	}
+	for q, exp := range map[float64]float64{0.5: 1000, 0.95: 1900, 0.99: 1980} {
+		if v := s1.Quantile(q); math.IsNaN(v) || math.Abs(v-exp)/exp > 0.02 {
+			t.Fatalf("wrong quantile %f: %f", q, v)
+		}
+	}
+
+	as, err := NewAggregateSeries("p50,p99", "v", 10, 10000, 10000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := as.NewSetFromChunks(1, 0)
+	set.MergeSketch(0, 0, s2.Encode())
+	set.MergeSketch(0, 2000, s2.Encode()) // sketch from another bucket time is ignored
+	if v := set.GetCellValue(aggrTypeP50, 0); math.IsNaN(v) || math.Abs(v-1500)/1500 > 0.02 {
+		t.Fatalf("wrong p50 %f", v)
+	}
+}
+
+func TestSketchRestore(t *testing.T) {
+	stored := NewSketch()
+	for i := 1; i <= 100; i++ {
+		stored.Add(int64(i), float64(i))
+	}
+
+	// a restarted appender reads the stored bucket sketch and merges the new values into it
+	aggrList := NewAggregatorList(aggrTypeP99)
+	attrs := map[string]interface{}{SketchAttrName("v", 3): stored.Encode()}
+	if err := aggrList.RestoreState("v", 3, attrs); err != nil {
+		t.Fatal(err)
+	}
+	aggrList.Aggregate(101, 101.0)
+
+	expected := NewSketch()
+	expected.Merge(stored)
+	expected.Add(101, 101)
+	if expr := aggrList.UpdateExpr("v", 3); !strings.Contains(expr, sketchExpr("v", 3, expected)) {
+		t.Fatalf("stored sketch was not merged: %s", expr)
+	}
+
+	if names := aggrList.StateAttrs("v", 3); len(names) != 1 || names[0] != SketchAttrName("v", 3) {
+		t.Fatalf("wrong state attributes %v", names)
+	}
+	if names := NewAggregatorList(aggrTypeAll).StateAttrs("v", 3); len(names) != 0 {
+		t.Fatalf("unexpected state attributes %v", names)
+	}
+}
+
+func TestWindowFunctions(t *testing.T) {
+	as, err := NewAggregateSeries("avg,min,max", "v", 10, 10, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// step averages 2, 4, (empty), 8
+	set := as.NewSetFromChunks(4, 0)
+	set.AppendAllCells(0, 1, 2)
+	set.AppendAllCells(1, 11, 3)
+	set.AppendAllCells(1, 12, 5)
+	set.AppendAllCells(3, 31, 8)
+
+	expected := map[string][]float64{
+		"moving_avg(2)":     {2, 10.0 / 3, 4, 8}, // weighted by the number of samples
+		"moving_sum(2)":     {2, 10, 8, 8},
+		"moving_max(3)":     {2, 5, 5, 8},
+		"min_over_time(1m)": {2, 2, 3, 8}, // 30 sec steps, 2 steps window
+		"ewma(0.5)":         {2, 3, 3, 5.5},
+	}
+
+	for name, exp := range expected {
+		wf, err := ParseWindowFunction(name, 30000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := wf.Values(set, 4)
+		for i, v := range values {
+			if math.IsNaN(v) || math.Abs(v-exp[i]) > 1e-9 {
+				t.Fatalf("%s: wrong value %v at %d, expected %v", name, v, i, exp)
+			}
+		}
+	}
+
+	for _, bad := range []string{"moving_avg(0)", "moving_p99(3)", "ewma(2)", "sum_over_time(x)"} {
+		if _, err := ParseWindowFunction(bad, 10); err == nil {
+			t.Fatalf("expected an error for %s", bad)
+		}
+	}
 }
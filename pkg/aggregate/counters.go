@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package aggregate
+
+import (
+	"math"
+)
+
+// counter state of a cell (step), holds the first, last and one before last samples and the counter reset correction
+type counterCell struct {
+	samples    int
+	firstT     int64
+	firstV     float64
+	lastT      int64
+	lastV      float64
+	prevT      int64
+	prevV      float64
+	correction float64 // sum of the counter values before every reset
+}
+
+// add a sample, samples must be added in time order
+func (c *counterCell) append(t int64, v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+
+	if c.samples == 0 {
+		c.firstT, c.firstV = t, v
+	} else {
+		if v < c.lastV {
+			c.correction += c.lastV
+		}
+		c.prevT, c.prevV = c.lastT, c.lastV
+	}
+	c.lastT, c.lastV = t, v
+	c.samples++
+}
+
+// return the counter function value for the cell time range [start, end)
+func (c *counterCell) value(aggr AggrType, start, end int64) float64 {
+	if c.samples < 2 {
+		return math.NaN()
+	}
+
+	if aggr == aggrTypeIrate {
+		dt := float64(c.lastT-c.prevT) / 1000
+		if dt == 0 {
+			return math.NaN()
+		}
+		if c.lastV < c.prevV {
+			return c.lastV / dt
+		}
+		return (c.lastV - c.prevV) / dt
+	}
+
+	isCounter := aggr != aggrTypeDelta
+	return c.extrapolatedRate(start, end, isCounter, aggr == aggrTypeRate)
+}
+
+// same as Prometheus extrapolatedRate(), extrapolate the increase to the cell edges if the samples are close enough
+func (c *counterCell) extrapolatedRate(start, end int64, isCounter, isRate bool) float64 {
+	result := c.lastV - c.firstV
+	if isCounter {
+		result += c.correction
+	}
+
+	durationToStart := float64(c.firstT-start) / 1000
+	durationToEnd := float64(end-c.lastT) / 1000
+	sampledInterval := float64(c.lastT-c.firstT) / 1000
+	if sampledInterval <= 0 {
+		return math.NaN()
+	}
+	averageDurationBetweenSamples := sampledInterval / float64(c.samples-1)
+
+	// counters can't go below zero, don't extrapolate the start beyond the (estimated) zero point
+	if isCounter && result > 0 && c.firstV >= 0 {
+		durationToZero := sampledInterval * (c.firstV / result)
+		if durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	extrapolationThreshold := averageDurationBetweenSamples * 1.1
+	extrapolateToInterval := sampledInterval
+
+	if durationToStart < extrapolationThreshold {
+		extrapolateToInterval += durationToStart
+	} else {
+		extrapolateToInterval += averageDurationBetweenSamples / 2
+	}
+	if durationToEnd < extrapolationThreshold {
+		extrapolateToInterval += durationToEnd
+	} else {
+		extrapolateToInterval += averageDurationBetweenSamples / 2
+	}
+
+	result = result * (extrapolateToInterval / sampledInterval)
+	if isRate {
+		result = result / (float64(end-start) / 1000)
+	}
+	return result
+}
@@ -139,3 +139,28 @@ func (a *LastAggregator) Aggregate(t int64, v float64) {
 func (a *LastAggregator) UpdateExpr(col string, bucket int) string {
 	return fmt.Sprintf("_%s_%s[%d]=%f;", col, a.attr, bucket, a.val)
 }
+
+// First value Aggregator, the bucket keeps the first value written to it (used by counter functions)
+type FirstAggregator struct {
+	FloatAggregator
+	firstT int64
+}
+
+func (a *FirstAggregator) Clear() {
+	a.val = math.NaN()
+	a.firstT = math.MaxInt64
+}
+
+func (a *FirstAggregator) Aggregate(t int64, v float64) {
+	if t < a.firstT {
+		a.val = v
+		a.firstT = t
+	}
+}
+
+// the store writes the first sample of every bucket with SetExpr (a bucket after the bucket of _maxtime, which is
+// read back after a restart), the following updates carry later samples so the stored first value is kept, only
+// late samples (older than _maxtime) within the bucket are not considered
+func (a *FirstAggregator) UpdateExpr(col string, bucket int) string {
+	return ""
+}
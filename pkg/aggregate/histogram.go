@@ -0,0 +1,280 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package aggregate
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
+	"sort"
+)
+
+// Histogram aggregation arrays (per rollup bucket), all the histogram buckets are stored in one flat array
+// with numBuckets cells per rollup bucket, values are the counter increase during the rollup interval
+const (
+	HistBucketsAttr = "bkt"
+	HistSumAttr     = "sum"
+	HistCountAttr   = "count"
+)
+
+// create an aggregator list for histogram samples with numBuckets (le) buckets
+func NewHistogramAggregatorList(numBuckets int) *AggregatorList {
+	list := AggregatorList{&HistogramAggregator{numBuckets: numBuckets, incr: make([]float64, numBuckets)}}
+	return &list
+}
+
+// Histogram Aggregator, accumulates the counter increase of every bucket, sum and count
+type HistogramAggregator struct {
+	numBuckets int
+	incr       []float64
+	sum        float64
+	count      float64
+	prev       *chunkenc.Histogram
+}
+
+// float samples are ignored, histograms are aggregated using AggregateHistogram
+func (a *HistogramAggregator) Aggregate(t int64, v float64) {}
+
+func (a *HistogramAggregator) AggregateHistogram(t int64, h *chunkenc.Histogram) {
+	if a.prev != nil {
+		incr, sum, count := HistogramIncrease(a.prev, h)
+		for i := range a.incr {
+			a.incr[i] += incr[i]
+		}
+		a.sum += sum
+		a.count += count
+	}
+	a.prev = h
+}
+
+// clear the interval increase (the last histogram is kept to calculate the next increase)
+func (a *HistogramAggregator) Clear() {
+	for i := range a.incr {
+		a.incr[i] = 0
+	}
+	a.sum = 0
+	a.count = 0
+}
+
+func (a *HistogramAggregator) GetAttr() string { return HistBucketsAttr }
+
+func (a *HistogramAggregator) UpdateExpr(col string, bucket int) string {
+	expr := ""
+	for i, val := range a.incr {
+		if val != 0 {
+			idx := bucket*a.numBuckets + i
+			expr = expr + fmt.Sprintf("_%s_%s[%d]=_%s_%s[%d]+%f;", col, HistBucketsAttr, idx, col, HistBucketsAttr, idx, val)
+		}
+	}
+	expr = expr + fmt.Sprintf("_%s_%s[%d]=_%s_%s[%d]+%f;", col, HistSumAttr, bucket, col, HistSumAttr, bucket, a.sum)
+	return expr + fmt.Sprintf("_%s_%s[%d]=_%s_%s[%d]+%f;", col, HistCountAttr, bucket, col, HistCountAttr, bucket, a.count)
+}
+
+// set all the buckets, overwrite old values from the previous cycle
+func (a *HistogramAggregator) SetExpr(col string, bucket int) string {
+	expr := ""
+	for i, val := range a.incr {
+		expr = expr + fmt.Sprintf("_%s_%s[%d]=%f;", col, HistBucketsAttr, bucket*a.numBuckets+i, val)
+	}
+	expr = expr + fmt.Sprintf("_%s_%s[%d]=%f;", col, HistSumAttr, bucket, a.sum)
+	return expr + fmt.Sprintf("_%s_%s[%d]=%f;", col, HistCountAttr, bucket, a.count)
+}
+
+func (a *HistogramAggregator) InitExpr(col string, buckets int) string {
+	expr := fmt.Sprintf("_%s_%s=init_array(%d,'double');", col, HistBucketsAttr, buckets*a.numBuckets)
+	expr = expr + fmt.Sprintf("_%s_%s=init_array(%d,'double');", col, HistSumAttr, buckets)
+	return expr + fmt.Sprintf("_%s_%s=init_array(%d,'double');", col, HistCountAttr, buckets)
+}
+
+// return the counter increase per bucket, sum and count between two histogram samples, handle counter resets
+func HistogramIncrease(prev, cur *chunkenc.Histogram) ([]float64, float64, float64) {
+	incr := make([]float64, len(cur.Counts))
+	reset := cur.Count < prev.Count || len(prev.Counts) != len(cur.Counts)
+
+	for i, cnt := range cur.Counts {
+		if reset || cnt < prev.Counts[i] {
+			incr[i] = float64(cnt)
+		} else {
+			incr[i] = float64(cnt - prev.Counts[i])
+		}
+	}
+
+	if reset {
+		return incr, cur.Sum, float64(cur.Count)
+	}
+	return incr, cur.Sum - prev.Sum, float64(cur.Count - prev.Count)
+}
+
+// calculate the quantile from cumulative bucket counts, using linear interpolation within the bucket
+// (same as Prometheus histogram_quantile), the last bound must be +Inf
+func BucketQuantile(q float64, bounds []float64, counts []float64) float64 {
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(+1)
+	}
+
+	num := len(counts)
+	if num < 2 || len(bounds) != num || !math.IsInf(bounds[num-1], +1) {
+		return math.NaN()
+	}
+
+	// make sure the counts are monotonic (increases may be read from different samples)
+	cum := make([]float64, num)
+	max := math.Inf(-1)
+	for i, c := range counts {
+		if c > max {
+			max = c
+		}
+		cum[i] = max
+	}
+
+	observations := cum[num-1]
+	if observations == 0 {
+		return math.NaN()
+	}
+
+	rank := q * observations
+	b := sort.Search(num-1, func(i int) bool { return cum[i] >= rank })
+
+	if b == num-1 {
+		return bounds[num-2]
+	}
+	if b == 0 && bounds[0] <= 0 {
+		return bounds[0]
+	}
+
+	bucketStart := 0.0
+	bucketEnd := bounds[b]
+	count := cum[b]
+	if b > 0 {
+		bucketStart = bounds[b-1]
+		count -= cum[b-1]
+		rank -= cum[b-1]
+	}
+	return bucketStart + (bucketEnd-bucketStart)*(rank/count)
+}
+
+// histogram increase per query interval (step), built from raw chunks or the aggregation arrays
+type HistogramSet struct {
+	bounds   []float64
+	buckets  [][]float64
+	sum      []float64
+	count    []float64
+	length   int
+	maxCell  int
+	baseTime int64
+	interval int64
+	prev     *chunkenc.Histogram
+}
+
+func NewHistogramSet(bounds []float64, length int, baseTime, interval int64) *HistogramSet {
+	newSet := HistogramSet{bounds: bounds, length: length, baseTime: baseTime, interval: interval, maxCell: -1}
+	newSet.buckets = make([][]float64, length)
+	for i := range newSet.buckets {
+		newSet.buckets[i] = make([]float64, len(bounds))
+	}
+	newSet.sum = make([]float64, length)
+	newSet.count = make([]float64, length)
+	return &newSet
+}
+
+func (hs *HistogramSet) GetMaxCell() int {
+	return hs.maxCell
+}
+
+func (hs *HistogramSet) GetCellTime(index int) int64 {
+	return hs.baseTime + int64(index)*hs.interval
+}
+
+// add a raw histogram sample (samples must be time sorted), the increase from the previous sample is added to the cell
+func (hs *HistogramSet) AppendHistogram(t int64, h *chunkenc.Histogram) {
+	prev := hs.prev
+	hs.prev = h
+	if prev == nil || len(h.Counts) != len(hs.bounds) {
+		return
+	}
+
+	cell := int((t - hs.baseTime) / hs.interval)
+	if cell < 0 || cell >= hs.length {
+		return
+	}
+
+	incr, sum, count := HistogramIncrease(prev, h)
+	hs.addCell(cell, incr, sum, count)
+}
+
+// merge the histogram aggregation arrays (v3io blobs) of the rollup buckets between start and end,
+// mint is the start time of the first rollup bucket
+func (hs *HistogramSet) MergeArrays(attrs map[string]interface{}, col string, start, end, rollupBuckets int,
+	mint, rollupTime int64) error {
+
+	arrays := map[string][]uint64{}
+	for _, attr := range []string{HistBucketsAttr, HistSumAttr, HistCountAttr} {
+		name := "_" + col + "_" + attr
+		blob, ok := attrs[name]
+		if !ok || blob == nil {
+			return fmt.Errorf("Aggregation Attribute %s was not found", name)
+		}
+		arrays[attr] = utils.AsInt64Array(blob.([]byte))
+	}
+
+	numBuckets := len(hs.bounds)
+	arrayIndex := start
+	for i := 0; arrayIndex != end; i++ {
+		cell := int((mint + int64(i)*rollupTime - hs.baseTime) / hs.interval)
+		if cell >= 0 && cell < hs.length && arrayIndex < len(arrays[HistSumAttr]) &&
+			(arrayIndex+1)*numBuckets <= len(arrays[HistBucketsAttr]) {
+
+			incr := make([]float64, numBuckets)
+			for b := range incr {
+				incr[b] = math.Float64frombits(arrays[HistBucketsAttr][arrayIndex*numBuckets+b])
+			}
+			hs.addCell(cell, incr,
+				math.Float64frombits(arrays[HistSumAttr][arrayIndex]),
+				math.Float64frombits(arrays[HistCountAttr][arrayIndex]))
+		}
+		arrayIndex = (arrayIndex + 1) % rollupBuckets
+	}
+
+	return nil
+}
+
+func (hs *HistogramSet) addCell(cell int, incr []float64, sum, count float64) {
+	if cell > hs.maxCell {
+		hs.maxCell = cell
+	}
+	for i, v := range incr {
+		hs.buckets[cell][i] += v
+	}
+	hs.sum[cell] += sum
+	hs.count[cell] += count
+}
+
+// return the quantile of the observations in the cell
+func (hs *HistogramSet) Quantile(q float64, cell int) float64 {
+	if cell < 0 || cell > hs.maxCell {
+		return math.NaN()
+	}
+	return BucketQuantile(q, hs.bounds, hs.buckets[cell])
+}
@@ -64,10 +64,21 @@ func NewAggregateSeries(functions, col string, buckets int, interval, rollupTime
 
 func (as *AggregateSeries) CanAggregate(partitionAggr AggrType) bool {
 	// keep only real aggregators
-	aggrMask := 0x7f & as.aggrMask
+	aggrMask := storedAggrMask & as.aggrMask
 	// make sure the DB has all the aggregators we need (on bits in the mask)
 	// and that the aggregator resolution is greater/eq to requested interval
-	return ((aggrMask & partitionAggr) == aggrMask) && as.interval >= as.rollupTime
+	// irate needs the last 2 samples of every step so it is calculated from raw chunks
+	return ((aggrMask & partitionAggr) == aggrMask) && as.interval >= as.rollupTime && !as.hasFunction(aggrTypeIrate)
+}
+
+// is the function in the list of requested functions (the mask bits of "*" cover all functions)
+func (as *AggregateSeries) hasFunction(aggr AggrType) bool {
+	for _, f := range as.functions {
+		if f == aggr {
+			return true
+		}
+	}
+	return false
 }
 
 func (as *AggregateSeries) GetAggrMask() AggrType {
@@ -86,6 +97,16 @@ func (as *AggregateSeries) toAttrName(aggr AggrType) string {
 	return "_" + as.colName + "_" + aggr.String()
 }
 
+// does the query use the bucket sketches (quantile functions)
+func (as *AggregateSeries) HasSketch() bool {
+	return as.aggrMask&aggrTypeSketch != 0
+}
+
+// the sketch attribute name of a rollup bucket
+func (as *AggregateSeries) SketchAttrName(bucket int) string {
+	return SketchAttrName(as.colName, bucket)
+}
+
 func (as *AggregateSeries) GetAttrNames() []string {
 	names := []string{}
 
@@ -98,33 +119,38 @@ func (as *AggregateSeries) GetAttrNames() []string {
 	return names
 }
 
-// create new aggregation set from v3io aggregation array attributes
-func (as *AggregateSeries) NewSetFromAttrs(
-	length, start, end int, mint, maxt int64, attrs *map[string]interface{}) (*AggregateSet, error) {
-
+// decode the v3io aggregation array attributes of the series aggregates
+func (as *AggregateSeries) ArraysFromAttrs(attrs map[string]interface{}) (map[AggrType][]uint64, error) {
 	aggrArrays := map[AggrType][]uint64{}
-	dataArrays := map[AggrType][]float64{}
-
-	var maxAligned int64
-	if as.overlapWindows != nil {
-		length = len(as.overlapWindows)
-		maxAligned = (maxt / as.interval) * as.interval
-	}
-
 	for _, aggr := range rawAggregators {
 		if aggr&as.aggrMask != 0 {
-			attrBlob, ok := (*attrs)[as.toAttrName(aggr)]
-			if !ok {
+			attrBlob, ok := attrs[as.toAttrName(aggr)]
+			if !ok || attrBlob == nil {
 				return nil, fmt.Errorf("Aggregation Attribute %s was not found", as.toAttrName(aggr))
 			}
 			aggrArrays[aggr] = utils.AsInt64Array(attrBlob.([]byte))
-			dataArrays[aggr] = make([]float64, length, length)
 		}
 	}
+	return aggrArrays, nil
+}
+
+// create new aggregation set from v3io aggregation array attributes
+func (as *AggregateSeries) NewSetFromAttrs(
+	length, start, end int, mint, maxt int64, attrs *map[string]interface{}) (*AggregateSet, error) {
 
-	aggrSet := AggregateSet{length: length, interval: as.interval, overlapWin: as.overlapWindows}
-	aggrSet.dataArrays = dataArrays
+	aggrArrays, err := as.ArraysFromAttrs(*attrs)
+	if err != nil {
+		return nil, err
+	}
 
+	var maxAligned int64
+	baseTime := mint
+	if as.overlapWindows != nil {
+		maxAligned = (maxt / as.interval) * as.interval
+		baseTime = maxAligned
+	}
+
+	aggrSet := as.NewSetFromChunks(length, baseTime)
 	arrayIndex := start
 	i := 0
 
@@ -134,8 +160,9 @@ func (as *AggregateSeries) NewSetFromAttrs(
 
 			// standard aggregates (evenly spaced intervals)
 			cellIndex := int((int64(i) * as.rollupTime) / as.interval)
-			for aggr, array := range aggrArrays {
-				aggrSet.mergeArrayCell(aggr, cellIndex, array[arrayIndex])
+			aggrSet.MergeArrays(aggrArrays, arrayIndex, cellIndex, mint+int64(i)*as.rollupTime)
+			if as.HasSketch() {
+				aggrSet.MergeSketch(cellIndex, mint+int64(i)*as.rollupTime, (*attrs)[as.SketchAttrName(arrayIndex)])
 			}
 		} else {
 
@@ -144,8 +171,9 @@ func (as *AggregateSeries) NewSetFromAttrs(
 			if t < maxAligned {
 				for i, win := range as.overlapWindows {
 					if t > maxAligned-int64(win)*as.interval {
-						for aggr, array := range aggrArrays {
-							aggrSet.mergeArrayCell(aggr, i, array[arrayIndex])
+						aggrSet.MergeArrays(aggrArrays, arrayIndex, i, t)
+						if as.HasSketch() {
+							aggrSet.MergeSketch(i, t, (*attrs)[as.SketchAttrName(arrayIndex)])
 						}
 					}
 				}
@@ -157,26 +185,40 @@ func (as *AggregateSeries) NewSetFromAttrs(
 		arrayIndex = (arrayIndex + 1) % as.buckets
 	}
 
-	return &aggrSet, nil
+	return aggrSet, nil
 }
 
-// prepare new aggregation set from v3io raw chunk attributes (in case there are no aggregation arrays)
-func (as *AggregateSeries) NewSetFromChunks(length int) *AggregateSet {
+// prepare new aggregation set from v3io raw chunk attributes (in case there are no aggregation arrays),
+// baseTime is the time of the first cell (or the end time of the overlapping windows)
+func (as *AggregateSeries) NewSetFromChunks(length int, baseTime int64) *AggregateSet {
 
 	if as.overlapWindows != nil {
 		length = len(as.overlapWindows)
 	}
 
-	newAggregateSet := AggregateSet{length: length, interval: as.interval, overlapWin: as.overlapWindows}
+	newAggregateSet := AggregateSet{length: length, interval: as.interval, overlapWin: as.overlapWindows,
+		baseTime: baseTime, rollupTime: as.rollupTime}
 	dataArrays := map[AggrType][]float64{}
 
 	for _, aggr := range rawAggregators {
 		if aggr&as.aggrMask != 0 {
 			dataArrays[aggr] = make([]float64, length, length) // TODO: len/capacity & reuse (pool)
+			if aggr == aggrTypeMin || aggr == aggrTypeMax || aggr == aggrTypeFirst {
+				for i := range dataArrays[aggr] {
+					dataArrays[aggr][i] = math.NaN()
+				}
+			}
 		}
 	}
 
 	newAggregateSet.dataArrays = dataArrays
+	newAggregateSet.used = make([]bool, length)
+	if as.aggrMask&counterFuncMask != 0 {
+		newAggregateSet.counters = make([]counterCell, length)
+	}
+	if as.HasSketch() {
+		newAggregateSet.sketches = make([]*Sketch, length)
+	}
 	return &newAggregateSet
 
 }
@@ -187,40 +229,128 @@ type AggregateSet struct {
 	maxCell    int
 	baseTime   int64
 	interval   int64
+	rollupTime int64
 	overlapWin []int
+
+	counters       []counterCell          // per cell counter state (for rate, irate, increase, delta)
+	mergedCounters map[AggrType][]float64 // counter function results of multiple merged series
+	sketches       []*Sketch              // per cell quantile sketch (for p50, p95, ..)
+	used           []bool                 // cells with data
+	bounds         []int64                // cell boundaries of calendar steps, cell i is [bounds[i], bounds[i+1])
 }
 
 func (as *AggregateSet) GetMaxCell() int {
 	return as.maxCell
 }
 
-// append the value to a cell in all relevant aggregation arrays
-func (as *AggregateSet) AppendAllCells(cell int, val float64) {
+func (as *AggregateSet) GetLength() int {
+	return as.length
+}
+
+// set variable length cells (calendar steps), cell i covers [bounds[i], bounds[i+1])
+func (as *AggregateSet) SetCellBounds(bounds []int64) {
+	as.bounds = bounds
+}
+
+func (as *AggregateSet) GetCellBounds() []int64 {
+	return as.bounds
+}
+
+// return true if any sample or aggregate was added to the cell
+func (as *AggregateSet) HasData(cell int) bool {
+	return cell >= 0 && cell < len(as.used) && as.used[cell]
+}
+
+// append the value (sampled at time t) to a cell in all relevant aggregation arrays
+func (as *AggregateSet) AppendAllCells(cell int, t int64, val float64) {
 
-	if cell >= as.length {
+	if cell < 0 || cell >= as.length || utils.IsStaleNaN(val) {
 		return
 	}
 
 	if cell > as.maxCell {
 		as.maxCell = cell
 	}
+	as.used[cell] = true
 
 	for aggr, _ := range as.dataArrays {
 		as.updateCell(aggr, cell, val)
 	}
+
+	if as.counters != nil {
+		as.counters[cell].append(t, val)
+	}
+
+	if as.sketches != nil {
+		as.cellSketch(cell).Add(t, val)
+	}
+}
+
+func (as *AggregateSet) cellSketch(cell int) *Sketch {
+	if as.sketches[cell] == nil {
+		as.sketches[cell] = NewSketch()
+	}
+	return as.sketches[cell]
+}
+
+// merge a stored bucket sketch (string attribute) into a cell, sketches which were not written during the bucket
+// time (left from the previous cycle of the cyclic buckets) are ignored
+func (as *AggregateSet) MergeSketch(cell int, bucketTime int64, attr interface{}) {
+	str, ok := attr.(string)
+	if !ok || as.sketches == nil || cell < 0 || cell >= as.length {
+		return
+	}
+
+	sketch, err := DecodeSketch(str)
+	if err != nil || sketch.maxT < bucketTime || sketch.maxT >= bucketTime+as.rollupTime {
+		return
+	}
+
+	if cell > as.maxCell {
+		as.maxCell = cell
+	}
+	as.used[cell] = true
+	as.cellSketch(cell).Merge(sketch)
+}
+
+// merge one rollup bucket (arrayIndex, starting at bucketTime) of the v3io aggregation arrays into a cell,
+// empty buckets are skipped so the (zero) min/max of an empty bucket is ignored
+func (as *AggregateSet) MergeArrays(arrays map[AggrType][]uint64, arrayIndex, cell int, bucketTime int64) {
+	cnt, hasCount := arrays[aggrTypeCount]
+	if hasCount && arrayIndex < len(cnt) && cnt[arrayIndex] == 0 {
+		return
+	}
+
+	for aggr, array := range arrays {
+		if arrayIndex < len(array) {
+			as.mergeArrayCell(aggr, cell, array[arrayIndex])
+		}
+	}
+
+	// the bucket first & last values are used as samples at the bucket start & end by the counter functions
+	if as.counters != nil && cell >= 0 && cell < as.length && hasCount && arrayIndex < len(cnt) {
+		first, last := arrays[aggrTypeFirst], arrays[aggrTypeLast]
+		if arrayIndex < len(first) && arrayIndex < len(last) {
+			as.counters[cell].append(bucketTime, math.Float64frombits(first[arrayIndex]))
+			if cnt[arrayIndex] > 1 {
+				as.counters[cell].append(bucketTime+as.rollupTime-1, math.Float64frombits(last[arrayIndex]))
+			}
+		}
+	}
 }
 
 // append/merge (v3io) aggregation values into aggregation per requested interval/step
 // if the requested step interval is higher than stored interval we need to collapse multiple cells to one
 func (as *AggregateSet) mergeArrayCell(aggr AggrType, cell int, val uint64) {
 
-	if cell >= as.length {
+	if cell < 0 || cell >= as.length {
 		return
 	}
 
 	if cell > as.maxCell {
 		as.maxCell = cell
 	}
+	as.used[cell] = true
 
 	if aggr == aggrTypeCount {
 		as.dataArrays[aggr][cell] += float64(val)
@@ -242,18 +372,125 @@ func (as *AggregateSet) updateCell(aggr AggrType, cell int, val float64) {
 	case aggrTypeSqr:
 		as.dataArrays[aggr][cell] += val * val
 	case aggrTypeMin:
-		if val < as.dataArrays[aggr][cell] {
+		if math.IsNaN(as.dataArrays[aggr][cell]) || val < as.dataArrays[aggr][cell] {
 			as.dataArrays[aggr][cell] = val
 		}
 	case aggrTypeMax:
-		if val > as.dataArrays[aggr][cell] {
+		if math.IsNaN(as.dataArrays[aggr][cell]) || val > as.dataArrays[aggr][cell] {
 			as.dataArrays[aggr][cell] = val
 		}
 	case aggrTypeLast:
 		as.dataArrays[aggr][cell] = val
+	case aggrTypeFirst:
+		if math.IsNaN(as.dataArrays[aggr][cell]) {
+			as.dataArrays[aggr][cell] = val
+		}
 	}
 }
 
+// merge the aggregates of another set (of the same series/query) into this set, used to aggregate across series
+// (count/sum/sqr/first/last and the counter functions are added, min/max keep the lower/higher value)
+func (as *AggregateSet) Merge(other *AggregateSet) {
+	for cell, sketch := range other.sketches {
+		if sketch != nil && cell < len(as.sketches) {
+			as.cellSketch(cell).Merge(sketch)
+		}
+	}
+
+	if as.counters != nil {
+		if as.mergedCounters == nil {
+			as.mergedCounters = as.counterValues()
+		}
+		for aggr, values := range other.counterValues() {
+			addValues(as.mergedCounters[aggr], values)
+		}
+	}
+
+	for aggr, array := range other.dataArrays {
+		dest, ok := as.dataArrays[aggr]
+		if !ok {
+			continue
+		}
+		for cell := 0; cell <= other.maxCell && cell < len(array) && cell < len(dest); cell++ {
+			val := array[cell]
+			switch aggr {
+			case aggrTypeMin:
+				if !math.IsNaN(val) && (math.IsNaN(dest[cell]) || val < dest[cell]) {
+					dest[cell] = val
+				}
+			case aggrTypeMax:
+				if !math.IsNaN(val) && (math.IsNaN(dest[cell]) || val > dest[cell]) {
+					dest[cell] = val
+				}
+			case aggrTypeFirst:
+				if math.IsNaN(dest[cell]) {
+					dest[cell] = val
+				} else if !math.IsNaN(val) {
+					dest[cell] += val
+				}
+			default:
+				dest[cell] += val
+			}
+		}
+	}
+
+	if other.maxCell > as.maxCell {
+		as.maxCell = other.maxCell
+	}
+	for cell, used := range other.used {
+		if used && cell < len(as.used) {
+			as.used[cell] = true
+		}
+	}
+}
+
+// return the counter functions values of all the cells
+func (as *AggregateSet) counterValues() map[AggrType][]float64 {
+	if as.mergedCounters != nil {
+		return as.mergedCounters
+	}
+
+	values := map[AggrType][]float64{}
+	for _, aggr := range counterFunctions {
+		values[aggr] = make([]float64, as.length)
+		for cell := range values[aggr] {
+			values[aggr][cell] = as.counterValue(aggr, cell)
+		}
+	}
+	return values
+}
+
+// add values to a list, NaN means no value
+func addValues(dest, values []float64) {
+	for i := 0; i < len(dest) && i < len(values); i++ {
+		if math.IsNaN(dest[i]) {
+			dest[i] = values[i]
+		} else if !math.IsNaN(values[i]) {
+			dest[i] += values[i]
+		}
+	}
+}
+
+// return the counter function value of a cell, the cell range is [cell time, cell time + interval) or [bound, next bound)
+// or [window start, base time) in overlapping windows
+func (as *AggregateSet) counterValue(aggr AggrType, cell int) float64 {
+	if as.mergedCounters != nil {
+		return as.mergedCounters[aggr][cell]
+	}
+	if as.counters == nil {
+		return math.NaN()
+	}
+
+	start := as.GetCellTime(as.baseTime, cell)
+	end := start + as.interval
+	if as.overlapWin != nil {
+		end = as.baseTime
+	} else if as.bounds != nil {
+		end = as.bounds[cell+1]
+	}
+	return as.counters[cell].value(aggr, start, end)
+}
+
 // return the value per aggregate or complex function
 func (as *AggregateSet) GetCellValue(aggr AggrType, cell int) float64 {
 
@@ -274,13 +511,13 @@ func (as *AggregateSet) GetCellValue(aggr AggrType, cell int) float64 {
 		sum := as.dataArrays[aggrTypeSum][cell]
 		sqr := as.dataArrays[aggrTypeSqr][cell]
 		return (cnt*sqr - sum*sum) / (cnt * (cnt - 1))
-	case aggrTypeRate:
-		if cell == 0 {
+	case aggrTypeRate, aggrTypeIrate, aggrTypeIncrease, aggrTypeDelta:
+		return as.counterValue(aggr, cell)
+	case aggrTypeP50, aggrTypeP75, aggrTypeP90, aggrTypeP95, aggrTypeP99, aggrTypeP999:
+		if as.sketches == nil || as.sketches[cell] == nil {
 			return math.NaN()
 		}
-		last := as.dataArrays[aggrTypeLast][cell-1]
-		this := as.dataArrays[aggrTypeLast][cell]
-		return (this - last) / float64(as.interval/1000) // clac rate per sec
+		return as.sketches[cell].Quantile(quantileFunctions[aggr])
 	default:
 		return as.dataArrays[aggr][cell]
 	}
@@ -289,6 +526,9 @@ func (as *AggregateSet) GetCellValue(aggr AggrType, cell int) float64 {
 
 // get the time per aggregate cell
 func (as *AggregateSet) GetCellTime(base int64, index int) int64 {
+	if as.bounds != nil && index < len(as.bounds) {
+		return as.bounds[index]
+	}
 	if as.overlapWin == nil {
 		return base + int64(index)*as.interval
 	}
@@ -303,4 +543,7 @@ func (as *AggregateSet) Clear() {
 	for aggr, _ := range as.dataArrays {
 		as.dataArrays[aggr] = as.dataArrays[aggr][:0]
 	}
+	as.counters = as.counters[:0]
+	as.mergedCounters = nil
+	as.sketches = as.sketches[:0]
 }
@@ -0,0 +1,298 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package aggregate
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DDSketch parameters, quantiles are accurate to 1% of the value, the number of bins is bounded
+// (the lowest bins are collapsed) so the stored sketch stays compact
+const (
+	sketchAlpha   = 0.01
+	sketchMaxBins = 1024
+	sketchMinVal  = 1e-9 // smaller (absolute) values are counted as zero
+	sketchVersion = 1
+)
+
+var sketchGamma = (1 + sketchAlpha) / (1 - sketchAlpha)
+var sketchLogGamma = math.Log(sketchGamma)
+
+// Sketch is a mergeable quantile sketch (DDSketch), bins hold the counts of values in [gamma^(i-1), gamma^i)
+type Sketch struct {
+	pos   map[int]uint64
+	neg   map[int]uint64
+	zero  uint64
+	count uint64
+	maxT  int64 // time of the latest sample, used to verify stored bucket sketches
+}
+
+func NewSketch() *Sketch {
+	return &Sketch{pos: map[int]uint64{}, neg: map[int]uint64{}}
+}
+
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// add a value sampled at time t
+func (s *Sketch) Add(t int64, v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+
+	switch {
+	case v > sketchMinVal:
+		s.pos[sketchIndex(v)]++
+		s.collapse(s.pos)
+	case v < -sketchMinVal:
+		s.neg[sketchIndex(-v)]++
+		s.collapse(s.neg)
+	default:
+		s.zero++
+	}
+
+	s.count++
+	if t > s.maxT {
+		s.maxT = t
+	}
+}
+
+// merge another sketch into this sketch
+func (s *Sketch) Merge(other *Sketch) {
+	for i, c := range other.pos {
+		s.pos[i] += c
+	}
+	for i, c := range other.neg {
+		s.neg[i] += c
+	}
+	s.collapse(s.pos)
+	s.collapse(s.neg)
+	s.zero += other.zero
+	s.count += other.count
+	if other.maxT > s.maxT {
+		s.maxT = other.maxT
+	}
+}
+
+// return the estimated value at quantile q (0 <= q <= 1)
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	var cum uint64
+
+	// negative values, from the lowest (largest absolute value) up
+	for _, i := range sortedKeys(s.neg, true) {
+		cum += s.neg[i]
+		if cum > rank {
+			return -sketchValue(i)
+		}
+	}
+
+	cum += s.zero
+	if cum > rank {
+		return 0
+	}
+
+	for _, i := range sortedKeys(s.pos, false) {
+		cum += s.pos[i]
+		if cum > rank {
+			return sketchValue(i)
+		}
+	}
+
+	return math.NaN()
+}
+
+// collapse the lowest bins if the sketch exceeds the max number of bins
+func (s *Sketch) collapse(bins map[int]uint64) {
+	if len(bins) <= sketchMaxBins {
+		return
+	}
+
+	keys := sortedKeys(bins, false)
+	target := keys[len(keys)-sketchMaxBins]
+	for _, i := range keys[:len(keys)-sketchMaxBins] {
+		bins[target] += bins[i]
+		delete(bins, i)
+	}
+}
+
+// the bin index of a (positive) value
+func sketchIndex(v float64) int {
+	return int(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+// the representative value of a bin (relative error <= alpha for all the values in the bin)
+func sketchValue(i int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1)
+}
+
+func sortedKeys(bins map[int]uint64, reverse bool) []int {
+	keys := make([]int, 0, len(bins))
+	for i := range bins {
+		keys = append(keys, i)
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
+
+// serialize the sketch to a base64 string (stored as a string attribute)
+func (s *Sketch) Encode() string {
+	b := []byte{sketchVersion}
+	var buf [binary.MaxVarintLen64]byte
+	putVarint := func(v int64) {
+		n := binary.PutVarint(buf[:], v)
+		b = append(b, buf[:n]...)
+	}
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		b = append(b, buf[:n]...)
+	}
+
+	putVarint(s.maxT)
+	putUvarint(s.zero)
+	for _, bins := range []map[int]uint64{s.pos, s.neg} {
+		putUvarint(uint64(len(bins)))
+		prev := 0
+		for _, i := range sortedKeys(bins, false) {
+			putVarint(int64(i - prev))
+			putUvarint(bins[i])
+			prev = i
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// deserialize a sketch encoded with Encode()
+func DecodeSketch(str string) (*Sketch, error) {
+	b, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 || b[0] != sketchVersion {
+		return nil, fmt.Errorf("unsupported sketch encoding")
+	}
+	b = b[1:]
+
+	var decodeErr error
+	varint := func() int64 {
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			decodeErr = fmt.Errorf("sketch is truncated")
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			decodeErr = fmt.Errorf("sketch is truncated")
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+
+	s := NewSketch()
+	s.maxT = varint()
+	s.zero = uvarint()
+	s.count = s.zero
+	for _, bins := range []map[int]uint64{s.pos, s.neg} {
+		num := uvarint()
+		prev := 0
+		for j := uint64(0); j < num && decodeErr == nil; j++ {
+			i := prev + int(varint())
+			c := uvarint()
+			bins[i] = c
+			s.count += c
+			prev = i
+		}
+	}
+
+	return s, decodeErr
+}
+
+// Sketch Aggregator, keeps a sketch per rollup bucket in a string attribute (_<col>_sk<bucket>), the bucket sketch
+// is kept between writes (Clear only drops the values added since the last write) and overwritten on every update,
+// the stored sketch of the current bucket is restored when the appender starts so it is merged and not replaced
+type SketchAggregator struct {
+	bucket       int
+	bucketSketch *Sketch
+	pending      *Sketch
+}
+
+func NewSketchAggregator() *SketchAggregator {
+	return &SketchAggregator{bucket: -1, pending: NewSketch()}
+}
+
+func (a *SketchAggregator) Aggregate(t int64, v float64) { a.pending.Add(t, v) }
+func (a *SketchAggregator) Clear()                       { a.pending = NewSketch() }
+func (a *SketchAggregator) GetAttr() string              { return "sketch" }
+
+// new bucket, start from the values added since the last write
+func (a *SketchAggregator) SetExpr(col string, bucket int) string {
+	a.bucket = bucket
+	a.bucketSketch = NewSketch()
+	a.bucketSketch.Merge(a.pending)
+	return sketchExpr(col, bucket, a.bucketSketch)
+}
+
+// same bucket, merge the new values into the bucket sketch and overwrite it
+func (a *SketchAggregator) UpdateExpr(col string, bucket int) string {
+	if bucket != a.bucket || a.bucketSketch == nil {
+		return a.SetExpr(col, bucket)
+	}
+	a.bucketSketch.Merge(a.pending)
+	return sketchExpr(col, bucket, a.bucketSketch)
+}
+
+// continue from a sketch stored in the DB (e.g. after an appender restart)
+func (a *SketchAggregator) Restore(bucket int, s *Sketch) {
+	a.bucket = bucket
+	a.bucketSketch = s
+}
+
+func (a *SketchAggregator) InitExpr(col string, buckets int) string {
+	return ""
+}
+
+func sketchExpr(col string, bucket int, s *Sketch) string {
+	return fmt.Sprintf("%s='%s';", SketchAttrName(col, bucket), s.Encode())
+}
+
+// the attribute name of the sketch of a rollup bucket
+func SketchAttrName(col string, bucket int) string {
+	return fmt.Sprintf("_%s_sk%d", col, bucket)
+}
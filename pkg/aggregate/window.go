@@ -0,0 +1,177 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package aggregate
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// aggregates which can be used in moving windows (moving_<aggr>(N), <aggr>_over_time(range))
+var windowAggregates = map[string]AggrType{
+	"avg": aggrTypeAvg, "sum": aggrTypeSum, "min": aggrTypeMin, "max": aggrTypeMax, "count": aggrTypeCount,
+	"last": aggrTypeLast}
+
+// WindowFunction is a sliding window function evaluated at every step over the step aggregates (cells) of the
+// last N steps: moving_avg/sum/min/max/count(N), <aggr>_over_time(range) (range is rounded up to whole steps)
+// or ewma(alpha), an exponentially weighted moving average of the step averages
+type WindowFunction struct {
+	name  string
+	aggr  AggrType // the step aggregate the window is calculated from
+	cells int      // window length in steps
+	alpha float64  // ewma smoothing factor
+}
+
+// return true if the function string is a window function, i.e. has a parameter
+func IsWindowFunction(function string) bool {
+	return strings.HasSuffix(function, ")")
+}
+
+// parse a window function, step is the query step (used to convert over_time ranges to steps)
+func ParseWindowFunction(function string, step int64) (*WindowFunction, error) {
+	open := strings.Index(function, "(")
+	if open < 0 || !strings.HasSuffix(function, ")") {
+		return nil, fmt.Errorf("invalid window function %s", function)
+	}
+	name, param := function[:open], function[open+1:len(function)-1]
+	wf := WindowFunction{name: function}
+
+	switch {
+	case name == "ewma":
+		alpha, err := strconv.ParseFloat(param, 64)
+		if err != nil || alpha <= 0 || alpha > 1 {
+			return nil, fmt.Errorf("invalid ewma factor %s, must be in (0, 1]", param)
+		}
+		wf.aggr, wf.alpha, wf.cells = aggrTypeAvg, alpha, 1
+
+	case strings.HasPrefix(name, "moving_"):
+		aggr, ok := windowAggregates[strings.TrimPrefix(name, "moving_")]
+		if !ok {
+			return nil, fmt.Errorf("invalid window function %s", function)
+		}
+		cells, err := strconv.Atoi(param)
+		if err != nil || cells < 1 {
+			return nil, fmt.Errorf("invalid number of steps %s in %s", param, function)
+		}
+		wf.aggr, wf.cells = aggr, cells
+
+	case strings.HasSuffix(name, "_over_time"):
+		aggr, ok := windowAggregates[strings.TrimSuffix(name, "_over_time")]
+		if !ok {
+			return nil, fmt.Errorf("invalid window function %s", function)
+		}
+		duration, err := utils.Str2duration(param)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid range %s in %s", param, function)
+		}
+		if step <= 0 {
+			return nil, fmt.Errorf("%s requires an aggregation step", function)
+		}
+		wf.aggr, wf.cells = aggr, int((duration+step-1)/step)
+
+	default:
+		return nil, fmt.Errorf("invalid window function %s", function)
+	}
+
+	return &wf, nil
+}
+
+func (wf *WindowFunction) String() string { return wf.name }
+
+// the name of the step aggregate the window is calculated from
+func (wf *WindowFunction) BaseFunction() string { return wf.aggr.String() }
+
+// number of steps before the first output step which are needed to fill the first window
+func (wf *WindowFunction) Lookback() int {
+	return wf.cells - 1
+}
+
+// calculate the window function of the cells [0, length), NaN for cells with an empty window
+func (wf *WindowFunction) Values(as *AggregateSet, length int) []float64 {
+	values := make([]float64, length)
+
+	if wf.alpha > 0 {
+		ewma := math.NaN()
+		for i := range values {
+			if as.HasData(i) && i <= as.maxCell {
+				v := as.dataArrays[aggrTypeSum][i] / as.dataArrays[aggrTypeCount][i]
+				if math.IsNaN(ewma) {
+					ewma = v
+				} else {
+					ewma = wf.alpha*v + (1-wf.alpha)*ewma
+				}
+			}
+			values[i] = ewma
+		}
+		return values
+	}
+
+	for i := range values {
+		values[i] = wf.window(as, i-wf.cells+1, i)
+	}
+	return values
+}
+
+// aggregate the cells [from, to]
+func (wf *WindowFunction) window(as *AggregateSet, from, to int) float64 {
+	result, count := math.NaN(), 0.0
+
+	for i := from; i <= to; i++ {
+		if !as.HasData(i) || i > as.maxCell {
+			continue
+		}
+
+		switch wf.aggr {
+		case aggrTypeAvg:
+			if math.IsNaN(result) {
+				result = 0
+			}
+			result += as.dataArrays[aggrTypeSum][i]
+			count += as.dataArrays[aggrTypeCount][i]
+		case aggrTypeSum, aggrTypeCount:
+			if math.IsNaN(result) {
+				result = 0
+			}
+			result += as.dataArrays[wf.aggr][i]
+		case aggrTypeMin:
+			if v := as.dataArrays[aggrTypeMin][i]; math.IsNaN(result) || v < result {
+				result = v
+			}
+		case aggrTypeMax:
+			if v := as.dataArrays[aggrTypeMax][i]; math.IsNaN(result) || v > result {
+				result = v
+			}
+		case aggrTypeLast:
+			result = as.dataArrays[aggrTypeLast][i]
+		}
+	}
+
+	if wf.aggr == aggrTypeAvg && count > 0 {
+		return result / count
+	}
+	if wf.aggr == aggrTypeAvg {
+		return math.NaN()
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package appender
+
+import (
+	"fmt"
+	"sort"
+)
+
+// HeadSeries holds a copy of the unflushed (queued, pending or being written) float samples of a series
+type HeadSeries struct {
+	Name   string
+	Key    string // series labels key (the _lset attribute), k1=v1,k2=v2,..
+	Item   string // series item name
+	Times  []int64
+	Values []float64 // sorted by time, a single (the last appended) sample per time
+}
+
+// return the unflushed samples in [mint, maxt] of all the float series, samples are tracked only with the
+// ReadYourWrites option
+func (mc *MetricsCache) HeadSeries(mint, maxt int64) []*HeadSeries {
+	if !mc.cfg.ReadYourWrites {
+		return nil
+	}
+
+	mc.mtx.RLock()
+	metrics := make([]*MetricState, 0, len(mc.cacheMetricMap))
+	for _, metric := range mc.cacheMetricMap {
+		metrics = append(metrics, metric)
+	}
+	mc.mtx.RUnlock()
+
+	list := []*HeadSeries{}
+	for _, metric := range metrics {
+		if series := metric.headSeries(mint, maxt); series != nil {
+			list = append(list, series)
+		}
+	}
+	return list
+}
+
+// copy the unflushed samples of the metric, from the oldest (being written) to the newest (queued)
+func (m *MetricState) headSeries(mint, maxt int64) *HeadSeries {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.store.isHistogram {
+		return nil
+	}
+	samples := pendingList{}
+	for _, list := range []pendingList{m.store.writing, m.store.pending, m.queued} {
+		for _, sample := range list {
+			if sample.t >= mint && sample.t <= maxt {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+
+	sort.Stable(samples)
+	series := &HeadSeries{Name: m.name, Key: m.key, Item: fmt.Sprintf("%s.%016x", m.name, m.hash)}
+	for i, sample := range samples {
+		if i < len(samples)-1 && samples[i+1].t == sample.t {
+			continue // keep the last appended sample
+		}
+		series.Times = append(series.Times, sample.t)
+		series.Values = append(series.Values, sample.v.(float64))
+	}
+	return series
+}
@@ -26,8 +26,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/v3io/v3io-go-http"
 	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
 	"github.com/v3io/v3io-tsdb/pkg/partmgr"
 	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
 	"sync"
 	"time"
 )
@@ -45,6 +47,11 @@ type MetricState struct {
 	err        error
 	retryCount uint8
 	newName    bool
+	queued     pendingList // samples in the append channel (tracked for read your writes)
+
+	indexUpdates int  // label index updates in flight
+	indexFailed  bool // a label index update failed, the index is updated again with the next write
+	indexRetries uint8
 }
 
 const MAX_WRITE_RETRY = 2
@@ -68,6 +75,7 @@ type MetricsCache struct {
 	responseChan    chan *v3io.Response
 	getRespChan     chan *v3io.Response
 	nameUpdateChan  chan *v3io.Response
+	indexUpdateChan chan *v3io.Response
 	asyncAppendChan chan *asyncAppend
 
 	lastMetric     uint64
@@ -86,6 +94,7 @@ func NewMetricsCache(container *v3io.Container, logger logger.Logger, cfg *confi
 	newCache.responseChan = make(chan *v3io.Response, CHAN_SIZE)
 	newCache.getRespChan = make(chan *v3io.Response, CHAN_SIZE)
 	newCache.nameUpdateChan = make(chan *v3io.Response, CHAN_SIZE)
+	newCache.indexUpdateChan = make(chan *v3io.Response, CHAN_SIZE)
 	newCache.asyncAppendChan = make(chan *asyncAppend, CHAN_SIZE)
 
 	newCache.NameLabelMap = map[string]bool{}
@@ -144,6 +153,7 @@ func (mc *MetricsCache) start() error {
 						// Set fields so next write will not include redundant info (bytes, lables, init_array)
 						metric.store.ProcessWriteResp()
 					} else {
+						metric.store.ProcessWriteError()
 						metric.retryCount++
 						if metric.retryCount == MAX_WRITE_RETRY {
 							metric.err = errors.Wrap(respErr, "chunk update failed")
@@ -178,11 +188,28 @@ func (mc *MetricsCache) start() error {
 
 				resp.Release()
 
+			case resp := <-mc.indexUpdateChan:
+				// Handle V3io label index updates
+
+				metric, ok := resp.Context.(*MetricState)
+				if ok {
+					metric.Lock()
+					if resp.Error != nil {
+						mc.logger.ErrorWith("Label index update failed", "id", resp.ID, "metric", metric.key,
+							"err", resp.Error)
+					}
+					metric.indexUpdateDone(resp.Error)
+					metric.Unlock()
+				}
+
+				resp.Release()
+
 			case app := <-mc.asyncAppendChan:
 				// Handle append requests (Add / AddFast)
 
 				metric := app.metric
 				metric.Lock()
+				metric.dequeue(app.t, app.v)
 
 				// if its the first Append we need to get the metric state from the DB
 				if metric.store.GetState() == storeStateInit {
@@ -194,6 +221,11 @@ func (mc *MetricsCache) start() error {
 
 				metric.store.Append(app.t, app.v)
 
+				// send the failed label index updates again
+				if metric.needsIndexUpdate() {
+					mc.updateLabelIndex(metric)
+				}
+
 				if metric.store.IsReady() {
 					// if there are no in flight requests, update the DB
 					err := metric.store.WriteChunks(mc, metric)
@@ -270,6 +302,52 @@ func (mc *MetricsCache) addMetric(hash uint64, name string, metric *MetricState)
 	}
 }
 
+// add the series to the label index items of its label pairs
+func (mc *MetricsCache) updateLabelIndex(metric *MetricState) {
+	attr := utils.LabelIndexAttr(metric.hash)
+	expr := fmt.Sprintf("%s='%s';", attr, metric.name)
+
+	if metric.indexFailed {
+		metric.indexFailed = false
+		metric.indexRetries++
+	}
+	for _, label := range utils.KeyLabels(metric.key) {
+		path := mc.cfg.Path + utils.LabelIndexPath + utils.LabelIndexKey(label.Name, label.Value)
+		input := v3io.UpdateItemInput{Path: path, Expression: &expr}
+
+		request, err := mc.container.UpdateItem(&input, metric, mc.indexUpdateChan)
+		if err != nil {
+			mc.logger.ErrorWith("Update label index failed", "metric", metric.key, "label", label.Name, "err", err)
+			metric.indexUpdateFailed(err)
+		} else {
+			metric.indexUpdates++
+			mc.logger.DebugWith("Update label index", "name", metric.name, "key", metric.key, "reqid", request.ID)
+		}
+	}
+}
+
+// a label index update response
+func (m *MetricState) indexUpdateDone(err error) {
+	m.indexUpdates--
+	if err != nil {
+		m.indexUpdateFailed(err)
+	}
+}
+
+// a series missing from the label index is not found by the queries, the failed updates are sent again with the
+// next write of the series, and fail its writes when they still fail after MAX_WRITE_RETRY retries
+func (m *MetricState) indexUpdateFailed(err error) {
+	m.indexFailed = true
+	if m.indexRetries >= MAX_WRITE_RETRY && m.err == nil {
+		m.err = errors.Wrap(err, "label index update failed")
+	}
+}
+
+// should the label index be updated again (after a failure, once the in flight updates are done)
+func (m *MetricState) needsIndexUpdate() bool {
+	return m.indexFailed && m.indexUpdates == 0 && m.err == nil
+}
+
 // return metric struct by refID
 func (mc *MetricsCache) getMetricByRef(ref uint64) (*MetricState, bool) {
 	mc.mtx.RLock()
@@ -279,11 +357,63 @@ func (mc *MetricsCache) getMetricByRef(ref uint64) (*MetricState, bool) {
 	return metric, ok
 }
 
+// make sure the sample type (float or histogram) match the metric, and histograms have the same buckets
+func (m *MetricState) checkSampleType(v interface{}) error {
+	h, isHistogram := v.(*chunkenc.Histogram)
+	if isHistogram != m.store.isHistogram {
+		return fmt.Errorf("metric %s sample type mismatch, cant mix float and histogram samples", m.name)
+	}
+	if !isHistogram {
+		return nil
+	}
+	if len(h.Counts) != len(h.Bounds) || len(h.Bounds) != len(m.store.histBounds.Bounds) {
+		return fmt.Errorf("metric %s histogram buckets dont match the first sample (%d buckets)",
+			m.name, len(m.store.histBounds.Bounds))
+	}
+	// the chunks and arrays are decoded with the bounds of the first sample (_hbounds)
+	for i, bound := range h.Bounds {
+		if bound != m.store.histBounds.Bounds[i] {
+			return fmt.Errorf("metric %s histogram bounds dont match the first sample (%s)",
+				m.name, m.store.histBounds.BoundsString())
+		}
+	}
+	return nil
+}
+
 // Push append to async channel
 func (mc *MetricsCache) appendTV(metric *MetricState, t int64, v interface{}) {
+	if _, ok := v.(float64); ok && mc.cfg.ReadYourWrites {
+		metric.Lock()
+		metric.queued = append(metric.queued, pendingData{t: t, v: v})
+		metric.Unlock()
+	}
 	mc.asyncAppendChan <- &asyncAppend{metric: metric, t: t, v: v}
 }
 
+// QueueFull returns true when the append queue is full and new appends would block
+func (mc *MetricsCache) QueueFull() bool {
+	return len(mc.asyncAppendChan) >= cap(mc.asyncAppendChan)
+}
+
+// remove a sample taken from the append channel from the queued samples, the channel is FIFO so it's normally
+// the first queued sample. values are compared by their bits since NaN (e.g. a staleness marker) != NaN
+func (m *MetricState) dequeue(t int64, v interface{}) {
+	f, ok := v.(float64)
+	if !ok {
+		return
+	}
+	for i, sample := range m.queued {
+		if qv, _ := sample.v.(float64); sample.t == t && math.Float64bits(qv) == math.Float64bits(f) {
+			if i == 0 {
+				m.queued = m.queued[1:]
+			} else {
+				m.queued = append(m.queued[:i], m.queued[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
 // First time add time & value to metric (by label set)
 func (mc *MetricsCache) Add(lset utils.LabelsIfc, t int64, v interface{}) (uint64, error) {
 
@@ -296,12 +426,21 @@ func (mc *MetricsCache) Add(lset utils.LabelsIfc, t int64, v interface{}) (uint6
 		if err != nil {
 			return 0, err
 		}
+		if err = metric.checkSampleType(v); err != nil {
+			return 0, err
+		}
 		mc.appendTV(metric, t, v)
 		return metric.refId, nil
 	}
 
 	metric = &MetricState{Lset: lset, key: key, name: name, hash: hash}
 	metric.store = NewChunkStore()
+	if h, ok := v.(*chunkenc.Histogram); ok {
+		if len(h.Counts) != len(h.Bounds) || len(h.Bounds) == 0 {
+			return 0, fmt.Errorf("histogram must have the same number of bounds and counts")
+		}
+		metric.store.initHistogram(h)
+	}
 	mc.addMetric(hash, name, metric)
 
 	// push new/next update
@@ -322,6 +461,9 @@ func (mc *MetricsCache) AddFast(ref uint64, t int64, v interface{}) error {
 	if err != nil {
 		return err
 	}
+	if err = metric.checkSampleType(v); err != nil {
+		return err
+	}
 	mc.appendTV(metric, t, v)
 	return nil
 
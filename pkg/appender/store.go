@@ -23,6 +23,7 @@ package appender
 import (
 	"encoding/base64"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/v3io/v3io-go-http"
 	"github.com/v3io/v3io-tsdb/pkg/aggregate"
 	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
@@ -52,6 +53,11 @@ type chunkStore struct {
 	maxTime       int64
 	initMaxTime   int64 // max time read from DB metric before first append
 	DelRawSamples bool  // TODO: for metrics w aggregates only
+
+	isHistogram bool                // store native histogram samples (all buckets in one chunk)
+	histBounds  *chunkenc.Histogram // bucket bounds of the histogram (from the first sample)
+	writing     pendingList         // samples of the update in flight (tracked for read your writes)
+	stateBucket int                 // aggregation bucket whose stored state (sketch) is read with the first GetItem
 }
 
 // Store states
@@ -69,6 +75,7 @@ const (
 type attrAppender struct {
 	state     chunkState
 	appender  chunkenc.Appender
+	histApp   chunkenc.HistogramAppender
 	partition *partmgr.DBPartition
 	chunkMint int64
 }
@@ -101,8 +108,20 @@ func (a *attrAppender) isAhead(t int64) bool {
 
 // Append a single t/v to a chunk
 // TODO: change appender from float to interface (allow map[str]interface cols)
-func (a *attrAppender) appendAttr(t int64, v interface{}) {
+func (a *attrAppender) appendAttr(t int64, v interface{}) error {
+	if h, ok := v.(*chunkenc.Histogram); ok {
+		return a.histApp.AppendHistogram(t, h)
+	}
 	a.appender.Append(t, v.(float64))
+	return nil
+}
+
+// return the chunk we append to
+func (a *attrAppender) chunk() chunkenc.Chunk {
+	if a.histApp != nil {
+		return a.histApp.Chunk()
+	}
+	return a.appender.Chunk()
 }
 
 // struct/list storing uncommitted samples, with time sorting support
@@ -117,6 +136,36 @@ func (l pendingList) Len() int           { return len(l) }
 func (l pendingList) Less(i, j int) bool { return l[i].t < l[j].t }
 func (l pendingList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
 
+// set the store to hold native histogram samples, the bucket bounds are taken from the first sample
+func (cs *chunkStore) initHistogram(h *chunkenc.Histogram) {
+	cs.isHistogram = true
+	cs.histBounds = &chunkenc.Histogram{Bounds: h.Bounds}
+}
+
+// return the column (chunk and aggregation attributes prefix) used by the store
+func (cs *chunkStore) col() string {
+	if cs.isHistogram {
+		return "h"
+	}
+	return "v"
+}
+
+// initialize a new chunk and appender based on the store sample type
+func (cs *chunkStore) newChunkAppender(chunk *attrAppender) error {
+	if cs.isHistogram {
+		chunk.appender = nil
+		chunk.histApp = chunkenc.NewHistogramChunk().HistogramAppender()
+		return nil
+	}
+
+	app, err := chunkenc.NewXORChunk().Appender()
+	if err != nil {
+		return err
+	}
+	chunk.appender = app
+	return nil
+}
+
 // store is ready to update samples into the DB
 func (cs *chunkStore) IsReady() bool {
 	return cs.state == storeStateReady
@@ -137,14 +186,24 @@ func (cs *chunkStore) GetChunksState(mc *MetricsCache, metric *MetricState, t in
 	// init chunk and create aggregation list object based on partition policy
 	part := mc.partitionMngr.TimeToPart(t)
 	cs.chunks[0].initialize(part, t)
-	cs.aggrList = aggregate.NewAggregatorList(part.AggrType())
+	if cs.isHistogram {
+		cs.aggrList = &aggregate.AggregatorList{}
+		if part.AggrBuckets() > 0 {
+			cs.aggrList = aggregate.NewHistogramAggregatorList(len(cs.histBounds.Bounds))
+		}
+	} else {
+		cs.aggrList = aggregate.NewAggregatorList(part.AggrType())
+	}
 
 	// TODO: if policy to merge w old chunks need to get prev chunk, vs restart appender
 
 	// issue DB GetItem command to load last state of metric
+	// and the stored state of the current aggregation bucket, which following updates should merge into
 	path := cs.GetMetricPath(metric, part.GetPath())
+	cs.stateBucket = part.Time2Bucket(t)
+	attrs := append([]string{"_maxtime"}, cs.aggrList.StateAttrs(cs.col(), cs.stateBucket)...)
 	getInput := v3io.GetItemInput{
-		Path: path, AttributeNames: []string{"_maxtime"}}
+		Path: path, AttributeNames: attrs}
 
 	request, err := mc.container.GetItem(&getInput, metric, mc.getRespChan)
 	if err != nil {
@@ -163,9 +222,10 @@ func (cs *chunkStore) GetChunksState(mc *MetricsCache, metric *MetricState, t in
 func (cs *chunkStore) ProcessGetResp(mc *MetricsCache, metric *MetricState, resp *v3io.Response) {
 
 	// TODO: init based on schema, use init function, recover old state vs append based on policy
-	chunk := chunkenc.NewXORChunk()
-	app, _ := chunk.Appender()
-	cs.chunks[0].appender = app
+	if err := cs.newChunkAppender(cs.chunks[0]); err != nil {
+		mc.logger.ErrorWith("Failed to create the chunk appender", "metric", metric.key, "err", err)
+		metric.err = errors.Wrap(err, "chunk appender init failed")
+	}
 	cs.chunks[0].state |= chunkStateFirst
 
 	cs.state = storeStateReady
@@ -185,6 +245,11 @@ func (cs *chunkStore) ProcessGetResp(mc *MetricsCache, metric *MetricState, resp
 			}
 		}
 
+		// a new series, add it to the label index
+		if mc.cfg.LabelIndex {
+			mc.updateLabelIndex(metric)
+		}
+
 		return
 	}
 
@@ -197,6 +262,10 @@ func (cs *chunkStore) ProcessGetResp(mc *MetricsCache, metric *MetricState, resp
 	}
 	mc.logger.DebugWith("Got Item", "name", metric.name, "key", metric.key, "maxt", maxTime)
 
+	if err := cs.aggrList.RestoreState(cs.col(), cs.stateBucket, item); err != nil {
+		mc.logger.ErrorWith("Failed to restore aggregation state", "metric", metric.key, "err", err)
+	}
+
 	if !mc.cfg.OverrideOld {
 		cs.maxTime = maxTime
 		cs.initMaxTime = maxTime
@@ -233,13 +302,11 @@ func (cs *chunkStore) chunkByTime(t int64) *attrAppender {
 		part := cur.partition
 		cur = cs.chunks[cs.curChunk^1]
 
-		chunk := chunkenc.NewXORChunk() // TODO: init based on schema, use init function
-		app, err := chunk.Appender()
+		err := cs.newChunkAppender(cur) // TODO: init based on schema, use init function
 		if err != nil {
 			return nil
 		}
 		cur.initialize(part.NextPart(t), t) // TODO: next part
-		cur.appender = app
 		cs.curChunk = cs.curChunk ^ 1
 
 		return cur
@@ -305,20 +372,24 @@ func (cs *chunkStore) WriteChunks(mc *MetricsCache, metric *MetricState) error {
 			}
 		}
 
-		// advance maximum time processed in metric
-		if t > cs.maxTime {
-			cs.maxTime = t
+		// add value to compressed raw value chunk and to the aggregators, a sample which cant be encoded is dropped
+		if err := activeChunk.appendAttr(t, cs.pending[i].v); err != nil {
+			mc.logger.ErrorWith("Failed to append a sample", "metric", metric.key, "t", t, "err", err)
+			metric.err = errors.Wrap(err, "sample append failed")
+		} else {
+			// advance maximum time processed in metric
+			if t > cs.maxTime {
+				cs.maxTime = t
+			}
+			cs.aggrList.Aggregate(t, cs.pending[i].v)
+			if mc.cfg.ReadYourWrites && !cs.isHistogram {
+				cs.writing = append(cs.writing, cs.pending[i])
+			}
 		}
 
-		// add value to aggregators
-		cs.aggrList.Aggregate(t, cs.pending[i].v)
-
-		// add value to compressed raw value chunk
-		activeChunk.appendAttr(t, cs.pending[i].v.(float64))
-
 		// if the last item or last item in the same partition add expressions and break
 		if (i == len(cs.pending)-1) || !partition.InRange(cs.pending[i+1].t) {
-			expr = expr + cs.aggrList.SetOrUpdateExpr("v", bucket, isNewBucket)
+			expr = expr + cs.aggrList.SetOrUpdateExpr(cs.col(), bucket, isNewBucket)
 			expr = expr + cs.appendExpression(activeChunk)
 			break
 		}
@@ -327,7 +398,7 @@ func (cs *chunkStore) WriteChunks(mc *MetricsCache, metric *MetricState) error {
 		nextT := cs.pending[i+1].t
 		nextBucket := partition.Time2Bucket(nextT)
 		if nextBucket != bucket {
-			expr = expr + cs.aggrList.SetOrUpdateExpr("v", bucket, isNewBucket)
+			expr = expr + cs.aggrList.SetOrUpdateExpr(cs.col(), bucket, isNewBucket)
 			cs.aggrList.Clear()
 			bucket = nextBucket
 			isNewBucket = true
@@ -357,7 +428,12 @@ func (cs *chunkStore) WriteChunks(mc *MetricsCache, metric *MetricState) error {
 		lblexpr := metric.Lset.GetExpr()
 
 		// init aggregate arrays
-		lblexpr = lblexpr + cs.aggrList.InitExpr("v", numBuckets)
+		lblexpr = lblexpr + cs.aggrList.InitExpr(cs.col(), numBuckets)
+
+		// histogram bucket bounds, used to decode the histogram chunks and arrays
+		if cs.isHistogram {
+			lblexpr = lblexpr + fmt.Sprintf("_hbounds='%s'; ", cs.histBounds.BoundsString())
+		}
 
 		expr = lblexpr + fmt.Sprintf("_lset='%s'; ", metric.key) + expr
 	}
@@ -386,24 +462,31 @@ func (cs *chunkStore) ProcessWriteResp() {
 		if chunk.state&chunkStateWriting != 0 {
 			chunk.state |= chunkStateCommitted
 			chunk.state &^= chunkStateWriting
-			chunk.appender.Chunk().Clear()
+			chunk.chunk().Clear()
 		}
 	}
 
+	cs.writing = cs.writing[:0]
 	cs.state = storeStateReady
 
 }
 
+// drop the samples of a failed update from the in flight samples, so the next update doesn't add to them and
+// queries don't return samples which were not stored
+func (cs *chunkStore) ProcessWriteError() {
+	cs.writing = cs.writing[:0]
+}
+
 // return the chunk update expression
 func (cs *chunkStore) appendExpression(chunk *attrAppender) string {
 
 	if chunk != nil {
-		bytes := chunk.appender.Chunk().Bytes()
+		bytes := chunk.chunk().Bytes()
 		chunk.state |= chunkStateWriting
 
 		expr := ""
 		idx := chunk.partition.TimeToChunkId(chunk.chunkMint) // TODO: add DaysPerObj from part manager
-		attr := chunk.partition.ChunkID2Attr("v", idx)
+		attr := chunk.partition.ChunkID2Attr(cs.col(), idx)
 
 		val := base64.StdEncoding.EncodeToString(bytes)
 
@@ -1,7 +1,13 @@
 package appender
 
 import (
+	"fmt"
+	"math"
+	"reflect"
 	"testing"
+
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
 )
 
 func TestStore(t *testing.T) {
@@ -18,3 +24,85 @@ func TestStore(t *testing.T) {
 	//println(exp, tid)
 	//store.ProcessWriteResp()
 }
+
+func TestHeadSeries(t *testing.T) {
+	metric := &MetricState{name: "cpu", key: "host=a", hash: 0xab, store: NewChunkStore()}
+	metric.store.writing = pendingList{{t: 10, v: 1.0}, {t: 20, v: 2.0}}
+	metric.store.pending = pendingList{{t: 30, v: 3.0}, {t: 20, v: 2.5}}
+	metric.queued = pendingList{{t: 40, v: 4.0}, {t: 5, v: 0.5}}
+
+	series := metric.headSeries(10, 35)
+	if series.Item != "cpu.00000000000000ab" {
+		t.Fatalf("wrong item name %s", series.Item)
+	}
+	if !reflect.DeepEqual(series.Times, []int64{10, 20, 30}) || !reflect.DeepEqual(series.Values, []float64{1, 2.5, 3}) {
+		t.Fatalf("wrong head samples %v %v", series.Times, series.Values)
+	}
+	if metric.headSeries(50, 60) != nil {
+		t.Fatal("expected no head samples out of the range")
+	}
+
+	metric.dequeue(40, 4.0)
+	if !reflect.DeepEqual(metric.queued, pendingList{{t: 5, v: 0.5}}) {
+		t.Fatalf("wrong queued samples after dequeue %v", metric.queued)
+	}
+
+	// NaN (a staleness marker) doesn't equal itself, it's matched by the value bits
+	metric.queued = pendingList{{t: 50, v: utils.StaleNaN}, {t: 60, v: 6.0}}
+	metric.dequeue(50, utils.StaleNaN)
+	if !reflect.DeepEqual(metric.queued, pendingList{{t: 60, v: 6.0}}) {
+		t.Fatalf("the NaN sample wasn't dequeued %v", metric.queued)
+	}
+}
+
+func TestCheckSampleType(t *testing.T) {
+	inf := math.Inf(1)
+	metric := &MetricState{name: "latency", store: NewChunkStore()}
+	metric.store.initHistogram(&chunkenc.Histogram{Bounds: []float64{0.1, 1, inf}, Counts: []uint64{1, 2, 3}})
+
+	for _, test := range []struct {
+		v     interface{}
+		valid bool
+	}{
+		{&chunkenc.Histogram{Bounds: []float64{0.1, 1, inf}, Counts: []uint64{2, 3, 4}}, true},
+		{&chunkenc.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{2, 3}}, false},
+		{&chunkenc.Histogram{Bounds: []float64{0.5, 1, inf}, Counts: []uint64{2, 3, 4}}, false},
+		{1.5, false},
+	} {
+		if err := metric.checkSampleType(test.v); (err == nil) != test.valid {
+			t.Fatalf("wrong check of %+v: %v", test.v, err)
+		}
+	}
+}
+
+func TestIndexUpdateRetry(t *testing.T) {
+	metric := &MetricState{name: "cpu", key: "host=a", store: NewChunkStore()}
+	resend := func() {
+		// what updateLabelIndex does for a series with 2 labels
+		if metric.indexFailed {
+			metric.indexFailed = false
+			metric.indexRetries++
+		}
+		metric.indexUpdates += 2
+	}
+
+	resend()
+	metric.indexUpdateDone(nil)
+	if metric.needsIndexUpdate() {
+		t.Fatal("index update resent while an update is in flight")
+	}
+	metric.indexUpdateDone(fmt.Errorf("timeout"))
+	if !metric.needsIndexUpdate() || metric.Err() != nil {
+		t.Fatalf("expected the failed index update to be resent, err %v", metric.Err())
+	}
+
+	// the series writes fail when the index update still fails after the retries
+	for i := 0; i < MAX_WRITE_RETRY; i++ {
+		resend()
+		metric.indexUpdateDone(fmt.Errorf("timeout"))
+		metric.indexUpdateDone(nil)
+	}
+	if metric.needsIndexUpdate() || metric.Err() == nil {
+		t.Fatal("expected a series error after the index update retries")
+	}
+}
@@ -42,14 +42,17 @@ func (e Encoding) String() string {
 		return "none"
 	case EncXOR:
 		return "XOR"
+	case EncHistogram:
+		return "histogram"
 	}
 	return "<unknown>"
 }
 
 // The different available chunk encodings.
 const (
-	EncNone Encoding = 0
-	EncXOR  Encoding = 1
+	EncNone      Encoding = 0
+	EncXOR       Encoding = 1
+	EncHistogram Encoding = 2
 )
 
 // Chunk holds a sequence of sample pairs that can be iterated over and appended to.
@@ -66,6 +69,8 @@ func FromData(e Encoding, d []byte, samples uint16) (Chunk, error) {
 	switch e {
 	case EncXOR:
 		return &XORChunk{b: &bstream{count: 0, stream: d}, samples: samples}, nil
+	case EncHistogram:
+		return &HistogramChunk{b: d, samples: samples}, nil
 	}
 	return nil, fmt.Errorf("unknown chunk encoding: %d", e)
 }
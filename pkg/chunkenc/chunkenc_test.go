@@ -25,6 +25,7 @@ import (
 	"testing"
 
 	"encoding/base64"
+	"math"
 	"math/rand"
 	"time"
 )
@@ -180,3 +181,61 @@ func RealSample(num int) []sample {
 	}
 	return samples
 }
+
+func TestHistogramChunk(tst *testing.T) {
+
+	bounds := []float64{0.1, 0.5, 1, math.Inf(1)}
+	samples := []Histogram{
+		{Bounds: bounds, Counts: []uint64{1, 3, 4, 5}, Count: 5, Sum: 2.5},
+		{Bounds: bounds, Counts: []uint64{2, 5, 7, 9}, Count: 9, Sum: 4.75},
+		{Bounds: bounds, Counts: []uint64{2, 6, 9, 12}, Count: 12, Sum: 6.5},
+		{Bounds: bounds, Counts: []uint64{0, 1, 1, 1}, Count: 1, Sum: 0.3}, // counter reset
+	}
+
+	// write the chunk in parts (like the appender does between DB updates) and concatenate the blobs
+	ch := NewHistogramChunk()
+	appender := ch.HistogramAppender()
+	byteArray := []byte{}
+	for i, h := range samples {
+		if err := appender.AppendHistogram(basetime+int64(i)*10000, &h); err != nil {
+			tst.Fatal(err)
+		}
+		byteArray = append(byteArray, ch.Bytes()...)
+		ch.Clear()
+	}
+
+	ch2, err := FromData(EncHistogram, byteArray, 0)
+	if err != nil {
+		tst.Fatal(err)
+	}
+
+	iter := ch2.(*HistogramChunk).HistogramIterator()
+	i := 0
+	for iter.Next() {
+		t, h := iter.At()
+		if t != basetime+int64(i)*10000 || h.Count != samples[i].Count || h.Sum != samples[i].Sum {
+			tst.Fatalf("sample %d mismatch t=%d h=%+v", i, t, h)
+		}
+		for b, cnt := range samples[i].Counts {
+			if h.Counts[b] != cnt {
+				tst.Fatalf("sample %d bucket %d mismatch %d != %d", i, b, h.Counts[b], cnt)
+			}
+		}
+		i++
+	}
+
+	if iter.Err() != nil {
+		tst.Fatal(iter.Err())
+	}
+	if i != len(samples) {
+		tst.Fatalf("got %d samples, expected %d", i, len(samples))
+	}
+
+	if str := samples[0].BoundsString(); str != "0.1,0.5,1,+Inf" {
+		tst.Fatalf("bad bounds string %s", str)
+	}
+	parsed, err := BoundsFromString("0.1,0.5,1,+Inf")
+	if err != nil || len(parsed) != 4 || !math.IsInf(parsed[3], 1) {
+		tst.Fatalf("bad bounds %v %v", parsed, err)
+	}
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package chunkenc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Histogram is a single histogram sample, all the buckets of one Prometheus histogram at a point in time.
+// Counts are cumulative (like the Prometheus le buckets), Bounds hold the matching upper bounds (last is +Inf)
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// return the bucket bounds as a comma separated string (stored in the metric item)
+func (h *Histogram) BoundsString() string {
+	list := make([]string, 0, len(h.Bounds))
+	for _, b := range h.Bounds {
+		list = append(list, strconv.FormatFloat(b, 'g', -1, 64))
+	}
+	return strings.Join(list, ",")
+}
+
+// convert comma separated bucket bounds (as stored in the metric item) to a float list
+func BoundsFromString(str string) ([]float64, error) {
+	if str == "" {
+		return nil, nil
+	}
+	bounds := []float64{}
+	for _, s := range strings.Split(str, ",") {
+		b, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram bound %s", s)
+		}
+		bounds = append(bounds, b)
+	}
+	return bounds, nil
+}
+
+// record types, every record is byte aligned so chunks can be appended to (blob + blob) like XOR chunks
+const (
+	histRecordFull  byte = 0xf1 // absolute time & values, starts a new run (new chunk or appender restart)
+	histRecordDelta byte = 0xf2 // time & values relative to the previous record
+)
+
+// HistogramChunk holds all the buckets of a histogram series, one item/attribute per chunk instead of
+// one series per bucket
+type HistogramChunk struct {
+	b       []byte
+	samples uint16
+}
+
+// NewHistogramChunk returns a new empty histogram chunk
+func NewHistogramChunk() *HistogramChunk {
+	return &HistogramChunk{b: make([]byte, 0, 256)}
+}
+
+// Encoding returns the encoding type.
+func (c *HistogramChunk) Encoding() Encoding {
+	return EncHistogram
+}
+
+// Bytes returns the underlying byte slice of the chunk (since the last Clear).
+func (c *HistogramChunk) Bytes() []byte {
+	return c.b
+}
+
+// Clear the written bytes, the appender state is kept so the next records are deltas
+func (c *HistogramChunk) Clear() {
+	c.b = c.b[:0]
+}
+
+// Appender is not supported for float samples, use HistogramAppender()
+func (c *HistogramChunk) Appender() (Appender, error) {
+	return nil, fmt.Errorf("histogram chunk does not accept float samples")
+}
+
+// Iterator over the total observation count of each histogram sample
+func (c *HistogramChunk) Iterator() Iterator {
+	return &histCountIterator{it: c.HistogramIterator()}
+}
+
+// HistogramAppender returns an appender of histogram samples
+func (c *HistogramChunk) HistogramAppender() HistogramAppender {
+	return &histAppender{c: c}
+}
+
+// HistogramIterator returns an iterator over the histogram samples
+func (c *HistogramChunk) HistogramIterator() HistogramIterator {
+	return &histIterator{b: c.b}
+}
+
+// HistogramAppender adds histogram samples to a chunk.
+type HistogramAppender interface {
+	AppendHistogram(t int64, h *Histogram) error
+	Chunk() Chunk
+}
+
+// HistogramIterator iterates over the histogram samples of a chunk
+type HistogramIterator interface {
+	At() (int64, *Histogram)
+	Err() error
+	Next() bool
+}
+
+type histAppender struct {
+	c    *HistogramChunk
+	t    int64
+	prev *Histogram
+}
+
+func (a *histAppender) Chunk() Chunk {
+	return a.c
+}
+
+func (a *histAppender) AppendHistogram(t int64, h *Histogram) error {
+	if len(h.Counts) != len(h.Bounds) {
+		return fmt.Errorf("histogram has %d counts and %d bounds", len(h.Counts), len(h.Bounds))
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	putVarint := func(v int64) {
+		n := binary.PutVarint(buf[:], v)
+		a.c.b = append(a.c.b, buf[:n]...)
+	}
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		a.c.b = append(a.c.b, buf[:n]...)
+	}
+
+	if a.prev == nil || len(a.prev.Counts) != len(h.Counts) {
+		a.c.b = append(a.c.b, histRecordFull)
+		putVarint(t)
+		putUvarint(uint64(len(h.Counts)))
+		putUvarint(math.Float64bits(h.Sum))
+		putUvarint(h.Count)
+		for _, cnt := range h.Counts {
+			putUvarint(cnt)
+		}
+	} else {
+		// counters are mostly growing slowly, store the delta from the previous sample (negative on reset)
+		a.c.b = append(a.c.b, histRecordDelta)
+		putVarint(t - a.t)
+		putUvarint(math.Float64bits(h.Sum) ^ math.Float64bits(a.prev.Sum))
+		putVarint(int64(h.Count - a.prev.Count))
+		for i, cnt := range h.Counts {
+			putVarint(int64(cnt - a.prev.Counts[i]))
+		}
+	}
+
+	a.t = t
+	a.prev = &Histogram{Counts: append([]uint64{}, h.Counts...), Count: h.Count, Sum: h.Sum}
+	a.c.samples++
+	return nil
+}
+
+type histIterator struct {
+	b   []byte
+	t   int64
+	h   *Histogram
+	err error
+}
+
+func (it *histIterator) At() (int64, *Histogram) {
+	return it.t, it.h
+}
+
+func (it *histIterator) Err() error {
+	return it.err
+}
+
+func (it *histIterator) Next() bool {
+	if it.err != nil || len(it.b) == 0 {
+		return false
+	}
+
+	record := it.b[0]
+	it.b = it.b[1:]
+
+	switch record {
+	case histRecordFull:
+		it.t = it.varint()
+		num := it.uvarint()
+		if it.err == nil && num > uint64(len(it.b)) {
+			it.err = fmt.Errorf("histogram chunk: invalid bucket number %d", num)
+		}
+		if it.err != nil {
+			return false
+		}
+		h := Histogram{Counts: make([]uint64, num)}
+		h.Sum = math.Float64frombits(it.uvarint())
+		h.Count = it.uvarint()
+		for i := range h.Counts {
+			h.Counts[i] = it.uvarint()
+		}
+		it.h = &h
+
+	case histRecordDelta:
+		if it.h == nil {
+			it.err = fmt.Errorf("histogram chunk: delta record without a base record")
+			return false
+		}
+		it.t += it.varint()
+		h := Histogram{Counts: make([]uint64, len(it.h.Counts))}
+		h.Sum = math.Float64frombits(it.uvarint() ^ math.Float64bits(it.h.Sum))
+		h.Count = it.h.Count + uint64(it.varint())
+		for i := range h.Counts {
+			h.Counts[i] = it.h.Counts[i] + uint64(it.varint())
+		}
+		it.h = &h
+
+	default:
+		it.err = fmt.Errorf("histogram chunk: unknown record type %x", record)
+		return false
+	}
+
+	return it.err == nil
+}
+
+func (it *histIterator) varint() int64 {
+	if it.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(it.b)
+	if n <= 0 {
+		it.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	it.b = it.b[n:]
+	return v
+}
+
+func (it *histIterator) uvarint() uint64 {
+	if it.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(it.b)
+	if n <= 0 {
+		it.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	it.b = it.b[n:]
+	return v
+}
+
+// float iterator over the histogram observation count (used by standard raw queries)
+type histCountIterator struct {
+	it HistogramIterator
+}
+
+func (it *histCountIterator) At() (int64, float64) {
+	t, h := it.it.At()
+	return t, float64(h.Count)
+}
+
+func (it *histCountIterator) Err() error { return it.it.Err() }
+func (it *histCountIterator) Next() bool { return it.it.Next() }
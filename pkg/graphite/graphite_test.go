@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package graphite
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParser(t *testing.T) {
+	p, err := NewParser(&config.GraphiteConfig{Separator: "_", Templates: []string{
+		"servers.* .host.measurement* dc=eu",
+		"stats.*.*.latency .service.region.measurement.",
+		"app.* .measurement.measurement.instance",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]string{
+		"servers.web01.cpu.load":         `{__name__="cpu_load", dc="eu", host="web01"}`,
+		"stats.api.us-east.latency.p99":  `{__name__="latency", region="us-east", service="api"}`,
+		"app.http.requests.a.extra":      `{__name__="http_requests", instance="a"}`,
+		"other.disk-used.1":              `{__name__="other_disk_used_1"}`,
+		"stats.api.us-east.errors.count": `{__name__="stats_api_us_east_errors_count"}`,
+	} {
+		lset, err := p.Labels(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lset.String() != expected {
+			t.Fatalf("wrong labels of %s: %s", path, lset)
+		}
+	}
+
+	lset, ts, v, err := p.ParseLine("servers.web01.cpu 0.5 1500000000.25", 1)
+	if err != nil || lset.Get("host") != "web01" || ts != 1500000000250 || v != 0.5 {
+		t.Fatalf("wrong line %s %d %f %v", lset, ts, v, err)
+	}
+	if _, ts, _, err = p.ParseLine("a.b 1 -1", 42); err != nil || ts != 42 {
+		t.Fatalf("wrong current time %d %v", ts, err)
+	}
+	for _, line := range []string{"a.b", "a.b x", "a.b 1 x", "a..b 1", "a.b 1 2 3"} {
+		if _, _, _, err := p.ParseLine(line, 0); err == nil {
+			t.Fatalf("expected an error for %q", line)
+		}
+	}
+
+	for _, tmpl := range []string{"a b c d", "measurement*.host", "a.b-c", "a.measurement x=1,y"} {
+		if _, err := NewParser(&config.GraphiteConfig{Templates: []string{tmpl}}); err == nil {
+			t.Fatalf("expected an error for template %q", tmpl)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &tsdbtest.Appender{}
+	server, err := NewServer(logger, app, &config.GraphiteConfig{Templates: []string{"servers.* .host.measurement*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "servers.web01.cpu.load 1.5 1500000000\ninvalid\r\nservers.web02.cpu.load 2 1500000010\n")
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(app.Samples()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(app.Samples()) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(app.Samples()))
+	}
+
+	if s := app.Samples()[1]; s.Lset.String() != `{__name__="cpu_load", host="web02"}` || s.T != 1500000010000 || s.V != 2 {
+		t.Fatalf("wrong sample %+v", s)
+	}
+}
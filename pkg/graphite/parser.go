@@ -0,0 +1,194 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// a path template, e.g. "servers.* .host.measurement* dc=eu": the filter selects the paths, each template part
+// names the path segment in the same position, measurement parts make the metric name (measurement* takes the
+// rest of the path), other names are labels and empty parts are skipped
+type template struct {
+	filter []string
+	parts  []string
+	labels utils.Labels
+}
+
+// Parser maps graphite plaintext lines to metrics using the configured templates
+type Parser struct {
+	templates []*template
+	separator string
+}
+
+func NewParser(cfg *config.GraphiteConfig) (*Parser, error) {
+	p := &Parser{separator: "_"}
+	if cfg == nil {
+		return p, nil
+	}
+	if cfg.Separator != "" {
+		p.separator = cfg.Separator
+	}
+
+	for _, str := range cfg.Templates {
+		t, err := parseTemplate(str)
+		if err != nil {
+			return nil, err
+		}
+		p.templates = append(p.templates, t)
+	}
+	return p, nil
+}
+
+func parseTemplate(str string) (*template, error) {
+	fields := strings.Fields(str)
+	t := &template{}
+	switch {
+	case len(fields) == 1:
+		t.parts = strings.Split(fields[0], ".")
+	case len(fields) == 2 && strings.Contains(fields[1], "="):
+		t.parts = strings.Split(fields[0], ".")
+		fields = fields[1:]
+	case len(fields) == 2 || len(fields) == 3:
+		t.filter = strings.Split(fields[0], ".")
+		t.parts = strings.Split(fields[1], ".")
+		fields = fields[2:]
+	default:
+		return nil, fmt.Errorf("invalid graphite template %q, use [filter] template [label=value,...]", str)
+	}
+
+	if len(fields) > 0 {
+		for _, pair := range strings.Split(fields[0], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || !utils.IsValidLabelName(kv[0]) || kv[1] == "" {
+				return nil, fmt.Errorf("invalid label %q in graphite template %q", pair, str)
+			}
+			t.labels = append(t.labels, utils.Label{Name: kv[0], Value: kv[1]})
+		}
+	}
+
+	for i, part := range t.parts {
+		if part == "measurement*" && i != len(t.parts)-1 {
+			return nil, fmt.Errorf("measurement* must be the last part of graphite template %q", str)
+		}
+		if part != "" && part != "measurement" && part != "measurement*" && !utils.IsValidLabelName(part) {
+			return nil, fmt.Errorf("invalid label %q in graphite template %q", part, str)
+		}
+	}
+	return t, nil
+}
+
+// return true if the path matches the template filter (the filter segments are glob patterns)
+func (t *template) match(segments []string) bool {
+	if len(segments) < len(t.filter) {
+		return false
+	}
+	for i, pattern := range t.filter {
+		if ok, _ := path.Match(pattern, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Labels returns the metric labels of a dotted path, with the first matching template (or the whole path as
+// the metric name)
+func (p *Parser) Labels(metricPath string) (utils.Labels, error) {
+	segments := strings.Split(metricPath, ".")
+	for _, s := range segments {
+		if s == "" {
+			return nil, fmt.Errorf("invalid path %q", metricPath)
+		}
+	}
+
+	var t *template
+	for _, candidate := range p.templates {
+		if candidate.match(segments) {
+			t = candidate
+			break
+		}
+	}
+	if t == nil {
+		t = &template{parts: []string{"measurement*"}}
+	}
+
+	name := []string{}
+	values := map[string][]string{}
+	for i, part := range t.parts {
+		if i >= len(segments) {
+			break
+		}
+		switch part {
+		case "":
+		case "measurement":
+			name = append(name, segments[i])
+		case "measurement*":
+			name = append(name, segments[i:]...)
+		default:
+			values[part] = append(values[part], segments[i])
+		}
+	}
+	if len(name) == 0 {
+		name = segments
+	}
+
+	lset := utils.Labels{{Name: utils.MetricName, Value: utils.SanitizeLabelName(strings.Join(name, p.separator))}}
+	lset = append(lset, t.labels...)
+	for label, parts := range values {
+		lset = append(lset, utils.Label{Name: label, Value: strings.Join(parts, p.separator)})
+	}
+	sort.Sort(lset)
+	return lset, lset.Validate()
+}
+
+// ParseLine parses a plaintext line "<path> <value> [<timestamp>]", timestamps are in (fractional) seconds,
+// a missing or -1 timestamp is the current time. returns the labels, time (milliseconds) and value
+func (p *Parser) ParseLine(line string, now int64) (utils.Labels, int64, float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, 0, 0, fmt.Errorf("invalid line %q, expected <path> <value> [<timestamp>]", line)
+	}
+
+	v, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	t := now
+	if len(fields) == 3 && fields[2] != "-1" {
+		secs, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		t = int64(math.Round(secs * 1000))
+	}
+
+	lset, err := p.Labels(fields[0])
+	return lset, t, v, err
+}
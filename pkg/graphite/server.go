@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+)
+
+// max length of a plaintext line
+const maxLineSize = 64 * 1024
+
+// Server is a Graphite plaintext protocol (TCP) listener, every line is appended through the TSDB Appender
+type Server struct {
+	logger   logger.Logger
+	appender tsdb.Appender
+	parser   *Parser
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a plaintext listener, fails on invalid templates
+func NewServer(logger logger.Logger, appender tsdb.Appender, cfg *config.GraphiteConfig) (*Server, error) {
+	parser, err := NewParser(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{logger: logger, appender: appender, parser: parser, conns: map[net.Conn]struct{}{}}, nil
+}
+
+// Start listens on the TCP address and serves the connections in the background
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to listen on %s", addr)
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.accept()
+	return nil
+}
+
+// Addr returns the listener address
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the listener and closes the open connections
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		lset, t, v, err := s.parser.ParseLine(line, time.Now().UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			s.logger.WarnWith("Invalid graphite line", "remote", conn.RemoteAddr().String(), "err", err)
+			continue
+		}
+		if _, err := s.appender.Add(lset, t, v); err != nil {
+			s.logger.ErrorWith("Failed to append graphite metric", "labels", lset.String(), "err", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.logger.WarnWith("Graphite connection failed", "remote", conn.RemoteAddr().String(), "err", err)
+	}
+}
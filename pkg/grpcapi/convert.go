@@ -0,0 +1,76 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package grpcapi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/v3io/v3io-tsdb/pkg/grpcapi/tsdbpb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// convert labels to a sorted label set, empty labels are dropped (same as Prometheus)
+func toLabels(list []*tsdbpb.Label) utils.Labels {
+	lset := make(utils.Labels, 0, len(list))
+	for _, l := range list {
+		if l.Value != "" {
+			lset = append(lset, utils.Label{Name: l.Name, Value: l.Value})
+		}
+	}
+	sort.Sort(lset)
+	return lset
+}
+
+func fromLabels(lset utils.Labels) []*tsdbpb.Label {
+	list := make([]*tsdbpb.Label, 0, len(lset))
+	for _, l := range lset {
+		list = append(list, &tsdbpb.Label{Name: l.Name, Value: l.Value})
+	}
+	return list
+}
+
+// convert the matchers and the (optional) series selector to label matchers
+func toMatchers(list []*tsdbpb.LabelMatcher, selector string) ([]*utils.LabelMatcher, error) {
+	var matchers []*utils.LabelMatcher
+	if selector != "" {
+		parsed, err := utils.ParseSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		matchers = parsed
+	}
+
+	for _, m := range list {
+		if m.Type < tsdbpb.MatchType_EQUAL || m.Type > tsdbpb.MatchType_NOT_REGEXP {
+			return nil, fmt.Errorf("invalid match type %d", m.Type)
+		}
+		if !utils.IsValidLabelName(m.Name) {
+			return nil, fmt.Errorf("invalid matcher label name %q", m.Name)
+		}
+		matcher, err := utils.NewLabelMatcher(utils.MatchType(m.Type), m.Name, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/grpcapi/tsdbpb"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func toStrings(list []*tsdbpb.Label) []string {
+	var res []string
+	for _, l := range list {
+		res = append(res, l.Name, l.Value)
+	}
+	return res
+}
+
+func TestServer(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &tsdbtest.Appender{Reject: func(s tsdbtest.Sample) error {
+		if s.T < 0 {
+			return fmt.Errorf("negative time")
+		}
+		return nil
+	}}
+	full := false
+	q := &tsdbtest.Querier{Raw: []*tsdbtest.Series{
+		{Lset: utils.FromStrings("__name__", "cpu", "host", "b"),
+			Points: []tsdbtest.Point{{T: 1000, V: 1}, {T: 2000, V: 2}, {T: 3000, V: 3}, {T: 4000, V: 4}, {T: 5000, V: 5}}},
+		{Lset: utils.FromStrings("__name__", "cpu", "host", "a"), Points: []tsdbtest.Point{{T: 1000, V: 10}, {T: 2000, V: 20}}},
+		{Lset: utils.FromStrings("__name__", "mem", "host", "a")},
+	}}
+	cfg := &config.DBPartConfig{Version: "1.0", HrInChunk: 1, DefaultRollups: "count,sum", RollupMin: 60}
+	server := newServer(logger, app, func() bool { return full }, q.New,
+		func() *tsdbpb.InfoResponse { return infoResponse("metrics", cfg) })
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, err := grpc.NewClient(server.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := tsdbpb.NewTSDBClient(conn)
+	ctx := context.Background()
+
+	// append
+	cpu := []*tsdbpb.Label{{Name: "host", Value: "a"}, {Name: "__name__", Value: "cpu"}, {Name: "dc", Value: ""}}
+	appendStream := func(requests ...*tsdbpb.AppendRequest) *tsdbpb.AppendResponse {
+		stream, err := client.Append(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, req := range requests {
+			if err := stream.Send(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, err := stream.CloseAndRecv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := appendStream(
+		&tsdbpb.AppendRequest{Series: []*tsdbpb.AppendSeries{
+			{Labels: cpu, Samples: []*tsdbpb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}},
+			{Labels: []*tsdbpb.Label{{Name: "host", Value: "a"}}, Samples: []*tsdbpb.Sample{{Timestamp: 1000, Value: 1}}},
+		}},
+		&tsdbpb.AppendRequest{Series: []*tsdbpb.AppendSeries{
+			{Ref: server.seriesRef(1), Samples: []*tsdbpb.Sample{{Timestamp: 3000, Value: 3}, {Timestamp: -1, Value: 4}}},
+			{Ref: 1, Samples: []*tsdbpb.Sample{{Timestamp: 3000, Value: 3}}},
+			{Ref: uint64(server.epoch+2)<<32 | 1, Samples: []*tsdbpb.Sample{{Timestamp: 3000, Value: 3}}},
+		}})
+	ref := server.seriesRef(1)
+	if ref>>32 == 0 || !reflect.DeepEqual(resp.Refs, []uint64{ref, 0, ref, 0, 0}) || resp.Samples != 3 ||
+		len(resp.Errors) != 4 {
+		t.Fatalf("wrong append response %v", resp)
+	}
+	if e := resp.Errors[1]; e.Request != 1 || e.Series != 0 {
+		t.Fatalf("wrong append error %v", e)
+	}
+	// the appender refs and the refs of another server are not accepted
+	for _, e := range resp.Errors[2:] {
+		if e.Request != 1 || e.Error != errUnknownRef.Error() {
+			t.Fatalf("expected an unknown ref error, got %v", e)
+		}
+	}
+	if s := app.Samples()[2]; s.T != 3000 || s.Ref != 1 {
+		t.Fatalf("wrong sample %+v", s)
+	}
+
+	// the labels are used when they are sent with a ref
+	resp = appendStream(&tsdbpb.AppendRequest{Series: []*tsdbpb.AppendSeries{
+		{Labels: cpu, Ref: uint64(server.epoch+2)<<32 | 5, Samples: []*tsdbpb.Sample{{Timestamp: 4000, Value: 4}}}}})
+	if !reflect.DeepEqual(resp.Refs, []uint64{ref}) || len(resp.Errors) != 0 {
+		t.Fatalf("wrong append response %v", resp)
+	}
+	if s := app.Samples()[3]; s.T != 4000 || s.Lset.String() != `{__name__="cpu", host="a"}` {
+		t.Fatalf("wrong sample %+v", s)
+	}
+	if lset := app.Samples()[0].Lset.String(); lset != `{__name__="cpu", host="a"}` {
+		t.Fatalf("wrong labels %s", lset)
+	}
+
+	full = true
+	resp = appendStream(&tsdbpb.AppendRequest{Series: []*tsdbpb.AppendSeries{
+		{Labels: cpu, Samples: []*tsdbpb.Sample{{Timestamp: 4000, Value: 4}}}}})
+	if resp.Samples != 0 || len(resp.Errors) != 1 || resp.Errors[0].Error != errQueueFull.Error() {
+		t.Fatalf("expected a queue full error, got %v", resp)
+	}
+
+	// select
+	stream, err := client.Select(ctx, &tsdbpb.SelectRequest{Start: 1000, End: 5000, Selector: "cpu",
+		Matchers:  []*tsdbpb.LabelMatcher{{Type: tsdbpb.MatchType_REGEXP, Name: "host", Value: "a|b"}},
+		Functions: "avg", Step: 1000, GroupBy: []string{"host"}, Fill: "zero", BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batches []string
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, fmt.Sprintf("%d %v %d", resp.Series, toStrings(resp.Labels), len(resp.Samples)))
+	}
+	expected := []string{"0 [__name__ cpu host b] 2", "0 [] 2", "0 [] 1", "1 [__name__ cpu host a] 2"}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Fatalf("wrong select batches %v", batches)
+	}
+	if p := q.LastParams(); p.Functions != "avg" || p.Step != 1000 || len(p.Matchers) != 2 || p.Fill.Type != querier.FillZero ||
+		!reflect.DeepEqual(p.GroupBy, []string{"host"}) {
+		t.Fatalf("wrong select params %+v", p)
+	}
+	if mint, maxt := q.Range(); mint != 1000 || maxt != 5000 {
+		t.Fatalf("wrong select range %d-%d", mint, maxt)
+	}
+
+	for _, req := range []*tsdbpb.SelectRequest{
+		{Start: 0, End: 1000},
+		{Start: 0, End: 1000, Selector: "cpu{"},
+		{Start: 0, End: 1000, Selector: "cpu", Fill: "x"},
+		{Start: 10, End: 0, Selector: "cpu"},
+		{Start: 0, End: 1000, Matchers: []*tsdbpb.LabelMatcher{{Type: 9, Name: "a", Value: "b"}}},
+	} {
+		stream, err := client.Select(ctx, req)
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected an invalid argument error for %v, got %v", req, err)
+		}
+	}
+
+	// metadata
+	labels, err := client.Labels(ctx, &tsdbpb.LabelsRequest{})
+	if err != nil || !reflect.DeepEqual(labels.Values, []string{"__name__", "host"}) {
+		t.Fatalf("wrong label names %v %v", labels, err)
+	}
+	labels, err = client.Labels(ctx, &tsdbpb.LabelsRequest{Name: "host", Selector: "cpu"})
+	if err != nil || !reflect.DeepEqual(labels.Values, []string{"a", "b"}) {
+		t.Fatalf("wrong label values %v %v", labels, err)
+	}
+	if mint, maxt := q.Range(); mint != 0 || maxt == 0 {
+		t.Fatalf("wrong label values range %d-%d", mint, maxt)
+	}
+	if _, err := client.Labels(ctx, &tsdbpb.LabelsRequest{Name: "a-b"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an invalid argument error, got %v", err)
+	}
+
+	series, err := client.Series(ctx, &tsdbpb.SeriesRequest{Selector: `{host="a"}`})
+	if err != nil || len(series.Series) != 2 || !reflect.DeepEqual(toStrings(series.Series[0].Labels),
+		[]string{"__name__", "cpu", "host", "a"}) {
+		t.Fatalf("wrong series %v %v", series, err)
+	}
+
+	info, err := client.Info(ctx, &tsdbpb.InfoRequest{})
+	if err != nil || info.Path != "metrics" || info.DefaultRollups != "count,sum" || info.RollupMin != 60 {
+		t.Fatalf("wrong info %v %v", info, err)
+	}
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package grpcapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/grpcapi/tsdbpb"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// max size of a received message (an Append request)
+	maxRecvMsgSize = 64 << 20
+	// default max samples per Select response
+	defaultBatchSize = 1000
+	// time given to the running calls to complete on Close
+	closeTimeout = 10 * time.Second
+)
+
+var (
+	errQueueFull  = errors.New("the append queue is full, retry later")
+	errUnknownRef = errors.New("ref not found, resend the series labels")
+)
+
+// Server serves the TSDB gRPC API (see tsdbpb/tsdb.proto), samples are appended through the TSDB Appender and
+// queries run over the V3ioQuerier
+type Server struct {
+	tsdbpb.UnimplementedTSDBServer
+	logger   logger.Logger
+	appender tsdb.Appender
+	full     func() bool
+	querier  func(ctx context.Context, mint, maxt int64) (querier.Querier, error)
+	info     func() *tsdbpb.InfoResponse
+	// the high 32 bits of the returned refs, the appender refs are only valid in this process
+	epoch uint32
+
+	grpcServer *grpc.Server
+	listener   net.Listener
+}
+
+// NewServer creates the API server over the adapter
+func NewServer(logger logger.Logger, adapter *tsdb.V3ioAdapter) (*Server, error) {
+	appender, err := adapter.Appender()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create the appender")
+	}
+	_, path := adapter.GetContainer()
+
+	return newServer(logger, appender, adapter.MetricsCache.QueueFull,
+		func(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+			return adapter.Querier(ctx, mint, maxt)
+		},
+		func() *tsdbpb.InfoResponse {
+			return infoResponse(path, adapter.GetDBConfig())
+		}), nil
+}
+
+func newServer(logger logger.Logger, appender tsdb.Appender, full func() bool,
+	newQuerier func(ctx context.Context, mint, maxt int64) (querier.Querier, error),
+	info func() *tsdbpb.InfoResponse) *Server {
+	return &Server{logger: logger, appender: appender, full: full, querier: newQuerier, info: info, epoch: newEpoch()}
+}
+
+// a random non zero epoch, so the refs of another server (or of a restarted server) are not accepted
+func newEpoch() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano()) | 1
+	}
+	return binary.LittleEndian.Uint32(b[:]) | 1
+}
+
+// the ref returned to the client, the server epoch and the appender ref (0 if it doesn't fit in 32 bits)
+func (s *Server) seriesRef(ref uint64) uint64 {
+	if ref == 0 || ref>>32 != 0 {
+		return 0
+	}
+	return uint64(s.epoch)<<32 | ref
+}
+
+// the appender ref of a client ref, false if the ref was not returned by this server
+func (s *Server) appenderRef(ref uint64) (uint64, bool) {
+	if ref == 0 || uint32(ref>>32) != s.epoch {
+		return 0, false
+	}
+	return ref & 0xffffffff, true
+}
+
+// Start listens on the TCP address and serves the API in the background
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to listen on %s", addr)
+	}
+	s.listener = listener
+
+	s.grpcServer = grpc.NewServer(grpc.MaxRecvMsgSize(maxRecvMsgSize))
+	tsdbpb.RegisterTSDBServer(s.grpcServer, s)
+	go func() {
+		if err := s.grpcServer.Serve(listener); err != nil {
+			s.logger.WarnWith("gRPC server failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the listener address
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server, the running calls are given a few seconds to complete
+func (s *Server) Close() error {
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		s.grpcServer.Stop()
+	}
+	return nil
+}
+
+// Append appends the series of every request in the stream, a series is appended until its first failed sample
+func (s *Server) Append(stream tsdbpb.TSDB_AppendServer) error {
+	resp := &tsdbpb.AppendResponse{}
+	for index := uint32(0); ; index++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			if len(resp.Errors) > 0 {
+				s.logger.DebugWith("Append failed for some series", "failed", len(resp.Errors),
+					"series", len(resp.Refs), "first", resp.Errors[0].Error)
+			}
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+
+		full := s.full != nil && s.full()
+		for i, series := range req.Series {
+			ref, appended, err := s.appendSeries(series, full)
+			resp.Refs = append(resp.Refs, ref)
+			resp.Samples += appended
+			if err != nil {
+				resp.Errors = append(resp.Errors, &tsdbpb.AppendError{Request: index, Series: uint32(i), Error: err.Error()})
+			}
+		}
+	}
+}
+
+// append the samples of a series, returns the series ref and the number of appended samples. the labels are used
+// when they are sent (the ref is ignored), a ref which was not returned by this server is rejected
+func (s *Server) appendSeries(series *tsdbpb.AppendSeries, full bool) (uint64, uint64, error) {
+	if full {
+		return series.Ref, 0, errQueueFull
+	}
+
+	var lset utils.Labels
+	var ref uint64
+	if len(series.Labels) > 0 || series.Ref == 0 {
+		lset = toLabels(series.Labels)
+		if err := lset.Validate(); err != nil {
+			return 0, 0, err
+		}
+	} else if appRef, ok := s.appenderRef(series.Ref); ok {
+		ref = appRef
+	} else {
+		return 0, 0, errUnknownRef
+	}
+
+	var appended uint64
+	for _, sample := range series.Samples {
+		var err error
+		if ref == 0 {
+			ref, err = s.appender.Add(lset, sample.Timestamp, sample.Value)
+		} else {
+			err = s.appender.AddFast(lset, ref, sample.Timestamp, sample.Value)
+		}
+		if err != nil {
+			return s.seriesRef(ref), appended, errors.Wrapf(err, "failed to append the sample at %d", sample.Timestamp)
+		}
+		appended++
+	}
+	return s.seriesRef(ref), appended, nil
+}
+
+// Select streams the matching series, a response has up to batch_size samples of a series
+func (s *Server) Select(req *tsdbpb.SelectRequest, stream tsdbpb.TSDB_SelectServer) error {
+	matchers, err := toMatchers(req.Matchers, req.Selector)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(matchers) == 0 {
+		return status.Error(codes.InvalidArgument, "no matchers or selector")
+	}
+	if req.End < req.Start {
+		return status.Error(codes.InvalidArgument, "end is before start")
+	}
+	params := &querier.SelectParams{Matchers: matchers, Functions: req.Functions, Step: req.Step,
+		GroupBy: req.GroupBy, Without: req.Without}
+	if params.Fill, err = querier.ParseFill(req.Fill); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	batchSize := int(req.BatchSize)
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+
+	q, err := s.querier(stream.Context(), req.Start, req.End)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer q.Close()
+	set, err := q.SelectQry(params)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	for index := uint64(0); set.Next(); index++ {
+		series := set.At()
+		lset := series.Labels().Copy()
+		sort.Sort(lset)
+		resp := &tsdbpb.SelectResponse{Series: index, Labels: fromLabels(lset)}
+
+		iter := series.Iterator()
+		for iter.Next() {
+			t, v := iter.At()
+			resp.Samples = append(resp.Samples, &tsdbpb.Sample{Timestamp: t, Value: v})
+			if len(resp.Samples) == batchSize {
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+				resp = &tsdbpb.SelectResponse{Series: index}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		// the last batch, or the labels of a series without samples
+		if len(resp.Samples) > 0 || resp.Labels != nil {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+	if err := set.Err(); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// Labels returns the label names, or the values of the name label
+func (s *Server) Labels(ctx context.Context, req *tsdbpb.LabelsRequest) (*tsdbpb.LabelsResponse, error) {
+	if req.Name != "" && !utils.IsValidLabelName(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid label name %q", req.Name)
+	}
+	matchers, q, err := s.metadataQuerier(ctx, req.Start, req.End, req.Matchers, req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	var values []string
+	if req.Name == "" {
+		values, err = q.LabelNames(matchers...)
+	} else {
+		values, err = q.LabelValues(req.Name, matchers...)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &tsdbpb.LabelsResponse{Values: values}, nil
+}
+
+// Series returns the sorted label sets of the matching series
+func (s *Server) Series(ctx context.Context, req *tsdbpb.SeriesRequest) (*tsdbpb.SeriesResponse, error) {
+	matchers, q, err := s.metadataQuerier(ctx, req.Start, req.End, req.Matchers, req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	list, err := q.Series(matchers...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	for _, lset := range list {
+		sort.Sort(lset)
+	}
+	sort.Slice(list, func(i, j int) bool { return utils.Compare(list[i], list[j]) < 0 })
+
+	resp := &tsdbpb.SeriesResponse{}
+	for _, lset := range list {
+		resp.Series = append(resp.Series, &tsdbpb.LabelSet{Labels: fromLabels(lset)})
+	}
+	return resp, nil
+}
+
+// Info returns the TSDB configuration
+func (s *Server) Info(ctx context.Context, req *tsdbpb.InfoRequest) (*tsdbpb.InfoResponse, error) {
+	return s.info(), nil
+}
+
+// convert the matchers and create a querier for the start/end range (default all the data), errors are statuses
+func (s *Server) metadataQuerier(ctx context.Context, start, end int64, list []*tsdbpb.LabelMatcher,
+	selector string) ([]*utils.LabelMatcher, querier.Querier, error) {
+
+	matchers, err := toMatchers(list, selector)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if end == 0 {
+		end = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	q, err := s.querier(ctx, start, end)
+	if err != nil {
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	return matchers, q, nil
+}
+
+func infoResponse(path string, cfg *config.DBPartConfig) *tsdbpb.InfoResponse {
+	return &tsdbpb.InfoResponse{
+		Path:           path,
+		Version:        cfg.Version,
+		Description:    cfg.Description,
+		ShardingKey:    cfg.ShardingKey,
+		SortingKey:     cfg.SortingKey,
+		Cyclic:         cfg.IsCyclic,
+		HrInChunk:      int32(cfg.HrInChunk),
+		DaysPerObj:     int32(cfg.DaysPerObj),
+		DaysRetention:  int32(cfg.DaysRetention),
+		StartTime:      cfg.StartTime,
+		EndTime:        cfg.EndTime,
+		DefaultRollups: cfg.DefaultRollups,
+		RollupMin:      int32(cfg.RollupMin),
+		DelRawSamples:  cfg.DelRawSamples,
+	}
+}
@@ -0,0 +1,1326 @@
+//
+//Copyright 2018 Iguazio Systems Ltd.
+//
+//Licensed under the Apache License, Version 2.0 (the "License") with
+//an addition restriction as set forth herein. You may not use this
+//file except in compliance with the License. You may obtain a copy of
+//the License at http://www.apache.org/licenses/LICENSE-2.0.
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//implied. See the License for the specific language governing
+//permissions and limitations under the License.
+//
+//In addition, you may not use the software for any purposes that are
+//illegal under applicable law, and the grant of the foregoing license
+//under the Apache 2.0 license is conditioned upon your compliance with
+//such restriction.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.28.3
+// source: tsdb.proto
+
+package tsdbpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type MatchType int32
+
+const (
+	MatchType_EQUAL      MatchType = 0
+	MatchType_NOT_EQUAL  MatchType = 1
+	MatchType_REGEXP     MatchType = 2
+	MatchType_NOT_REGEXP MatchType = 3
+)
+
+// Enum value maps for MatchType.
+var (
+	MatchType_name = map[int32]string{
+		0: "EQUAL",
+		1: "NOT_EQUAL",
+		2: "REGEXP",
+		3: "NOT_REGEXP",
+	}
+	MatchType_value = map[string]int32{
+		"EQUAL":      0,
+		"NOT_EQUAL":  1,
+		"REGEXP":     2,
+		"NOT_REGEXP": 3,
+	}
+)
+
+func (x MatchType) Enum() *MatchType {
+	p := new(MatchType)
+	*p = x
+	return p
+}
+
+func (x MatchType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (MatchType) Descriptor() protoreflect.EnumDescriptor {
+	return file_tsdb_proto_enumTypes[0].Descriptor()
+}
+
+func (MatchType) Type() protoreflect.EnumType {
+	return &file_tsdb_proto_enumTypes[0]
+}
+
+func (x MatchType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use MatchType.Descriptor instead.
+func (MatchType) EnumDescriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{0}
+}
+
+type Label struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Label) Reset() {
+	*x = Label{}
+	mi := &file_tsdb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Label) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Label) ProtoMessage() {}
+
+func (x *Label) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Label.ProtoReflect.Descriptor instead.
+func (*Label) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Label) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Label) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type Sample struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Value         float64                `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	mi := &file_tsdb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Sample) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Sample) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+// a label matcher, regular expressions are fully anchored (like Prometheus)
+type LabelMatcher struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          MatchType              `protobuf:"varint,1,opt,name=type,proto3,enum=v3io.tsdb.v1.MatchType" json:"type,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Value         string                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelMatcher) Reset() {
+	*x = LabelMatcher{}
+	mi := &file_tsdb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelMatcher) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelMatcher) ProtoMessage() {}
+
+func (x *LabelMatcher) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelMatcher.ProtoReflect.Descriptor instead.
+func (*LabelMatcher) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *LabelMatcher) GetType() MatchType {
+	if x != nil {
+		return x.Type
+	}
+	return MatchType_EQUAL
+}
+
+func (x *LabelMatcher) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *LabelMatcher) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type AppendSeries struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the series labels (including __name__), not needed when the ref is set (the ref is ignored when they are sent)
+	Labels []*Label `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty"`
+	// a ref ID returned by a previous Append of the same server, refs of another server (or of a restarted server)
+	// are rejected with "ref not found" and a 0 ref, the series must then be sent with its labels
+	Ref           uint64    `protobuf:"varint,2,opt,name=ref,proto3" json:"ref,omitempty"`
+	Samples       []*Sample `protobuf:"bytes,3,rep,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendSeries) Reset() {
+	*x = AppendSeries{}
+	mi := &file_tsdb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendSeries) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendSeries) ProtoMessage() {}
+
+func (x *AppendSeries) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendSeries.ProtoReflect.Descriptor instead.
+func (*AppendSeries) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AppendSeries) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *AppendSeries) GetRef() uint64 {
+	if x != nil {
+		return x.Ref
+	}
+	return 0
+}
+
+func (x *AppendSeries) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+type AppendRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Series        []*AppendSeries        `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendRequest) Reset() {
+	*x = AppendRequest{}
+	mi := &file_tsdb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendRequest) ProtoMessage() {}
+
+func (x *AppendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendRequest.ProtoReflect.Descriptor instead.
+func (*AppendRequest) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AppendRequest) GetSeries() []*AppendSeries {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+// a rejected series, the samples before the failed sample were appended
+type AppendError struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// index of the request in the stream
+	Request uint32 `protobuf:"varint,1,opt,name=request,proto3" json:"request,omitempty"`
+	// index of the series in the request
+	Series        uint32 `protobuf:"varint,2,opt,name=series,proto3" json:"series,omitempty"`
+	Error         string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendError) Reset() {
+	*x = AppendError{}
+	mi := &file_tsdb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendError) ProtoMessage() {}
+
+func (x *AppendError) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendError.ProtoReflect.Descriptor instead.
+func (*AppendError) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AppendError) GetRequest() uint32 {
+	if x != nil {
+		return x.Request
+	}
+	return 0
+}
+
+func (x *AppendError) GetSeries() uint32 {
+	if x != nil {
+		return x.Series
+	}
+	return 0
+}
+
+func (x *AppendError) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type AppendResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the ref ID of every series in the stream (in order), 0 for a series which was rejected before its first sample
+	Refs []uint64 `protobuf:"varint,1,rep,packed,name=refs,proto3" json:"refs,omitempty"`
+	// the number of appended samples
+	Samples       uint64         `protobuf:"varint,2,opt,name=samples,proto3" json:"samples,omitempty"`
+	Errors        []*AppendError `protobuf:"bytes,3,rep,name=errors,proto3" json:"errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendResponse) Reset() {
+	*x = AppendResponse{}
+	mi := &file_tsdb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendResponse) ProtoMessage() {}
+
+func (x *AppendResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendResponse.ProtoReflect.Descriptor instead.
+func (*AppendResponse) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AppendResponse) GetRefs() []uint64 {
+	if x != nil {
+		return x.Refs
+	}
+	return nil
+}
+
+func (x *AppendResponse) GetSamples() uint64 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+func (x *AppendResponse) GetErrors() []*AppendError {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+type SelectRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Start    int64                  `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End      int64                  `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	Matchers []*LabelMatcher        `protobuf:"bytes,3,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	// a Prometheus style series selector e.g. cpu{host=~"web.*"}, added to the matchers
+	Selector string `protobuf:"bytes,4,opt,name=selector,proto3" json:"selector,omitempty"`
+	// comma separated aggregation functions e.g. avg,max, empty for the raw samples
+	Functions string `protobuf:"bytes,5,opt,name=functions,proto3" json:"functions,omitempty"`
+	// aggregation (or resample) step in milliseconds
+	Step int64 `protobuf:"varint,6,opt,name=step,proto3" json:"step,omitempty"`
+	// aggregate across the series with the same group_by labels (or all the other labels with without)
+	GroupBy []string `protobuf:"bytes,7,rep,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`
+	Without bool     `protobuf:"varint,8,opt,name=without,proto3" json:"without,omitempty"`
+	// fill policy of empty steps: none, null, zero, previous, linear or a number
+	Fill string `protobuf:"bytes,9,opt,name=fill,proto3" json:"fill,omitempty"`
+	// max samples per response message (default 1000)
+	BatchSize     uint32 `protobuf:"varint,10,opt,name=batch_size,json=batchSize,proto3" json:"batch_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelectRequest) Reset() {
+	*x = SelectRequest{}
+	mi := &file_tsdb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelectRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelectRequest) ProtoMessage() {}
+
+func (x *SelectRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelectRequest.ProtoReflect.Descriptor instead.
+func (*SelectRequest) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SelectRequest) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *SelectRequest) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *SelectRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+func (x *SelectRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *SelectRequest) GetFunctions() string {
+	if x != nil {
+		return x.Functions
+	}
+	return ""
+}
+
+func (x *SelectRequest) GetStep() int64 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *SelectRequest) GetGroupBy() []string {
+	if x != nil {
+		return x.GroupBy
+	}
+	return nil
+}
+
+func (x *SelectRequest) GetWithout() bool {
+	if x != nil {
+		return x.Without
+	}
+	return false
+}
+
+func (x *SelectRequest) GetFill() string {
+	if x != nil {
+		return x.Fill
+	}
+	return ""
+}
+
+func (x *SelectRequest) GetBatchSize() uint32 {
+	if x != nil {
+		return x.BatchSize
+	}
+	return 0
+}
+
+// a batch of samples of a series, the labels are sent only in the first batch of a series
+type SelectResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// index of the series in the result
+	Series        uint64    `protobuf:"varint,1,opt,name=series,proto3" json:"series,omitempty"`
+	Labels        []*Label  `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty"`
+	Samples       []*Sample `protobuf:"bytes,3,rep,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelectResponse) Reset() {
+	*x = SelectResponse{}
+	mi := &file_tsdb_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelectResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelectResponse) ProtoMessage() {}
+
+func (x *SelectResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelectResponse.ProtoReflect.Descriptor instead.
+func (*SelectResponse) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SelectResponse) GetSeries() uint64 {
+	if x != nil {
+		return x.Series
+	}
+	return 0
+}
+
+func (x *SelectResponse) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *SelectResponse) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+type LabelsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the label to return the values of (__name__ for the metric names), empty for the label names
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// the time range of the series, default all the data
+	Start         int64           `protobuf:"varint,2,opt,name=start,proto3" json:"start,omitempty"`
+	End           int64           `protobuf:"varint,3,opt,name=end,proto3" json:"end,omitempty"`
+	Matchers      []*LabelMatcher `protobuf:"bytes,4,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	Selector      string          `protobuf:"bytes,5,opt,name=selector,proto3" json:"selector,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelsRequest) Reset() {
+	*x = LabelsRequest{}
+	mi := &file_tsdb_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelsRequest) ProtoMessage() {}
+
+func (x *LabelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelsRequest.ProtoReflect.Descriptor instead.
+func (*LabelsRequest) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *LabelsRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *LabelsRequest) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *LabelsRequest) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *LabelsRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+func (x *LabelsRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+type LabelsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the sorted label names or values
+	Values        []string `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelsResponse) Reset() {
+	*x = LabelsResponse{}
+	mi := &file_tsdb_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelsResponse) ProtoMessage() {}
+
+func (x *LabelsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelsResponse.ProtoReflect.Descriptor instead.
+func (*LabelsResponse) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *LabelsResponse) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type SeriesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the time range of the series, default all the data
+	Start         int64           `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           int64           `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	Matchers      []*LabelMatcher `protobuf:"bytes,3,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	Selector      string          `protobuf:"bytes,4,opt,name=selector,proto3" json:"selector,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SeriesRequest) Reset() {
+	*x = SeriesRequest{}
+	mi := &file_tsdb_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SeriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SeriesRequest) ProtoMessage() {}
+
+func (x *SeriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SeriesRequest.ProtoReflect.Descriptor instead.
+func (*SeriesRequest) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SeriesRequest) GetStart() int64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *SeriesRequest) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *SeriesRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+func (x *SeriesRequest) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+type LabelSet struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Labels        []*Label               `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelSet) Reset() {
+	*x = LabelSet{}
+	mi := &file_tsdb_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelSet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelSet) ProtoMessage() {}
+
+func (x *LabelSet) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelSet.ProtoReflect.Descriptor instead.
+func (*LabelSet) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *LabelSet) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type SeriesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Series        []*LabelSet            `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SeriesResponse) Reset() {
+	*x = SeriesResponse{}
+	mi := &file_tsdb_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SeriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SeriesResponse) ProtoMessage() {}
+
+func (x *SeriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SeriesResponse.ProtoReflect.Descriptor instead.
+func (*SeriesResponse) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SeriesResponse) GetSeries() []*LabelSet {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+type InfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InfoRequest) Reset() {
+	*x = InfoRequest{}
+	mi := &file_tsdb_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InfoRequest) ProtoMessage() {}
+
+func (x *InfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InfoRequest.ProtoReflect.Descriptor instead.
+func (*InfoRequest) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{14}
+}
+
+type InfoResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the TSDB table path
+	Path           string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Version        string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Description    string `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	ShardingKey    string `protobuf:"bytes,4,opt,name=sharding_key,json=shardingKey,proto3" json:"sharding_key,omitempty"`
+	SortingKey     string `protobuf:"bytes,5,opt,name=sorting_key,json=sortingKey,proto3" json:"sorting_key,omitempty"`
+	Cyclic         bool   `protobuf:"varint,6,opt,name=cyclic,proto3" json:"cyclic,omitempty"`
+	HrInChunk      int32  `protobuf:"varint,7,opt,name=hr_in_chunk,json=hrInChunk,proto3" json:"hr_in_chunk,omitempty"`
+	DaysPerObj     int32  `protobuf:"varint,8,opt,name=days_per_obj,json=daysPerObj,proto3" json:"days_per_obj,omitempty"`
+	DaysRetention  int32  `protobuf:"varint,9,opt,name=days_retention,json=daysRetention,proto3" json:"days_retention,omitempty"`
+	StartTime      int64  `protobuf:"varint,10,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	EndTime        int64  `protobuf:"varint,11,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	DefaultRollups string `protobuf:"bytes,12,opt,name=default_rollups,json=defaultRollups,proto3" json:"default_rollups,omitempty"`
+	RollupMin      int32  `protobuf:"varint,13,opt,name=rollup_min,json=rollupMin,proto3" json:"rollup_min,omitempty"`
+	DelRawSamples  bool   `protobuf:"varint,14,opt,name=del_raw_samples,json=delRawSamples,proto3" json:"del_raw_samples,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *InfoResponse) Reset() {
+	*x = InfoResponse{}
+	mi := &file_tsdb_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InfoResponse) ProtoMessage() {}
+
+func (x *InfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tsdb_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InfoResponse.ProtoReflect.Descriptor instead.
+func (*InfoResponse) Descriptor() ([]byte, []int) {
+	return file_tsdb_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *InfoResponse) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetShardingKey() string {
+	if x != nil {
+		return x.ShardingKey
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetSortingKey() string {
+	if x != nil {
+		return x.SortingKey
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetCyclic() bool {
+	if x != nil {
+		return x.Cyclic
+	}
+	return false
+}
+
+func (x *InfoResponse) GetHrInChunk() int32 {
+	if x != nil {
+		return x.HrInChunk
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetDaysPerObj() int32 {
+	if x != nil {
+		return x.DaysPerObj
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetDaysRetention() int32 {
+	if x != nil {
+		return x.DaysRetention
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetStartTime() int64 {
+	if x != nil {
+		return x.StartTime
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetEndTime() int64 {
+	if x != nil {
+		return x.EndTime
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetDefaultRollups() string {
+	if x != nil {
+		return x.DefaultRollups
+	}
+	return ""
+}
+
+func (x *InfoResponse) GetRollupMin() int32 {
+	if x != nil {
+		return x.RollupMin
+	}
+	return 0
+}
+
+func (x *InfoResponse) GetDelRawSamples() bool {
+	if x != nil {
+		return x.DelRawSamples
+	}
+	return false
+}
+
+var File_tsdb_proto protoreflect.FileDescriptor
+
+const file_tsdb_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"tsdb.proto\x12\fv3io.tsdb.v1\"1\n" +
+	"\x05Label\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\"<\n" +
+	"\x06Sample\x12\x1c\n" +
+	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\"e\n" +
+	"\fLabelMatcher\x12+\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x17.v3io.tsdb.v1.MatchTypeR\x04type\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\tR\x05value\"}\n" +
+	"\fAppendSeries\x12+\n" +
+	"\x06labels\x18\x01 \x03(\v2\x13.v3io.tsdb.v1.LabelR\x06labels\x12\x10\n" +
+	"\x03ref\x18\x02 \x01(\x04R\x03ref\x12.\n" +
+	"\asamples\x18\x03 \x03(\v2\x14.v3io.tsdb.v1.SampleR\asamples\"C\n" +
+	"\rAppendRequest\x122\n" +
+	"\x06series\x18\x01 \x03(\v2\x1a.v3io.tsdb.v1.AppendSeriesR\x06series\"U\n" +
+	"\vAppendError\x12\x18\n" +
+	"\arequest\x18\x01 \x01(\rR\arequest\x12\x16\n" +
+	"\x06series\x18\x02 \x01(\rR\x06series\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"q\n" +
+	"\x0eAppendResponse\x12\x12\n" +
+	"\x04refs\x18\x01 \x03(\x04R\x04refs\x12\x18\n" +
+	"\asamples\x18\x02 \x01(\x04R\asamples\x121\n" +
+	"\x06errors\x18\x03 \x03(\v2\x19.v3io.tsdb.v1.AppendErrorR\x06errors\"\xa5\x02\n" +
+	"\rSelectRequest\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x03R\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x03R\x03end\x126\n" +
+	"\bmatchers\x18\x03 \x03(\v2\x1a.v3io.tsdb.v1.LabelMatcherR\bmatchers\x12\x1a\n" +
+	"\bselector\x18\x04 \x01(\tR\bselector\x12\x1c\n" +
+	"\tfunctions\x18\x05 \x01(\tR\tfunctions\x12\x12\n" +
+	"\x04step\x18\x06 \x01(\x03R\x04step\x12\x19\n" +
+	"\bgroup_by\x18\a \x03(\tR\agroupBy\x12\x18\n" +
+	"\awithout\x18\b \x01(\bR\awithout\x12\x12\n" +
+	"\x04fill\x18\t \x01(\tR\x04fill\x12\x1d\n" +
+	"\n" +
+	"batch_size\x18\n" +
+	" \x01(\rR\tbatchSize\"\x85\x01\n" +
+	"\x0eSelectResponse\x12\x16\n" +
+	"\x06series\x18\x01 \x01(\x04R\x06series\x12+\n" +
+	"\x06labels\x18\x02 \x03(\v2\x13.v3io.tsdb.v1.LabelR\x06labels\x12.\n" +
+	"\asamples\x18\x03 \x03(\v2\x14.v3io.tsdb.v1.SampleR\asamples\"\x9f\x01\n" +
+	"\rLabelsRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\x03R\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\x03R\x03end\x126\n" +
+	"\bmatchers\x18\x04 \x03(\v2\x1a.v3io.tsdb.v1.LabelMatcherR\bmatchers\x12\x1a\n" +
+	"\bselector\x18\x05 \x01(\tR\bselector\"(\n" +
+	"\x0eLabelsResponse\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\"\x8b\x01\n" +
+	"\rSeriesRequest\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x03R\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x03R\x03end\x126\n" +
+	"\bmatchers\x18\x03 \x03(\v2\x1a.v3io.tsdb.v1.LabelMatcherR\bmatchers\x12\x1a\n" +
+	"\bselector\x18\x04 \x01(\tR\bselector\"7\n" +
+	"\bLabelSet\x12+\n" +
+	"\x06labels\x18\x01 \x03(\v2\x13.v3io.tsdb.v1.LabelR\x06labels\"@\n" +
+	"\x0eSeriesResponse\x12.\n" +
+	"\x06series\x18\x01 \x03(\v2\x16.v3io.tsdb.v1.LabelSetR\x06series\"\r\n" +
+	"\vInfoRequest\"\xcd\x03\n" +
+	"\fInfoResponse\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12!\n" +
+	"\fsharding_key\x18\x04 \x01(\tR\vshardingKey\x12\x1f\n" +
+	"\vsorting_key\x18\x05 \x01(\tR\n" +
+	"sortingKey\x12\x16\n" +
+	"\x06cyclic\x18\x06 \x01(\bR\x06cyclic\x12\x1e\n" +
+	"\vhr_in_chunk\x18\a \x01(\x05R\thrInChunk\x12 \n" +
+	"\fdays_per_obj\x18\b \x01(\x05R\n" +
+	"daysPerObj\x12%\n" +
+	"\x0edays_retention\x18\t \x01(\x05R\rdaysRetention\x12\x1d\n" +
+	"\n" +
+	"start_time\x18\n" +
+	" \x01(\x03R\tstartTime\x12\x19\n" +
+	"\bend_time\x18\v \x01(\x03R\aendTime\x12'\n" +
+	"\x0fdefault_rollups\x18\f \x01(\tR\x0edefaultRollups\x12\x1d\n" +
+	"\n" +
+	"rollup_min\x18\r \x01(\x05R\trollupMin\x12&\n" +
+	"\x0fdel_raw_samples\x18\x0e \x01(\bR\rdelRawSamples*A\n" +
+	"\tMatchType\x12\t\n" +
+	"\x05EQUAL\x10\x00\x12\r\n" +
+	"\tNOT_EQUAL\x10\x01\x12\n" +
+	"\n" +
+	"\x06REGEXP\x10\x02\x12\x0e\n" +
+	"\n" +
+	"NOT_REGEXP\x10\x032\xdd\x02\n" +
+	"\x04TSDB\x12E\n" +
+	"\x06Append\x12\x1b.v3io.tsdb.v1.AppendRequest\x1a\x1c.v3io.tsdb.v1.AppendResponse(\x01\x12E\n" +
+	"\x06Select\x12\x1b.v3io.tsdb.v1.SelectRequest\x1a\x1c.v3io.tsdb.v1.SelectResponse0\x01\x12C\n" +
+	"\x06Labels\x12\x1b.v3io.tsdb.v1.LabelsRequest\x1a\x1c.v3io.tsdb.v1.LabelsResponse\x12C\n" +
+	"\x06Series\x12\x1b.v3io.tsdb.v1.SeriesRequest\x1a\x1c.v3io.tsdb.v1.SeriesResponse\x12=\n" +
+	"\x04Info\x12\x19.v3io.tsdb.v1.InfoRequest\x1a\x1a.v3io.tsdb.v1.InfoResponseB.Z,github.com/v3io/v3io-tsdb/pkg/grpcapi/tsdbpbb\x06proto3"
+
+var (
+	file_tsdb_proto_rawDescOnce sync.Once
+	file_tsdb_proto_rawDescData []byte
+)
+
+func file_tsdb_proto_rawDescGZIP() []byte {
+	file_tsdb_proto_rawDescOnce.Do(func() {
+		file_tsdb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tsdb_proto_rawDesc), len(file_tsdb_proto_rawDesc)))
+	})
+	return file_tsdb_proto_rawDescData
+}
+
+var file_tsdb_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_tsdb_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_tsdb_proto_goTypes = []any{
+	(MatchType)(0),         // 0: v3io.tsdb.v1.MatchType
+	(*Label)(nil),          // 1: v3io.tsdb.v1.Label
+	(*Sample)(nil),         // 2: v3io.tsdb.v1.Sample
+	(*LabelMatcher)(nil),   // 3: v3io.tsdb.v1.LabelMatcher
+	(*AppendSeries)(nil),   // 4: v3io.tsdb.v1.AppendSeries
+	(*AppendRequest)(nil),  // 5: v3io.tsdb.v1.AppendRequest
+	(*AppendError)(nil),    // 6: v3io.tsdb.v1.AppendError
+	(*AppendResponse)(nil), // 7: v3io.tsdb.v1.AppendResponse
+	(*SelectRequest)(nil),  // 8: v3io.tsdb.v1.SelectRequest
+	(*SelectResponse)(nil), // 9: v3io.tsdb.v1.SelectResponse
+	(*LabelsRequest)(nil),  // 10: v3io.tsdb.v1.LabelsRequest
+	(*LabelsResponse)(nil), // 11: v3io.tsdb.v1.LabelsResponse
+	(*SeriesRequest)(nil),  // 12: v3io.tsdb.v1.SeriesRequest
+	(*LabelSet)(nil),       // 13: v3io.tsdb.v1.LabelSet
+	(*SeriesResponse)(nil), // 14: v3io.tsdb.v1.SeriesResponse
+	(*InfoRequest)(nil),    // 15: v3io.tsdb.v1.InfoRequest
+	(*InfoResponse)(nil),   // 16: v3io.tsdb.v1.InfoResponse
+}
+var file_tsdb_proto_depIdxs = []int32{
+	0,  // 0: v3io.tsdb.v1.LabelMatcher.type:type_name -> v3io.tsdb.v1.MatchType
+	1,  // 1: v3io.tsdb.v1.AppendSeries.labels:type_name -> v3io.tsdb.v1.Label
+	2,  // 2: v3io.tsdb.v1.AppendSeries.samples:type_name -> v3io.tsdb.v1.Sample
+	4,  // 3: v3io.tsdb.v1.AppendRequest.series:type_name -> v3io.tsdb.v1.AppendSeries
+	6,  // 4: v3io.tsdb.v1.AppendResponse.errors:type_name -> v3io.tsdb.v1.AppendError
+	3,  // 5: v3io.tsdb.v1.SelectRequest.matchers:type_name -> v3io.tsdb.v1.LabelMatcher
+	1,  // 6: v3io.tsdb.v1.SelectResponse.labels:type_name -> v3io.tsdb.v1.Label
+	2,  // 7: v3io.tsdb.v1.SelectResponse.samples:type_name -> v3io.tsdb.v1.Sample
+	3,  // 8: v3io.tsdb.v1.LabelsRequest.matchers:type_name -> v3io.tsdb.v1.LabelMatcher
+	3,  // 9: v3io.tsdb.v1.SeriesRequest.matchers:type_name -> v3io.tsdb.v1.LabelMatcher
+	1,  // 10: v3io.tsdb.v1.LabelSet.labels:type_name -> v3io.tsdb.v1.Label
+	13, // 11: v3io.tsdb.v1.SeriesResponse.series:type_name -> v3io.tsdb.v1.LabelSet
+	5,  // 12: v3io.tsdb.v1.TSDB.Append:input_type -> v3io.tsdb.v1.AppendRequest
+	8,  // 13: v3io.tsdb.v1.TSDB.Select:input_type -> v3io.tsdb.v1.SelectRequest
+	10, // 14: v3io.tsdb.v1.TSDB.Labels:input_type -> v3io.tsdb.v1.LabelsRequest
+	12, // 15: v3io.tsdb.v1.TSDB.Series:input_type -> v3io.tsdb.v1.SeriesRequest
+	15, // 16: v3io.tsdb.v1.TSDB.Info:input_type -> v3io.tsdb.v1.InfoRequest
+	7,  // 17: v3io.tsdb.v1.TSDB.Append:output_type -> v3io.tsdb.v1.AppendResponse
+	9,  // 18: v3io.tsdb.v1.TSDB.Select:output_type -> v3io.tsdb.v1.SelectResponse
+	11, // 19: v3io.tsdb.v1.TSDB.Labels:output_type -> v3io.tsdb.v1.LabelsResponse
+	14, // 20: v3io.tsdb.v1.TSDB.Series:output_type -> v3io.tsdb.v1.SeriesResponse
+	16, // 21: v3io.tsdb.v1.TSDB.Info:output_type -> v3io.tsdb.v1.InfoResponse
+	17, // [17:22] is the sub-list for method output_type
+	12, // [12:17] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
+}
+
+func init() { file_tsdb_proto_init() }
+func file_tsdb_proto_init() {
+	if File_tsdb_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tsdb_proto_rawDesc), len(file_tsdb_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   16,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tsdb_proto_goTypes,
+		DependencyIndexes: file_tsdb_proto_depIdxs,
+		EnumInfos:         file_tsdb_proto_enumTypes,
+		MessageInfos:      file_tsdb_proto_msgTypes,
+	}.Build()
+	File_tsdb_proto = out.File
+	file_tsdb_proto_goTypes = nil
+	file_tsdb_proto_depIdxs = nil
+}
@@ -0,0 +1,305 @@
+//
+//Copyright 2018 Iguazio Systems Ltd.
+//
+//Licensed under the Apache License, Version 2.0 (the "License") with
+//an addition restriction as set forth herein. You may not use this
+//file except in compliance with the License. You may obtain a copy of
+//the License at http://www.apache.org/licenses/LICENSE-2.0.
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//implied. See the License for the specific language governing
+//permissions and limitations under the License.
+//
+//In addition, you may not use the software for any purposes that are
+//illegal under applicable law, and the grant of the foregoing license
+//under the Apache 2.0 license is conditioned upon your compliance with
+//such restriction.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.0
+// - protoc             v5.28.3
+// source: tsdb.proto
+
+package tsdbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TSDB_Append_FullMethodName = "/v3io.tsdb.v1.TSDB/Append"
+	TSDB_Select_FullMethodName = "/v3io.tsdb.v1.TSDB/Select"
+	TSDB_Labels_FullMethodName = "/v3io.tsdb.v1.TSDB/Labels"
+	TSDB_Series_FullMethodName = "/v3io.tsdb.v1.TSDB/Series"
+	TSDB_Info_FullMethodName   = "/v3io.tsdb.v1.TSDB/Info"
+)
+
+// TSDBClient is the client API for TSDB service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TSDB is the gRPC API of the TSDB, times are unix milliseconds
+type TSDBClient interface {
+	// Append appends a stream of samples, the response has the ref ID of every series (to append more samples
+	// with the ref instead of the labels) and the errors of the rejected series
+	Append(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AppendRequest, AppendResponse], error)
+	// Select streams the series which match the query, the samples of a series are sent in batches
+	Select(ctx context.Context, in *SelectRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SelectResponse], error)
+	// Labels returns the label names, or the values of a label
+	Labels(ctx context.Context, in *LabelsRequest, opts ...grpc.CallOption) (*LabelsResponse, error)
+	// Series returns the label sets of the series which match the matchers
+	Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (*SeriesResponse, error)
+	// Info returns the TSDB configuration
+	Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+}
+
+type tSDBClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTSDBClient(cc grpc.ClientConnInterface) TSDBClient {
+	return &tSDBClient{cc}
+}
+
+func (c *tSDBClient) Append(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AppendRequest, AppendResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TSDB_ServiceDesc.Streams[0], TSDB_Append_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AppendRequest, AppendResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TSDB_AppendClient = grpc.ClientStreamingClient[AppendRequest, AppendResponse]
+
+func (c *tSDBClient) Select(ctx context.Context, in *SelectRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SelectResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TSDB_ServiceDesc.Streams[1], TSDB_Select_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SelectRequest, SelectResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TSDB_SelectClient = grpc.ServerStreamingClient[SelectResponse]
+
+func (c *tSDBClient) Labels(ctx context.Context, in *LabelsRequest, opts ...grpc.CallOption) (*LabelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LabelsResponse)
+	err := c.cc.Invoke(ctx, TSDB_Labels_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tSDBClient) Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (*SeriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SeriesResponse)
+	err := c.cc.Invoke(ctx, TSDB_Series_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tSDBClient) Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InfoResponse)
+	err := c.cc.Invoke(ctx, TSDB_Info_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TSDBServer is the server API for TSDB service.
+// All implementations must embed UnimplementedTSDBServer
+// for forward compatibility.
+//
+// TSDB is the gRPC API of the TSDB, times are unix milliseconds
+type TSDBServer interface {
+	// Append appends a stream of samples, the response has the ref ID of every series (to append more samples
+	// with the ref instead of the labels) and the errors of the rejected series
+	Append(grpc.ClientStreamingServer[AppendRequest, AppendResponse]) error
+	// Select streams the series which match the query, the samples of a series are sent in batches
+	Select(*SelectRequest, grpc.ServerStreamingServer[SelectResponse]) error
+	// Labels returns the label names, or the values of a label
+	Labels(context.Context, *LabelsRequest) (*LabelsResponse, error)
+	// Series returns the label sets of the series which match the matchers
+	Series(context.Context, *SeriesRequest) (*SeriesResponse, error)
+	// Info returns the TSDB configuration
+	Info(context.Context, *InfoRequest) (*InfoResponse, error)
+	mustEmbedUnimplementedTSDBServer()
+}
+
+// UnimplementedTSDBServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTSDBServer struct{}
+
+func (UnimplementedTSDBServer) Append(grpc.ClientStreamingServer[AppendRequest, AppendResponse]) error {
+	return status.Error(codes.Unimplemented, "method Append not implemented")
+}
+func (UnimplementedTSDBServer) Select(*SelectRequest, grpc.ServerStreamingServer[SelectResponse]) error {
+	return status.Error(codes.Unimplemented, "method Select not implemented")
+}
+func (UnimplementedTSDBServer) Labels(context.Context, *LabelsRequest) (*LabelsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Labels not implemented")
+}
+func (UnimplementedTSDBServer) Series(context.Context, *SeriesRequest) (*SeriesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Series not implemented")
+}
+func (UnimplementedTSDBServer) Info(context.Context, *InfoRequest) (*InfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Info not implemented")
+}
+func (UnimplementedTSDBServer) mustEmbedUnimplementedTSDBServer() {}
+func (UnimplementedTSDBServer) testEmbeddedByValue()              {}
+
+// UnsafeTSDBServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TSDBServer will
+// result in compilation errors.
+type UnsafeTSDBServer interface {
+	mustEmbedUnimplementedTSDBServer()
+}
+
+func RegisterTSDBServer(s grpc.ServiceRegistrar, srv TSDBServer) {
+	// If the following call panics, it indicates UnimplementedTSDBServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TSDB_ServiceDesc, srv)
+}
+
+func _TSDB_Append_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TSDBServer).Append(&grpc.GenericServerStream[AppendRequest, AppendResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TSDB_AppendServer = grpc.ClientStreamingServer[AppendRequest, AppendResponse]
+
+func _TSDB_Select_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SelectRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TSDBServer).Select(m, &grpc.GenericServerStream[SelectRequest, SelectResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TSDB_SelectServer = grpc.ServerStreamingServer[SelectResponse]
+
+func _TSDB_Labels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TSDBServer).Labels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TSDB_Labels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TSDBServer).Labels(ctx, req.(*LabelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TSDB_Series_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TSDBServer).Series(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TSDB_Series_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TSDBServer).Series(ctx, req.(*SeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TSDB_Info_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TSDBServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TSDB_Info_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TSDBServer).Info(ctx, req.(*InfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TSDB_ServiceDesc is the grpc.ServiceDesc for TSDB service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TSDB_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v3io.tsdb.v1.TSDB",
+	HandlerType: (*TSDBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Labels",
+			Handler:    _TSDB_Labels_Handler,
+		},
+		{
+			MethodName: "Series",
+			Handler:    _TSDB_Series_Handler,
+		},
+		{
+			MethodName: "Info",
+			Handler:    _TSDB_Info_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Append",
+			Handler:       _TSDB_Append_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Select",
+			Handler:       _TSDB_Select_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tsdb.proto",
+}
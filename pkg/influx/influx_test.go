@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParseLine(t *testing.T) {
+	p, err := ParseLine(`cpu\ load,host=a\,b,dc=eu\=1 usage=0.5,cores=8i,up=true,name="x \"y\", z",big=5u 1500000000000000000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Point{
+		Measurement: "cpu load",
+		Tags:        []Tag{{"host", "a,b"}, {"dc", "eu=1"}},
+		Fields:      []Field{{"usage", 0.5}, {"cores", 8}, {"up", 1}, {"big", 5}},
+		Skipped:     1,
+		Time:        1500000000000000000,
+		HasTime:     true,
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Fatalf("wrong point %+v", p)
+	}
+
+	if p, err = ParseLine("mem value=-1.5e3"); err != nil || p.HasTime || p.Fields[0].Value != -1500 {
+		t.Fatalf("wrong point %+v %v", p, err)
+	}
+
+	for _, line := range []string{
+		"cpu", "cpu ", ",host=a value=1", "cpu,host value=1", "cpu,host= value=1", "cpu value=abc",
+		"cpu value=1i2", `cpu s="abc`, "cpu value=1 12:00", "cpu value=1 1 2", "cpu value",
+	} {
+		if _, err := ParseLine(line); err == nil {
+			t.Fatalf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestMapper(t *testing.T) {
+	p, _ := ParseLine("disk.io,host=a,dc=eu,device=sda1 reads=1,value=2")
+
+	m := NewMapper(nil)
+	if lset := m.Labels(p, "reads"); lset.String() != `{__name__="disk_io_reads", dc="eu", device="sda1", host="a"}` {
+		t.Fatalf("wrong default labels %s", lset)
+	}
+	if lset := m.Labels(p, "value"); lset.Get("__name__") != "disk_io" {
+		t.Fatalf("wrong value field labels %s", lset)
+	}
+
+	m = NewMapper(&config.InfluxConfig{Prefix: "tg_", Separator: ":", ValueField: "value", FieldLabel: "field",
+		DropTags: []string{"dc"}, RenameTags: map[string]string{"host": "instance"}})
+	if lset := m.Labels(p, "reads"); lset.String() != `{__name__="tg_disk_io", device="sda1", field="reads", instance="a"}` {
+		t.Fatalf("wrong configured labels %s", lset)
+	}
+}
+
+func TestWriteHandler(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &tsdbtest.Appender{}
+	full := false
+	handler := NewWriteHandler(logger, app, func() bool { return full }, nil)
+
+	post := func(path, body string, gz bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if gz {
+			buf := &bytes.Buffer{}
+			w := gzip.NewWriter(buf)
+			w.Write([]byte(body))
+			w.Close()
+			req = httptest.NewRequest(http.MethodPost, path, buf)
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/write?precision=s", "# comment\ncpu,host=a usage=1,idle=2 1500000000\n\nmem value=3 1500000001\n", false)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	cpu := utils.FromStrings("__name__", "cpu_usage", "host", "a")
+	samples := app.Samples()
+	if len(samples) != 3 || !reflect.DeepEqual(samples[0], tsdbtest.Sample{Lset: cpu, Ref: 1, T: 1500000000000, V: 1}) ||
+		samples[2].T != 1500000001000 || samples[2].Lset.Get("__name__") != "mem" {
+		t.Fatalf("wrong samples %v", samples)
+	}
+
+	// default nanosecond precision, gzip body, per line errors (the valid lines are still written)
+	app.Reset()
+	rec = post("/api/v2/write", "cpu usage=1 1500000000123456789\ncpu usage=x\n9cpu,host=a usage=2", true)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "1 of 3 lines failed: line 2:") {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	samples = app.Samples()
+	if len(samples) != 2 || samples[0].T != 1500000000123 || samples[1].Lset.Get("__name__") != "_9cpu_usage" {
+		t.Fatalf("wrong samples %v", samples)
+	}
+
+	if rec = post("/write?precision=xs", "cpu usage=1", false); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid precision error, got %d", rec.Code)
+	}
+	full = true
+	if rec = post("/write", "cpu usage=1", false); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected backpressure, got %d", rec.Code)
+	}
+}
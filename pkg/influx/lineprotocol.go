@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package influx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tag is a point tag (key/value)
+type Tag struct {
+	Key, Value string
+}
+
+// Field is a numeric point field, booleans are 1/0
+type Field struct {
+	Key   string
+	Value float64
+}
+
+// Point is a parsed line of the InfluxDB line protocol, string fields are not stored (counted in Skipped)
+type Point struct {
+	Measurement string
+	Tags        []Tag
+	Fields      []Field
+	Skipped     int
+	Time        int64 // in the request precision
+	HasTime     bool
+}
+
+// scan a token until one of the (unescaped) stop characters, backslash escapes the escapable characters
+func scanToken(line string, pos int, stops, escapable string) (string, int) {
+	var sb strings.Builder
+	for pos < len(line) {
+		c := line[pos]
+		if c == '\\' && pos+1 < len(line) && strings.IndexByte(escapable, line[pos+1]) >= 0 {
+			sb.WriteByte(line[pos+1])
+			pos += 2
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		sb.WriteByte(c)
+		pos++
+	}
+	return sb.String(), pos
+}
+
+// ParseLine parses a line in the form: measurement[,tag=value...] field=value[,field=value...] [timestamp]
+func ParseLine(line string) (*Point, error) {
+	p := &Point{}
+	measurement, pos := scanToken(line, 0, ", ", ", ")
+	if measurement == "" {
+		return nil, fmt.Errorf("missing measurement")
+	}
+	p.Measurement = measurement
+
+	for pos < len(line) && line[pos] == ',' {
+		var key, value string
+		key, pos = scanToken(line, pos+1, "=, ", ",= ")
+		if pos >= len(line) || line[pos] != '=' || key == "" {
+			return nil, fmt.Errorf("invalid tag %q", key)
+		}
+		value, pos = scanToken(line, pos+1, ", ", ",= ")
+		if value == "" {
+			return nil, fmt.Errorf("missing value of tag %s", key)
+		}
+		p.Tags = append(p.Tags, Tag{Key: key, Value: value})
+	}
+
+	if pos >= len(line) || line[pos] != ' ' {
+		return nil, fmt.Errorf("missing fields")
+	}
+	for pos < len(line) && line[pos] == ' ' {
+		pos++
+	}
+
+	for {
+		var key string
+		key, pos = scanToken(line, pos, "=, ", ",= ")
+		if pos >= len(line) || line[pos] != '=' || key == "" {
+			return nil, fmt.Errorf("invalid field %q", key)
+		}
+		pos++
+
+		if pos < len(line) && line[pos] == '"' {
+			// string fields can't be stored, skip to the closing quote
+			end := pos + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string field %s", key)
+			}
+			pos = end + 1
+			p.Skipped++
+		} else {
+			var raw string
+			raw, pos = scanToken(line, pos, ", ", "")
+			value, err := parseFieldValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", key, err)
+			}
+			p.Fields = append(p.Fields, Field{Key: key, Value: value})
+		}
+
+		if pos >= len(line) || line[pos] != ',' {
+			break
+		}
+		pos++
+	}
+
+	rest := strings.TrimSpace(line[pos:])
+	if pos < len(line) && line[pos] != ' ' {
+		return nil, fmt.Errorf("invalid fields at %q", line[pos:])
+	}
+	if rest != "" {
+		t, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", rest)
+		}
+		p.Time, p.HasTime = t, true
+	}
+	return p, nil
+}
+
+// parse a numeric field value: float, integer (1i), unsigned (1u) or boolean
+func parseFieldValue(raw string) (float64, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+
+	if strings.HasSuffix(raw, "i") {
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", raw)
+		}
+		return float64(v), nil
+	}
+	if strings.HasSuffix(raw, "u") {
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		return float64(v), nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", raw)
+	}
+	return v, nil
+}
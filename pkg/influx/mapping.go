@@ -0,0 +1,74 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package influx
+
+import (
+	"sort"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// Mapper maps the point fields to metrics, the metric name is <prefix><measurement><separator><field> (or the
+// measurement alone for the value field, or with the field in a label) and the tags are the labels
+type Mapper struct {
+	cfg  config.InfluxConfig
+	drop map[string]bool
+}
+
+func NewMapper(cfg *config.InfluxConfig) *Mapper {
+	m := &Mapper{cfg: config.InfluxConfig{Separator: "_", ValueField: "value"}, drop: map[string]bool{}}
+	if cfg != nil {
+		m.cfg = *cfg
+	}
+	for _, tag := range m.cfg.DropTags {
+		m.drop[tag] = true
+	}
+	return m
+}
+
+// Labels returns the (sorted) label set of a point field
+func (m *Mapper) Labels(p *Point, field string) utils.Labels {
+	lset := make(utils.Labels, 0, len(p.Tags)+2)
+
+	name := m.cfg.Prefix + p.Measurement
+	switch {
+	case m.cfg.FieldLabel != "":
+		lset = append(lset, utils.Label{Name: m.cfg.FieldLabel, Value: field})
+	case field != m.cfg.ValueField:
+		name += m.cfg.Separator + field
+	}
+	lset = append(lset, utils.Label{Name: utils.MetricName, Value: utils.SanitizeLabelName(name)})
+
+	for _, tag := range p.Tags {
+		if m.drop[tag.Key] {
+			continue
+		}
+		key := tag.Key
+		if renamed, ok := m.cfg.RenameTags[key]; ok {
+			key = renamed
+		}
+		lset = append(lset, utils.Label{Name: utils.SanitizeLabelName(key), Value: tag.Value})
+	}
+
+	sort.Sort(lset)
+	return lset
+}
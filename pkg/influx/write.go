@@ -0,0 +1,157 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package influx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const (
+	// max size of a (decompressed) request body
+	maxBodySize = 64 << 20
+	// max number of line errors listed in a response
+	maxReportedErrors = 10
+)
+
+// timestamp precisions (v1 and v2 names), as the divisor (or negative multiplier) to milliseconds
+var precisions = map[string]int64{
+	"": 1000000, "n": 1000000, "ns": 1000000, "u": 1000, "us": 1000, "ms": 1,
+	"s": -1000, "m": -60 * 1000, "h": -3600 * 1000,
+}
+
+// WriteHandler is an http.Handler for the InfluxDB line protocol write endpoint (/write and /api/v2/write),
+// every numeric field of a point is appended to a metric through the TSDB Appender
+type WriteHandler struct {
+	logger   logger.Logger
+	appender tsdb.Appender
+	full     func() bool
+	mapper   *Mapper
+}
+
+// NewWriteHandler creates a line protocol write handler, full() reports when the appender queue is full
+// so the request is rejected with 429 (Too Many Requests)
+func NewWriteHandler(logger logger.Logger, appender tsdb.Appender, full func() bool,
+	cfg *config.InfluxConfig) *WriteHandler {
+	return &WriteHandler{logger: logger, appender: appender, full: full, mapper: NewMapper(cfg)}
+}
+
+// errors are returned in the InfluxDB format, {"error": "..."}
+func writeError(w http.ResponseWriter, status int, msg string) {
+	body, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", msg)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (h *WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if utils.QueueFull(w, h.full) {
+		writeError(w, http.StatusTooManyRequests, "append queue is full")
+		return
+	}
+
+	precision, ok := precisions[r.URL.Query().Get("precision")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid precision, use ns, us, ms, s, m or h")
+		return
+	}
+
+	buf, status, err := utils.ReadBody(r, maxBodySize)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	var errs []string
+	var writeStatus utils.WriteStatus
+	lines := 0
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		lines++
+
+		invalid, err := h.write(line, precision, now)
+		if err == nil {
+			continue
+		}
+		writeStatus.Fail(invalid)
+		errs = append(errs, fmt.Sprintf("line %d: %v", i+1, err))
+	}
+
+	status = writeStatus.Code(http.StatusNoContent)
+	if len(errs) == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	h.logger.WarnWith("Line protocol write failed for some lines", "failed", len(errs), "lines", lines,
+		"first", errs[0])
+	msg := fmt.Sprintf("partial write: %d of %d lines failed: ", len(errs), lines)
+	if len(errs) > maxReportedErrors {
+		errs = append(errs[:maxReportedErrors], "...")
+	}
+	writeError(w, status, msg+strings.Join(errs, "; "))
+}
+
+// append the fields of one line, returns true if the line itself is invalid
+func (h *WriteHandler) write(line string, precision, now int64) (bool, error) {
+	p, err := ParseLine(line)
+	if err != nil {
+		return true, err
+	}
+
+	t := now
+	if p.HasTime {
+		t = p.Time / precision
+		if precision < 0 {
+			t = p.Time * -precision
+		}
+	}
+
+	for _, field := range p.Fields {
+		lset := h.mapper.Labels(p, field.Key)
+		if err := lset.Validate(); err != nil {
+			return true, err
+		}
+		if _, err := h.appender.Add(lset, t, field.Value); err != nil {
+			return false, fmt.Errorf("failed to append to %s: %v", lset, err)
+		}
+	}
+	return false, nil
+}
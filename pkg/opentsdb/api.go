@@ -0,0 +1,311 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// max size of a (decompressed) request body
+const maxBodySize = 64 << 20
+
+// Handler serves the OpenTSDB HTTP API put (/api/put) and query (/api/query) endpoints, metrics are stored with
+// the (sanitized) OpenTSDB metric name as the metric name and the tags as labels
+type Handler struct {
+	logger   logger.Logger
+	appender tsdb.Appender
+	full     func() bool
+	querier  func(ctx context.Context, mint, maxt int64) (querier.Querier, error)
+	mux      *http.ServeMux
+}
+
+// NewHandler creates the API handler, full() reports when the appender queue is full (put is rejected with 429)
+// and newQuerier creates a querier for a time range (e.g. tsdb.V3ioAdapter.Querier)
+func NewHandler(logger logger.Logger, appender tsdb.Appender, full func() bool,
+	newQuerier func(ctx context.Context, mint, maxt int64) (*querier.V3ioQuerier, error)) *Handler {
+	return newHandler(logger, appender, full, func(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+		return newQuerier(ctx, mint, maxt)
+	})
+}
+
+func newHandler(logger logger.Logger, appender tsdb.Appender, full func() bool,
+	newQuerier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)) *Handler {
+	h := &Handler{logger: logger, appender: appender, full: full, querier: newQuerier, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/api/put", h.put)
+	h.mux.HandleFunc("/api/query", h.query)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// errors are returned in the OpenTSDB format, {"error": {"code": <status>, "message": "..."}}
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"error": map[string]interface{}{"code": status, "message": msg}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body = []byte(fmt.Sprintf(`{"error":{"code":500,"message":%q}}`, err.Error()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// a put data point, the value can be a number or a numeric string and the timestamp is in seconds or
+// milliseconds
+type dataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp json.Number       `json:"timestamp"`
+	Value     interface{}       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type putError struct {
+	DataPoint *dataPoint `json:"datapoint"`
+	Error     string     `json:"error"`
+}
+
+type putSummary struct {
+	Success int         `json:"success"`
+	Failed  int         `json:"failed"`
+	Errors  *[]putError `json:"errors,omitempty"`
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if utils.QueueFull(w, h.full) {
+		writeError(w, http.StatusTooManyRequests, "append queue is full")
+		return
+	}
+
+	buf, status, err := utils.ReadBody(r, maxBodySize)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	// a single data point or an array of data points
+	var points []*dataPoint
+	trimmed := strings.TrimSpace(string(buf))
+	if strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(buf, &points)
+	} else {
+		p := &dataPoint{}
+		err = json.Unmarshal(buf, p)
+		points = []*dataPoint{p}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid data points: "+err.Error())
+		return
+	}
+
+	summary := putSummary{}
+	errs := []putError{}
+	var writeStatus utils.WriteStatus
+	for _, p := range points {
+		invalid, err := h.write(p)
+		if err == nil {
+			summary.Success++
+			continue
+		}
+		writeStatus.Fail(invalid)
+		summary.Failed++
+		errs = append(errs, putError{DataPoint: p, Error: err.Error()})
+	}
+
+	if summary.Failed > 0 {
+		h.logger.WarnWith("OpenTSDB put failed for some data points", "failed", summary.Failed,
+			"points", len(points), "first", errs[0].Error)
+	}
+
+	status = writeStatus.Code(http.StatusNoContent)
+	query := r.URL.Query()
+	_, details := query["details"]
+	_, summarize := query["summary"]
+	switch {
+	case details:
+		summary.Errors = &errs
+		fallthrough
+	case summarize:
+		if status == http.StatusNoContent {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, summary)
+	case summary.Failed > 0:
+		writeError(w, status, fmt.Sprintf("%d of %d data points had errors: %s (add details to the request for all the errors)",
+			summary.Failed, len(points), errs[0].Error))
+	default:
+		w.WriteHeader(status)
+	}
+}
+
+// append a data point, returns true if the data point itself is invalid
+func (h *Handler) write(p *dataPoint) (bool, error) {
+	if p.Metric == "" {
+		return true, fmt.Errorf("missing metric")
+	}
+
+	var v float64
+	var err error
+	switch value := p.Value.(type) {
+	case float64:
+		v = value
+	case string:
+		v, err = strconv.ParseFloat(value, 64)
+	default:
+		err = fmt.Errorf("missing value")
+	}
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return true, fmt.Errorf("invalid value %v", p.Value)
+	}
+
+	t, err := strconv.ParseInt(p.Timestamp.String(), 10, 64)
+	if err != nil || t <= 0 {
+		return true, fmt.Errorf("invalid timestamp %q", p.Timestamp)
+	}
+	t = toMillis(t)
+
+	lset := utils.Labels{{Name: utils.MetricName, Value: utils.SanitizeLabelName(p.Metric)}}
+	for key, value := range p.Tags {
+		if key == "" || value == "" {
+			return true, fmt.Errorf("invalid tag %s=%s", key, value)
+		}
+		lset = append(lset, utils.Label{Name: utils.SanitizeLabelName(key), Value: value})
+	}
+	sort.Sort(lset)
+	if err := lset.Validate(); err != nil {
+		return true, err
+	}
+
+	if _, err := h.appender.Add(lset, t, v); err != nil {
+		return false, fmt.Errorf("failed to append to %s: %v", lset, err)
+	}
+	return false, nil
+}
+
+func (h *Handler) query(w http.ResponseWriter, r *http.Request) {
+	var req *queryRequest
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		req, err = parseQueryParams(r.URL.Query())
+	case http.MethodPost:
+		var buf []byte
+		var status int
+		if buf, status, err = utils.ReadBody(r, maxBodySize); err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		req, err = parseQueryBody(buf)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loc := time.Local
+	if req.Timezone != "" {
+		if loc, err = time.LoadLocation(req.Timezone); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid timezone %q", req.Timezone))
+			return
+		}
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if req.Start == nil || req.Start == "" {
+		writeError(w, http.StatusBadRequest, "missing start time")
+		return
+	}
+	start, err := parseTime(req.Start, now, loc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start: "+err.Error())
+		return
+	}
+	end := now
+	if req.End != nil && req.End != "" {
+		if end, err = parseTime(req.End, now, loc); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid end: "+err.Error())
+			return
+		}
+	}
+	if end < start {
+		writeError(w, http.StatusBadRequest, "end time is before the start time")
+		return
+	}
+	if len(req.Queries) == 0 {
+		writeError(w, http.StatusBadRequest, "missing sub queries")
+		return
+	}
+
+	plans := make([]*plan, len(req.Queries))
+	for i, q := range req.Queries {
+		if plans[i], err = newPlan(q); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	results := []*result{}
+	for _, p := range plans {
+		res, err := h.run(r.Context(), p, start, end, req.MsResolution)
+		if err != nil {
+			h.logger.ErrorWith("OpenTSDB query failed", "metric", p.query.Metric, "err", err)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		results = append(results, res...)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// OpenTSDB timestamps are in seconds (up to 10 digits) or milliseconds
+func toMillis(t int64) int64 {
+	if t < 1e10 {
+		return t * 1000
+	}
+	return t
+}
@@ -0,0 +1,427 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package opentsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const aggregatorLabel = "Aggregator"
+
+type point struct {
+	t int64
+	v float64
+}
+
+type series struct {
+	lset   utils.Labels
+	points []point
+}
+
+// an aggregator across series, interpolating aggregators use the linear interpolation of the series without a
+// point at a time (between its first and last points), the others (zimsum, mimmin, mimmax, count, first and
+// last) only the series points at that time
+type aggregator struct {
+	reduce      func(values []float64) float64
+	interpolate bool
+}
+
+var aggregators = map[string]*aggregator{
+	"sum":    {reduce: sum, interpolate: true},
+	"zimsum": {reduce: sum},
+	"avg":    {reduce: avg, interpolate: true},
+	"min":    {reduce: min, interpolate: true},
+	"mimmin": {reduce: min},
+	"max":    {reduce: max, interpolate: true},
+	"mimmax": {reduce: max},
+	"count":  {reduce: func(values []float64) float64 { return float64(len(values)) }},
+	"first":  {reduce: func(values []float64) float64 { return values[0] }},
+	"last":   {reduce: func(values []float64) float64 { return values[len(values)-1] }},
+	"dev":    {reduce: dev, interpolate: true},
+	"median": {reduce: percentile(0.5), interpolate: true},
+	"p50":    {reduce: percentile(0.5), interpolate: true},
+	"p75":    {reduce: percentile(0.75), interpolate: true},
+	"p90":    {reduce: percentile(0.9), interpolate: true},
+	"p95":    {reduce: percentile(0.95), interpolate: true},
+	"p99":    {reduce: percentile(0.99), interpolate: true},
+	"p999":   {reduce: percentile(0.999), interpolate: true},
+}
+
+func sum(values []float64) float64 {
+	s := 0.0
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+func avg(values []float64) float64 {
+	return sum(values) / float64(len(values))
+}
+
+func min(values []float64) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+func max(values []float64) float64 {
+	m := values[0]
+	for _, v := range values[1:] {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+func dev(values []float64) float64 {
+	mean := avg(values)
+	s := 0.0
+	for _, v := range values {
+		s += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(s / float64(len(values)))
+}
+
+// nearest rank percentile
+func percentile(q float64) func(values []float64) float64 {
+	return func(values []float64) float64 {
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return sorted[rank]
+	}
+}
+
+// a query result
+type result struct {
+	Metric        string            `json:"metric"`
+	Tags          map[string]string `json:"tags"`
+	AggregateTags []string          `json:"aggregateTags"`
+	Dps           dataPoints        `json:"dps"`
+}
+
+// data points are encoded as {"<unix seconds or milliseconds>": <value>, ...}, NaN values as null
+type dataPoints struct {
+	points []point
+	ms     bool
+}
+
+func (d dataPoints) MarshalJSON() ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, p := range d.points {
+		t := p.t
+		if !d.ms {
+			t /= 1000
+			// keep the last value of a second
+			if i+1 < len(d.points) && d.points[i+1].t/1000 == t {
+				continue
+			}
+		}
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(`"` + strconv.FormatInt(t, 10) + `":`)
+		if math.IsNaN(p.v) || math.IsInf(p.v, 0) {
+			sb.WriteString("null")
+		} else {
+			sb.WriteString(strconv.FormatFloat(p.v, 'f', -1, 64))
+		}
+	}
+	sb.WriteByte('}')
+	return []byte(sb.String()), nil
+}
+
+// how a sub query is run, the querier selects the matching series (downsampled with the interval function)
+// and the series are aggregated here
+type plan struct {
+	query      *subQuery
+	params     *querier.SelectParams
+	aggregator *aggregator // nil for none
+	groupBy    []string
+	downsample *downsample
+}
+
+func newPlan(q *subQuery) (*plan, error) {
+	p := &plan{query: q, aggregator: aggregators[q.Aggregator]}
+	if q.Aggregator != "none" && p.aggregator == nil {
+		return nil, fmt.Errorf("invalid aggregator %q", q.Aggregator)
+	}
+	if q.Metric == "" {
+		return nil, fmt.Errorf("missing metric")
+	}
+
+	matcher, err := utils.NewLabelMatcher(utils.MatchEqual, utils.MetricName, utils.SanitizeLabelName(q.Metric))
+	if err != nil {
+		return nil, err
+	}
+	p.params = &querier.SelectParams{Matchers: []*utils.LabelMatcher{matcher}}
+	for _, f := range q.Filters {
+		m, err := f.matcher()
+		if err != nil {
+			return nil, err
+		}
+		p.params.Matchers = append(p.params.Matchers, m)
+		if f.GroupBy {
+			p.groupBy = append(p.groupBy, m.Name)
+		}
+	}
+
+	// downsampling is done by the querier (the aggregate of each interval), 0all reduces the raw samples
+	if q.Downsample != "" {
+		if p.downsample, err = parseDownsample(q.Downsample); err != nil {
+			return nil, err
+		}
+		if p.downsample.interval > 0 {
+			p.params.Functions = downsampleFunctions[p.downsample.fn]
+			p.params.Step = p.downsample.interval
+			p.params.Fill = p.downsample.fill
+		}
+	}
+	return p, nil
+}
+
+// run a sub query over [start, end]
+func (h *Handler) run(ctx context.Context, p *plan, start, end int64, ms bool) ([]*result, error) {
+	q, ds := p.query, p.downsample
+	list, err := h.selectSeries(ctx, p.params, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range list {
+		if ds != nil && ds.interval == 0 {
+			s.points = reduceAll(s.points, aggregators[ds.fn].reduce, start)
+		}
+		if q.Rate {
+			s.points = rate(s.points, q.RateOptions)
+		}
+	}
+
+	var results []*result
+	if p.aggregator == nil {
+		for _, s := range list {
+			if len(s.points) > 0 {
+				results = append(results, &result{Metric: q.Metric, Tags: s.lset.Map(), AggregateTags: []string{},
+					Dps: dataPoints{points: s.points, ms: ms}})
+			}
+		}
+	} else {
+		for _, group := range groupSeries(list, p.groupBy) {
+			tags, aggregated := commonTags(group)
+			points := aggregate(group, p.aggregator)
+			if len(points) > 0 {
+				results = append(results, &result{Metric: q.Metric, Tags: tags, AggregateTags: aggregated,
+					Dps: dataPoints{points: points, ms: ms}})
+			}
+		}
+	}
+	return results, nil
+}
+
+// select the series points in [start, end], labels without the metric name and Aggregator
+func (h *Handler) selectSeries(ctx context.Context, params *querier.SelectParams, start, end int64) ([]*series, error) {
+	q, err := h.querier(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	set, err := q.SelectQry(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*series
+	for set.Next() {
+		s := &series{lset: utils.NewBuilder(set.At().Labels()).Del(utils.MetricName, aggregatorLabel).Labels()}
+		sort.Sort(s.lset)
+		iter := set.At().Iterator()
+		for iter.Next() {
+			t, v := iter.At()
+			if t >= start-params.Step && t <= end && !utils.IsStaleNaN(v) {
+				s.points = append(s.points, point{t: t, v: v})
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, set.Err()
+}
+
+// reduce all the points to a single point at start
+func reduceAll(points []point, reduce func(values []float64) float64, start int64) []point {
+	var values []float64
+	for _, p := range points {
+		if !math.IsNaN(p.v) {
+			values = append(values, p.v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return []point{{t: start, v: reduce(values)}}
+}
+
+// the per second rate of change between consecutive points, counters handle resets (a decrease) by wrapping
+// around counterMax (or from 0 when it's not set) or drop them, rates above resetValue are reported as 0
+func rate(points []point, opts *rateOptions) []point {
+	if opts == nil {
+		opts = &rateOptions{}
+	}
+	var res []point
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if math.IsNaN(prev.v) || math.IsNaN(cur.v) {
+			continue
+		}
+		delta := cur.v - prev.v
+		if opts.Counter && delta < 0 {
+			if opts.DropResets {
+				continue
+			}
+			delta = cur.v
+			if opts.CounterMax > 0 {
+				delta = opts.CounterMax - prev.v + cur.v
+			}
+		}
+		r := delta / (float64(cur.t-prev.t) / 1000)
+		if opts.ResetValue > 0 && r > opts.ResetValue {
+			r = 0
+		}
+		res = append(res, point{t: cur.t, v: r})
+	}
+	return res
+}
+
+// group the series by the values of the group by tags
+func groupSeries(list []*series, groupBy []string) [][]*series {
+	groups := map[string][]*series{}
+	var keys []string
+	for _, s := range list {
+		values := make([]string, len(groupBy))
+		for i, name := range groupBy {
+			values[i] = s.lset.Get(name)
+		}
+		key := strings.Join(values, "\xff")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], s)
+	}
+
+	sort.Strings(keys)
+	res := make([][]*series, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, groups[key])
+	}
+	return res
+}
+
+// the tags with the same value in all the series of a group, and the other (aggregated) tag names
+func commonTags(group []*series) (map[string]string, []string) {
+	tags := group[0].lset.Map()
+	names := map[string]bool{}
+	for _, s := range group {
+		for _, l := range s.lset {
+			names[l.Name] = true
+		}
+	}
+	for _, s := range group[1:] {
+		for name, value := range tags {
+			if s.lset.Get(name) != value {
+				delete(tags, name)
+			}
+		}
+	}
+
+	aggregated := []string{}
+	for name := range names {
+		if _, ok := tags[name]; !ok {
+			aggregated = append(aggregated, name)
+		}
+	}
+	sort.Strings(aggregated)
+	return tags, aggregated
+}
+
+// aggregate the series of a group at every point time of the group
+func aggregate(group []*series, agg *aggregator) []point {
+	var times []int64
+	for _, s := range group {
+		for _, p := range s.points {
+			times = append(times, p.t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	var res []point
+	next := make([]int, len(group)) // index of the first point at or after the current time, per series
+	for i, t := range times {
+		if i > 0 && times[i-1] == t {
+			continue
+		}
+
+		var values []float64
+		hasNaN := false
+		for j, s := range group {
+			for next[j] < len(s.points) && s.points[next[j]].t < t {
+				next[j]++
+			}
+			k := next[j]
+			switch {
+			case k < len(s.points) && s.points[k].t == t:
+				if math.IsNaN(s.points[k].v) {
+					hasNaN = true
+				} else {
+					values = append(values, s.points[k].v)
+				}
+			case agg.interpolate && k > 0 && k < len(s.points):
+				before, after := s.points[k-1], s.points[k]
+				if !math.IsNaN(before.v) && !math.IsNaN(after.v) {
+					values = append(values, before.v+(after.v-before.v)*float64(t-before.t)/float64(after.t-before.t))
+				}
+			}
+		}
+
+		if len(values) > 0 {
+			res = append(res, point{t: t, v: agg.reduce(values)})
+		} else if hasNaN {
+			res = append(res, point{t: t, v: math.NaN()})
+		}
+	}
+	return res
+}
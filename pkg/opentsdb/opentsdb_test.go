@@ -0,0 +1,263 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package opentsdb
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParseSubQuery(t *testing.T) {
+	q, err := parseSubQuery("sum:1h-avg-zero:rate{counter,100,5}:sys.cpu.user{host=*,dc=lga|lgb}{env=literal_or(prod)}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &subQuery{
+		Aggregator: "sum", Metric: "sys.cpu.user", Downsample: "1h-avg-zero", Rate: true,
+		RateOptions: &rateOptions{Counter: true, CounterMax: 100, ResetValue: 5},
+		Filters: []*filter{
+			{Type: "wildcard", Tagk: "host", Filter: "*", GroupBy: true},
+			{Type: "literal_or", Tagk: "dc", Filter: "lga|lgb", GroupBy: true},
+			{Type: "literal_or", Tagk: "env", Filter: "prod"},
+		},
+	}
+	if !reflect.DeepEqual(q, expected) {
+		t.Fatalf("wrong query %+v", q)
+	}
+
+	if q, err = parseSubQuery("max:cpu"); err != nil || q.Metric != "cpu" || q.Filters != nil {
+		t.Fatalf("wrong query %+v %v", q, err)
+	}
+
+	for _, m := range []string{"cpu", "sum:cpu{host}", "sum:cpu{host=a", "sum:rate{x}:cpu", "sum:cpu{a=b}{c=d}{e=f}"} {
+		if _, err := parseSubQuery(m); err == nil {
+			t.Fatalf("expected an error for %q", m)
+		}
+	}
+}
+
+func TestFilterMatchers(t *testing.T) {
+	lset := utils.FromStrings("host", "web01.lga", "dc", "LGA")
+	for _, test := range []struct {
+		f     filter
+		match bool
+	}{
+		{filter{Type: "literal_or", Filter: "web01.lga|web02"}, true},
+		{filter{Type: "literal_or", Filter: "web01xlga"}, false},
+		{filter{Type: "not_literal_or", Filter: "web02"}, true},
+		{filter{Type: "wildcard", Filter: "*.lga"}, true},
+		{filter{Type: "wildcard", Filter: "web0*"}, true},
+		{filter{Type: "wildcard", Filter: "*.ewr"}, false},
+		{filter{Type: "regexp", Filter: "b0[0-9]"}, true},
+		{filter{Type: "iliteral_or", Tagk: "dc", Filter: "lga"}, true},
+		{filter{Type: "literal_or", Tagk: "dc", Filter: "lga"}, false},
+	} {
+		if test.f.Tagk == "" {
+			test.f.Tagk = "host"
+		}
+		m, err := test.f.matcher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if utils.MatchLabels(lset, []*utils.LabelMatcher{m}) != test.match {
+			t.Fatalf("wrong match of %+v", test.f)
+		}
+	}
+
+	if _, err := (&filter{Type: "unknown", Tagk: "host", Filter: "a"}).matcher(); err == nil {
+		t.Fatalf("expected an error for an unknown filter type")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := int64(1500000000000)
+	for _, test := range []struct {
+		v        interface{}
+		expected int64
+	}{
+		{"1h-ago", now - 3600*1000},
+		{"2w-ago", now - 14*24*3600*1000},
+		{1400000000.0, 1400000000000},
+		{"1400000000123", 1400000000123},
+		{"2017/07/14-02:40:00", 1500000000000},
+		{"2017/07/14", 1499990400000},
+	} {
+		if ts, err := parseTime(test.v, now, time.UTC); err != nil || ts != test.expected {
+			t.Fatalf("wrong time of %v: %d %v", test.v, ts, err)
+		}
+	}
+	for _, v := range []interface{}{"1x-ago", "yesterday", true} {
+		if _, err := parseTime(v, now, time.UTC); err == nil {
+			t.Fatalf("expected an error for %v", v)
+		}
+	}
+
+	ds, err := parseDownsample("5m-dev-nan")
+	if err != nil || ds.interval != 5*60*1000 || ds.fn != "dev" || ds.fill.Type != querier.FillNull {
+		t.Fatalf("wrong downsample %+v %v", ds, err)
+	}
+	if ds, err = parseDownsample("0all-sum"); err != nil || ds.interval != 0 {
+		t.Fatalf("wrong downsample %+v %v", ds, err)
+	}
+	for _, str := range []string{"1h", "1h-foo", "1x-avg", "1h-avg-previous"} {
+		if _, err := parseDownsample(str); err == nil {
+			t.Fatalf("expected an error for %q", str)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	group := []*series{
+		{points: []point{{0, 1}, {10, 2}, {20, 3}}},
+		{points: []point{{5, 10}, {15, 20}}},
+	}
+	// the first series is interpolated at 5 and 15, the second only between its points
+	expected := []point{{0, 1}, {5, 11.5}, {10, 17}, {15, 22.5}, {20, 3}}
+	if res := aggregate(group, aggregators["sum"]); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("wrong sum %v", res)
+	}
+	expected = []point{{0, 1}, {5, 10}, {10, 2}, {15, 20}, {20, 3}}
+	if res := aggregate(group, aggregators["zimsum"]); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("wrong zimsum %v", res)
+	}
+
+	points := []point{{0, 10}, {1000, 30}, {2000, 5}, {4000, 25}}
+	if res := rate(points, nil); !reflect.DeepEqual(res, []point{{1000, 20}, {2000, -25}, {4000, 10}}) {
+		t.Fatalf("wrong rate %v", res)
+	}
+	if res := rate(points, &rateOptions{Counter: true, CounterMax: 40}); res[1].v != 15 {
+		t.Fatalf("wrong counter rate %v", res)
+	}
+	if res := rate(points, &rateOptions{Counter: true, DropResets: true}); len(res) != 2 {
+		t.Fatalf("wrong counter rate without resets %v", res)
+	}
+
+	d, _ := json.Marshal(dataPoints{points: []point{{1000, 1}, {1500, 2}, {3000, math.NaN()}}})
+	if string(d) != `{"1":2,"3":null}` {
+		t.Fatalf("wrong data points %s", d)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const hour = 3600 * 1000
+	q := &tsdbtest.Querier{
+		Raw: []*tsdbtest.Series{
+			{Lset: utils.FromStrings("__name__", "sys_cpu", "host", "a", "dc", "lga"), Points: []tsdbtest.Point{{T: 1000, V: 1}, {T: 3000, V: 3}}},
+			{Lset: utils.FromStrings("__name__", "sys_cpu", "host", "b", "dc", "lga"), Points: []tsdbtest.Point{{T: 1000, V: 10}, {T: 3000, V: 30}}},
+		},
+		Aggr: []*tsdbtest.Series{
+			{Lset: utils.FromStrings("__name__", "sys_cpu", "host", "a", "dc", "lga", "Aggregator", "avg"),
+				Points: []tsdbtest.Point{{T: 0, V: 2}, {T: hour, V: 4}}},
+			{Lset: utils.FromStrings("__name__", "sys_cpu", "host", "b", "dc", "lga", "Aggregator", "avg"),
+				Points: []tsdbtest.Point{{T: 0, V: 20}, {T: hour, V: 40}}},
+		},
+	}
+	app := &tsdbtest.Appender{}
+	handler := newHandler(logger, app, nil, q.New)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// put
+	rec := do(http.MethodPost, "/api/put", `[{"metric":"sys.cpu","timestamp":1500000000,"value":1.5,"tags":{"host":"a"}},
+		{"metric":"sys.cpu","timestamp":1500000000123,"value":"2","tags":{"host.name":"b"}}]`)
+	if rec.Code != http.StatusNoContent || len(app.Samples()) != 2 {
+		t.Fatalf("put failed %d %s", rec.Code, rec.Body)
+	}
+	if s := app.Samples()[1]; s.Lset.String() != `{__name__="sys_cpu", host_name="b"}` || s.T != 1500000000123 || s.V != 2 {
+		t.Fatalf("wrong sample %+v", s)
+	}
+
+	rec = do(http.MethodPost, "/api/put?details", `[{"metric":"cpu","timestamp":1500000000,"value":1},
+		{"metric":"","timestamp":1500000000,"value":1},{"metric":"cpu","timestamp":1500000000,"value":"x"}]`)
+	summary := putSummary{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil || rec.Code != http.StatusBadRequest ||
+		summary.Success != 1 || summary.Failed != 2 || len(*summary.Errors) != 2 {
+		t.Fatalf("wrong put details %d %s", rec.Code, rec.Body)
+	}
+	if rec = do(http.MethodPost, "/api/put", `{"metric":"cpu","value":1}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a put error, got %d", rec.Code)
+	}
+
+	// query
+	decode := func(rec *httptest.ResponseRecorder) []map[string]interface{} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("query failed %d %s", rec.Code, rec.Body)
+		}
+		var res []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := decode(do(http.MethodGet, "/api/query?start=0&end=10000&ms&m="+url.QueryEscape("sum:sys.cpu{dc=lga}"), ""))
+	expected := []map[string]interface{}{{
+		"metric": "sys.cpu", "tags": map[string]interface{}{"dc": "lga"}, "aggregateTags": []interface{}{"host"},
+		"dps": map[string]interface{}{"1000": 11.0, "3000": 33.0},
+	}}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("wrong query result %v", res)
+	}
+
+	res = decode(do(http.MethodPost, "/api/query", `{"start":"1970/01/01-00:00:00","end":7200,"timezone":"UTC",
+		"queries":[{"aggregator":"none","metric":"sys.cpu","downsample":"1h-avg","tags":{"host":"a|b"}}]}`))
+	if len(res) != 2 || !reflect.DeepEqual(res[1]["dps"], map[string]interface{}{"0": 20.0, "3600": 40.0}) {
+		t.Fatalf("wrong downsampled result %v", res)
+	}
+	if p := q.LastParams(); p.Functions != "avg" || p.Step != hour {
+		t.Fatalf("wrong select params %+v", p)
+	}
+
+	res = decode(do(http.MethodGet, "/api/query?start=0&end=10&m="+url.QueryEscape("max:0all-sum:sys.cpu{host=*}"), ""))
+	if len(res) != 2 || !reflect.DeepEqual(res[0]["dps"], map[string]interface{}{"0": 4.0}) {
+		t.Fatalf("wrong 0all result %v", res)
+	}
+
+	for _, path := range []string{
+		"/api/query?m=sum:cpu", "/api/query?start=1h-ago&m=foo:cpu", "/api/query?start=1h-ago",
+		"/api/query?start=1h-ago&m=sum:1h-x:cpu", "/api/query?start=10&end=5&m=sum:cpu",
+	} {
+		if rec := do(http.MethodGet, path, ""); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected an error for %s, got %d", path, rec.Code)
+		}
+	}
+}
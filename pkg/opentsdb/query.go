@@ -0,0 +1,388 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package opentsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// a query request, the JSON body of POST /api/query (GET requests are converted to it)
+type queryRequest struct {
+	Start        interface{} `json:"start"`
+	End          interface{} `json:"end"`
+	Queries      []*subQuery `json:"queries"`
+	MsResolution bool        `json:"msResolution"`
+	Timezone     string      `json:"timezone"`
+}
+
+type subQuery struct {
+	Aggregator  string            `json:"aggregator"`
+	Metric      string            `json:"metric"`
+	Downsample  string            `json:"downsample"`
+	Rate        bool              `json:"rate"`
+	RateOptions *rateOptions      `json:"rateOptions"`
+	Tags        map[string]string `json:"tags"`
+	Filters     []*filter         `json:"filters"`
+}
+
+type rateOptions struct {
+	Counter    bool    `json:"counter"`
+	CounterMax float64 `json:"counterMax"`
+	ResetValue float64 `json:"resetValue"`
+	DropResets bool    `json:"dropResets"`
+}
+
+type filter struct {
+	Type    string `json:"type"`
+	Tagk    string `json:"tagk"`
+	Filter  string `json:"filter"`
+	GroupBy bool   `json:"groupBy"`
+}
+
+// a downsample spec, <interval>-<function>[-<fill>], the interval is 0 for "0all" (a single value for the
+// whole query range)
+type downsample struct {
+	interval int64
+	fn       string
+	fill     querier.FillPolicy
+}
+
+// the querier functions of the OpenTSDB downsample functions
+var downsampleFunctions = map[string]string{
+	"avg": "avg", "sum": "sum", "zimsum": "sum", "min": "min", "mimmin": "min", "max": "max", "mimmax": "max",
+	"count": "count", "first": "first", "last": "last", "dev": "stddev", "median": "p50",
+	"p50": "p50", "p75": "p75", "p90": "p90", "p95": "p95", "p99": "p99", "p999": "p999",
+}
+
+// interval units, n is a 30 days month and y a 365 days year (same as OpenTSDB)
+var intervalUnits = map[string]int64{
+	"ms": 1, "s": 1000, "m": 60 * 1000, "h": 3600 * 1000, "d": 24 * 3600 * 1000, "w": 7 * 24 * 3600 * 1000,
+	"n": 30 * 24 * 3600 * 1000, "y": 365 * 24 * 3600 * 1000,
+}
+
+var intervalRegexp = regexp.MustCompile(`^([0-9]+)(ms|s|m|h|d|w|n|y)$`)
+
+// absolute time formats (in the request timezone)
+var timeFormats = []string{
+	"2006/01/02-15:04:05", "2006/01/02 15:04:05", "2006/01/02-15:04", "2006/01/02 15:04", "2006/01/02",
+}
+
+// parse an interval, e.g. 10s or 1h, in milliseconds
+func parseInterval(str string) (int64, error) {
+	m := intervalRegexp.FindStringSubmatch(str)
+	if m == nil {
+		return 0, fmt.Errorf("invalid interval %q", str)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", str)
+	}
+	return n * intervalUnits[m[2]], nil
+}
+
+// parse a start or end time: unix seconds or milliseconds, <interval>-ago or an absolute time
+func parseTime(v interface{}, now int64, loc *time.Location) (int64, error) {
+	switch t := v.(type) {
+	case float64:
+		return toMillis(int64(t)), nil
+	case string:
+		if strings.HasSuffix(t, "-ago") {
+			d, err := parseInterval(strings.TrimSuffix(t, "-ago"))
+			if err != nil {
+				return 0, err
+			}
+			return now - d, nil
+		}
+		if n, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return toMillis(n), nil
+		}
+		for _, format := range timeFormats {
+			if tm, err := time.ParseInLocation(format, t, loc); err == nil {
+				return tm.UnixNano() / int64(time.Millisecond), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid time %v", v)
+}
+
+func parseDownsample(str string) (*downsample, error) {
+	parts := strings.Split(str, "-")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid downsample %q, use <interval>-<function>[-<fill>]", str)
+	}
+
+	ds := &downsample{fn: parts[1], fill: querier.FillPolicy{Type: querier.FillNone}}
+	if _, ok := downsampleFunctions[ds.fn]; !ok {
+		return nil, fmt.Errorf("invalid downsample function %q", ds.fn)
+	}
+	if parts[0] != "0all" {
+		interval, err := parseInterval(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		ds.interval = interval
+	}
+
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "none":
+		case "nan", "null":
+			ds.fill.Type = querier.FillNull
+		case "zero":
+			ds.fill.Type = querier.FillZero
+		default:
+			return nil, fmt.Errorf("invalid downsample fill policy %q, use none, nan, null or zero", parts[2])
+		}
+	}
+	return ds, nil
+}
+
+// split a string by sep, except inside braces or parentheses
+func splitTopLevel(str string, sep byte) []string {
+	var parts []string
+	depth, from := 0, 0
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '{', '(':
+			depth++
+		case '}', ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, str[from:i])
+				from = i + 1
+			}
+		}
+	}
+	return append(parts, str[from:])
+}
+
+// parse a GET query (the m parameter), <aggregator>:[<downsample>:][rate[{counter[,<max>[,<reset>]]}]:]<metric>
+// [{<tag>=<filter>,...}][{<tag>=<filter>,...}], filters in the first braces group the results by their tag
+func parseSubQuery(str string) (*subQuery, error) {
+	parts := splitTopLevel(str, ':')
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid query %q, use <aggregator>:[<downsample>:][rate:]<metric>[{tags}]", str)
+	}
+
+	q := &subQuery{Aggregator: parts[0]}
+	for _, part := range parts[1 : len(parts)-1] {
+		if !strings.HasPrefix(part, "rate") {
+			q.Downsample = part
+			continue
+		}
+		q.Rate = true
+		if part == "rate" {
+			continue
+		}
+		if !strings.HasPrefix(part, "rate{") || !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("invalid rate %q", part)
+		}
+		opts, err := parseRateOptions(part[5 : len(part)-1])
+		if err != nil {
+			return nil, err
+		}
+		q.RateOptions = opts
+	}
+
+	metric := parts[len(parts)-1]
+	brace := strings.IndexByte(metric, '{')
+	if brace < 0 {
+		q.Metric = metric
+		return q, nil
+	}
+	q.Metric = metric[:brace]
+
+	groups, err := splitBraces(metric[brace:])
+	if err != nil || len(groups) > 2 {
+		return nil, fmt.Errorf("invalid tags in %q", metric)
+	}
+	for i, group := range groups {
+		if group == "" {
+			continue
+		}
+		for _, pair := range splitTopLevel(group, ',') {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return nil, fmt.Errorf("invalid tag filter %q", pair)
+			}
+			q.Filters = append(q.Filters, newFilter(kv[0], kv[1], i == 0))
+		}
+	}
+	return q, nil
+}
+
+// split "{...}{...}" into the contents of the braces
+func splitBraces(str string) ([]string, error) {
+	var groups []string
+	for str != "" {
+		if str[0] != '{' {
+			return nil, fmt.Errorf("expected {")
+		}
+		depth := 0
+		end := -1
+		for i := 1; i < len(str) && end < 0; i++ {
+			switch str[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case '}':
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("missing }")
+		}
+		groups = append(groups, str[1:end])
+		str = str[end+1:]
+	}
+	return groups, nil
+}
+
+func parseRateOptions(str string) (*rateOptions, error) {
+	parts := strings.Split(str, ",")
+	opts := &rateOptions{Counter: parts[0] == "counter", DropResets: parts[0] == "dropcounter"}
+	if !opts.Counter && !opts.DropResets || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid rate options %q, use counter|dropcounter[,<max>[,<reset>]]", str)
+	}
+	opts.Counter = true
+
+	var err error
+	if len(parts) > 1 && parts[1] != "" {
+		if opts.CounterMax, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid counter max %q", parts[1])
+		}
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		if opts.ResetValue, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			return nil, fmt.Errorf("invalid reset value %q", parts[2])
+		}
+	}
+	return opts, nil
+}
+
+// a filter of a tag value spec, "*", "a|b", "a" or "<type>(<filter>)"
+func newFilter(tagk, spec string, groupBy bool) *filter {
+	f := &filter{Type: "literal_or", Tagk: tagk, Filter: spec, GroupBy: groupBy}
+	if spec == "*" {
+		f.Type = "wildcard"
+	} else if open := strings.IndexByte(spec, '('); open > 0 && strings.HasSuffix(spec, ")") {
+		f.Type, f.Filter = spec[:open], spec[open+1:len(spec)-1]
+	}
+	return f
+}
+
+// the label matcher of a filter
+func (f *filter) matcher() (*utils.LabelMatcher, error) {
+	name := utils.SanitizeLabelName(f.Tagk)
+	if f.Tagk == "" || f.Filter == "" {
+		return nil, fmt.Errorf("invalid %s filter %s=%s", f.Type, f.Tagk, f.Filter)
+	}
+
+	fold := ""
+	typ := f.Type
+	if strings.HasPrefix(typ, "i") || strings.HasPrefix(typ, "not_i") {
+		fold = "(?i)"
+		typ = strings.Replace(typ, "i", "", 1)
+	}
+
+	switch typ {
+	case "literal_or", "not_literal_or":
+		values := strings.Split(f.Filter, "|")
+		negate := typ == "not_literal_or"
+		if len(values) == 1 && fold == "" {
+			if negate {
+				return utils.NewLabelMatcher(utils.MatchNotEqual, name, values[0])
+			}
+			return utils.NewLabelMatcher(utils.MatchEqual, name, values[0])
+		}
+		for i, v := range values {
+			values[i] = regexp.QuoteMeta(v)
+		}
+		expr := fold + "(?:" + strings.Join(values, "|") + ")"
+		if negate {
+			return utils.NewLabelMatcher(utils.MatchNotRegexp, name, expr)
+		}
+		return utils.NewLabelMatcher(utils.MatchRegexp, name, expr)
+	case "wildcard":
+		if f.Filter == "*" {
+			return utils.NewLabelMatcher(utils.MatchRegexp, name, ".+")
+		}
+		expr := strings.Replace(regexp.QuoteMeta(f.Filter), `\*`, ".*", -1)
+		return utils.NewLabelMatcher(utils.MatchRegexp, name, fold+expr)
+	case "regexp":
+		if fold != "" {
+			break
+		}
+		// OpenTSDB regexps match any part of the value
+		return utils.NewLabelMatcher(utils.MatchRegexp, name, ".*(?:"+f.Filter+").*")
+	}
+	return nil, fmt.Errorf("unsupported filter type %q", f.Type)
+}
+
+// convert a GET request to a query request
+func parseQueryParams(params map[string][]string) (*queryRequest, error) {
+	get := func(name string) string {
+		if values := params[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	req := &queryRequest{Start: get("start"), Timezone: get("tz")}
+	if end := get("end"); end != "" {
+		req.End = end
+	}
+	_, req.MsResolution = params["ms"]
+
+	for _, m := range params["m"] {
+		q, err := parseSubQuery(m)
+		if err != nil {
+			return nil, err
+		}
+		req.Queries = append(req.Queries, q)
+	}
+	return req, nil
+}
+
+func parseQueryBody(body []byte) (*queryRequest, error) {
+	req := &queryRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	for _, q := range req.Queries {
+		for tagk, spec := range q.Tags {
+			q.Filters = append(q.Filters, newFilter(tagk, spec, true))
+		}
+	}
+	return req, nil
+}
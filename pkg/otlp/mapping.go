@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package otlp
+
+import (
+	"sort"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// Mapper maps the OTLP metrics to label sets, the metric name is <prefix><name> and the labels are the selected
+// resource and scope attributes and the data point attributes (which take precedence), attribute names are
+// renamed and sanitized into label names
+type Mapper struct {
+	cfg      config.OtlpConfig
+	resource map[string]bool
+	scope    map[string]bool
+	drop     map[string]bool
+}
+
+func NewMapper(cfg *config.OtlpConfig) *Mapper {
+	m := &Mapper{
+		cfg: config.OtlpConfig{
+			ResourceAttributes: []string{"service.name", "service.instance.id"},
+			RenameAttributes:   map[string]string{"service.name": "job", "service.instance.id": "instance"},
+		},
+		resource: map[string]bool{},
+		scope:    map[string]bool{},
+		drop:     map[string]bool{},
+	}
+	if cfg != nil {
+		m.cfg = *cfg
+	}
+	for _, name := range m.cfg.ResourceAttributes {
+		m.resource[name] = true
+	}
+	for _, name := range m.cfg.ScopeAttributes {
+		m.scope[name] = true
+	}
+	for _, name := range m.cfg.DropAttributes {
+		m.drop[name] = true
+	}
+	return m
+}
+
+// the labels of a resource and scope (shared by all their metrics), by label name
+func (m *Mapper) scopeLabels(rm *resourceMetrics, sm *scopeMetrics) map[string]string {
+	labels := map[string]string{}
+	m.addAttributes(labels, rm.Resource, m.resource)
+	m.addAttributes(labels, sm.Attributes, m.scope)
+	if m.cfg.ScopeInfo {
+		if sm.Name != "" {
+			labels["otel_scope_name"] = sm.Name
+		}
+		if sm.Version != "" {
+			labels["otel_scope_version"] = sm.Version
+		}
+	}
+	return labels
+}
+
+// add the selected attributes (all for "*") to the labels
+func (m *Mapper) addAttributes(labels map[string]string, attrs []attribute, selected map[string]bool) {
+	for _, attr := range attrs {
+		if !selected[attr.Key] && !selected["*"] {
+			continue
+		}
+		name := attr.Key
+		if renamed, ok := m.cfg.RenameAttributes[name]; ok {
+			name = renamed
+		}
+		name = utils.SanitizeLabelName(name)
+		if name == utils.MetricName || name == "" {
+			continue
+		}
+		if attr.Value == "" {
+			delete(labels, name)
+		} else {
+			labels[name] = attr.Value
+		}
+	}
+}
+
+// the (sorted) label set of a data point
+func (m *Mapper) labels(scope map[string]string, name string, attrs []attribute) utils.Labels {
+	labels := make(map[string]string, len(scope)+len(attrs))
+	for k, v := range scope {
+		labels[k] = v
+	}
+	points := make([]attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		if !m.drop[attr.Key] {
+			points = append(points, attr)
+		}
+	}
+	m.addAttributes(labels, points, map[string]bool{"*": true})
+
+	lset := make(utils.Labels, 0, len(labels)+1)
+	lset = append(lset, utils.Label{Name: utils.MetricName, Value: utils.SanitizeLabelName(m.cfg.Prefix + name)})
+	for k, v := range labels {
+		lset = append(lset, utils.Label{Name: k, Value: v})
+	}
+	sort.Sort(lset)
+	return lset
+}
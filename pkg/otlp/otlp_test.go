@@ -0,0 +1,372 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/protowire"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// encoders of the OTLP test messages
+
+func fixed64(w *protowire.Writer, field int, v uint64) {
+	w.Tag(field, protowire.WireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.Buf = append(w.Buf, b[:]...)
+}
+
+func keyValues(w *protowire.Writer, field int, kvs ...string) {
+	for i := 0; i < len(kvs); i += 2 {
+		w.Message(field, func(w *protowire.Writer) {
+			w.String(1, kvs[i])
+			w.Message(2, func(w *protowire.Writer) { w.String(1, kvs[i+1]) })
+		})
+	}
+}
+
+type testPoint struct {
+	attrs []string
+	t     int64 // ms
+	v     float64
+}
+
+type testHistogram struct {
+	attrs  []string
+	t      int64
+	counts []uint64
+	bounds []float64
+	sum    float64
+}
+
+func numberMetric(w *protowire.Writer, name string, typ, temporality int, monotonic bool, points ...testPoint) {
+	w.Message(2, func(w *protowire.Writer) {
+		w.String(1, name)
+		w.Message(typ, func(w *protowire.Writer) {
+			for _, p := range points {
+				w.Message(1, func(w *protowire.Writer) {
+					keyValues(w, 7, p.attrs...)
+					fixed64(w, 3, uint64(p.t*1000000))
+					if p.v == math.Trunc(p.v) {
+						fixed64(w, 6, uint64(int64(p.v)))
+					} else {
+						w.Double(4, p.v)
+					}
+				})
+			}
+			w.Int64(2, int64(temporality))
+			if monotonic {
+				w.Int64(3, 1)
+			}
+		})
+	})
+}
+
+func histogramMetric(w *protowire.Writer, name string, temporality int, points ...testHistogram) {
+	w.Message(2, func(w *protowire.Writer) {
+		w.String(1, name)
+		w.Message(typeHistogram, func(w *protowire.Writer) {
+			for _, p := range points {
+				w.Message(1, func(w *protowire.Writer) {
+					keyValues(w, 9, p.attrs...)
+					fixed64(w, 3, uint64(p.t*1000000))
+					var count uint64
+					for _, c := range p.counts {
+						count += c
+					}
+					fixed64(w, 4, count)
+					w.Double(5, p.sum)
+					// packed bucket counts, unpacked bounds
+					packed := protowire.Writer{}
+					for _, c := range p.counts {
+						var b [8]byte
+						binary.LittleEndian.PutUint64(b[:], c)
+						packed.Buf = append(packed.Buf, b[:]...)
+					}
+					w.Bytes(6, packed.Buf)
+					for _, b := range p.bounds {
+						fixed64(w, 7, math.Float64bits(b))
+					}
+				})
+			}
+			w.Int64(2, int64(temporality))
+		})
+	})
+}
+
+// an export request of one resource and scope
+func exportRequest(resource []string, metrics func(w *protowire.Writer)) []byte {
+	w := protowire.Writer{}
+	w.Message(1, func(w *protowire.Writer) {
+		w.Message(1, func(w *protowire.Writer) { keyValues(w, 1, resource...) })
+		w.Message(2, func(w *protowire.Writer) {
+			w.Message(1, func(w *protowire.Writer) {
+				w.String(1, "io.test")
+				w.String(2, "1.0")
+			})
+			metrics(w)
+		})
+	})
+	return w.Buf
+}
+
+// the testdata request is encoded by the reference encoder (the OTLP generated types), see testdata/generate.go
+func TestGoldenExportRequest(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/export_request.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := unmarshalExportRequest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*resourceMetrics{{
+		Resource: []attribute{
+			{Key: "service.name", Value: "api"},
+			{Key: "replicas", Value: "-3"},
+			{Key: "enabled", Value: "true"},
+			{Key: "ratio", Value: "0.5"},
+			{Key: "tags", Value: "[a,1]"},
+			{Key: "meta", Value: "{k:v}"},
+			{Key: "raw", Value: "AQID"},
+		},
+		Scopes: []*scopeMetrics{{
+			Name:       "io.opentelemetry.runtime",
+			Version:    "1.2.0",
+			Attributes: []attribute{{Key: "scope.attr", Value: "x"}},
+			Metrics: []*metric{
+				{Name: "cpu.utilization", Type: typeGauge, Points: []*numberPoint{
+					{Attributes: []attribute{{Key: "cpu", Value: "0"}}, Time: 2e12, Value: 0.25},
+					{Attributes: []attribute{{Key: "cpu", Value: "1"}}, Time: 2e12, Value: -7, Flags: flagNoRecordedValue},
+				}},
+				{Name: "http.server.requests", Type: typeSum, Temporality: temporalityDelta, Monotonic: true,
+					Points: []*numberPoint{
+						{Attributes: []attribute{{Key: "method", Value: "GET"}}, StartTime: 1e12, Time: 2e12, Value: 5}}},
+				{Name: "http.server.duration", Type: typeHistogram, Temporality: temporalityCumulative,
+					Histograms: []*histogramPoint{{StartTime: 1e12, Time: 2e12, Count: 6, Sum: 1.5,
+						BucketCounts: []uint64{1, 2, 3}, Bounds: []float64{0.1, 1}}}},
+				{Name: "queue.latency", Type: typeExponentialHistogram, Unsupported: 2},
+				{Name: "rpc.duration", Type: typeSummary, Unsupported: 1},
+			},
+		}},
+	}}
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("wrong export request\n%s\nexpected\n%s", dumpResources(list), dumpResources(expected))
+	}
+}
+
+func dumpResources(list []*resourceMetrics) string {
+	buf, _ := json.Marshal(list)
+	return string(buf)
+}
+
+func TestWriteHandler(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &tsdbtest.Appender{}
+	handler := NewWriteHandler(logger, app, nil, &config.OtlpConfig{
+		ResourceAttributes: []string{"service.name", "host.name"},
+		RenameAttributes:   map[string]string{"service.name": "job"},
+		ScopeInfo:          true,
+		DropAttributes:     []string{"secret"},
+	})
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	resource := []string{"service.name", "api", "host.name", "h1", "cloud.region", "eu"}
+	rec := post(exportRequest(resource, func(w *protowire.Writer) {
+		numberMetric(w, "memory.used", typeGauge, 0, false, testPoint{[]string{"state", "free", "secret", "x"}, 1000, 1.5})
+		numberMetric(w, "requests", typeSum, temporalityCumulative, true, testPoint{nil, 1000, 10}, testPoint{nil, 2000, 15})
+		numberMetric(w, "bytes", typeSum, temporalityDelta, true, testPoint{nil, 1000, 100}, testPoint{nil, 2000, 50})
+		numberMetric(w, "queue", typeSum, temporalityDelta, false, testPoint{nil, 1000, 5}, testPoint{nil, 2000, -2})
+		histogramMetric(w, "latency", temporalityDelta,
+			testHistogram{nil, 1000, []uint64{1, 2, 3}, []float64{0.1, 1}, 4},
+			testHistogram{nil, 2000, []uint64{0, 1, 0}, []float64{0.1, 1}, 0.5})
+	}))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatalf("export failed %d %q", rec.Code, rec.Body)
+	}
+
+	samples := app.Samples()
+	if lset := samples[0].Lset.String(); lset !=
+		`{__name__="memory_used", host_name="h1", job="api", otel_scope_name="io.test", otel_scope_version="1.0", state="free"}` {
+		t.Fatalf("wrong labels %s", lset)
+	}
+
+	values := map[string][]float64{}
+	var hists []*chunkenc.Histogram
+	for _, s := range samples {
+		if s.H != nil {
+			hists = append(hists, s.H)
+			continue
+		}
+		name := s.Lset.Get(utils.MetricName)
+		values[name] = append(values[name], s.V)
+	}
+	expected := map[string][]float64{
+		"memory_used": {1.5}, "requests": {10, 15}, "bytes": {100, 150}, "queue": {5, 3},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("wrong values %v", values)
+	}
+	inf := math.Inf(1)
+	expectedHists := []*chunkenc.Histogram{
+		{Bounds: []float64{0.1, 1, inf}, Counts: []uint64{1, 3, 6}, Count: 6, Sum: 4},
+		{Bounds: []float64{0.1, 1, inf}, Counts: []uint64{1, 4, 7}, Count: 7, Sum: 4.5},
+	}
+	if !reflect.DeepEqual(hists, expectedHists) {
+		t.Fatalf("wrong histograms %+v %+v", hists[0], hists[1])
+	}
+	if samples[0].T != 1000 {
+		t.Fatalf("wrong time %d", samples[0].T)
+	}
+
+	// rejected points (out of order delta, unspecified temporality, invalid histogram) are a partial success
+	app.Reset()
+	rec = post(exportRequest(resource, func(w *protowire.Writer) {
+		numberMetric(w, "bytes", typeSum, temporalityDelta, true, testPoint{nil, 1500, 1}, testPoint{nil, 3000, 1})
+		numberMetric(w, "other", typeSum, 0, true, testPoint{nil, 1000, 1})
+		histogramMetric(w, "bad", temporalityCumulative, testHistogram{nil, 1000, []uint64{1, 2}, []float64{0.1, 1}, 1})
+	}))
+	var rejected int64
+	err = protowire.DecodeMessage(rec.Body.Bytes(), func(r *protowire.Reader, field, wire int) error {
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		return protowire.DecodeMessage(b, func(r *protowire.Reader, field, wire int) error {
+			if field == 1 {
+				rejected, err = r.Int64()
+				return err
+			}
+			return r.Skip(wire)
+		})
+	})
+	if err != nil || rec.Code != http.StatusOK || rejected != 3 {
+		t.Fatalf("wrong partial success %d %d %v", rec.Code, rejected, err)
+	}
+	if samples = app.Samples(); len(samples) != 1 || samples[0].V != 151 {
+		t.Fatalf("wrong samples %+v", samples)
+	}
+
+	// a failed append is retried by the client, the deltas of the failed request must not be accumulated
+	retried := exportRequest(resource, func(w *protowire.Writer) {
+		numberMetric(w, "bytes", typeSum, temporalityDelta, true, testPoint{nil, 4000, 9})
+		histogramMetric(w, "latency", temporalityDelta, testHistogram{nil, 3000, []uint64{1, 0, 0}, []float64{0.1, 1}, 0.1})
+	})
+	app.Reset()
+	app.Reject = func(tsdbtest.Sample) error { return fmt.Errorf("append queue closed") }
+	if rec = post(retried); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a retryable error, got %d", rec.Code)
+	}
+	app.Reject = nil
+	rec = post(retried)
+	if samples = app.Samples(); rec.Code != http.StatusOK || len(samples) != 2 || samples[0].V != 160 ||
+		!reflect.DeepEqual(samples[1].H.Counts, []uint64{2, 5, 8}) {
+		t.Fatalf("wrong samples after a retry %d %+v", rec.Code, samples)
+	}
+
+	if rec = post([]byte{0xff}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a decode error, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected an unsupported media type error, got %d", rec.Code)
+	}
+}
+
+func TestConcurrentDeltas(t *testing.T) {
+	logger, err := utils.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a slow append, so the concurrent points of the series are accumulated while others are appended
+	app := &tsdbtest.Appender{Reject: func(tsdbtest.Sample) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}}
+	handler := NewWriteHandler(logger, app, nil, &config.OtlpConfig{})
+	lset := utils.FromStrings("__name__", "requests")
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(t int64) {
+			defer wg.Done()
+			handler.appendPoint(lset, t, 1, nil, true)
+		}(int64(i))
+	}
+	wg.Wait()
+
+	// every appended point adds 1 to the value of the previous appended point (out of order points are rejected)
+	values := []float64{}
+	for _, s := range app.Samples() {
+		values = append(values, s.V)
+	}
+	sort.Float64s(values)
+	for i, v := range values {
+		if v != float64(i+1) {
+			t.Fatalf("points were accumulated from the same value %v", values)
+		}
+	}
+	if st := handler.deltas[lset.String()]; st.value != float64(len(values)) || st.users != 0 {
+		t.Fatalf("wrong delta state %+v after %d points", st, len(values))
+	}
+}
+
+func TestMapperDefaults(t *testing.T) {
+	m := NewMapper(nil)
+	rm := &resourceMetrics{Resource: []attribute{{"service.name", "api"}, {"service.instance.id", "i-1"}, {"os.type", "linux"}}}
+	scope := m.scopeLabels(rm, &scopeMetrics{Name: "lib"})
+	if lset := m.labels(scope, "http.server.duration", []attribute{{"http.method", "GET"}, {"job", "override"}}); lset.String() !=
+		`{__name__="http_server_duration", http_method="GET", instance="i-1", job="override"}` {
+		t.Fatalf("wrong default labels %s", lset)
+	}
+}
@@ -0,0 +1,444 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package otlp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/v3io/v3io-tsdb/pkg/protowire"
+)
+
+// the subset of the OTLP metrics messages (opentelemetry/proto/metrics/v1) we use, mapped on the wire by field
+// number (testdata holds a request encoded by the generated types)
+
+// metric data types (the Metric data oneof field numbers)
+const (
+	typeGauge                = 5
+	typeSum                  = 7
+	typeHistogram            = 9
+	typeExponentialHistogram = 10
+	typeSummary              = 11
+)
+
+// aggregation temporality of sums and histograms
+const (
+	temporalityUnspecified = 0
+	temporalityDelta       = 1
+	temporalityCumulative  = 2
+)
+
+// data point flag of a point without a value (e.g. the series stopped being reported)
+const flagNoRecordedValue = 1
+
+type attribute struct {
+	Key   string
+	Value string
+}
+
+type resourceMetrics struct {
+	Resource []attribute
+	Scopes   []*scopeMetrics
+}
+
+type scopeMetrics struct {
+	Name       string
+	Version    string
+	Attributes []attribute
+	Metrics    []*metric
+}
+
+type metric struct {
+	Name        string
+	Type        int
+	Temporality int
+	Monotonic   bool
+	Points      []*numberPoint
+	Histograms  []*histogramPoint
+	// number of points of the unsupported types
+	Unsupported int
+}
+
+type numberPoint struct {
+	Attributes []attribute
+	StartTime  int64 // unix nano
+	Time       int64
+	Value      float64
+	Flags      uint64
+}
+
+type histogramPoint struct {
+	Attributes   []attribute
+	StartTime    int64
+	Time         int64
+	Count        uint64
+	Sum          float64
+	BucketCounts []uint64 // per bucket (not cumulative), one more than the bounds
+	Bounds       []float64
+	Flags        uint64
+}
+
+// unmarshal an ExportMetricsServiceRequest
+func unmarshalExportRequest(buf []byte) ([]*resourceMetrics, error) {
+	var list []*resourceMetrics
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if field != 1 || wire != protowire.WireBytes {
+			return r.Skip(wire)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		rm, err := unmarshalResourceMetrics(b)
+		if err != nil {
+			return fmt.Errorf("resource metrics %d: %v", len(list), err)
+		}
+		list = append(list, rm)
+		return nil
+	})
+	return list, err
+}
+
+func unmarshalResourceMetrics(buf []byte) (*resourceMetrics, error) {
+	rm := &resourceMetrics{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if wire != protowire.WireBytes || field != 1 && field != 2 {
+			return r.Skip(wire)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		if field == 1 {
+			// Resource
+			return protowire.DecodeMessage(b, func(r *protowire.Reader, field, wire int) error {
+				return readAttribute(r, field, wire, 1, &rm.Resource)
+			})
+		}
+		sm, err := unmarshalScopeMetrics(b)
+		if err != nil {
+			return err
+		}
+		rm.Scopes = append(rm.Scopes, sm)
+		return nil
+	})
+	return rm, err
+}
+
+func unmarshalScopeMetrics(buf []byte) (*scopeMetrics, error) {
+	sm := &scopeMetrics{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if wire != protowire.WireBytes || field != 1 && field != 2 {
+			return r.Skip(wire)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		if field == 1 {
+			// InstrumentationScope
+			return protowire.DecodeMessage(b, func(r *protowire.Reader, field, wire int) error {
+				switch {
+				case field == 1 && wire == protowire.WireBytes:
+					sm.Name, err = r.String()
+					return err
+				case field == 2 && wire == protowire.WireBytes:
+					sm.Version, err = r.String()
+					return err
+				}
+				return readAttribute(r, field, wire, 3, &sm.Attributes)
+			})
+		}
+		m, err := unmarshalMetric(b)
+		if err != nil {
+			return err
+		}
+		sm.Metrics = append(sm.Metrics, m)
+		return nil
+	})
+	return sm, err
+}
+
+func unmarshalMetric(buf []byte) (*metric, error) {
+	m := &metric{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if wire != protowire.WireBytes {
+			return r.Skip(wire)
+		}
+		switch field {
+		case 1:
+			var err error
+			m.Name, err = r.String()
+			return err
+		case typeGauge, typeSum, typeHistogram, typeExponentialHistogram, typeSummary:
+			m.Type = field
+		default:
+			return r.Skip(wire)
+		}
+
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		return protowire.DecodeMessage(b, func(r *protowire.Reader, field, wire int) error {
+			switch {
+			case field == 1 && wire == protowire.WireBytes:
+				b, err := r.Bytes()
+				if err != nil {
+					return err
+				}
+				switch m.Type {
+				case typeGauge, typeSum:
+					p, err := unmarshalNumberPoint(b)
+					if err != nil {
+						return fmt.Errorf("metric %s: %v", m.Name, err)
+					}
+					m.Points = append(m.Points, p)
+				case typeHistogram:
+					p, err := unmarshalHistogramPoint(b)
+					if err != nil {
+						return fmt.Errorf("metric %s: %v", m.Name, err)
+					}
+					m.Histograms = append(m.Histograms, p)
+				default:
+					m.Unsupported++
+				}
+				return nil
+			case field == 2 && wire == protowire.WireVarint && m.Type != typeGauge:
+				v, err := r.Varint()
+				m.Temporality = int(v)
+				return err
+			case field == 3 && wire == protowire.WireVarint && m.Type == typeSum:
+				v, err := r.Varint()
+				m.Monotonic = v != 0
+				return err
+			}
+			return r.Skip(wire)
+		})
+	})
+	return m, err
+}
+
+func unmarshalNumberPoint(buf []byte) (*numberPoint, error) {
+	p := &numberPoint{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		var err error
+		var v uint64
+		switch {
+		case field == 7:
+			return readAttribute(r, field, wire, 7, &p.Attributes)
+		case field == 2 && wire == protowire.WireFixed64:
+			v, err = r.Fixed64()
+			p.StartTime = int64(v)
+		case field == 3 && wire == protowire.WireFixed64:
+			v, err = r.Fixed64()
+			p.Time = int64(v)
+		case field == 4 && wire == protowire.WireFixed64:
+			p.Value, err = r.Double()
+		case field == 6 && wire == protowire.WireFixed64:
+			// as_int is an sfixed64
+			v, err = r.Fixed64()
+			p.Value = float64(int64(v))
+		case field == 8 && wire == protowire.WireVarint:
+			p.Flags, err = r.Varint()
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return p, err
+}
+
+func unmarshalHistogramPoint(buf []byte) (*histogramPoint, error) {
+	p := &histogramPoint{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		var err error
+		var v uint64
+		switch {
+		case field == 9:
+			return readAttribute(r, field, wire, 9, &p.Attributes)
+		case field == 2 && wire == protowire.WireFixed64:
+			v, err = r.Fixed64()
+			p.StartTime = int64(v)
+		case field == 3 && wire == protowire.WireFixed64:
+			v, err = r.Fixed64()
+			p.Time = int64(v)
+		case field == 4 && wire == protowire.WireFixed64:
+			p.Count, err = r.Fixed64()
+		case field == 5 && wire == protowire.WireFixed64:
+			p.Sum, err = r.Double()
+		case field == 6:
+			err = readFixed64s(r, wire, func(v uint64) { p.BucketCounts = append(p.BucketCounts, v) })
+		case field == 7:
+			err = readFixed64s(r, wire, func(v uint64) { p.Bounds = append(p.Bounds, math.Float64frombits(v)) })
+		case field == 10 && wire == protowire.WireVarint:
+			p.Flags, err = r.Varint()
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return p, err
+}
+
+// read a repeated fixed64 (or double) field, packed or not
+func readFixed64s(r *protowire.Reader, wire int, fn func(v uint64)) error {
+	switch wire {
+	case protowire.WireFixed64:
+		v, err := r.Fixed64()
+		fn(v)
+		return err
+	case protowire.WireBytes:
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		packed := protowire.NewReader(b)
+		for !packed.Done() {
+			v, err := packed.Fixed64()
+			if err != nil {
+				return err
+			}
+			fn(v)
+		}
+		return nil
+	}
+	return r.Skip(wire)
+}
+
+// read a KeyValue attribute field (with the given field number), other fields are skipped
+func readAttribute(r *protowire.Reader, field, wire, attrField int, list *[]attribute) error {
+	if field != attrField || wire != protowire.WireBytes {
+		return r.Skip(wire)
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	attr, err := unmarshalKeyValue(b)
+	if err != nil {
+		return err
+	}
+	*list = append(*list, attr)
+	return nil
+}
+
+func unmarshalKeyValue(buf []byte) (attribute, error) {
+	attr := attribute{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if wire != protowire.WireBytes || field != 1 && field != 2 {
+			return r.Skip(wire)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		if field == 1 {
+			attr.Key = string(b)
+			return nil
+		}
+		attr.Value, err = anyValueString(b)
+		return err
+	})
+	return attr, err
+}
+
+// the string form of an AnyValue, arrays are [a,b] and key value lists {k:v}
+func anyValueString(buf []byte) (string, error) {
+	var res string
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		var err error
+		var v uint64
+		var b []byte
+		switch {
+		case field == 1 && wire == protowire.WireBytes:
+			res, err = r.String()
+		case field == 2 && wire == protowire.WireVarint:
+			v, err = r.Varint()
+			res = strconv.FormatBool(v != 0)
+		case field == 3 && wire == protowire.WireVarint:
+			v, err = r.Varint()
+			res = strconv.FormatInt(int64(v), 10)
+		case field == 4 && wire == protowire.WireFixed64:
+			v, err = r.Fixed64()
+			res = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+		case (field == 5 || field == 6) && wire == protowire.WireBytes:
+			if b, err = r.Bytes(); err != nil {
+				return err
+			}
+			var items []string
+			err = protowire.DecodeMessage(b, func(r *protowire.Reader, itemField, wire int) error {
+				if itemField != 1 || wire != protowire.WireBytes {
+					return r.Skip(wire)
+				}
+				item, err := r.Bytes()
+				if err != nil {
+					return err
+				}
+				var str string
+				if field == 5 {
+					str, err = anyValueString(item)
+				} else {
+					var kv attribute
+					kv, err = unmarshalKeyValue(item)
+					str = kv.Key + ":" + kv.Value
+				}
+				items = append(items, str)
+				return err
+			})
+			if field == 5 {
+				res = "[" + strings.Join(items, ",") + "]"
+			} else {
+				res = "{" + strings.Join(items, ",") + "}"
+			}
+		case field == 7 && wire == protowire.WireBytes:
+			b, err = r.Bytes()
+			res = base64.StdEncoding.EncodeToString(b)
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return res, err
+}
+
+// marshal an ExportMetricsServiceResponse, with the partial success when points were rejected
+func marshalExportResponse(rejected int, msg string) []byte {
+	w := protowire.Writer{}
+	if rejected > 0 {
+		w.Message(1, func(w *protowire.Writer) {
+			w.Int64(1, int64(rejected))
+			w.String(2, msg)
+		})
+	}
+	return w.Buf
+}
+
+// marshal a google.rpc.Status error response
+func marshalStatus(code int, msg string) []byte {
+	w := protowire.Writer{}
+	w.Int64(1, int64(code))
+	w.String(2, msg)
+	return w.Buf
+}
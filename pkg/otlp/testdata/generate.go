@@ -0,0 +1,102 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+// generates the golden OTLP export request with the OpenTelemetry generated types (go.opentelemetry.io/proto/otlp),
+// run from this directory with: go run generate.go
+package main
+
+import (
+	"io/ioutil"
+	"log"
+
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	metrics "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resource "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func str(v string) *common.AnyValue {
+	return &common.AnyValue{Value: &common.AnyValue_StringValue{StringValue: v}}
+}
+
+func attr(key string, v *common.AnyValue) *common.KeyValue {
+	return &common.KeyValue{Key: key, Value: v}
+}
+
+func main() {
+	sum := 1.5
+	req := &colmetrics.ExportMetricsServiceRequest{ResourceMetrics: []*metrics.ResourceMetrics{{
+		Resource: &resource.Resource{Attributes: []*common.KeyValue{
+			attr("service.name", str("api")),
+			attr("replicas", &common.AnyValue{Value: &common.AnyValue_IntValue{IntValue: -3}}),
+			attr("enabled", &common.AnyValue{Value: &common.AnyValue_BoolValue{BoolValue: true}}),
+			attr("ratio", &common.AnyValue{Value: &common.AnyValue_DoubleValue{DoubleValue: 0.5}}),
+			attr("tags", &common.AnyValue{Value: &common.AnyValue_ArrayValue{ArrayValue: &common.ArrayValue{
+				Values: []*common.AnyValue{str("a"), {Value: &common.AnyValue_IntValue{IntValue: 1}}}}}}),
+			attr("meta", &common.AnyValue{Value: &common.AnyValue_KvlistValue{KvlistValue: &common.KeyValueList{
+				Values: []*common.KeyValue{attr("k", str("v"))}}}}),
+			attr("raw", &common.AnyValue{Value: &common.AnyValue_BytesValue{BytesValue: []byte{1, 2, 3}}}),
+		}},
+		ScopeMetrics: []*metrics.ScopeMetrics{{
+			Scope: &common.InstrumentationScope{Name: "io.opentelemetry.runtime", Version: "1.2.0",
+				Attributes: []*common.KeyValue{attr("scope.attr", str("x"))}},
+			Metrics: []*metrics.Metric{
+				{Name: "cpu.utilization", Unit: "1", Description: "the CPU utilization", Data: &metrics.Metric_Gauge{
+					Gauge: &metrics.Gauge{DataPoints: []*metrics.NumberDataPoint{
+						{Attributes: []*common.KeyValue{attr("cpu", str("0"))}, TimeUnixNano: 2e12,
+							Value: &metrics.NumberDataPoint_AsDouble{AsDouble: 0.25},
+							Exemplars: []*metrics.Exemplar{{TimeUnixNano: 2e12,
+								Value: &metrics.Exemplar_AsDouble{AsDouble: 0.3}, TraceId: []byte{1, 2}}}},
+						{Attributes: []*common.KeyValue{attr("cpu", str("1"))}, TimeUnixNano: 2e12,
+							Value: &metrics.NumberDataPoint_AsInt{AsInt: -7},
+							Flags: uint32(metrics.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)},
+					}}}},
+				{Name: "http.server.requests", Data: &metrics.Metric_Sum{Sum: &metrics.Sum{
+					AggregationTemporality: metrics.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					IsMonotonic:            true,
+					DataPoints: []*metrics.NumberDataPoint{{Attributes: []*common.KeyValue{attr("method", str("GET"))},
+						StartTimeUnixNano: 1e12, TimeUnixNano: 2e12, Value: &metrics.NumberDataPoint_AsInt{AsInt: 5}}},
+				}}},
+				{Name: "http.server.duration", Data: &metrics.Metric_Histogram{Histogram: &metrics.Histogram{
+					AggregationTemporality: metrics.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					DataPoints: []*metrics.HistogramDataPoint{{StartTimeUnixNano: 1e12, TimeUnixNano: 2e12, Count: 6,
+						Sum: &sum, BucketCounts: []uint64{1, 2, 3}, ExplicitBounds: []float64{0.1, 1}}},
+				}}},
+				{Name: "queue.latency", Data: &metrics.Metric_ExponentialHistogram{
+					ExponentialHistogram: &metrics.ExponentialHistogram{DataPoints: []*metrics.ExponentialHistogramDataPoint{
+						{TimeUnixNano: 2e12, Count: 1}, {TimeUnixNano: 3e12, Count: 2}}}}},
+				{Name: "rpc.duration", Data: &metrics.Metric_Summary{Summary: &metrics.Summary{
+					DataPoints: []*metrics.SummaryDataPoint{{TimeUnixNano: 2e12, Count: 1, Sum: 0.5}}}}},
+			},
+		}},
+	}}}
+
+	b, err := proto.Marshal(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("export_request.pb", b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const (
+	// max size of a (decompressed) request body
+	maxBodySize = 64 << 20
+	// max number of point errors listed in a response
+	maxReportedErrors = 10
+	// delta series which were not updated for this long are forgotten (their next point starts from 0)
+	deltaStateTTL = time.Hour
+)
+
+// google.rpc.Code values of the error responses
+const (
+	codeInvalidArgument   = 3
+	codeResourceExhausted = 8
+	codeUnavailable       = 14
+)
+
+// the accumulated value of a delta temporality series, locked from the accumulation of a point until it is
+// appended and committed, so the concurrent points of a series are accumulated one after the other
+type deltaState struct {
+	mu        sync.Mutex
+	value     float64
+	histogram *chunkenc.Histogram
+	time      int64 // unix nano of the last point, 0 before the first committed point
+	seen      time.Time
+	users     int // the requests which hold (or wait for) the state, protected by the handler lock
+}
+
+// WriteHandler is an http.Handler for the OTLP/HTTP metrics endpoint (/v1/metrics, protobuf encoding), gauges and
+// sums are appended as samples and histograms as native histograms through the TSDB Appender. cumulative
+// temporality points are appended as is, delta points are accumulated (per series) into cumulative values
+type WriteHandler struct {
+	logger   logger.Logger
+	appender tsdb.Appender
+	full     func() bool
+	mapper   *Mapper
+
+	mu        sync.Mutex
+	deltas    map[string]*deltaState
+	lastSweep time.Time
+}
+
+// NewWriteHandler creates an OTLP metrics handler, full() reports when the appender queue is full so the
+// request is rejected with 429 (Too Many Requests)
+func NewWriteHandler(logger logger.Logger, appender tsdb.Appender, full func() bool,
+	cfg *config.OtlpConfig) *WriteHandler {
+	return &WriteHandler{logger: logger, appender: appender, full: full, mapper: NewMapper(cfg),
+		deltas: map[string]*deltaState{}, lastSweep: time.Now()}
+}
+
+// errors are returned as a protobuf google.rpc.Status
+func writeError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(status)
+	w.Write(marshalStatus(code, msg))
+}
+
+func (h *WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, codeInvalidArgument, "method not allowed")
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-protobuf" {
+		writeError(w, http.StatusUnsupportedMediaType, codeInvalidArgument,
+			"unsupported content type, only application/x-protobuf is supported")
+		return
+	}
+
+	if utils.QueueFull(w, h.full) {
+		writeError(w, http.StatusTooManyRequests, codeResourceExhausted, "append queue is full")
+		return
+	}
+
+	buf, status, err := utils.ReadBody(r, maxBodySize)
+	if err != nil {
+		writeError(w, status, codeInvalidArgument, err.Error())
+		return
+	}
+
+	resources, err := unmarshalExportRequest(buf)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "invalid export request: "+err.Error())
+		return
+	}
+
+	h.sweep()
+
+	var errs []string
+	rejected := 0
+	var appendErr error
+	for _, rm := range resources {
+		for _, sm := range rm.Scopes {
+			scope := h.mapper.scopeLabels(rm, sm)
+			for _, m := range sm.Metrics {
+				n, err := h.write(scope, m, &errs)
+				rejected += n
+				if err != nil && appendErr == nil {
+					appendErr = err
+				}
+			}
+		}
+	}
+
+	// append failures are retried (5xx), rejected points are not
+	if appendErr != nil {
+		h.logger.ErrorWith("OTLP metrics append failed", "err", appendErr)
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, appendErr.Error())
+		return
+	}
+
+	msg := ""
+	if rejected > 0 {
+		h.logger.WarnWith("OTLP metrics rejected some data points", "rejected", rejected, "first", errs[0])
+		if len(errs) > maxReportedErrors {
+			errs = append(errs[:maxReportedErrors], "...")
+		}
+		msg = strings.Join(errs, "; ")
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(marshalExportResponse(rejected, msg))
+}
+
+// append the data points of a metric, returns the number of rejected points (with their errors added to errs)
+// and the first append failure
+func (h *WriteHandler) write(scope map[string]string, m *metric, errs *[]string) (int, error) {
+	rejected := 0
+	reject := func(err error) {
+		rejected++
+		*errs = append(*errs, fmt.Sprintf("metric %s: %v", m.Name, err))
+	}
+
+	if m.Unsupported > 0 {
+		rejected += m.Unsupported - 1
+		reject(fmt.Errorf("unsupported metric type (exponential histograms and summaries are not supported)"))
+	}
+	if m.Type != typeGauge && m.Temporality != temporalityDelta && m.Temporality != temporalityCumulative &&
+		len(m.Points)+len(m.Histograms) > 0 {
+		rejected += len(m.Points) + len(m.Histograms) - 1
+		reject(fmt.Errorf("unspecified aggregation temporality"))
+		return rejected, nil
+	}
+	delta := m.Temporality == temporalityDelta
+
+	for _, p := range m.Points {
+		if p.Flags&flagNoRecordedValue != 0 {
+			continue
+		}
+		lset, err := h.pointLabels(scope, m.Name, p.Attributes, p.Time)
+		if err != nil {
+			reject(err)
+			continue
+		}
+
+		if err := h.appendPoint(lset, p.Time, p.Value, nil, delta); isPointError(err) {
+			reject(err)
+		} else if err != nil {
+			return rejected, err
+		}
+	}
+
+	for _, p := range m.Histograms {
+		if p.Flags&flagNoRecordedValue != 0 {
+			continue
+		}
+		lset, err := h.pointLabels(scope, m.Name, p.Attributes, p.Time)
+		if err != nil {
+			reject(err)
+			continue
+		}
+		hist, err := toHistogram(p)
+		if err != nil {
+			reject(err)
+			continue
+		}
+
+		if err := h.appendPoint(lset, p.Time, 0, hist, delta); isPointError(err) {
+			reject(err)
+		} else if err != nil {
+			return rejected, err
+		}
+	}
+	return rejected, nil
+}
+
+// a rejected data point (unlike an append failure, the request is not retried)
+type pointError struct {
+	error
+}
+
+func isPointError(err error) bool {
+	_, ok := err.(pointError)
+	return ok
+}
+
+// append a point (or a histogram point), a delta point is accumulated into the cumulative value of its series while
+// the series delta state is locked. returns a pointError for a rejected point
+func (h *WriteHandler) appendPoint(lset utils.Labels, t int64, v float64, hist *chunkenc.Histogram, delta bool) error {
+	var st *deltaState
+	if delta {
+		st = h.lockDelta(lset)
+		defer h.unlockDelta(st)
+
+		var err error
+		if v, err = st.accumulate(lset, t, v, hist); err != nil {
+			return pointError{err}
+		}
+	}
+
+	var err error
+	if hist != nil {
+		_, err = h.appender.AddHistogram(lset, t/int64(time.Millisecond), hist)
+	} else {
+		_, err = h.appender.Add(lset, t/int64(time.Millisecond), v)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to append to %s: %v", lset, err)
+	}
+
+	if st != nil {
+		st.commit(t, v, hist)
+	}
+	return nil
+}
+
+func (h *WriteHandler) pointLabels(scope map[string]string, name string, attrs []attribute, t int64) (utils.Labels, error) {
+	if t <= 0 {
+		return nil, fmt.Errorf("missing data point time")
+	}
+	lset := h.mapper.labels(scope, name, attrs)
+	return lset, lset.Validate()
+}
+
+// convert a histogram point (per bucket counts) to a native histogram (cumulative counts, the last bound is +Inf)
+func toHistogram(p *histogramPoint) (*chunkenc.Histogram, error) {
+	hist := &chunkenc.Histogram{Count: p.Count, Sum: p.Sum}
+	if len(p.BucketCounts) == 0 {
+		hist.Bounds, hist.Counts = []float64{math.Inf(1)}, []uint64{p.Count}
+		return hist, nil
+	}
+	if len(p.BucketCounts) != len(p.Bounds)+1 {
+		return nil, fmt.Errorf("histogram has %d buckets and %d bounds", len(p.BucketCounts), len(p.Bounds))
+	}
+
+	hist.Bounds = append(append(make([]float64, 0, len(p.Bounds)+1), p.Bounds...), math.Inf(1))
+	hist.Counts = make([]uint64, len(p.BucketCounts))
+	var total uint64
+	for i, c := range p.BucketCounts {
+		if i > 0 && i < len(p.Bounds) && p.Bounds[i] <= p.Bounds[i-1] {
+			return nil, fmt.Errorf("histogram bounds are not increasing")
+		}
+		total += c
+		hist.Counts[i] = total
+	}
+	return hist, nil
+}
+
+// lock the delta state of a series (created on the first point)
+func (h *WriteHandler) lockDelta(lset utils.Labels) *deltaState {
+	key := lset.String()
+
+	h.mu.Lock()
+	st, ok := h.deltas[key]
+	if !ok {
+		st = &deltaState{seen: time.Now()}
+		h.deltas[key] = st
+	}
+	st.users++
+	h.mu.Unlock()
+
+	st.mu.Lock()
+	return st
+}
+
+func (h *WriteHandler) unlockDelta(st *deltaState) {
+	st.mu.Unlock()
+
+	h.mu.Lock()
+	st.users--
+	h.mu.Unlock()
+}
+
+// add a delta point to the accumulated value of its series and return the cumulative value, a histogram is
+// updated in place to the cumulative histogram (a change of the bounds restarts the accumulation). the state is
+// only updated by commit after the point is appended, so the points of a retried request are not counted twice
+func (st *deltaState) accumulate(lset utils.Labels, t int64, v float64, hist *chunkenc.Histogram) (float64, error) {
+	if st.time == 0 {
+		if hist == nil {
+			return v, nil
+		}
+		return 0, nil
+	}
+	if t <= st.time {
+		return 0, fmt.Errorf("out of order delta point of %s", lset)
+	}
+
+	if hist == nil {
+		return st.value + v, nil
+	}
+
+	if prev := st.histogram; prev != nil && equalBounds(prev.Bounds, hist.Bounds) {
+		for i := range hist.Counts {
+			hist.Counts[i] += prev.Counts[i]
+		}
+		hist.Count += prev.Count
+		hist.Sum += prev.Sum
+	}
+	return 0, nil
+}
+
+// store the cumulative value (or histogram) of an appended delta point as the state of its series
+func (st *deltaState) commit(t int64, v float64, hist *chunkenc.Histogram) {
+	st.time, st.seen, st.value = t, time.Now(), v
+
+	// the appended histogram must not be modified, keep a copy
+	if hist != nil {
+		st.histogram = &chunkenc.Histogram{Bounds: hist.Bounds, Counts: append([]uint64{}, hist.Counts...),
+			Count: hist.Count, Sum: hist.Sum}
+	}
+}
+
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// forget the delta series which were not updated recently (at most once a minute)
+func (h *WriteHandler) sweep() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.lastSweep) < time.Minute {
+		return
+	}
+	h.lastSweep = now
+	for key, st := range h.deltas {
+		if st.users == 0 && now.Sub(st.seen) > deltaStateTTL {
+			delete(h.deltas, key)
+		}
+	}
+}
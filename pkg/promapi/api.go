@@ -0,0 +1,379 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package promapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// max points per series of a range query (same as Prometheus)
+const maxPoints = 11000
+
+type apiError struct {
+	typ    string // bad_data or execution
+	status int
+	err    error
+}
+
+func badData(err error) *apiError {
+	return &apiError{typ: "bad_data", status: http.StatusBadRequest, err: err}
+}
+
+func execution(err error) *apiError {
+	return &apiError{typ: "execution", status: http.StatusUnprocessableEntity, err: err}
+}
+
+type response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type queryData struct {
+	ResultType string      `json:"resultType"`
+	Result     interface{} `json:"result"`
+}
+
+type matrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values []point           `json:"values"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  point             `json:"value"`
+}
+
+// a point is encoded as [<unix seconds>, "<value>"]
+func (p point) MarshalJSON() ([]byte, error) {
+	t := strconv.FormatFloat(float64(p.t)/1000, 'f', -1, 64)
+	return []byte(fmt.Sprintf(`[%s,"%s"]`, t, formatValue(p.v))), nil
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Handler serves the Prometheus HTTP API query endpoints (query, query_range, series, labels and label values)
+// over the V3ioQuerier, expressions are evaluated with the supported PromQL subset (see parser.go)
+type Handler struct {
+	logger  logger.Logger
+	querier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)
+	mux     *http.ServeMux
+}
+
+// NewHandler creates the API handler, newQuerier creates a querier for a time range (e.g. tsdb.V3ioAdapter.Querier)
+func NewHandler(logger logger.Logger,
+	newQuerier func(ctx context.Context, mint, maxt int64) (*querier.V3ioQuerier, error)) *Handler {
+	return newHandler(logger, func(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+		return newQuerier(ctx, mint, maxt)
+	})
+}
+
+func newHandler(logger logger.Logger, newQuerier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)) *Handler {
+	h := &Handler{logger: logger, querier: newQuerier, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/api/v1/query", h.wrap(h.query))
+	h.mux.HandleFunc("/api/v1/query_range", h.wrap(h.queryRange))
+	h.mux.HandleFunc("/api/v1/series", h.wrap(h.series))
+	h.mux.HandleFunc("/api/v1/labels", h.wrap(h.labels))
+	h.mux.HandleFunc("/api/v1/label/", h.wrap(h.labelValues))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// wrap an API function, parse the (query or form) params and encode the JSON response
+func (h *Handler) wrap(fn func(r *http.Request) (interface{}, *apiError)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var data interface{}
+		var apiErr *apiError
+		if err := r.ParseForm(); err != nil {
+			apiErr = badData(err)
+		} else {
+			data, apiErr = fn(r)
+		}
+
+		resp := response{Status: "success", Data: data}
+		status := http.StatusOK
+		if apiErr != nil {
+			h.logger.DebugWith("API request failed", "url", r.URL.String(), "err", apiErr.err)
+			resp = response{Status: "error", ErrorType: apiErr.typ, Error: apiErr.err.Error()}
+			status = apiErr.status
+		}
+
+		body, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+func (h *Handler) evaluator(r *http.Request) *evaluator {
+	return &evaluator{ctx: r.Context(), querier: h.querier}
+}
+
+// instant query, returns a vector
+func (h *Handler) query(r *http.Request) (interface{}, *apiError) {
+	t, err := parseTimeParam(r, "time", time.Now())
+	if err != nil {
+		return nil, badData(err)
+	}
+	e, err := parseExpr(r.Form.Get("query"))
+	if err != nil {
+		return nil, badData(err)
+	}
+
+	result, err := h.evaluator(r).eval(e, t, t, 0)
+	if err != nil {
+		return nil, execution(err)
+	}
+
+	vector := []vectorSample{}
+	for _, s := range sortSeries(result) {
+		vector = append(vector, vectorSample{Metric: s.lset.Map(), Value: s.points[len(s.points)-1]})
+	}
+	return queryData{ResultType: "vector", Result: vector}, nil
+}
+
+// range query, returns a matrix
+func (h *Handler) queryRange(r *http.Request) (interface{}, *apiError) {
+	start, err := parseTimeParam(r, "start", time.Time{})
+	if err != nil {
+		return nil, badData(err)
+	}
+	end, err := parseTimeParam(r, "end", time.Time{})
+	if err != nil {
+		return nil, badData(err)
+	}
+	step, err := parseStep(r.Form.Get("step"))
+	if err != nil {
+		return nil, badData(err)
+	}
+	if end < start {
+		return nil, badData(fmt.Errorf("end timestamp must not be before start time"))
+	}
+	if (end-start)/step > maxPoints {
+		return nil, badData(fmt.Errorf("exceeded maximum resolution of %d points per timeseries, "+
+			"try decreasing the query resolution (?step=XX)", maxPoints))
+	}
+	e, err := parseExpr(r.Form.Get("query"))
+	if err != nil {
+		return nil, badData(err)
+	}
+
+	result, err := h.evaluator(r).eval(e, start, end, step)
+	if err != nil {
+		return nil, execution(err)
+	}
+
+	matrix := []matrixSeries{}
+	for _, s := range sortSeries(result) {
+		matrix = append(matrix, matrixSeries{Metric: s.lset.Map(), Values: s.points})
+	}
+	return queryData{ResultType: "matrix", Result: matrix}, nil
+}
+
+// the label sets of the series matching any of the match[] selectors
+func (h *Handler) series(r *http.Request) (interface{}, *apiError) {
+	selectors, q, apiErr := h.metadataQuerier(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	defer q.Close()
+	if len(selectors) == 0 {
+		return nil, badData(fmt.Errorf("no match[] parameter provided"))
+	}
+
+	unique := map[string]utils.Labels{}
+	for _, matchers := range selectors {
+		list, err := q.Series(matchers...)
+		if err != nil {
+			return nil, execution(err)
+		}
+		for _, lset := range list {
+			unique[lset.String()] = lset
+		}
+	}
+
+	keys := make([]string, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := []map[string]string{}
+	for _, key := range keys {
+		result = append(result, unique[key].Map())
+	}
+	return result, nil
+}
+
+// the label names, of the series matching any of the match[] selectors if specified
+func (h *Handler) labels(r *http.Request) (interface{}, *apiError) {
+	selectors, q, apiErr := h.metadataQuerier(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	defer q.Close()
+	return unionStrings(selectors, func(matchers []*utils.LabelMatcher) ([]string, error) {
+		return q.LabelNames(matchers...)
+	})
+}
+
+// /api/v1/label/<name>/values
+func (h *Handler) labelValues(r *http.Request) (interface{}, *apiError) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/label/")
+	if !strings.HasSuffix(path, "/values") {
+		return nil, &apiError{typ: "not_found", status: http.StatusNotFound, err: fmt.Errorf("unknown path %s", r.URL.Path)}
+	}
+	name := strings.TrimSuffix(path, "/values")
+	if !utils.IsValidLabelName(name) {
+		return nil, badData(fmt.Errorf("invalid label name: %q", name))
+	}
+
+	selectors, q, apiErr := h.metadataQuerier(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	defer q.Close()
+	return unionStrings(selectors, func(matchers []*utils.LabelMatcher) ([]string, error) {
+		return q.LabelValues(name, matchers...)
+	})
+}
+
+// parse the match[] selectors and create a querier for the start/end range (default all the data)
+func (h *Handler) metadataQuerier(r *http.Request) ([][]*utils.LabelMatcher, querier.Querier, *apiError) {
+	start, err := parseTimeParam(r, "start", time.Unix(0, 0))
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+	end, err := parseTimeParam(r, "end", time.Now())
+	if err != nil {
+		return nil, nil, badData(err)
+	}
+
+	selectors := [][]*utils.LabelMatcher{}
+	for _, str := range r.Form["match[]"] {
+		matchers, err := utils.ParseSelector(str)
+		if err != nil {
+			return nil, nil, badData(err)
+		}
+		selectors = append(selectors, matchers)
+	}
+
+	q, err := h.querier(r.Context(), start, end)
+	if err != nil {
+		return nil, nil, execution(err)
+	}
+	return selectors, q, nil
+}
+
+// the sorted union of the strings returned for each selector (or for no matchers when there are no selectors)
+func unionStrings(selectors [][]*utils.LabelMatcher,
+	fn func(matchers []*utils.LabelMatcher) ([]string, error)) (interface{}, *apiError) {
+
+	if len(selectors) == 0 {
+		selectors = append(selectors, nil)
+	}
+	unique := map[string]bool{}
+	for _, matchers := range selectors {
+		list, err := fn(matchers)
+		if err != nil {
+			return nil, execution(err)
+		}
+		for _, str := range list {
+			unique[str] = true
+		}
+	}
+
+	result := []string{}
+	for str := range unique {
+		result = append(result, str)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func sortSeries(list []*series) []*series {
+	sort.Slice(list, func(i, j int) bool { return utils.Compare(list[i].lset, list[j].lset) < 0 })
+	return list
+}
+
+// parse a time parameter, unix seconds (with a fraction) or RFC3339, returns unix milliseconds
+func parseTimeParam(r *http.Request, name string, def time.Time) (int64, error) {
+	str := r.Form.Get(name)
+	if str == "" {
+		if def.IsZero() {
+			return 0, fmt.Errorf("missing %s parameter", name)
+		}
+		return def.UnixNano() / int64(time.Millisecond), nil
+	}
+	if secs, err := strconv.ParseFloat(str, 64); err == nil {
+		return int64(math.Round(secs * 1000)), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter %q, use unix seconds or RFC3339", name, str)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// parse the query step, seconds (with a fraction) or a duration, returns milliseconds
+func parseStep(str string) (int64, error) {
+	if secs, err := strconv.ParseFloat(str, 64); err == nil {
+		if secs <= 0 || secs*1000 < 1 {
+			return 0, fmt.Errorf("zero or negative query resolution step widths are not accepted")
+		}
+		return int64(math.Round(secs * 1000)), nil
+	}
+	step, err := parseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid step parameter: %v", err)
+	}
+	return step, nil
+}
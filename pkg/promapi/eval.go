@@ -0,0 +1,368 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package promapi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const (
+	// how far back a selector looks for the last sample (same as Prometheus)
+	lookbackDelta = 5 * 60 * 1000
+
+	aggregatorLabel = "Aggregator"
+)
+
+// aggregations of the function values across the series (the querier group aggregation)
+var groupAggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// functions which can rank topk/bottomk series, and their rank aggregate
+var rankFunctions = map[string]string{
+	"avg_over_time": "avg", "sum_over_time": "sum", "min_over_time": "min", "max_over_time": "max",
+	"count_over_time": "count", "last_over_time": "last",
+}
+
+type point struct {
+	t int64
+	v float64
+}
+
+// an evaluated series
+type series struct {
+	lset   utils.Labels
+	points []point
+}
+
+// how an expression is evaluated, a select of the raw samples (the selector lookback is applied to every
+// evaluation time here) or of the step aggregates (calculated by the querier)
+type plan struct {
+	params     *querier.SelectParams
+	raw        bool
+	rangeMs    int64 // range of the function selector
+	exactRange bool  // the step aggregate is only valid when the range equals the step (counters, stddev/stdvar)
+
+	// aggregation of the selector values across the series at each evaluation time
+	aggr     string
+	grouping []string
+	without  bool
+}
+
+func newPlan(e expr) (*plan, error) {
+	switch e := e.(type) {
+	case *selectorExpr:
+		if e.rangeMs > 0 {
+			return nil, fmt.Errorf("range selectors are only supported as function arguments")
+		}
+		return &plan{params: &querier.SelectParams{Matchers: e.matchers}, raw: true}, nil
+
+	case *callExpr:
+		return callPlan(e)
+
+	case *aggrExpr:
+		inner, err := newPlan(e.inner)
+		if err != nil {
+			return nil, err
+		}
+		call, isCall := e.inner.(*callExpr)
+
+		switch {
+		case e.op == "topk" || e.op == "bottomk":
+			// the series are ranked over the whole query range
+			if isCall {
+				if inner.params.RankBy = rankFunctions[call.fn]; inner.params.RankBy == "" {
+					return nil, fmt.Errorf("%s of %s is not supported", e.op, call.fn)
+				}
+			} else {
+				inner.params.RankBy = "last"
+			}
+			if e.op == "topk" {
+				inner.params.TopK = e.param
+			} else {
+				inner.params.BottomK = e.param
+			}
+			return inner, nil
+
+		case isCall && !groupAggregations[e.op]:
+			return nil, fmt.Errorf("%s of %s is not supported", e.op, call.fn)
+
+		case isCall:
+			// aggregate the function values of the group series in each step
+			inner.params.GroupAggr = e.op
+
+		case !isCall:
+			// aggregate the selector values of the group series at each evaluation time
+			inner.aggr, inner.grouping, inner.without = e.op, e.grouping, e.without
+			return inner, nil
+		}
+
+		inner.params.GroupBy = e.grouping
+		inner.params.Without = e.without
+		if !e.without && len(e.grouping) == 0 {
+			// a label name which no series has puts all the series in one group
+			inner.params.GroupBy = []string{""}
+		}
+		return inner, nil
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+func callPlan(e *callExpr) (*plan, error) {
+	p := &plan{params: &querier.SelectParams{Matchers: e.arg.matchers}, rangeMs: e.arg.rangeMs}
+	aggr := rangeFunctions[e.fn]
+
+	switch {
+	case aggr == "stddev" || aggr == "stdvar":
+		p.params.Functions = aggr
+		p.exactRange = true
+	case aggr != e.fn:
+		// <aggr>_over_time is a window function over the step aggregates
+		if e.arg.rangeMs%1000 != 0 {
+			return nil, fmt.Errorf("%s range must be whole seconds", e.fn)
+		}
+		p.params.Functions = fmt.Sprintf("%s(%ds)", e.fn, e.arg.rangeMs/1000)
+	default:
+		// counter functions are calculated per step (over the step samples), so the range must be the step
+		p.params.Functions = aggr
+		p.exactRange = true
+	}
+	return p, nil
+}
+
+// evaluates expressions over queriers created for the evaluation range
+type evaluator struct {
+	ctx     context.Context
+	querier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)
+}
+
+// evaluate an expression at the times start, start+step, .. end, an instant query has start == end and no step
+func (ev *evaluator) eval(e expr, start, end, step int64) ([]*series, error) {
+	p, err := newPlan(e)
+	if err != nil {
+		return nil, err
+	}
+	if !p.raw {
+		return ev.evalSteps(p, start, end, step)
+	}
+	result, err := ev.evalRaw(p, start, end, step)
+	if err != nil || p.aggr == "" {
+		return result, err
+	}
+	return aggregateSeries(result, p.aggr, p.grouping, p.without), nil
+}
+
+// the aggregation of the series values at one time (the variance is calculated with Welford's algorithm)
+type aggrValue struct {
+	sum, min, max, mean, m2 float64
+	count                   int
+}
+
+func (a *aggrValue) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.count++
+	a.sum += v
+	delta := v - a.mean
+	a.mean += delta / float64(a.count)
+	a.m2 += delta * (v - a.mean)
+}
+
+func (a *aggrValue) value(op string) float64 {
+	switch op {
+	case "avg":
+		return a.mean
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "count":
+		return float64(a.count)
+	case "stddev":
+		return math.Sqrt(a.m2 / float64(a.count))
+	case "stdvar":
+		return a.m2 / float64(a.count)
+	}
+	return a.sum
+}
+
+// aggregate the values of the series with the same group labels at each time, like PromQL the group is made of
+// the grouping labels, or of all the labels except the grouping labels and the metric name (without)
+func aggregateSeries(list []*series, op string, grouping []string, without bool) []*series {
+	type group struct {
+		lset   utils.Labels
+		values map[int64]*aggrValue
+	}
+	groups := map[string]*group{}
+
+	for _, s := range list {
+		var lset utils.Labels
+		if without {
+			lset = utils.NewBuilder(s.lset).Del(grouping...).Del(utils.MetricName).Labels()
+		} else {
+			lset = utils.Labels{}
+			for _, name := range grouping {
+				if s.lset.Has(name) {
+					lset = append(lset, utils.Label{Name: name, Value: s.lset.Get(name)})
+				}
+			}
+			sort.Sort(lset)
+		}
+
+		key := lset.String()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{lset: lset, values: map[int64]*aggrValue{}}
+			groups[key] = g
+		}
+		for _, p := range s.points {
+			value, ok := g.values[p.t]
+			if !ok {
+				value = &aggrValue{}
+				g.values[p.t] = value
+			}
+			value.add(p.v)
+		}
+	}
+
+	result := []*series{}
+	for _, g := range groups {
+		s := &series{lset: g.lset}
+		for t, value := range g.values {
+			s.points = append(s.points, point{t: t, v: value.value(op)})
+		}
+		sort.Slice(s.points, func(i, j int) bool { return s.points[i].t < s.points[j].t })
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return utils.Compare(result[i].lset, result[j].lset) < 0 })
+	return result
+}
+
+// select the raw samples, the value at each evaluation time is the last sample in the lookback window (none
+// after a staleness marker)
+func (ev *evaluator) evalRaw(p *plan, start, end, step int64) ([]*series, error) {
+	result := []*series{}
+	err := ev.selectSeries(p.params, start-lookbackDelta, end, func(lset utils.Labels, iter querier.SeriesIterator) {
+		s := &series{lset: lset}
+		var prev *point
+		hasNext := iter.Next()
+		for t := start; t <= end; t += step {
+			for hasNext {
+				st, sv := iter.At()
+				if st > t {
+					break
+				}
+				prev = &point{t: st, v: sv}
+				hasNext = iter.Next()
+			}
+			if prev != nil && prev.t > t-lookbackDelta && !utils.IsStaleNaN(prev.v) {
+				s.points = append(s.points, point{t: t, v: prev.v})
+			}
+			if step == 0 {
+				break
+			}
+		}
+		if len(s.points) > 0 {
+			result = append(result, s)
+		}
+	})
+	return result, err
+}
+
+// select the step aggregates, the aggregation steps are epoch aligned and the aggregate of the step
+// [t-step, t) is the value at t. instant queries use the range of the function (or the lookback) as the
+// step and return the last complete step
+func (ev *evaluator) evalSteps(p *plan, start, end, step int64) ([]*series, error) {
+	instant := step == 0
+	if instant {
+		step = p.rangeMs
+		if step == 0 {
+			step = lookbackDelta
+		}
+	}
+	if p.exactRange && p.rangeMs != step {
+		return nil, fmt.Errorf("%s requires the range to equal the query step", p.params.Functions)
+	}
+
+	first, last := ((start+step-1)/step)*step, (end/step)*step
+	if instant {
+		first = last
+	}
+	result := []*series{}
+	if first > last {
+		return result, nil
+	}
+
+	p.params.Step = step
+	err := ev.selectSeries(p.params, first-step, last-1, func(lset utils.Labels, iter querier.SeriesIterator) {
+		s := &series{lset: utils.NewBuilder(lset).Del(aggregatorLabel, utils.MetricName).Labels()}
+		for iter.Next() {
+			t, v := iter.At()
+			t += step
+			if t < first || t > last || math.IsNaN(v) {
+				continue
+			}
+			if instant {
+				t = start
+			}
+			s.points = append(s.points, point{t: t, v: v})
+		}
+		if len(s.points) > 0 {
+			result = append(result, s)
+		}
+	})
+	return result, err
+}
+
+// run a select over [mint, maxt] and call fn for each series (with sorted labels)
+func (ev *evaluator) selectSeries(params *querier.SelectParams, mint, maxt int64,
+	fn func(lset utils.Labels, iter querier.SeriesIterator)) error {
+
+	q, err := ev.querier(ev.ctx, mint, maxt)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	set, err := q.SelectQry(params)
+	if err != nil {
+		return err
+	}
+	for set.Next() {
+		s := set.At()
+		lset := s.Labels().Copy()
+		sort.Sort(lset)
+		iter := s.Iterator()
+		fn(lset, iter)
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return set.Err()
+}
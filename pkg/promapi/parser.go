@@ -0,0 +1,339 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package promapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// the supported PromQL subset:
+//   selectors     metric{label="value",...}, range selectors (metric{...}[5m]) only as function arguments
+//   functions     rate, irate, increase, delta and <aggr>_over_time (avg, sum, min, max, count, last, stddev, stdvar)
+//   aggregations  sum, avg, min, max, count, stddev, stdvar [by|without (labels)], topk and bottomk
+
+// PromQL functions of a range selector and the TSDB aggregate they map to
+var rangeFunctions = map[string]string{
+	"rate": "rate", "irate": "irate", "increase": "increase", "delta": "delta",
+	"avg_over_time": "avg", "sum_over_time": "sum", "min_over_time": "min", "max_over_time": "max",
+	"count_over_time": "count", "last_over_time": "last", "stddev_over_time": "stddev", "stdvar_over_time": "stdvar",
+}
+
+var aggregations = map[string]bool{
+	"sum": true, "avg": true, "min": true, "max": true, "count": true, "stddev": true, "stdvar": true,
+	"topk": true, "bottomk": true,
+}
+
+type expr interface{}
+
+// a series selector, with a range when used as a function argument
+type selectorExpr struct {
+	matchers []*utils.LabelMatcher
+	rangeMs  int64
+}
+
+// a function of a range selector, e.g. rate(http_req[5m])
+type callExpr struct {
+	fn  string
+	arg *selectorExpr
+}
+
+// an aggregation across series, e.g. sum by (os) (rate(cpu[5m])) or topk(5, cpu)
+type aggrExpr struct {
+	op       string
+	param    int // k of topk/bottomk
+	grouping []string
+	without  bool
+	inner    expr // a selector or a function
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+// parse a PromQL expression (the supported subset)
+func parseExpr(input string) (expr, error) {
+	p := &parser{input: input}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("parse error at char %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\r\n", p.input[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// return true and consume the character if it is next
+func (p *parser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(c byte) error {
+	if !p.accept(c) {
+		return p.errorf("expected %q", c)
+	}
+	return nil
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *parser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && isIdentChar(p.input[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// peek at the next identifier without consuming it
+func (p *parser) peekIdent() string {
+	pos := p.pos
+	ident := p.ident()
+	p.pos = pos
+	return ident
+}
+
+func (p *parser) expr() (expr, error) {
+	start := p.pos
+	name := p.ident()
+	next := p.peekIdent()
+	p.skipSpace()
+	isCall := p.pos < len(p.input) && p.input[p.pos] == '('
+
+	switch {
+	case aggregations[name] && (isCall || next == "by" || next == "without"):
+		return p.aggregation(name)
+	case isCall && rangeFunctions[name] != "":
+		return p.call(name)
+	case isCall:
+		return nil, p.errorf("unsupported function %s", name)
+	}
+
+	p.pos = start
+	return p.selector()
+}
+
+// metric{matchers}[range]
+func (p *parser) selector() (*selectorExpr, error) {
+	p.skipSpace()
+	start := p.pos
+	p.ident()
+	if p.accept('{') {
+		var quote byte
+		for ; p.pos < len(p.input) && (quote != 0 || p.input[p.pos] != '}'); p.pos++ {
+			switch c := p.input[p.pos]; {
+			case quote != 0 && c == '\\':
+				p.pos++
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote == 0 && (c == '"' || c == '\'' || c == '`'):
+				quote = c
+			}
+		}
+		if err := p.expect('}'); err != nil {
+			return nil, err
+		}
+	}
+
+	text := strings.TrimSpace(p.input[start:p.pos])
+	if text == "" {
+		return nil, p.errorf("expected a series selector")
+	}
+	matchers, err := utils.ParseSelector(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, p.errorf("selector %s must contain at least one matcher", text)
+	}
+	sel := &selectorExpr{matchers: matchers}
+
+	if p.accept('[') {
+		end := strings.IndexByte(p.input[p.pos:], ']')
+		if end < 0 {
+			return nil, p.errorf("missing ] in range selector")
+		}
+		sel.rangeMs, err = parseDuration(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.pos += end + 1
+	}
+	return sel, nil
+}
+
+// fn(selector[range])
+func (p *parser) call(fn string) (expr, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	sel, err := p.selector()
+	if err != nil {
+		return nil, err
+	}
+	if sel.rangeMs == 0 {
+		return nil, p.errorf("%s requires a range selector", fn)
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return &callExpr{fn: fn, arg: sel}, nil
+}
+
+// op [by|without (labels)] ([k,] expr) [by|without (labels)]
+func (p *parser) aggregation(op string) (expr, error) {
+	agg := &aggrExpr{op: op}
+	hasGrouping, err := p.grouping(agg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if op == "topk" || op == "bottomk" {
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		if agg.param, err = strconv.Atoi(p.input[start:p.pos]); err != nil || agg.param <= 0 {
+			return nil, p.errorf("%s requires a positive integer parameter", op)
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+	}
+
+	if agg.inner, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if _, ok := agg.inner.(*aggrExpr); ok {
+		return nil, p.errorf("nested aggregations are not supported")
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+
+	if !hasGrouping {
+		if _, err := p.grouping(agg); err != nil {
+			return nil, err
+		}
+	}
+	if (op == "topk" || op == "bottomk") && (agg.grouping != nil || agg.without) {
+		return nil, p.errorf("%s does not support by/without", op)
+	}
+	return agg, nil
+}
+
+// optional by (labels) or without (labels) clause
+func (p *parser) grouping(agg *aggrExpr) (bool, error) {
+	word := p.peekIdent()
+	if word != "by" && word != "without" {
+		return false, nil
+	}
+	p.ident()
+	agg.without = word == "without"
+	agg.grouping = []string{}
+
+	if err := p.expect('('); err != nil {
+		return false, err
+	}
+	for !p.accept(')') {
+		if len(agg.grouping) > 0 {
+			if err := p.expect(','); err != nil {
+				return false, err
+			}
+		}
+		label := p.ident()
+		if label == "" {
+			return false, p.errorf("expected a label name in %s", word)
+		}
+		agg.grouping = append(agg.grouping, label)
+	}
+	return true, nil
+}
+
+var durationUnits = []struct {
+	unit string
+	ms   int64
+}{
+	{"ms", 1}, {"s", 1000}, {"m", 60 * 1000}, {"h", 3600 * 1000}, {"d", 24 * 3600 * 1000},
+	{"w", 7 * 24 * 3600 * 1000}, {"y", 365 * 24 * 3600 * 1000},
+}
+
+// parse a Prometheus duration (e.g. 5m, 1h30m, 500ms) to milliseconds
+func parseDuration(str string) (int64, error) {
+	var total int64
+	rest := str
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		num, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", str)
+		}
+		rest = rest[i:]
+
+		found := false
+		for _, u := range durationUnits {
+			// ms is matched before m
+			if strings.HasPrefix(rest, u.unit) {
+				total += num * u.ms
+				rest = rest[len(u.unit):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid duration %q, use a number followed by ms, s, m, h, d, w or y", str)
+		}
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", str)
+	}
+	return total, nil
+}
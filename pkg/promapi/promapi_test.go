@@ -0,0 +1,188 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package promapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParseExpr(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		params querier.SelectParams
+		raw    bool
+	}{
+		{`cpu{os=~"linux|win"}`, querier.SelectParams{}, true},
+		{`rate(http_req{code="200"}[5m])`, querier.SelectParams{Functions: "rate"}, false},
+		{`max_over_time(cpu[1h])`, querier.SelectParams{Functions: "max_over_time(3600s)"}, false},
+		{`sum by (os) (rate(http_req[5m]))`,
+			querier.SelectParams{Functions: "rate", GroupBy: []string{"os"}, GroupAggr: "sum"}, false},
+		{`max(last_over_time(cpu[1m]))`,
+			querier.SelectParams{Functions: "last_over_time(60s)", GroupBy: []string{""}, GroupAggr: "max"}, false},
+		{`avg(cpu) without (host)`, querier.SelectParams{}, true},
+		{`count({__name__="cpu"})`, querier.SelectParams{}, true},
+		{`topk(5, cpu)`, querier.SelectParams{TopK: 5, RankBy: "last"}, true},
+		{`bottomk(3, avg_over_time(cpu[10m]))`,
+			querier.SelectParams{Functions: "avg_over_time(600s)", BottomK: 3, RankBy: "avg"}, false},
+	} {
+		e, err := parseExpr(test.query)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.query, err)
+		}
+		p, err := newPlan(e)
+		if err != nil {
+			t.Fatalf("failed to plan %s: %v", test.query, err)
+		}
+		p.params.Matchers = nil
+		if !reflect.DeepEqual(*p.params, test.params) || p.raw != test.raw {
+			t.Fatalf("wrong plan for %s: %+v", test.query, p.params)
+		}
+	}
+
+	for _, query := range []string{
+		`cpu[5m]`, `rate(cpu)`, `abs(cpu)`, `stddev(rate(cpu[5m]))`, `sum(sum(cpu))`, `topk(cpu)`, `cpu{os="linux"`,
+		`topk(5, cpu) by (os)`, `rate(cpu[5x])`, `cpu +`,
+	} {
+		e, err := parseExpr(query)
+		if err == nil {
+			_, err = newPlan(e)
+		}
+		if err == nil {
+			t.Fatalf("expected an error for %s", query)
+		}
+	}
+}
+
+func TestQueryAPI(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const minute = 60000
+	q := &tsdbtest.Querier{
+		Raw: []*tsdbtest.Series{
+			// samples every minute until minute 10, then a gap
+			{Lset: utils.FromStrings("__name__", "cpu", "host", "b"), Points: []tsdbtest.Point{
+				{T: 0, V: 1}, {T: minute, V: 2}, {T: 2 * minute, V: 3}, {T: 10 * minute, V: 4}}},
+			{Lset: utils.FromStrings("__name__", "cpu", "host", "a"), Points: []tsdbtest.Point{{T: 30000, V: 7}}},
+			{Lset: utils.FromStrings("__name__", "mem", "host", "a"), Points: []tsdbtest.Point{{T: 0, V: 1}}},
+		},
+		// step aggregates at the step start times
+		Aggr: []*tsdbtest.Series{
+			{Lset: utils.Labels{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "b"}, {Name: "Aggregator", Value: "max"}},
+				Points: []tsdbtest.Point{{T: 0, V: 2}, {T: 2 * minute, V: 3}, {T: 10 * minute, V: 4}}},
+		},
+	}
+	handler := newHandler(logger, q.New)
+
+	get := func(path string, params url.Values, status int) interface{} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?"+params.Encode(), nil))
+		resp := response{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != status {
+			t.Fatalf("unexpected response to %s %v: %d %s", path, params, rec.Code, rec.Body)
+		}
+		return resp.Data
+	}
+	toJSON := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+
+	// the selector value is the last sample in the 5 minutes lookback
+	data := get("/api/v1/query_range", url.Values{"query": {"cpu"}, "start": {"0"}, "end": {"600"}, "step": {"2m"}}, 200)
+	expected := `{"result":[{"metric":{"__name__":"cpu","host":"a"},"values":[[120,"7"],[240,"7"]]},` +
+		`{"metric":{"__name__":"cpu","host":"b"},"values":[[0,"1"],[120,"3"],[240,"3"],[360,"3"],[600,"4"]]}],` +
+		`"resultType":"matrix"}`
+	if toJSON(data) != expected {
+		t.Fatalf("wrong range query result %s", toJSON(data))
+	}
+	if mint, maxt := q.Range(); mint != -5*minute || maxt != 10*minute {
+		t.Fatalf("wrong query range %d-%d", mint, maxt)
+	}
+
+	data = get("/api/v1/query", url.Values{"query": {`cpu{host="b"}`}, "time": {"150.5"}}, 200)
+	if toJSON(data) != `{"result":[{"metric":{"__name__":"cpu","host":"b"},"value":[150.5,"3"]}],"resultType":"vector"}` {
+		t.Fatalf("wrong instant query result %s", toJSON(data))
+	}
+
+	// step aggregates are returned at the end of their step, without the metric name
+	data = get("/api/v1/query_range",
+		url.Values{"query": {"max_over_time(cpu[1m])"}, "start": {"30"}, "end": {"600"}, "step": {"60"}}, 200)
+	expected = `{"result":[{"metric":{"host":"b"},"values":[[60,"2"],[180,"3"]]}],"resultType":"matrix"}`
+	if toJSON(data) != expected {
+		t.Fatalf("wrong aggregate query result %s", toJSON(data))
+	}
+	if mint, maxt := q.Range(); q.LastParams().Step != minute || mint != 0 || maxt != 10*minute-1 {
+		t.Fatalf("wrong aggregate select %+v %d-%d", q.LastParams(), mint, maxt)
+	}
+
+	// aggregations of a selector combine the selector values of the series (one per series) at each time
+	for query, expected := range map[string]string{
+		"count(cpu)":  `[{"metric":{},"values":[[0,"1"],[120,"2"],[240,"2"],[360,"1"],[600,"1"]]}]`,
+		"sum(cpu)":    `[{"metric":{},"values":[[0,"1"],[120,"10"],[240,"10"],[360,"3"],[600,"4"]]}]`,
+		"stddev(cpu)": `[{"metric":{},"values":[[0,"0"],[120,"2"],[240,"2"],[360,"0"],[600,"0"]]}]`,
+		"max by (host) (cpu)": `[{"metric":{"host":"a"},"values":[[120,"7"],[240,"7"]]},` +
+			`{"metric":{"host":"b"},"values":[[0,"1"],[120,"3"],[240,"3"],[360,"3"],[600,"4"]]}]`,
+	} {
+		data = get("/api/v1/query_range", url.Values{"query": {query}, "start": {"0"}, "end": {"600"}, "step": {"2m"}}, 200)
+		if result := toJSON(data.(map[string]interface{})["result"]); result != expected {
+			t.Fatalf("wrong %s result %s", query, result)
+		}
+		if p := q.LastParams(); p.Functions != "" || p.GroupBy != nil {
+			t.Fatalf("the selector of %s should be selected raw %+v", query, p)
+		}
+	}
+
+	get("/api/v1/query", url.Values{"query": {"cpu["}}, 400)
+	get("/api/v1/query", url.Values{"query": {"stddev_over_time(cpu[5m])"}, "time": {"600"}}, 200)
+	get("/api/v1/query_range", url.Values{"query": {"stddev_over_time(cpu[5m])"}, "start": {"0"}, "end": {"600"},
+		"step": {"60"}}, 422)
+	get("/api/v1/query_range", url.Values{"query": {"rate(cpu[5m])"}, "start": {"0"}, "end": {"600"},
+		"step": {"15"}}, 422)
+	get("/api/v1/query_range", url.Values{"query": {"sum(rate(cpu[5m]))"}, "start": {"0"}, "end": {"600"},
+		"step": {"5m"}}, 200)
+	get("/api/v1/query_range", url.Values{"query": {"cpu"}, "start": {"0"}, "end": {"600000"}, "step": {"1"}}, 400)
+
+	data = get("/api/v1/series", url.Values{"match[]": {`{host="a"}`, "mem"}}, 200)
+	if toJSON(data) != `[{"__name__":"cpu","host":"a"},{"__name__":"mem","host":"a"}]` {
+		t.Fatalf("wrong series %s", toJSON(data))
+	}
+	data = get("/api/v1/labels", nil, 200)
+	if toJSON(data) != `["__name__","host"]` {
+		t.Fatalf("wrong labels %s", toJSON(data))
+	}
+	data = get("/api/v1/label/host/values", url.Values{"match[]": {"cpu"}}, 200)
+	if toJSON(data) != `["a","b"]` {
+		t.Fatalf("wrong label values %s", toJSON(data))
+	}
+	get("/api/v1/label/host/names", nil, 404)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+// Package protowire reads and writes the fields of protobuf messages, used to decode and encode the messages of the
+// ingestion and query protocols without generated code. the wire format is parsed by the protobuf module
+// (google.golang.org/protobuf/encoding/protowire)
+package protowire
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobuf wire types
+const (
+	WireVarint  = int(protowire.VarintType)
+	WireFixed64 = int(protowire.Fixed64Type)
+	WireBytes   = int(protowire.BytesType)
+	WireFixed32 = int(protowire.Fixed32Type)
+)
+
+// Reader reads the fields of an encoded protobuf message
+type Reader struct {
+	buf   []byte
+	pos   int
+	field protowire.Number // the field number of the last tag (needed to skip groups)
+}
+
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+func (r *Reader) Done() bool {
+	return r.pos >= len(r.buf)
+}
+
+// advance over n consumed bytes, or return the parse error (n < 0)
+func (r *Reader) consumed(n int, what string) error {
+	if n < 0 {
+		return fmt.Errorf("invalid %s at offset %d: %v", what, r.pos, protowire.ParseError(n))
+	}
+	r.pos += n
+	return nil
+}
+
+func (r *Reader) Varint() (uint64, error) {
+	v, n := protowire.ConsumeVarint(r.buf[r.pos:])
+	return v, r.consumed(n, "varint")
+}
+
+func (r *Reader) Int64() (int64, error) {
+	v, err := r.Varint()
+	return int64(v), err
+}
+
+// Next returns the field number and wire type of the next field
+func (r *Reader) Next() (int, int, error) {
+	field, wire, n := protowire.ConsumeTag(r.buf[r.pos:])
+	if err := r.consumed(n, "tag"); err != nil {
+		return 0, 0, err
+	}
+	r.field = field
+	return int(field), int(wire), nil
+}
+
+func (r *Reader) Fixed64() (uint64, error) {
+	v, n := protowire.ConsumeFixed64(r.buf[r.pos:])
+	return v, r.consumed(n, "fixed64")
+}
+
+func (r *Reader) Double() (float64, error) {
+	v, err := r.Fixed64()
+	return math.Float64frombits(v), err
+}
+
+// Bytes returns a length delimited field (sharing the message buffer)
+func (r *Reader) Bytes() ([]byte, error) {
+	b, n := protowire.ConsumeBytes(r.buf[r.pos:])
+	return b, r.consumed(n, "length delimited field")
+}
+
+func (r *Reader) String() (string, error) {
+	b, err := r.Bytes()
+	return string(b), err
+}
+
+// Skip the value of an unknown field
+func (r *Reader) Skip(wire int) error {
+	n := protowire.ConsumeFieldValue(r.field, protowire.Type(wire), r.buf[r.pos:])
+	return r.consumed(n, fmt.Sprintf("field %d", r.field))
+}
+
+// Writer encodes protobuf fields, zero values are omitted like in proto3
+type Writer struct {
+	Buf []byte
+}
+
+func (w *Writer) Varint(v uint64) {
+	w.Buf = protowire.AppendVarint(w.Buf, v)
+}
+
+func (w *Writer) Tag(field, wire int) {
+	w.Buf = protowire.AppendTag(w.Buf, protowire.Number(field), protowire.Type(wire))
+}
+
+func (w *Writer) Int64(field int, v int64) {
+	if v != 0 {
+		w.Tag(field, WireVarint)
+		w.Varint(uint64(v))
+	}
+}
+
+func (w *Writer) Double(field int, v float64) {
+	if bits := math.Float64bits(v); bits != 0 {
+		w.Tag(field, WireFixed64)
+		w.Buf = protowire.AppendFixed64(w.Buf, bits)
+	}
+}
+
+func (w *Writer) Bytes(field int, b []byte) {
+	w.Tag(field, WireBytes)
+	w.Buf = protowire.AppendBytes(w.Buf, b)
+}
+
+func (w *Writer) String(field int, s string) {
+	if s != "" {
+		w.Tag(field, WireBytes)
+		w.Buf = protowire.AppendString(w.Buf, s)
+	}
+}
+
+// Message encodes a nested message written by fn
+func (w *Writer) Message(field int, fn func(w *Writer)) {
+	nested := Writer{}
+	fn(&nested)
+	w.Bytes(field, nested.Buf)
+}
+
+// DecodeMessage calls fn for each field of an encoded message, fn must read (or skip) the field value
+func DecodeMessage(buf []byte, fn func(r *Reader, field, wire int) error) error {
+	r := NewReader(buf)
+	for !r.Done() {
+		field, wire, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if err := fn(r, field, wire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
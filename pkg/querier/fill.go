@@ -0,0 +1,193 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+type FillType int
+
+const (
+	FillDefault  FillType = iota // no fill policy, return the aggregated cells as is
+	FillNone                     // skip empty steps
+	FillNull                     // NaN in empty steps
+	FillZero                     // 0 in empty steps
+	FillPrevious                 // the previous value in empty steps
+	FillLinear                   // linear interpolation between the surrounding values
+	FillValue                    // a fixed value in empty steps
+)
+
+// FillPolicy defines the values of empty steps, the result is a fixed grid (step) for every policy except none.
+// with a raw query (no aggregation functions) and a step the raw samples are resampled to the step grid
+type FillPolicy struct {
+	Type  FillType
+	Value float64 // the fill value of FillValue
+}
+
+// parse a fill policy: none, null, zero, previous, linear or a number (fixed value)
+func ParseFill(str string) (FillPolicy, error) {
+	switch str {
+	case "":
+		return FillPolicy{Type: FillDefault}, nil
+	case "none":
+		return FillPolicy{Type: FillNone}, nil
+	case "null":
+		return FillPolicy{Type: FillNull}, nil
+	case "zero":
+		return FillPolicy{Type: FillZero}, nil
+	case "previous":
+		return FillPolicy{Type: FillPrevious}, nil
+	case "linear":
+		return FillPolicy{Type: FillLinear}, nil
+	}
+
+	val, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return FillPolicy{}, fmt.Errorf("invalid fill policy %s, use none, null, zero, previous, linear or a number", str)
+	}
+	return FillPolicy{Type: FillValue, Value: val}, nil
+}
+
+// create a grid iterator, values are the step values (NaN for an empty step) at the step times
+func fillGrid(times []int64, values []float64, fill FillPolicy) *gridSeriesIterator {
+	it := gridSeriesIterator{index: -1}
+	prev := -1
+
+	for i, v := range values {
+		t := times[i]
+		if !math.IsNaN(v) {
+			it.append(t, v)
+			prev = i
+			continue
+		}
+
+		switch fill.Type {
+		case FillNull:
+			it.append(t, math.NaN())
+		case FillZero:
+			it.append(t, 0)
+		case FillValue:
+			it.append(t, fill.Value)
+		case FillPrevious:
+			if prev >= 0 {
+				it.append(t, values[prev])
+			} else {
+				it.append(t, math.NaN())
+			}
+		case FillLinear:
+			next := i + 1
+			for next < len(values) && math.IsNaN(values[next]) {
+				next++
+			}
+			if prev >= 0 && next < len(values) {
+				it.append(t, values[prev]+(values[next]-values[prev])*float64(i-prev)/float64(next-prev))
+			} else {
+				it.append(t, math.NaN())
+			}
+		}
+	}
+
+	return &it
+}
+
+// align raw samples to the step grid (grid points between mint and maxt), previous and linear interpolate the
+// value at every grid point, the other policies use the last sample in the step ending at the grid point
+// and fill the steps without samples
+func resample(iter SeriesIterator, mint, maxt, step int64, fill FillPolicy) *gridSeriesIterator {
+	times, values := []int64{}, []float64{}
+	for iter.Next() {
+		t, v := iter.At()
+		if t >= mint && t <= maxt {
+			times = append(times, t)
+			values = append(values, v)
+		}
+	}
+
+	baseTime := ((mint + step - 1) / step) * step
+	gridTimes, grid := []int64{}, []float64{}
+	for t := baseTime; t <= maxt; t += step {
+		// index of the first sample after t
+		next := sort.Search(len(times), func(i int) bool { return times[i] > t })
+		v := math.NaN()
+
+		switch fill.Type {
+		case FillPrevious:
+			if next > 0 {
+				v = values[next-1]
+			}
+		case FillLinear:
+			if next > 0 && times[next-1] == t {
+				v = values[next-1]
+			} else if next > 0 && next < len(times) {
+				prevT, nextT := times[next-1], times[next]
+				v = values[next-1] + (values[next]-values[next-1])*float64(t-prevT)/float64(nextT-prevT)
+			}
+		default:
+			if next > 0 && times[next-1] > t-step {
+				v = values[next-1]
+			}
+		}
+		gridTimes = append(gridTimes, t)
+		grid = append(grid, v)
+	}
+
+	return fillGrid(gridTimes, grid, fill)
+}
+
+// series iterator over precalculated (fixed grid) points
+type gridSeriesIterator struct {
+	times  []int64
+	values []float64
+	index  int
+}
+
+func (it *gridSeriesIterator) append(t int64, v float64) {
+	it.times = append(it.times, t)
+	it.values = append(it.values, v)
+}
+
+func (it *gridSeriesIterator) Seek(t int64) bool {
+	if it.index < 0 {
+		it.index = 0
+	}
+	for it.index < len(it.times) && it.times[it.index] < t {
+		it.index++
+	}
+	return it.index < len(it.times)
+}
+
+func (it *gridSeriesIterator) Next() bool {
+	if it.index >= len(it.times)-1 {
+		it.index = len(it.times)
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *gridSeriesIterator) At() (t int64, v float64) {
+	return it.times[it.index], it.values[it.index]
+}
+func (it *gridSeriesIterator) Err() error { return nil }
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"math"
+	"strings"
+)
+
+// an output function of an aggregation query, a step aggregate (count, avg, rate, ..) or a window function
+// (moving_avg(5), ewma(0.3), max_over_time(1h), ..) calculated from the step aggregates
+type seriesFunction struct {
+	aggr   aggregate.AggrType
+	window *aggregate.WindowFunction
+}
+
+func (f *seriesFunction) String() string {
+	if f.window != nil {
+		return f.window.String()
+	}
+	return f.aggr.String()
+}
+
+// split the query functions to the output functions and the step aggregates they are calculated from,
+// return the step aggregates (comma separated), the output functions and the window lookback (in steps)
+func parseFunctions(functions string, step int64) (string, []*seriesFunction, int, error) {
+	if functions == "" {
+		return "", nil, 0, nil
+	}
+
+	list := []*seriesFunction{}
+	base := []string{}
+	exist := map[string]bool{}
+	lookback := 0
+
+	for _, name := range strings.Split(functions, ",") {
+		if aggregate.IsWindowFunction(name) {
+			window, err := aggregate.ParseWindowFunction(name, step)
+			if err != nil {
+				return "", nil, 0, err
+			}
+			list = append(list, &seriesFunction{window: window})
+			if window.Lookback() > lookback {
+				lookback = window.Lookback()
+			}
+			name = window.BaseFunction()
+		} else {
+			aggr, err := aggregate.AggrsFromString(name)
+			if err != nil {
+				return "", nil, 0, err
+			}
+			list = append(list, &seriesFunction{aggr: aggr})
+		}
+
+		if !exist[name] {
+			exist[name] = true
+			base = append(base, name)
+		}
+	}
+
+	return strings.Join(base, ","), list, lookback, nil
+}
+
+// return the series iterator of an output function, firstCell is the first cell returned (cells before it are
+// only used to fill the windows)
+func newFunctionIterator(aggrSet *aggregate.AggregateSet, fn *seriesFunction, baseTime, interval int64,
+	fill FillPolicy, firstCell int) SeriesIterator {
+
+	if fn.window == nil && fill.Type == FillDefault && firstCell == 0 && aggrSet.GetCellBounds() == nil {
+		return newAggrSeriesIterator(aggrSet, fn.aggr, baseTime, interval)
+	}
+
+	// with a fill policy return all the cells, otherwise return the cells up to the last cell with data
+	length := aggrSet.GetLength()
+	if fill.Type == FillDefault {
+		length = aggrSet.GetMaxCell() + 1
+	}
+
+	var values []float64
+	if fn.window != nil {
+		values = fn.window.Values(aggrSet, length)
+	} else {
+		values = make([]float64, length)
+		for i := range values {
+			values[i] = math.NaN()
+			if aggrSet.HasData(i) || fill.Type == FillDefault {
+				values[i] = aggrSet.GetCellValue(fn.aggr, i)
+			}
+		}
+	}
+
+	times := make([]int64, length)
+	for i := range times {
+		times[i] = aggrSet.GetCellTime(baseTime, i)
+	}
+
+	if firstCell > length {
+		firstCell = length
+	}
+	times, values = times[firstCell:], values[firstCell:]
+
+	if fill.Type == FillDefault {
+		return &gridSeriesIterator{times: times, values: values, index: -1}
+	}
+	return fillGrid(times, values, fill)
+}
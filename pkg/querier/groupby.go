@@ -0,0 +1,261 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
+	"sort"
+	"strings"
+)
+
+// aggregations of the function values of the group series
+var groupAggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// Group by Time Series Query, aggregate all the series with the same group labels into one series per group
+// (like PromQL sum by (labels)), with without=true the group is made of all the labels except the listed labels.
+// the step aggregates of the group series are merged, i.e. the functions are calculated over all the group samples
+func (q *V3ioQuerier) SelectGroupBy(
+	name, functions string, step int64, labels []string, without bool, filter string) (SeriesSet, error) {
+
+	return q.SelectQry(&SelectParams{
+		Name: name, Functions: functions, Step: step, GroupBy: labels, Without: without, Filter: filter})
+}
+
+func (q *V3ioQuerier) selectGroupBy(
+	params *selectParams, labels []string, without bool, groupAggr string) (SeriesSet, error) {
+
+	if params.functions == "" {
+		return nil, fmt.Errorf("group by query requires aggregation functions")
+	}
+	if params.windows != nil {
+		return nil, fmt.Errorf("group by query does not support overlapping windows")
+	}
+	if groupAggr != "" && !groupAggregations[groupAggr] {
+		return nil, fmt.Errorf("invalid group aggregation %s, must be one of sum, avg, min, max or count", groupAggr)
+	}
+	if groupAggr == "" {
+		for _, fn := range strings.Split(params.functions, ",") {
+			if !aggregate.IsMergeable(fn) {
+				return nil, fmt.Errorf("%s cannot be merged across the group series, set a group aggregation", fn)
+			}
+		}
+	}
+
+	set, err := q.selectQry(params)
+	if err != nil {
+		return nil, err
+	}
+
+	v3ioSet, ok := set.(*V3ioSeriesSet)
+	if !ok {
+		return set, nil
+	}
+	if v3ioSet.aggrSeries == nil {
+		return nil, fmt.Errorf("group by query requires an aggregation step")
+	}
+
+	return &groupSeriesSet{set: v3ioSet, labels: labels, without: without, groupAggr: groupAggr, index: -1}, nil
+}
+
+// aggregates of all the series in a group, or the function values of the series per function and time
+type seriesGroup struct {
+	lset    utils.Labels
+	aggrSet *aggregate.AggregateSet
+	values  []map[int64]*groupValue
+}
+
+// the group aggregation of the series values at one time
+type groupValue struct {
+	sum, min, max float64
+	count         int
+}
+
+func (v *groupValue) add(val float64) {
+	if v.count == 0 || val < v.min {
+		v.min = val
+	}
+	if v.count == 0 || val > v.max {
+		v.max = val
+	}
+	v.sum += val
+	v.count++
+}
+
+func (v *groupValue) value(aggr string) float64 {
+	switch aggr {
+	case "avg":
+		return v.sum / float64(v.count)
+	case "min":
+		return v.min
+	case "max":
+		return v.max
+	case "count":
+		return float64(v.count)
+	}
+	return v.sum
+}
+
+// holds the group by result set, one series per group and aggregation function
+type groupSeriesSet struct {
+	set       *V3ioSeriesSet
+	labels    []string
+	without   bool
+	groupAggr string
+	groups    []*seriesGroup
+	loaded    bool
+	index     int
+	aggrIdx   int
+	err       error
+}
+
+// read all the series and merge their aggregates per group, groups are sorted by their labels
+func (s *groupSeriesSet) load() {
+	groups := map[string]*seriesGroup{}
+
+	for s.set.Next() {
+		// the set returns every series once per function
+		if s.set.aggrIdx != 0 && s.groupAggr == "" || s.set.nullSeries {
+			continue
+		}
+
+		lset := groupLabels(initLabels(s.set.iter), s.labels, s.without)
+		key := lset.String()
+		group, ok := groups[key]
+		if s.groupAggr != "" {
+			if !ok {
+				group = &seriesGroup{lset: lset, values: make([]map[int64]*groupValue, len(s.set.functions))}
+				groups[key] = group
+			}
+			if s.err = group.addValues(s.set.At().Iterator(), s.set.aggrIdx); s.err != nil {
+				return
+			}
+			continue
+		}
+		if !ok {
+			groups[key] = &seriesGroup{lset: lset, aggrSet: s.set.aggrSet}
+			continue
+		}
+		group.aggrSet.Merge(s.set.aggrSet)
+	}
+
+	for _, group := range groups {
+		s.groups = append(s.groups, group)
+	}
+	sort.Slice(s.groups, func(i, j int) bool { return utils.Compare(s.groups[i].lset, s.groups[j].lset) < 0 })
+	s.loaded = true
+}
+
+// add the function values of a series to the group values of the function
+func (g *seriesGroup) addValues(iter SeriesIterator, aggrIdx int) error {
+	if g.values[aggrIdx] == nil {
+		g.values[aggrIdx] = map[int64]*groupValue{}
+	}
+	for iter.Next() {
+		t, v := iter.At()
+		if math.IsNaN(v) {
+			continue
+		}
+		value, ok := g.values[aggrIdx][t]
+		if !ok {
+			value = &groupValue{}
+			g.values[aggrIdx][t] = value
+		}
+		value.add(v)
+	}
+	return iter.Err()
+}
+
+// keep only the group labels (or drop them when using without), the metric name is always dropped
+func groupLabels(lset utils.Labels, labels []string, without bool) utils.Labels {
+	if without {
+		return utils.NewBuilder(lset).Del(labels...).Del("__name__").Labels()
+	}
+
+	group := utils.Labels{}
+	for _, name := range labels {
+		if lset.Has(name) {
+			group = append(group, utils.Label{Name: name, Value: lset.Get(name)})
+		}
+	}
+	sort.Sort(group)
+	return group
+}
+
+// advance to the next function or group
+func (s *groupSeriesSet) Next() bool {
+	if !s.loaded {
+		s.load()
+		if s.Err() != nil {
+			return false
+		}
+	}
+
+	numFunctions := len(s.set.functions)
+	if s.index >= 0 && s.aggrIdx < numFunctions-1 {
+		s.aggrIdx++
+		return true
+	}
+
+	s.aggrIdx = 0
+	s.index++
+	return s.index < len(s.groups)
+}
+
+func (s *groupSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.set.Err()
+}
+
+// return the aggregated series of the current group and function
+func (s *groupSeriesSet) At() Series {
+	group := s.groups[s.index]
+	fn := s.set.functions[s.aggrIdx]
+	lset := append(group.lset.Copy(), utils.Label{Name: "Aggregator", Value: fn.String()})
+
+	if s.groupAggr != "" {
+		values := group.values[s.aggrIdx]
+		iter := &gridSeriesIterator{index: -1}
+		for t := range values {
+			iter.times = append(iter.times, t)
+		}
+		sort.Slice(iter.times, func(i, j int) bool { return iter.times[i] < iter.times[j] })
+		for _, t := range iter.times {
+			iter.values = append(iter.values, values[t].value(s.groupAggr))
+		}
+		return &groupSeries{lset: lset, iter: iter}
+	}
+
+	return &groupSeries{lset: lset,
+		iter: newFunctionIterator(group.aggrSet, fn, s.set.baseTime, s.set.interval, s.set.fill, s.set.firstCell)}
+}
+
+type groupSeries struct {
+	lset utils.Labels
+	iter SeriesIterator
+}
+
+func (s *groupSeries) Labels() utils.Labels     { return s.lset }
+func (s *groupSeries) Iterator() SeriesIterator { return s.iter }
@@ -0,0 +1,173 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"github.com/v3io/v3io-tsdb/pkg/appender"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
+	"sort"
+)
+
+// source of the unflushed samples (the appender metrics cache), merged into the query results
+type HeadReader interface {
+	HeadSeries(mint, maxt int64) []*appender.HeadSeries
+}
+
+// attribute of the items of the series which are only in the head (not written yet)
+const headItemAttr = "__head"
+
+// merge the unflushed samples of the head into the query results (read your writes)
+func (q *V3ioQuerier) SetHead(head HeadReader) {
+	q.head = head
+}
+
+func headKey(name, lset interface{}) string {
+	n, _ := name.(string)
+	l, _ := lset.(string)
+	return n + "{" + l + "}"
+}
+
+// return the head series which match the query names and matchers (by series key) and their min time
+func (q *V3ioQuerier) selectHead(params *selectParams, mint, maxt int64) (map[string]*appender.HeadSeries, int64) {
+	if q.head == nil {
+		return nil, 0
+	}
+
+	names := map[string]bool{}
+	for _, name := range params.names {
+		names[name] = true
+	}
+
+	var head map[string]*appender.HeadSeries
+	headMint := int64(math.MaxInt64)
+	for _, series := range q.head.HeadSeries(mint, maxt) {
+		if len(names) > 0 && !names[series.Name] {
+			continue
+		}
+		lset := append(utils.Labels{{Name: utils.MetricName, Value: series.Name}}, utils.KeyLabels(series.Key)...)
+		if !utils.MatchLabels(lset, params.headMatchers) {
+			continue
+		}
+		if head == nil {
+			head = map[string]*appender.HeadSeries{}
+		}
+		head[headKey(series.Name, series.Key)] = series
+		if series.Times[0] < headMint {
+			headMint = series.Times[0]
+		}
+	}
+	return head, headMint
+}
+
+// return the head series of the current item, nil if it has no unflushed samples
+func (s *V3ioSeriesSet) headSeries() *appender.HeadSeries {
+	if s.head == nil {
+		return nil
+	}
+	return s.head[headKey(s.iter.GetField("_name"), s.iter.GetField("_lset"))]
+}
+
+// return true if the current item is of a series which is only in the head
+func isHeadItem(iter utils.ItemsCursor) bool {
+	return iter.GetField(headItemAttr) != nil
+}
+
+// merge the head samples with the stored samples, the head sample replaces a stored sample with the same time
+func mergeHead(iter SeriesIterator, head *appender.HeadSeries) SeriesIterator {
+	merged := &gridSeriesIterator{index: -1}
+	i := 0
+	appendHead := func(t int64) {
+		for ; i < len(head.Times) && head.Times[i] < t; i++ {
+			merged.append(head.Times[i], head.Values[i])
+		}
+	}
+
+	for iter.Next() {
+		t, v := iter.At()
+		appendHead(t)
+		if i < len(head.Times) && head.Times[i] == t {
+			continue
+		}
+		merged.append(t, v)
+	}
+	if iter.Err() != nil {
+		return &nullSeriesIterator{err: iter.Err()}
+	}
+	appendHead(math.MaxInt64)
+	return merged
+}
+
+// items cursor which returns the series which are only in the head after the stored items
+type headItemsCursor struct {
+	utils.ItemsCursor
+	head     map[string]*appender.HeadSeries
+	seen     map[string]bool
+	addItems bool // add the head only series (false if the query has a filter which can't be verified)
+	items    []map[string]interface{}
+	index    int // index in items, -1 while reading the stored items
+}
+
+func newHeadItemsCursor(iter utils.ItemsCursor, head map[string]*appender.HeadSeries, addItems bool) *headItemsCursor {
+	return &headItemsCursor{ItemsCursor: iter, head: head, seen: map[string]bool{}, addItems: addItems, index: -1}
+}
+
+func (c *headItemsCursor) Next() bool {
+	if c.index < 0 {
+		if c.ItemsCursor.Next() {
+			c.seen[headKey(c.ItemsCursor.GetField("_name"), c.ItemsCursor.GetField("_lset"))] = true
+			return true
+		}
+		if c.ItemsCursor.Err() != nil || !c.addItems {
+			return false
+		}
+
+		keys := []string{}
+		for key := range c.head {
+			if !c.seen[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := c.head[key]
+			c.items = append(c.items, map[string]interface{}{"_name": series.Name, "_lset": series.Key,
+				"__name": series.Item, "_maxtime": int(series.Times[len(series.Times)-1]), headItemAttr: true})
+		}
+	}
+
+	c.index++
+	return c.index < len(c.items)
+}
+
+func (c *headItemsCursor) GetField(name string) interface{} {
+	if c.index >= 0 {
+		return c.items[c.index][name]
+	}
+	return c.ItemsCursor.GetField(name)
+}
+
+func (c *headItemsCursor) GetFields() map[string]interface{} {
+	if c.index >= 0 {
+		return c.items[c.index]
+	}
+	return c.ItemsCursor.GetFields()
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-go-http"
+	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/partmgr"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+// Native histogram quantile query, return a series per histogram and quantile, with the quantile of the
+// observations made in every step (from the buckets increase), if step is 0 the quantile covers the whole range
+func (q *V3ioQuerier) SelectQuantile(name string, quantiles []float64, step int64, filter string) (SeriesSet, error) {
+
+	filter = strings.Replace(filter, "__name__", "_name", -1)
+	q.logger.DebugWith("Select quantile query", "quantiles", quantiles, "step", step, "filter", filter)
+
+	if !q.partitionMngr.IsCyclic() {
+		q.logger.Warn("Select with multi partitions, not supported ")
+		return nullSeriesSet{}, nil
+	}
+
+	partition := q.partitionMngr.GetHead()
+	mint := partition.CyclicMinTime(q.mint, q.maxt)
+	newSet := &histogramSeriesSet{mint: mint, maxt: q.maxt, partition: partition, quantiles: quantiles,
+		logger: q.logger}
+
+	if step == 0 {
+		newSet.baseTime = mint
+		newSet.interval = q.maxt - mint + 1
+	} else {
+		newSet.baseTime = (mint / step) * step
+		newSet.interval = step
+	}
+	newSet.length = int((q.maxt-newSet.baseTime)/newSet.interval) + 1
+
+	// use the aggregation arrays if the steps are aligned with the rollup buckets
+	rollupTime := partition.RollupTime()
+	newSet.useArrays = rollupTime != 0 && partition.AggrBuckets() > 0 && step != 0 && step%rollupTime == 0
+
+	attrs := []string{"_lset", "_name", "_maxtime", "_hbounds"}
+	if newSet.useArrays {
+		for _, attr := range []string{aggregate.HistBucketsAttr, aggregate.HistSumAttr, aggregate.HistCountAttr} {
+			attrs = append(attrs, "_h_"+attr)
+		}
+	} else {
+		newSet.attrs, _ = partition.Range2Attrs("h", mint, q.maxt)
+		attrs = append(attrs, newSet.attrs...)
+	}
+
+	q.logger.DebugWith("Select quantile - GetItems", "path", partition.GetPath(), "attr", attrs, "name", name)
+	input := v3io.GetItemsInput{Path: partition.GetPath(), AttributeNames: attrs, Filter: filter, ShardingKey: name}
+	iter, err := utils.NewAsyncItemsCursorWithContext(q.ctx, q.container, &input, q.cfg.QryWorkers)
+	if err != nil {
+		return nil, err
+	}
+	newSet.iter = iter
+
+	return newSet, nil
+}
+
+// holds the histogram quantile query result set, one series per histogram and quantile
+type histogramSeriesSet struct {
+	err       error
+	logger    logger.Logger
+	partition *partmgr.DBPartition
+	iter      utils.ItemsCursor
+	mint      int64
+	maxt      int64
+	attrs     []string
+	useArrays bool
+
+	quantiles []float64
+	qIdx      int
+	baseTime  int64
+	interval  int64
+	length    int
+	lset      utils.Labels
+	hset      *aggregate.HistogramSet
+}
+
+// advance to the next quantile or histogram series
+func (s *histogramSeriesSet) Next() bool {
+
+	if s.hset != nil && s.qIdx < len(s.quantiles)-1 {
+		s.qIdx++
+		return true
+	}
+
+	for s.iter.Next() {
+
+		boundsAttr, _ := s.iter.GetField("_hbounds").(string)
+		bounds, err := chunkenc.BoundsFromString(boundsAttr)
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		// not a histogram series
+		if len(bounds) == 0 {
+			continue
+		}
+
+		s.lset = initLabels(s.iter)
+		s.hset = aggregate.NewHistogramSet(bounds, s.length, s.baseTime, s.interval)
+		s.qIdx = 0
+
+		maxt := s.maxt
+		maxTime := s.iter.GetField("_maxtime")
+		if maxTime != nil && int64(maxTime.(int)) < maxt {
+			maxt = int64(maxTime.(int))
+		}
+
+		if s.useArrays {
+			s.err = s.arraysToHistogramSet(maxt)
+		} else {
+			s.err = s.chunksToHistogramSet(maxt)
+		}
+		if s.err != nil {
+			return false
+		}
+
+		return len(s.quantiles) > 0
+	}
+
+	return false
+}
+
+// merge the histogram aggregation arrays of the rollup buckets in the query range
+func (s *histogramSeriesSet) arraysToHistogramSet(maxt int64) error {
+	rollupTime := s.partition.RollupTime()
+	buckets := int((maxt-s.baseTime)/rollupTime) + 1
+	if buckets >= s.partition.AggrBuckets() {
+		buckets = s.partition.AggrBuckets() - 1
+	}
+
+	start := s.partition.Time2Bucket(s.baseTime)
+	end := (start + buckets) % s.partition.AggrBuckets()
+	return s.hset.MergeArrays(s.iter.GetFields(), "h", start, end, s.partition.AggrBuckets(), s.baseTime, rollupTime)
+}
+
+// decode the histogram chunks and accumulate the buckets increase
+func (s *histogramSeriesSet) chunksToHistogramSet(maxt int64) error {
+	for _, attr := range s.attrs {
+		values := s.iter.GetField(attr)
+		if values == nil {
+			continue
+		}
+
+		chunk, err := chunkenc.FromData(chunkenc.EncHistogram, values.([]byte), 0)
+		if err != nil {
+			return err
+		}
+
+		iter := chunk.(*chunkenc.HistogramChunk).HistogramIterator()
+		for iter.Next() {
+			t, h := iter.At()
+			if t >= s.mint && t <= maxt {
+				s.hset.AppendHistogram(t, h)
+			}
+		}
+
+		if iter.Err() != nil {
+			s.logger.ErrorWith("Error reading histogram chunk", "lset", s.lset, "attr", attr, "err", iter.Err())
+		}
+	}
+
+	return nil
+}
+
+// return current error
+func (s *histogramSeriesSet) Err() error {
+	if s.iter.Err() != nil {
+		return s.iter.Err()
+	}
+	return s.err
+}
+
+// return the series of the current histogram and quantile
+func (s *histogramSeriesSet) At() Series {
+	q := s.quantiles[s.qIdx]
+	lset := append(s.lset.Copy(),
+		utils.Label{Name: "Aggregator", Value: "quantile"},
+		utils.Label{Name: "quantile", Value: strconv.FormatFloat(q, 'g', -1, 64)})
+
+	return &histogramQuantileSeries{lset: lset, iter: &histogramQuantileIterator{hset: s.hset, q: q, index: -1}}
+}
+
+type histogramQuantileSeries struct {
+	lset utils.Labels
+	iter SeriesIterator
+}
+
+func (s *histogramQuantileSeries) Labels() utils.Labels     { return s.lset }
+func (s *histogramQuantileSeries) Iterator() SeriesIterator { return s.iter }
+
+// iterate over the quantile per step
+type histogramQuantileIterator struct {
+	hset  *aggregate.HistogramSet
+	q     float64
+	index int
+}
+
+func (it *histogramQuantileIterator) Seek(t int64) bool {
+	for it.index < 0 || it.hset.GetCellTime(it.index) < t {
+		if !it.Next() {
+			return false
+		}
+	}
+	return true
+}
+
+func (it *histogramQuantileIterator) Next() bool {
+	if it.index >= it.hset.GetMaxCell() {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *histogramQuantileIterator) At() (t int64, v float64) {
+	return it.hset.GetCellTime(it.index), it.hset.Quantile(it.q, it.index)
+}
+
+func (it *histogramQuantileIterator) Err() error { return nil }
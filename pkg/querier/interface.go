@@ -31,6 +31,15 @@ import (
 	"github.com/v3io/v3io-tsdb/pkg/utils"
 )
 
+// Querier is the querier interface used by the query APIs (implemented by V3ioQuerier).
+type Querier interface {
+	SelectQry(params *SelectParams) (SeriesSet, error)
+	Series(matchers ...*utils.LabelMatcher) ([]utils.Labels, error)
+	LabelNames(matchers ...*utils.LabelMatcher) ([]string, error)
+	LabelValues(name string, matchers ...*utils.LabelMatcher) ([]string, error)
+	Close() error
+}
+
 // SeriesSet contains a set of series.
 type SeriesSet interface {
 	Next() bool
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// return the label pairs of the matchers which can be resolved through the label index (label equality)
+func indexedPairs(matchers []*utils.LabelMatcher) []string {
+	pairs := []string{}
+	exist := map[string]bool{}
+	for _, m := range matchers {
+		if m.Type != utils.MatchEqual || m.Value == "" || m.Name == utils.MetricName {
+			continue
+		}
+		key := utils.LabelIndexKey(m.Name, m.Value)
+		if !exist[key] {
+			exist[key] = true
+			pairs = append(pairs, key)
+		}
+	}
+	return pairs
+}
+
+// resolve the label equality matchers through the label index, return the items of the series which have all the
+// indexed label pairs (and one of the metric names, if any), false if none of the matchers can use the index
+func (q *V3ioQuerier) indexKeys(matchers []*utils.LabelMatcher, names []string) ([]string, bool, error) {
+	pairs := indexedPairs(matchers)
+	if len(pairs) == 0 {
+		return nil, false, nil
+	}
+
+	iter, err := utils.NewKeysItemsCursor(
+		q.ctx, q.container, q.cfg.Path+utils.LabelIndexPath, pairs, []string{"*"}, q.cfg.QryWorkers)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// a series is in the result if it is in all the index items (items which are not found are skipped)
+	counts := map[string]int{}
+	for iter.Next() {
+		for attr, val := range iter.GetFields() {
+			if item, ok := utils.IndexAttrToItem(attr, val); ok {
+				counts[item]++
+			}
+		}
+	}
+	if iter.Err() != nil {
+		return nil, false, iter.Err()
+	}
+
+	keys := intersectKeys(counts, len(pairs), names)
+	q.logger.DebugWith("Label index lookup", "pairs", pairs, "names", names, "series", len(keys))
+	return keys, true, nil
+}
+
+// return the (sorted) items which appear in all the index items and belong to one of the names (if not empty)
+func intersectKeys(counts map[string]int, pairs int, names []string) []string {
+	nameSet := map[string]bool{}
+	for _, name := range names {
+		nameSet[name+"."] = true
+	}
+
+	keys := map[string]bool{}
+	for item, count := range counts {
+		if count < pairs {
+			continue
+		}
+		if len(nameSet) > 0 && !nameSet[item[:len(item)-16]] {
+			continue
+		}
+		keys[item] = true
+	}
+	return sortedKeys(keys)
+}
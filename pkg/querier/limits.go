@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-tsdb/config"
+	"sync"
+	"sync/atomic"
+)
+
+// query limit types
+const (
+	LimitSeries  = "series"
+	LimitSamples = "samples"
+	LimitBytes   = "bytes"
+)
+
+// LimitError is returned (by the series set Err()) when a query exceeds one of the configured limits
+type LimitError struct {
+	Limit string // series, samples or bytes
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("query exceeded the max %s limit (%d)", e.Limit, e.Max)
+}
+
+// return true if the error (or its cause) is a query limit error
+func IsLimitError(err error) bool {
+	_, ok := errors.Cause(err).(*LimitError)
+	return ok
+}
+
+// counts the series returned, samples decoded and bytes read by a query, the first exceeded limit is kept
+// and stops the query, safe for use by concurrent (pipelined) decoders
+type queryLimiter struct {
+	mtx        sync.Mutex
+	maxSeries  int64
+	maxSamples int64
+	maxBytes   int64
+	series     int64
+	samples    int64
+	bytes      int64
+	err        error
+}
+
+func newQueryLimiter(cfg *config.V3ioConfig) *queryLimiter {
+	return &queryLimiter{maxSeries: int64(cfg.MaxQuerySeries), maxSamples: cfg.MaxQuerySamples,
+		maxBytes: cfg.MaxQueryBytes}
+}
+
+// return the limit error (nil if no limit was exceeded)
+func (l *queryLimiter) error() error {
+	if l == nil {
+		return nil
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.err
+}
+
+func (l *queryLimiter) check(limit string, value, max int64) error {
+	if max <= 0 || value <= max {
+		return nil
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.err == nil {
+		l.err = &LimitError{Limit: limit, Max: max}
+	}
+	return l.err
+}
+
+func (l *queryLimiter) addSeries() error {
+	if l == nil {
+		return nil
+	}
+	return l.check(LimitSeries, atomic.AddInt64(&l.series, 1), l.maxSeries)
+}
+
+func (l *queryLimiter) addSample() error {
+	if l == nil {
+		return nil
+	}
+	return l.check(LimitSamples, atomic.AddInt64(&l.samples, 1), l.maxSamples)
+}
+
+// add the size of the item attributes (chunks, arrays and strings)
+func (l *queryLimiter) addItem(fields map[string]interface{}) error {
+	if l == nil {
+		return nil
+	}
+	var size int64
+	for _, val := range fields {
+		switch v := val.(type) {
+		case []byte:
+			size += int64(len(v))
+		case string:
+			size += int64(len(v))
+		default:
+			size += 8
+		}
+	}
+	return l.check(LimitBytes, atomic.AddInt64(&l.bytes, size), l.maxBytes)
+}
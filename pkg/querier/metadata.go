@@ -0,0 +1,191 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"fmt"
+	"github.com/v3io/v3io-go-http"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"sort"
+)
+
+// Series returns the label sets of the series which match the matchers (and have samples after the querier min
+// time), only the series labels are read (no chunks or aggregation arrays)
+func (q *V3ioQuerier) Series(matchers ...*utils.LabelMatcher) ([]utils.Labels, error) {
+	list := []utils.Labels{}
+	limiter := newQueryLimiter(q.cfg)
+
+	err := q.scanLabels(matchers, func(lset utils.Labels) error {
+		list = append(list, lset)
+		return limiter.addSeries()
+	})
+	return list, err
+}
+
+// LabelNames returns the (sorted) label names of the series which match the matchers, including __name__
+func (q *V3ioQuerier) LabelNames(matchers ...*utils.LabelMatcher) ([]string, error) {
+	names := map[string]bool{}
+	err := q.scanLabels(matchers, func(lset utils.Labels) error {
+		for _, label := range lset {
+			names[label.Name] = true
+		}
+		return nil
+	})
+	return sortedKeys(names), err
+}
+
+// LabelValues returns the (sorted) values of a label in the series which match the matchers, the metric names
+// (name is __name__ or empty) without matchers are read from the names table
+func (q *V3ioQuerier) LabelValues(name string, matchers ...*utils.LabelMatcher) ([]string, error) {
+	if name == "" {
+		name = utils.MetricName
+	}
+	if name == utils.MetricName && len(matchers) == 0 {
+		return q.metricNames()
+	}
+
+	values := map[string]bool{}
+	err := q.scanLabels(matchers, func(lset utils.Labels) error {
+		if value := lset.Get(name); value != "" {
+			values[value] = true
+		}
+		return nil
+	})
+	return sortedKeys(values), err
+}
+
+// return the metric names from the names table
+func (q *V3ioQuerier) metricNames() ([]string, error) {
+	list := []string{}
+
+	input := v3io.GetItemsInput{Path: q.cfg.Path + "/names/", AttributeNames: []string{"__name"}, Filter: ""}
+	iter, err := utils.NewAsyncItemsCursorWithContext(q.ctx, q.container, &input, q.cfg.QryWorkers)
+	q.logger.DebugWith("GetItems to read names", "input", input, "err", err)
+	if err != nil {
+		return list, err
+	}
+
+	for iter.Next() {
+		name := iter.GetField("__name").(string)
+		list = append(list, name)
+	}
+
+	if iter.Err() != nil {
+		q.logger.InfoWith("Failed to read names, assume empty list", "err", iter.Err().Error())
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// read the labels (_name and _lset attributes) of the series which match the matchers, fn is called per series
+func (q *V3ioQuerier) scanLabels(matchers []*utils.LabelMatcher, fn func(lset utils.Labels) error) error {
+	if !q.partitionMngr.IsCyclic() {
+		return nil
+	}
+
+	path := q.partitionMngr.GetHead().GetPath()
+	attrs := []string{"_name", "_lset", "_maxtime"}
+	names, filter, post := utils.MatchersToFilter(matchers)
+
+	// resolve the label equality matchers through the label index, all the matchers are verified on the client
+	if q.cfg.LabelIndex {
+		keys, ok, err := q.indexKeys(matchers, names)
+		if err != nil {
+			return err
+		}
+		if ok {
+			iter, err := utils.NewKeysItemsCursor(q.ctx, q.container, path, keys, attrs, q.cfg.QryWorkers)
+			if err != nil {
+				return err
+			}
+			return readLabels(iter, matchers, q.mint, fn)
+		}
+	}
+
+	if q.mint > 0 {
+		timeFilter := fmt.Sprintf("_maxtime>=%d", q.mint)
+		if filter != "" {
+			filter = "(" + filter + ") and " + timeFilter
+		} else {
+			filter = timeFilter
+		}
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+
+	cursors := []utils.ItemsCursor{}
+	for _, name := range names {
+		q.logger.DebugWith("Labels - GetItems", "path", path, "filter", filter, "name", name)
+		input := v3io.GetItemsInput{Path: path, AttributeNames: attrs, Filter: filter, ShardingKey: name}
+		iter, err := utils.NewAsyncItemsCursorWithContext(q.ctx, q.container, &input, q.cfg.QryWorkers)
+		if err != nil {
+			return err
+		}
+		cursors = append(cursors, iter)
+	}
+
+	return readLabels(utils.NewMultiItemsCursor(cursors...), post, q.mint, fn)
+}
+
+// read the items labels, skip the items which don't match the (client side) matchers or have no samples after mint
+func readLabels(iter utils.ItemsCursor, post []*utils.LabelMatcher, mint int64,
+	fn func(lset utils.Labels) error) error {
+
+	for iter.Next() {
+		if maxTime, ok := iter.GetField("_maxtime").(int); ok && int64(maxTime) < mint {
+			continue
+		}
+		lset := initLabels(iter)
+		if len(post) > 0 && !utils.MatchLabels(lset, post) {
+			continue
+		}
+		if err := fn(lset); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for key := range set {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// NewLabelsSeriesSet returns a series set of label sets without samples (e.g. the result of Series())
+func NewLabelsSeriesSet(list []utils.Labels) SeriesSet {
+	return &labelsSeriesSet{list: list, index: -1}
+}
+
+type labelsSeriesSet struct {
+	list  []utils.Labels
+	index int
+}
+
+func (s *labelsSeriesSet) Next() bool { s.index++; return s.index < len(s.list) }
+func (s *labelsSeriesSet) At() Series {
+	return &V3ioSeries{lset: s.list[s.index], iter: &nullSeriesIterator{}}
+}
+func (s *labelsSeriesSet) Err() error { return nil }
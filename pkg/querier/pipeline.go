@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"context"
+)
+
+// pipelined decoding, a reader goroutine reads the items from the cursor and a pool of workers decodes and
+// aggregates the upcoming items while the consumer processes the current series. results are returned in the
+// cursor order, the number of items in flight is bounded (the reader blocks until the consumer catches up)
+type seriesPipeline struct {
+	results chan chan *V3ioSeriesSet // per item result channel, in the cursor order
+	err     error                    // cursor, limit or cancellation error, set before results is closed
+}
+
+// an item to decode, the result is sent to out
+type pipelineJob struct {
+	fields map[string]interface{}
+	out    chan *V3ioSeriesSet
+}
+
+func (s *V3ioSeriesSet) startPipeline() {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// the workers copy the (read only) query state, the set itself is updated by the consumer
+	template := *s
+	cursor := s.iter
+	pipe := &seriesPipeline{results: make(chan chan *V3ioSeriesSet, 2*s.workers)}
+	s.pipe = pipe
+	jobs := make(chan *pipelineJob, s.workers)
+
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			for job := range jobs {
+				job.out <- template.decodeItem(job.fields)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(pipe.results)
+		defer close(jobs)
+
+		for template.nextItem(cursor) {
+			job := &pipelineJob{fields: cursor.GetFields(), out: make(chan *V3ioSeriesSet, 1)}
+			// an abandoned set (the consumer stopped calling Next) is released by canceling the context
+			select {
+			case pipe.results <- job.out:
+			case <-ctx.Done():
+				pipe.err = ctx.Err()
+				return
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				pipe.err = ctx.Err()
+				return
+			}
+		}
+
+		pipe.err = cursor.Err()
+		if pipe.err == nil {
+			pipe.err = template.limiter.error()
+		}
+	}()
+}
+
+// decode (and aggregate) a single item, using a copy of the set state
+func (s *V3ioSeriesSet) decodeItem(fields map[string]interface{}) *V3ioSeriesSet {
+	item := *s
+	item.iter = &fieldsCursor{fields: fields}
+	item.err = item.loadItem()
+
+	// raw series, decode the samples now (in the worker) instead of in the consumer
+	if item.err == nil && item.aggrSeries == nil {
+		iter := item.currSeries.Iterator()
+		samples := &gridSeriesIterator{index: -1}
+		for iter.Next() {
+			samples.append(iter.At())
+		}
+		item.err = iter.Err()
+		item.currSeries = &V3ioSeries{set: &item, lset: item.currSeries.Labels(), iter: samples}
+	}
+	return &item
+}
+
+// move to the next decoded item
+func (s *V3ioSeriesSet) nextPipelined() bool {
+	if s.pipe == nil {
+		s.startPipeline()
+	}
+
+	out, ok := <-s.pipe.results
+	if !ok {
+		s.err = s.pipe.err
+		return false
+	}
+	item := <-out
+	if item.err != nil {
+		s.err = item.err
+		return false
+	}
+
+	// the item cursor replaces the shared cursor (used for the labels and attributes of the current series)
+	s.iter = item.iter
+	s.currSeries = item.currSeries
+	s.aggrSet = item.aggrSet
+	s.baseTime = item.baseTime
+	s.nullSeries = item.nullSeries
+	return true
+}
+
+// items cursor over the attributes of a single item
+type fieldsCursor struct {
+	fields map[string]interface{}
+}
+
+func (c *fieldsCursor) Err() error                        { return nil }
+func (c *fieldsCursor) Next() bool                        { return false }
+func (c *fieldsCursor) GetField(name string) interface{}  { return c.fields[name] }
+func (c *fieldsCursor) GetFields() map[string]interface{} { return c.fields }
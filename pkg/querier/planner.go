@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"github.com/v3io/v3io-tsdb/pkg/partmgr"
+	"sort"
+)
+
+// time range [start, end)
+type timeRange struct {
+	start, end int64
+}
+
+// aggregation query plan, splits the query range between the aggregation arrays and the raw chunks
+// rollup buckets which are fully inside the query range and inside a single step cell are read from the arrays,
+// partial buckets at the range edges and buckets crossing a step boundary are calculated from the raw samples
+type queryPlan struct {
+	baseTime    int64 // start time of the first step cell (aligned to the step)
+	step        int64
+	length      int // number of step cells
+	rollupTime  int64
+	arrayRanges []timeRange // bucket aligned ranges read from the aggregation arrays
+	rawRanges   []timeRange // ranges read from the raw chunks
+	bounds      []int64     // cell boundaries of calendar steps (variable length cells)
+	rawFrom     int64       // read the buckets from this time from the raw chunks (0 for none)
+}
+
+func newQueryPlan(mint, maxt, step, rollupTime int64, useArrays bool) *queryPlan {
+	plan := queryPlan{baseTime: (mint / step) * step, step: step, rollupTime: rollupTime}
+	plan.length = int((maxt-plan.baseTime)/step) + 1
+	plan.split(mint, maxt, useArrays)
+	return &plan
+}
+
+// query plan of calendar steps, cell i is [bounds[i], bounds[i+1]), rollup buckets are read from the arrays
+// only if they don't cross a step boundary (e.g. local midnight which is not aligned to the rollup interval)
+func newCalendarPlan(mint, maxt int64, bounds []int64, rollupTime int64, useArrays bool) *queryPlan {
+	plan := queryPlan{baseTime: bounds[0], rollupTime: rollupTime, bounds: bounds, length: len(bounds) - 1}
+	plan.split(mint, maxt, useArrays)
+	return &plan
+}
+
+// split the query range again, reading the buckets from rawFrom (e.g. of unflushed samples) from the raw chunks
+func (p *queryPlan) resplit(mint, maxt, rawFrom int64, useArrays bool) {
+	p.arrayRanges, p.rawRanges, p.rawFrom = nil, nil, rawFrom
+	p.split(mint, maxt, useArrays)
+}
+
+// split the query range between the aggregation arrays and the raw chunks
+func (p *queryPlan) split(mint, maxt int64, useArrays bool) {
+	rollupTime := p.rollupTime
+	if !useArrays || rollupTime == 0 {
+		p.rawRanges = addRange(p.rawRanges, mint, maxt+1)
+		return
+	}
+
+	for bucket := (mint / rollupTime) * rollupTime; bucket <= maxt; bucket += rollupTime {
+		end := bucket + rollupTime
+		if bucket >= mint && end-1 <= maxt && p.cell(bucket) == p.cell(end-1) && (p.rawFrom == 0 || end <= p.rawFrom) {
+			p.arrayRanges = addRange(p.arrayRanges, bucket, end)
+		} else {
+			p.rawRanges = addRange(p.rawRanges, maxInt64(bucket, mint), minInt64(end, maxt+1))
+		}
+	}
+}
+
+// add a range to the list, merge with the last range if they are adjacent
+func addRange(list []timeRange, start, end int64) []timeRange {
+	if len(list) > 0 && list[len(list)-1].end == start {
+		list[len(list)-1].end = end
+		return list
+	}
+	return append(list, timeRange{start: start, end: end})
+}
+
+// step cell index of time t
+func (p *queryPlan) cell(t int64) int {
+	if p.bounds != nil {
+		return sort.Search(len(p.bounds), func(i int) bool { return p.bounds[i] > t }) - 1
+	}
+	return int((t - p.baseTime) / p.step)
+}
+
+// is the time t in one of the raw ranges (ranges are time sorted)
+func (p *queryPlan) inRawRange(t int64) bool {
+	for _, r := range p.rawRanges {
+		if t < r.start {
+			return false
+		}
+		if t < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// return the raw chunk attributes covering the raw ranges (without duplicates, in time order)
+func (p *queryPlan) chunkAttrs(partition *partmgr.DBPartition, col string) []string {
+	attrs := []string{}
+	exist := map[string]bool{}
+	for _, r := range p.rawRanges {
+		list, _ := partition.Range2Attrs(col, r.start, r.end-1)
+		for _, attr := range list {
+			if !exist[attr] {
+				exist[attr] = true
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	return attrs
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
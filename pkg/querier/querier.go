@@ -21,22 +21,43 @@ such restriction.
 package querier
 
 import (
+	"context"
+	"fmt"
 	"github.com/nuclio/logger"
 	"github.com/v3io/v3io-go-http"
 	"github.com/v3io/v3io-tsdb/config"
 	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"github.com/v3io/v3io-tsdb/pkg/appender"
 	"github.com/v3io/v3io-tsdb/pkg/partmgr"
 	"github.com/v3io/v3io-tsdb/pkg/utils"
 	"sort"
 	"strings"
+	"time"
 )
 
-// Create a new Querier interface
-func NewV3ioQuerier(container *v3io.Container, logger logger.Logger, mint, maxt int64,
+// Create a new Querier interface, canceling the context (or the query timeout) stops the outstanding requests
+func NewV3ioQuerier(ctx context.Context, container *v3io.Container, logger logger.Logger, mint, maxt int64,
 	cfg *config.V3ioConfig, partMngr *partmgr.PartitionManager) *V3ioQuerier {
 	newQuerier := V3ioQuerier{container: container, mint: mint, maxt: maxt,
 		logger: logger.GetChild("Querier"), cfg: cfg}
 	newQuerier.partitionMngr = partMngr
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// always cancelable, so Close stops the outstanding requests and the pipeline workers
+	ctx, cancel := context.WithCancel(ctx)
+	if cfg.QryTimeoutSec > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(cfg.QryTimeoutSec)*time.Second)
+		newQuerier.cancel = func() {
+			cancelTimeout()
+			cancel()
+		}
+	} else {
+		newQuerier.cancel = cancel
+	}
+	newQuerier.ctx = ctx
 	return &newQuerier
 }
 
@@ -46,55 +67,210 @@ type V3ioQuerier struct {
 	cfg           *config.V3ioConfig
 	mint, maxt    int64
 	partitionMngr *partmgr.PartitionManager
-	overlapWin    []int
+	ctx           context.Context
+	cancel        context.CancelFunc
+	head          HeadReader // unflushed samples merged into the results (nil to read only the stored samples)
+}
+
+// Query parameters, used by SelectQry()
+type SelectParams struct {
+	Name      string                // metric name, empty for all the metrics (or use a __name__ matcher)
+	Functions string                // comma separated list of aggregation functions, e.g. count,avg,rate
+	Step      int64                 // aggregation (or resample) step in milliseconds
+	Windows   []int                 // overlapping windows (multiples of step)
+	Filter    string                // v3io filter expression
+	Matchers  []*utils.LabelMatcher // label matchers (equality, inequality, regex and alternation)
+	GroupBy   []string              // aggregate across series with the same group labels
+	Without   bool                  // group by all the labels except the GroupBy labels
+	GroupAggr string                // aggregate the function values of the group series: sum, avg, min, max, count
+	Fill      FillPolicy            // fill policy for empty steps, also enables resampling of raw series
+	Calendar  *utils.CalendarStep   // calendar step (days, weeks, months in a timezone), replaces Step
+	TopK      int                   // return only the k highest ranked series
+	BottomK   int                   // return only the k lowest ranked series
+	RankBy    string                // series rank aggregate over the query range: max, min, avg, last, sum, count
+}
+
+// query parameters (after converting the matchers)
+type selectParams struct {
+	names     []string // metric names (sharding keys), empty for all metrics
+	functions string
+	step      int64
+	windows   []int
+	filter    string
+	matchers  []*utils.LabelMatcher // matchers verified on the client side (against the series labels)
+	fill      FillPolicy
+	calendar  *utils.CalendarStep
+	limiter   *queryLimiter
+	keys      []string // series item names resolved from the label index, nil to scan (and filter) the items
+
+	headMatchers []*utils.LabelMatcher // all the matchers, used to select the head series
+	rawFilter    bool                  // the query has a v3io filter (which can't be verified on the head series)
 }
 
 // Standard Time Series Query, return a set of series which match the condition
 func (q *V3ioQuerier) Select(name, functions string, step int64, filter string) (SeriesSet, error) {
-	return q.selectQry(name, functions, step, nil, filter)
+	return q.SelectQry(&SelectParams{Name: name, Functions: functions, Step: step, Filter: filter})
 }
 
 // Overlapping windows Time Series Query, return a set of series each with a list of aggregated results per window
 // e.g. get the last 1hr, 6hr, 24hr stats per metric (specify a 1hr step of 3600*1000, 1,6,24 windows, and max time)
 func (q *V3ioQuerier) SelectOverlap(name, functions string, step int64, win []int, filter string) (SeriesSet, error) {
-	sort.Sort(sort.Reverse(sort.IntSlice(win)))
-	return q.selectQry(name, functions, step, win, filter)
+	return q.SelectQry(&SelectParams{Name: name, Functions: functions, Step: step, Windows: win, Filter: filter})
+}
+
+// Time Series Query using label matchers (equality, inequality, regex and alternation), matchers are converted
+// to v3io filter expressions where possible, the rest are checked against the series labels
+func (q *V3ioQuerier) SelectMatchers(functions string, step int64, matchers ...*utils.LabelMatcher) (SeriesSet, error) {
+	return q.SelectQry(&SelectParams{Functions: functions, Step: step, Matchers: matchers})
+}
+
+// Time Series Query with the full set of query parameters
+func (q *V3ioQuerier) SelectQry(params *SelectParams) (SeriesSet, error) {
+	qry := selectParams{names: nameList(params.Name), functions: params.Functions, step: params.Step,
+		windows: params.Windows, filter: params.Filter, fill: params.Fill,
+		calendar: params.Calendar, limiter: newQueryLimiter(q.cfg),
+		headMatchers: params.Matchers, rawFilter: params.Filter != ""}
+
+	if len(params.Matchers) > 0 {
+		names, filter, post := utils.MatchersToFilter(params.Matchers)
+		if qry.names == nil {
+			qry.names = names
+		} else if names != nil {
+			// the name is specified twice, verify the name matcher on the client side
+			post = append(post, nameMatchers(params.Matchers)...)
+		}
+		if filter != "" && qry.filter != "" {
+			qry.filter = "(" + qry.filter + ") and " + filter
+		} else if filter != "" {
+			qry.filter = filter
+		}
+		qry.matchers = post
+
+		// resolve the label equality matchers through the label index, all the matchers are verified on the client
+		if q.cfg.LabelIndex && qry.filter == "" {
+			keys, ok, err := q.indexKeys(params.Matchers, qry.names)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				qry.keys = keys
+				qry.matchers = params.Matchers
+			}
+		}
+	}
+
+	if qry.windows != nil {
+		sort.Sort(sort.Reverse(sort.IntSlice(qry.windows)))
+	}
+
+	if params.TopK > 0 || params.BottomK > 0 {
+		if len(params.GroupBy) > 0 || params.TopK > 0 && params.BottomK > 0 {
+			return nil, fmt.Errorf("top-k query cannot be combined with bottom-k or group by")
+		}
+		if params.BottomK > 0 {
+			return q.selectTopK(&qry, params.BottomK, true, params.RankBy)
+		}
+		return q.selectTopK(&qry, params.TopK, false, params.RankBy)
+	}
+
+	if len(params.GroupBy) > 0 || params.Without {
+		return q.selectGroupBy(&qry, params.GroupBy, params.Without, params.GroupAggr)
+	}
+	return q.selectQry(&qry)
+}
+
+func nameList(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
+
+// return the metric name matchers
+func nameMatchers(matchers []*utils.LabelMatcher) []*utils.LabelMatcher {
+	list := []*utils.LabelMatcher{}
+	for _, m := range matchers {
+		if m.Name == utils.MetricName {
+			list = append(list, m)
+		}
+	}
+	return list
 }
 
 // base query function
-func (q *V3ioQuerier) selectQry(name, functions string, step int64, win []int, filter string) (SeriesSet, error) {
+func (q *V3ioQuerier) selectQry(params *selectParams) (SeriesSet, error) {
 
-	filter = strings.Replace(filter, "__name__", "_name", -1)
+	filter := strings.Replace(params.filter, "__name__", "_name", -1)
+	functions, step := params.functions, params.step
 	q.logger.DebugWith("Select query", "func", functions, "step", step, "filter", filter)
 
+	if params.calendar != nil {
+		if functions == "" || params.windows != nil {
+			return nil, fmt.Errorf("calendar steps require aggregation functions and do not support overlapping windows")
+		}
+		step = params.calendar.Duration()
+	}
+
 	mint, maxt := q.mint, q.maxt
 	if q.partitionMngr.IsCyclic() {
 		partition := q.partitionMngr.GetHead()
 		mint = partition.CyclicMinTime(mint, maxt)
-		q.logger.DebugWith("Select - new cyclic series", "from", mint, "to", maxt, "name", name, "filter", filter)
-		newSet := &V3ioSeriesSet{mint: mint, maxt: maxt, partition: partition, logger: q.logger}
+		q.logger.DebugWith("Select - new cyclic series", "from", mint, "to", maxt, "names", params.names, "filter", filter)
+		newSet := &V3ioSeriesSet{mint: mint, maxt: maxt, partition: partition, logger: q.logger,
+			matchers: params.matchers, fill: params.fill, ctx: q.ctx, limiter: params.limiter, keys: params.keys,
+			workers: q.cfg.QryPipelineWorkers}
 
 		if functions != "" && step == 0 && partition.RollupTime() != 0 {
 			step = partition.RollupTime()
 		}
 
+		// window functions are calculated from step aggregates, read the steps before mint to fill the first windows
+		baseFunctions, seriesFunctions, lookback, err := parseFunctions(functions, step)
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range seriesFunctions {
+			if fn.window != nil && params.windows != nil {
+				return nil, fmt.Errorf("window functions do not support overlapping windows")
+			}
+		}
+		if lookback > 0 && step != 0 {
+			if params.calendar != nil {
+				return nil, fmt.Errorf("moving window functions do not support calendar steps")
+			}
+			newSet.mint = partition.CyclicMinTime(q.mint-int64(lookback)*step, maxt)
+			newSet.firstCell = int(mint/step - newSet.mint/step)
+		}
+
 		newAggrSeries, err := aggregate.NewAggregateSeries(
-			functions, "v", partition.AggrBuckets(), step, partition.RollupTime(), q.overlapWin)
+			baseFunctions, "v", partition.AggrBuckets(), step, partition.RollupTime(), params.windows)
 		if err != nil {
 			return nil, err
 		}
 
 		if newAggrSeries != nil && step != 0 {
 			newSet.aggrSeries = newAggrSeries
+			newSet.functions = seriesFunctions
+			newSet.interval = step
+			newSet.aggrIdx = len(seriesFunctions) - 1
+			newSet.overlapWin = params.windows
+			if params.calendar != nil {
+				newSet.bounds = params.calendar.Boundaries(mint, maxt)
+			}
+		} else if functions == "" && step != 0 && params.fill.Type != FillDefault {
+			// align the raw samples to the step grid
 			newSet.interval = step
-			newSet.aggrIdx = newAggrSeries.NumFunctions() - 1
-			newSet.overlapWin = q.overlapWin
 		}
 
-		err = newSet.getItems(partition.GetPath(), name, filter, q.container, q.cfg.QryWorkers)
+		newSet.head, newSet.headMint = q.selectHead(params, newSet.mint, maxt)
+
+		err = newSet.getItems(partition.GetPath(), params.names, filter, q.container, q.cfg.QryWorkers)
 		if err != nil {
 			return nil, err
 		}
+		if newSet.head != nil {
+			newSet.iter = newHeadItemsCursor(newSet.iter, newSet.head, !params.rawFilter)
+		}
 
 		return newSet, nil
 
@@ -107,33 +283,10 @@ func (q *V3ioQuerier) selectQry(name, functions string, step int64, win []int, f
 	return nullSeriesSet{}, nil
 }
 
-// return the current metric names
-func (q *V3ioQuerier) LabelValues(name string) ([]string, error) {
-	list := []string{}
-	//for k, _ := range *q.Keymap {
-	//	list = append(list, k)
-	//}
-
-	input := v3io.GetItemsInput{Path: q.cfg.Path + "/names/", AttributeNames: []string{"__name"}, Filter: ""}
-	//iter, err := q.container.Sync.GetItemsCursor(&input)
-	iter, err := utils.NewAsyncItemsCursor(q.container, &input, q.cfg.QryWorkers)
-	q.logger.DebugWith("GetItems to read names", "input", input, "err", err)
-	if err != nil {
-		return list, err
-	}
-
-	for iter.Next() {
-		name := iter.GetField("__name").(string)
-		list = append(list, name)
-	}
-
-	if iter.Err() != nil {
-		q.logger.InfoWith("Failed to read names, assume empty list", "err", iter.Err().Error())
-	}
-	return list, nil
-}
-
 func (q *V3ioQuerier) Close() error {
+	if q.cancel != nil {
+		q.cancel()
+	}
 	return nil
 }
 
@@ -160,131 +313,287 @@ type V3ioSeriesSet struct {
 	currSeries Series
 	aggrSet    *aggregate.AggregateSet
 	baseTime   int64
+	plan       *queryPlan
+	matchers   []*utils.LabelMatcher
+	fill       FillPolicy
+	bounds     []int64 // calendar step boundaries
+	functions  []*seriesFunction
+	firstCell  int  // first returned cell, the cells before it are only used by window functions
+	withKeys   bool // read the item names (used to rank series)
+	ctx        context.Context
+	limiter    *queryLimiter
+	workers    int // number of pipelined decoders (0 or 1 to decode on the caller goroutine)
+	pipe       *seriesPipeline
+	keys       []string                        // read only these items (resolved from the label index)
+	head       map[string]*appender.HeadSeries // unflushed samples of the matching series (by series key)
+	headMint   int64                           // min time of the unflushed samples, read from the raw chunks
 }
 
 // Get relevant items & attributes from the DB, and create an iterator
 // TODO: get items per partition + merge, per partition calc attrs
-func (s *V3ioSeriesSet) getItems(path, name, filter string, container *v3io.Container, workers int) error {
+func (s *V3ioSeriesSet) getItems(path string, names []string, filter string, container *v3io.Container, workers int) error {
+
+	attrs := s.itemAttrs()
+	if s.keys != nil {
+		s.logger.DebugWith("Select - GetItem", "path", path, "attr", attrs, "items", len(s.keys))
+		iter, err := utils.NewKeysItemsCursor(s.ctx, container, path, s.keys, attrs, workers)
+		if err != nil {
+			return err
+		}
+		s.iter = &matchItemsCursor{ItemsCursor: iter, matchers: s.matchers}
+		return nil
+	}
+
+	if len(names) == 0 {
+		names = []string{""}
+	}
+
+	// one GetItems request per metric name (sharding key)
+	cursors := []utils.ItemsCursor{}
+	for _, name := range names {
+		s.logger.DebugWith("Select - GetItems", "path", path, "attr", attrs, "filter", filter, "name", name)
+		input := v3io.GetItemsInput{Path: path, AttributeNames: attrs, Filter: filter, ShardingKey: name}
+		//iter, err := container.Sync.GetItemsCursor(&input)
+		iter, err := utils.NewAsyncItemsCursorWithContext(s.ctx, container, &input, workers)
+		//iter, err := utils.NewItemsCursor(container, &input)
+		if err != nil {
+			return err
+		}
+		cursors = append(cursors, iter)
+	}
+
+	s.iter = utils.NewMultiItemsCursor(cursors...)
+	if len(s.matchers) > 0 {
+		s.iter = &matchItemsCursor{ItemsCursor: s.iter, matchers: s.matchers}
+	}
+	return nil
+}
+
+// plan the query (chunk or aggregation array attributes kept in s.attrs) and return the item attributes to read
+func (s *V3ioSeriesSet) itemAttrs() []string {
 
 	attrs := []string{"_lset", "_meta", "_name", "_maxtime"}
+	if s.withKeys {
+		attrs = append(attrs, "__name")
+	}
 
-	if s.aggrSeries != nil && s.aggrSeries.CanAggregate(s.partition.AggrType()) && s.maxt-s.mint >= s.interval {
+	if s.aggrSeries != nil && s.overlapWin == nil {
+		// read the aggregation arrays for the fully covered rollup buckets and raw chunks for the rest
+		useArrays := s.aggrSeries.CanAggregate(s.partition.AggrType())
+		if s.bounds != nil {
+			s.plan = newCalendarPlan(s.mint, s.maxt, s.bounds, s.partition.RollupTime(), useArrays)
+		} else {
+			s.plan = newQueryPlan(s.mint, s.maxt, s.interval, s.partition.RollupTime(), useArrays)
+		}
+		if s.head != nil {
+			// the unflushed samples may not be in the aggregation arrays
+			s.plan.resplit(s.mint, s.maxt, s.headMint, useArrays)
+		}
+		if len(s.plan.arrayRanges) > 0 {
+			attrs = append(attrs, s.aggrSeries.GetAttrNames()...)
+			for _, r := range s.plan.arrayRanges {
+				attrs = append(attrs, s.sketchAttrs(r.start, r.end-1)...)
+			}
+		}
+		s.attrs = s.plan.chunkAttrs(s.partition, "v")
+	} else if s.aggrSeries != nil && s.aggrSeries.CanAggregate(s.partition.AggrType()) && s.maxt-s.mint >= s.interval &&
+		s.head == nil {
 		s.attrs = s.aggrSeries.GetAttrNames()
+		attrs = append(attrs, s.sketchAttrs(s.mint, s.maxt)...)
 	} else {
 		s.attrs, s.chunkIds = s.partition.Range2Attrs("v", s.mint, s.maxt)
 	}
-	attrs = append(attrs, s.attrs...)
+	return append(attrs, s.attrs...)
+}
 
-	s.logger.DebugWith("Select - GetItems", "path", path, "attr", attrs, "filter", filter, "name", name)
-	input := v3io.GetItemsInput{Path: path, AttributeNames: attrs, Filter: filter, ShardingKey: name}
-	//iter, err := container.Sync.GetItemsCursor(&input)
-	iter, err := utils.NewAsyncItemsCursor(container, &input, workers)
-	//iter, err := utils.NewItemsCursor(container, &input)
-	if err != nil {
-		return err
+// return the sketch attributes of the rollup buckets between mint and maxt (if the query uses sketches)
+func (s *V3ioSeriesSet) sketchAttrs(mint, maxt int64) []string {
+	attrs := []string{}
+	rollupTime := s.partition.RollupTime()
+	if !s.aggrSeries.HasSketch() || rollupTime == 0 {
+		return attrs
 	}
 
-	s.iter = iter
-	return nil
+	for t := (mint / rollupTime) * rollupTime; t <= maxt && len(attrs) < s.partition.AggrBuckets(); t += rollupTime {
+		attrs = append(attrs, s.aggrSeries.SketchAttrName(s.partition.Time2Bucket(t)))
+	}
+	return attrs
+}
 
+// items cursor which skips the items whose labels don't match the (client side) matchers
+type matchItemsCursor struct {
+	utils.ItemsCursor
+	matchers []*utils.LabelMatcher
+}
+
+func (c *matchItemsCursor) Next() bool {
+	for c.ItemsCursor.Next() {
+		if utils.MatchLabels(initLabels(c.ItemsCursor), c.matchers) {
+			return true
+		}
+	}
+	return false
+}
+
+// advance the cursor to the next item, count the item against the query limits
+func (s *V3ioSeriesSet) nextItem(cursor utils.ItemsCursor) bool {
+	if s.limiter.error() != nil || !cursor.Next() {
+		return false
+	}
+	// the ranking scans all the series, only the returned series are counted
+	if !s.withKeys && s.limiter.addSeries() != nil {
+		return false
+	}
+	return s.limiter.addItem(cursor.GetFields()) == nil
 }
 
 // advance to the next series
 func (s *V3ioSeriesSet) Next() bool {
 
-	// create raw chunks series (not aggregated)
-	if s.aggrSeries == nil {
-		if s.iter.Next() {
-			s.currSeries = NewSeries(s)
-			return true
+	// raw series or the first function of the aggregated series, move to the next item
+	if s.aggrSeries == nil || s.aggrIdx == len(s.functions)-1 {
+		if s.workers > 1 {
+			if !s.nextPipelined() {
+				return false
+			}
+		} else {
+			if !s.nextItem(s.iter) {
+				return false
+			}
+			s.err = s.loadItem()
+			if s.err != nil {
+				return false
+			}
 		}
-		return false
 	}
 
 	// create multiple aggregation series (one per aggregation function)
-	if s.aggrIdx == s.aggrSeries.NumFunctions()-1 {
-		if !s.iter.Next() {
-			return false
-		}
+	if s.aggrSeries != nil {
+		s.aggrIdx = (s.aggrIdx + 1) % len(s.functions)
+	}
+	return true
+}
 
-		s.nullSeries = false
+// create the series of the current item, decode the chunks and calculate the aggregates
+func (s *V3ioSeriesSet) loadItem() error {
 
-		if s.aggrSeries.CanAggregate(s.partition.AggrType()) && s.maxt-s.mint > s.interval {
+	// create raw chunks series (not aggregated)
+	if s.aggrSeries == nil {
+		s.currSeries = NewSeries(s)
+		return nil
+	}
 
-			// create series from aggregation arrays (in DB) if the partition stored the desired aggregates
-			maxtUpdate := s.maxt
-			maxTime := s.iter.GetField("_maxtime")
-			if maxTime != nil && int64(maxTime.(int)) < s.maxt {
-				maxtUpdate = int64(maxTime.(int))
-			}
-			mint := s.partition.CyclicMinTime(s.mint, maxtUpdate)
+	s.nullSeries = false
 
-			start := s.partition.Time2Bucket(mint)
-			end := s.partition.Time2Bucket(s.maxt + s.interval)
+	if s.plan != nil {
 
-			// len of the returned array, cropped at the end in case of cyclic overlap
-			length := int((maxtUpdate-mint)/s.interval) + 2
+		// create series from the aggregation arrays and raw chunks (per the query plan)
+		s.currSeries = NewSeries(s)
+		s.aggrSet = s.aggrSeries.NewSetFromChunks(s.plan.length, s.plan.baseTime)
+		if s.plan.bounds != nil {
+			s.aggrSet.SetCellBounds(s.plan.bounds)
+		}
+		s.baseTime = s.plan.baseTime
+		return s.plan2IntervalAggregates()
 
-			if s.overlapWin != nil {
-				s.baseTime = s.maxt //- int64(s.overlapWin[0]) * s.interval
-			} else {
-				s.baseTime = mint
-			}
+	} else if s.aggrSeries.CanAggregate(s.partition.AggrType()) && s.maxt-s.mint > s.interval && s.head == nil {
 
-			if length > 0 {
-				attrs := s.iter.GetFields()
-				aggrSet, err := s.aggrSeries.NewSetFromAttrs(length, start, end, mint, s.maxt, &attrs)
-				if err != nil {
-					s.err = err
-					return false
-				}
+		// create overlapping windows series from aggregation arrays (in DB) if the partition stored the desired aggregates
+		maxtUpdate := s.maxt
+		maxTime := s.iter.GetField("_maxtime")
+		if maxTime != nil && int64(maxTime.(int)) < s.maxt {
+			maxtUpdate = int64(maxTime.(int))
+		}
+		mint := s.partition.CyclicMinTime(s.mint, maxtUpdate)
 
-				s.aggrSet = aggrSet
-			} else {
-				s.nullSeries = true
-			}
+		start := s.partition.Time2Bucket(mint)
+		end := s.partition.Time2Bucket(s.maxt + s.interval)
 
-		} else {
+		// len of the returned array, cropped at the end in case of cyclic overlap
+		length := int((maxtUpdate-mint)/s.interval) + 2
+
+		s.baseTime = s.maxt //- int64(s.overlapWin[0]) * s.interval
 
-			// create series from raw chunks
-			s.currSeries = NewSeries(s)
-			s.aggrSet = s.aggrSeries.NewSetFromChunks(int((s.maxt-s.mint)/s.interval) + 1)
-			if s.overlapWin != nil {
-				s.chunks2WindowedAggregates()
-			} else {
-				s.chunks2IntervalAggregates()
+		if length > 0 {
+			attrs := s.iter.GetFields()
+			aggrSet, err := s.aggrSeries.NewSetFromAttrs(length, start, end, mint, s.maxt, &attrs)
+			if err != nil {
+				return err
 			}
 
+			s.aggrSet = aggrSet
+		} else {
+			s.nullSeries = true
 		}
+
+	} else {
+
+		// create overlapping windows series from raw chunks
+		s.currSeries = NewSeries(s)
+		s.aggrSet = s.aggrSeries.NewSetFromChunks(0, (s.maxt/s.interval)*s.interval)
+		s.chunks2WindowedAggregates()
+
 	}
 
-	s.aggrIdx = (s.aggrIdx + 1) % s.aggrSeries.NumFunctions()
-	return true
+	return nil
 }
 
-// convert raw chunks to fixed interval aggragator
-func (s *V3ioSeriesSet) chunks2IntervalAggregates() {
+// merge the aggregation arrays and raw samples (of the plan raw ranges) into fixed interval aggregates,
+// arrays and samples are merged in time order so the last value is correct
+func (s *V3ioSeriesSet) plan2IntervalAggregates() error {
 
-	iter := s.currSeries.Iterator()
-	if iter.Next() {
-
-		if iter.Err() != nil {
-			s.err = iter.Err()
-			return
+	var arrays map[aggregate.AggrType][]uint64
+	ranges := s.plan.arrayRanges
+	if isHeadItem(s.iter) {
+		ranges = nil // a series which is only in the head has no aggregation arrays
+	}
+	if len(ranges) > 0 {
+		var err error
+		arrays, err = s.aggrSeries.ArraysFromAttrs(s.iter.GetFields())
+		if err != nil {
+			return err
 		}
+	}
 
-		t0, _ := iter.At()
-		s.baseTime = (t0 / s.interval) * s.interval
-
-		for {
-			t, v := iter.At()
-			s.aggrSet.AppendAllCells(int((t-s.baseTime)/s.interval), v)
-			if !iter.Next() {
-				// s.err = iter.Err()  // if the internal iterator has error we dont need to err the aggregator
-				break
+	// the arrays are cyclic, the buckets after the item _maxtime (or a cycle before it) hold older values
+	maxtUpdate := s.maxt
+	if maxTime, ok := s.iter.GetField("_maxtime").(int); ok && int64(maxTime) < s.maxt {
+		maxtUpdate = int64(maxTime)
+	}
+	minUpdate := s.partition.CyclicMinTime(s.mint, maxtUpdate)
+
+	rollupTime := s.plan.rollupTime
+	mergeArraysUntil := func(t int64) {
+		for len(ranges) > 0 && ranges[0].start < t {
+			for bucket := ranges[0].start; bucket < ranges[0].end; bucket += rollupTime {
+				if bucket < minUpdate || bucket > maxtUpdate {
+					continue
+				}
+				arrayIndex := s.partition.Time2Bucket(bucket)
+				s.aggrSet.MergeArrays(arrays, arrayIndex, s.plan.cell(bucket), bucket)
+				if s.aggrSeries.HasSketch() {
+					s.aggrSet.MergeSketch(s.plan.cell(bucket), bucket, s.iter.GetField(s.aggrSeries.SketchAttrName(arrayIndex)))
+				}
 			}
+			ranges = ranges[1:]
 		}
 	}
+
+	iter := s.currSeries.Iterator()
+	for iter.Next() {
+		t, v := iter.At()
+		if s.plan.inRawRange(t) {
+			mergeArraysUntil(t)
+			s.aggrSet.AppendAllCells(s.plan.cell(t), t, v)
+		}
+	}
+	// if the internal iterator has error we dont need to err the aggregator, unless a query limit was exceeded
+	if err := s.limiter.error(); err != nil {
+		return err
+	}
+
+	mergeArraysUntil(s.maxt + 1)
+	return nil
 }
 
 // convert chunks to overlapping windows aggregator
@@ -309,7 +618,7 @@ func (s *V3ioSeriesSet) chunks2WindowedAggregates() {
 			if t < maxAligned {
 				for i, win := range s.overlapWin {
 					if t > maxAligned-int64(win)*s.interval {
-						s.aggrSet.AppendAllCells(i, v)
+						s.aggrSet.AppendAllCells(i, t, v)
 					}
 				}
 			}
@@ -326,7 +635,10 @@ func (s *V3ioSeriesSet) Err() error {
 	if s.iter.Err() != nil {
 		return s.iter.Err()
 	}
-	return s.err
+	if s.err != nil {
+		return s.err
+	}
+	return s.limiter.error()
 }
 
 // return a series iterator
@@ -335,7 +647,7 @@ func (s *V3ioSeriesSet) At() Series {
 		return s.currSeries
 	}
 
-	return NewAggrSeries(s, s.aggrSeries.GetFunctions()[s.aggrIdx])
+	return NewAggrSeries(s, s.functions[s.aggrIdx])
 }
 
 // empty series set
@@ -0,0 +1,556 @@
+package querier
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"github.com/v3io/v3io-tsdb/pkg/appender"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/partmgr"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestQueryPlan(t *testing.T) {
+	// 10 min rollup, 25 min step, query from 00:05 to 01:59:59
+	minute := int64(60 * 1000)
+	plan := newQueryPlan(5*minute, 120*minute-1, 25*minute, 10*minute, true)
+
+	if plan.baseTime != 0 || plan.length != 5 {
+		t.Fatalf("wrong base time or length: %d %d", plan.baseTime, plan.length)
+	}
+
+	// buckets 00:20-00:30 and 01:10-01:20 cross a step boundary, 00:00-00:10 is partial
+	expArrays := []timeRange{{10 * minute, 20 * minute}, {30 * minute, 70 * minute}, {80 * minute, 120 * minute}}
+	expRaw := []timeRange{{5 * minute, 10 * minute}, {20 * minute, 30 * minute}, {70 * minute, 80 * minute}}
+
+	if !reflect.DeepEqual(plan.arrayRanges, expArrays) {
+		t.Fatalf("wrong array ranges: %v", plan.arrayRanges)
+	}
+	if !reflect.DeepEqual(plan.rawRanges, expRaw) {
+		t.Fatalf("wrong raw ranges: %v", plan.rawRanges)
+	}
+	if !plan.inRawRange(25*minute) || plan.inRawRange(35*minute) || plan.inRawRange(120*minute) {
+		t.Fatal("wrong raw range lookup")
+	}
+
+	// no aggregation arrays, read the whole range from the raw chunks
+	plan = newQueryPlan(5*minute, 120*minute-1, 25*minute, 10*minute, false)
+	if len(plan.arrayRanges) != 0 || !reflect.DeepEqual(plan.rawRanges, []timeRange{{5 * minute, 120 * minute}}) {
+		t.Fatalf("wrong raw only plan: %v %v", plan.arrayRanges, plan.rawRanges)
+	}
+}
+
+func TestCalendarPlan(t *testing.T) {
+	// 1 hour rollup, days starting at 22:30 (local midnight at +01:30), query the first 2 days
+	hour := int64(3600 * 1000)
+	bounds := []int64{-3 * hour / 2, 45 * hour / 2, 93 * hour / 2, 141 * hour / 2}
+	plan := newCalendarPlan(0, 48*hour-1, bounds, hour, true)
+
+	if plan.baseTime != bounds[0] || plan.length != 3 {
+		t.Fatalf("wrong base time or length: %d %d", plan.baseTime, plan.length)
+	}
+	if plan.cell(22*hour) != 0 || plan.cell(23*hour) != 1 || plan.cell(47*hour) != 2 {
+		t.Fatal("wrong calendar cell lookup")
+	}
+
+	// the buckets which contain the local midnight are read from the raw chunks
+	expArrays := []timeRange{{0, 22 * hour}, {23 * hour, 46 * hour}, {47 * hour, 48 * hour}}
+	expRaw := []timeRange{{22 * hour, 23 * hour}, {46 * hour, 47 * hour}}
+	if !reflect.DeepEqual(plan.arrayRanges, expArrays) || !reflect.DeepEqual(plan.rawRanges, expRaw) {
+		t.Fatalf("wrong calendar plan ranges: %v %v", plan.arrayRanges, plan.rawRanges)
+	}
+}
+
+func TestParseFunctions(t *testing.T) {
+	base, list, lookback, err := parseFunctions("avg,moving_avg(5),max_over_time(1h),ewma(0.2)", 15*60*1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != "avg,max" || lookback != 4 || len(list) != 4 {
+		t.Fatalf("wrong functions %s %d %v", base, lookback, list)
+	}
+	if list[1].String() != "moving_avg(5)" || list[0].window != nil {
+		t.Fatalf("wrong output functions %v", list)
+	}
+
+	if _, _, _, err := parseFunctions("avg,moving_avg(x)", 1000); err == nil {
+		t.Fatal("expected an invalid window function error")
+	}
+}
+
+func TestRankHeap(t *testing.T) {
+	ranks := rankHeap{}
+	for i, score := range []float64{5, 1, 9, 3, 7, 8} {
+		ranks.add(&rankedItem{key: string(rune('a' + i)), score: score}, 3)
+	}
+
+	winners := ranks.sorted()
+	keys := ""
+	for _, item := range winners {
+		keys += item.key
+	}
+	if keys != "cfe" {
+		t.Fatalf("wrong top-k order %s", keys)
+	}
+}
+
+func TestQueryLimiter(t *testing.T) {
+	limiter := &queryLimiter{maxSeries: 2, maxBytes: 10}
+
+	if limiter.addSeries() != nil || limiter.addSeries() != nil {
+		t.Fatal("unexpected limit error")
+	}
+	if err := limiter.addSeries(); !IsLimitError(err) || err.(*LimitError).Limit != LimitSeries {
+		t.Fatalf("expected a series limit error, got %v", err)
+	}
+
+	// the first exceeded limit is kept
+	limiter.addItem(map[string]interface{}{"_v0": make([]byte, 20)})
+	if err := limiter.error(); err.(*LimitError).Limit != LimitSeries {
+		t.Fatalf("wrong limit error %v", err)
+	}
+
+	// the samples of series which span several chunks are counted once
+	for _, max := range []int64{3 * 720, 3*720 - 1} {
+		set := newTestSeriesSet(t, 3, "", 0, 0)
+		set.limiter = &queryLimiter{maxSamples: max}
+		samples := 0
+		for set.Next() {
+			for iter := set.At().Iterator(); iter.Next(); {
+				samples++
+			}
+		}
+		if exceeded := IsLimitError(set.limiter.error()); exceeded != (max < 3*720) || (!exceeded && samples != 3*720) {
+			t.Fatalf("wrong samples limit %d: %d samples, error %v", max, samples, set.limiter.error())
+		}
+	}
+
+	var noLimits *queryLimiter
+	if noLimits.addSample() != nil || noLimits.error() != nil {
+		t.Fatal("nil limiter should not fail")
+	}
+}
+
+func TestGroupLabels(t *testing.T) {
+	lset := utils.Labels{{Name: "__name__", Value: "cpu"}, {Name: "service", Value: "web"}, {Name: "pod", Value: "a1"}}
+
+	by := groupLabels(lset, []string{"service", "zone"}, false)
+	if !utils.Equal(by, utils.Labels{{Name: "service", Value: "web"}}) {
+		t.Fatalf("wrong group by labels: %v", by)
+	}
+
+	without := groupLabels(lset, []string{"pod"}, true)
+	if !utils.Equal(without, utils.Labels{{Name: "service", Value: "web"}}) {
+		t.Fatalf("wrong group without labels: %v", without)
+	}
+}
+
+func TestGroupAggregation(t *testing.T) {
+	// the value at the first step of the series h<i> is i+2000 (the last sample), of 60 samples
+	for _, test := range []struct {
+		groupAggr   string
+		last, count float64
+	}{{"sum", 6003, 180}, {"avg", 2001, 60}, {"max", 2002, 60}, {"min", 2000, 60}, {"count", 3, 3}} {
+		set := &groupSeriesSet{set: newTestSeriesSet(t, 3, "last,count", 600000, 0), labels: []string{""},
+			groupAggr: test.groupAggr, index: -1}
+		result := readSeriesSet(t, set)
+		if len(result) != 2 {
+			t.Fatalf("expected a series per function, got %v", result)
+		}
+		last, count := result[`{Aggregator="last"}`], result[`{Aggregator="count"}`]
+		if len(last) != 24 || last[0] != 0 || last[1] != test.last || count[1] != test.count {
+			t.Fatalf("wrong %s of the group last and count: %v %v", test.groupAggr, last, count)
+		}
+	}
+
+	// functions which depend on the number of series cannot be merged without a group aggregation
+	q := &V3ioQuerier{}
+	for _, functions := range []string{"count", "avg,last", "rate", "moving_count(3)"} {
+		params := &selectParams{functions: functions, step: 600000}
+		if _, err := q.selectGroupBy(params, []string{"host"}, false, ""); err == nil {
+			t.Fatalf("expected an error for a group by of %s", functions)
+		}
+	}
+	if _, err := q.selectGroupBy(&selectParams{functions: "avg", step: 600000}, nil, false, "last"); err == nil {
+		t.Fatal("expected an invalid group aggregation error")
+	}
+}
+
+func TestFill(t *testing.T) {
+	nan := math.NaN()
+	values := []float64{nan, 1, nan, nan, 4, nan}
+
+	expected := map[string][]float64{
+		"none":     {1, 4},
+		"null":     {nan, 1, nan, nan, 4, nan},
+		"zero":     {0, 1, 0, 0, 4, 0},
+		"previous": {nan, 1, 1, 1, 4, 4},
+		"linear":   {nan, 1, 2, 3, 4, nan},
+		"-1":       {-1, 1, -1, -1, 4, -1},
+	}
+
+	for str, exp := range expected {
+		fill, err := ParseFill(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iter := fillGrid([]int64{100, 110, 120, 130, 140, 150}, values, fill)
+		verifyGrid(t, str, iter, exp)
+	}
+
+	if _, err := ParseFill("nearest"); err == nil {
+		t.Fatal("expected an invalid fill policy error")
+	}
+}
+
+func TestResample(t *testing.T) {
+	raw := &gridSeriesIterator{index: -1}
+	for _, s := range [][2]float64{{3, 1}, {8, 2}, {25, 5}, {55, 8}} {
+		raw.append(int64(s[0]), s[1])
+	}
+
+	// grid points 10..50 (step 10), the last sample in (t-10, t]
+	iter := resample(raw, 1, 50, 10, FillPolicy{Type: FillNull})
+	verifyGrid(t, "null", iter, []float64{2, math.NaN(), 5, math.NaN(), math.NaN()})
+
+	iter.index = -1
+	if !iter.Seek(22) {
+		t.Fatal("seek failed")
+	}
+	if ts, v := iter.At(); ts != 30 || v != 5 {
+		t.Fatalf("wrong seek result %d %v", ts, v)
+	}
+
+	// interpolate between the samples, no value after the last sample
+	raw.index = -1
+	iter = resample(raw, 1, 60, 10, FillPolicy{Type: FillLinear})
+	verifyGrid(t, "linear", iter, []float64{2 + 3*2.0/17, 2 + 3*12.0/17, 5.5, 6.5, 7.5, math.NaN()})
+}
+
+func verifyGrid(t *testing.T, name string, iter SeriesIterator, expected []float64) {
+	i := 0
+	for iter.Next() {
+		_, v := iter.At()
+		if i >= len(expected) || v != expected[i] && !(math.IsNaN(v) && math.IsNaN(expected[i])) {
+			t.Fatalf("%s: wrong value %v at %d, expected %v", name, v, i, expected)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("%s: got %d points, expected %d", name, i, len(expected))
+	}
+}
+
+// items cursor over in memory items
+type sliceItemsCursor struct {
+	items []map[string]interface{}
+	index int
+}
+
+func (c *sliceItemsCursor) Err() error                        { return nil }
+func (c *sliceItemsCursor) Next() bool                        { c.index++; return c.index < len(c.items) }
+func (c *sliceItemsCursor) GetField(name string) interface{}  { return c.items[c.index][name] }
+func (c *sliceItemsCursor) GetFields() map[string]interface{} { return c.items[c.index] }
+
+// create a series set over in memory items, each item holds 2 hours of samples (one every 10 sec)
+func newTestSeriesSet(t testing.TB, series int, functions string, step int64, workers int) *V3ioSeriesSet {
+	log, err := utils.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition := partmgr.NewPartitionMngr(&config.DBPartConfig{DaysPerObj: 1, HrInChunk: 1}, "").GetHead()
+	hour := int64(3600 * 1000)
+
+	items := []map[string]interface{}{}
+	for i := 0; i < series; i++ {
+		item := map[string]interface{}{
+			"_name": "cpu", "_lset": fmt.Sprintf("host=h%d,", i), "_maxtime": int(2*hour - 1)}
+		for h := int64(0); h < 2; h++ {
+			chunk := chunkenc.NewXORChunk()
+			appender, _ := chunk.Appender()
+			data := []byte{}
+			for ts := h * hour; ts < (h+1)*hour; ts += 10000 {
+				appender.Append(ts, float64(i)+float64(ts%7000))
+				data = append(data, chunk.Bytes()...)
+				chunk.Clear()
+			}
+			item[partition.ChunkID2Attr("v", partition.TimeToChunkId(h*hour))] = data
+		}
+		items = append(items, item)
+	}
+
+	set := &V3ioSeriesSet{mint: 0, maxt: 2*hour - 1, partition: partition, logger: log, interval: step,
+		workers: workers}
+	if functions != "" {
+		base, list, _, err := parseFunctions(functions, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.functions = list
+		set.aggrIdx = len(list) - 1
+		set.aggrSeries, err = aggregate.NewAggregateSeries(base, "v", 0, step, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	set.itemAttrs()
+	set.iter = &sliceItemsCursor{items: items, index: -1}
+	return set
+}
+
+// read all the series of a set, labels to samples
+func readSeriesSet(t testing.TB, set SeriesSet) map[string][]float64 {
+	result := map[string][]float64{}
+	for set.Next() {
+		series := set.At()
+		iter := series.Iterator()
+		values := []float64{}
+		for iter.Next() {
+			tm, v := iter.At()
+			values = append(values, float64(tm), v)
+		}
+		if iter.Err() != nil {
+			t.Fatal(iter.Err())
+		}
+		result[series.Labels().String()] = values
+	}
+	if set.Err() != nil {
+		t.Fatal(set.Err())
+	}
+	return result
+}
+
+func TestPipelinedSeriesSet(t *testing.T) {
+	for _, functions := range []string{"", "avg,max", "moving_sum(3)"} {
+		expected := readSeriesSet(t, newTestSeriesSet(t, 50, functions, 600000, 0))
+		result := readSeriesSet(t, newTestSeriesSet(t, 50, functions, 600000, 4))
+
+		if len(expected) < 50 {
+			t.Fatalf("expected at least 50 series (functions %q), got %d", functions, len(expected))
+		}
+		for lset, values := range expected {
+			if len(values) == 0 {
+				t.Fatalf("no samples read for %s (functions %q)", lset, functions)
+			}
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("pipelined and sequential results differ (functions %q)", functions)
+		}
+	}
+}
+
+func TestAbandonedPipelinedSeriesSet(t *testing.T) {
+	log, err := utils.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	q := NewV3ioQuerier(nil, nil, log, 0, 0, &config.V3ioConfig{}, nil)
+	set := newTestSeriesSet(t, 200, "", 600000, 4)
+	set.ctx = q.ctx
+	if !set.Next() {
+		t.Fatalf("failed to read the first series: %v", set.Err())
+	}
+	q.Close()
+
+	// the reader and the workers exit once the querier is closed
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("pipeline goroutines leaked: %d running, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func benchmarkSeriesSet(b *testing.B, functions string, workers int) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		set := newTestSeriesSet(b, 1000, functions, 600000, workers)
+		b.StartTimer()
+		readSeriesSet(b, set)
+	}
+}
+
+func BenchmarkSeriesSet(b *testing.B)              { benchmarkSeriesSet(b, "", 0) }
+func BenchmarkSeriesSetPipelined(b *testing.B)     { benchmarkSeriesSet(b, "", 4) }
+func BenchmarkAggrSeriesSet(b *testing.B)          { benchmarkSeriesSet(b, "avg", 0) }
+func BenchmarkAggrSeriesSetPipelined(b *testing.B) { benchmarkSeriesSet(b, "avg", 4) }
+
+func TestReadLabels(t *testing.T) {
+	items := []map[string]interface{}{
+		{"_name": "cpu", "_lset": "host=a,dc=x,", "_maxtime": 2000},
+		{"_name": "cpu", "_lset": "host=c,dc=x,", "_maxtime": 500},
+		{"_name": "cpu", "_lset": "host=b,"},
+		{"_name": "mem", "_lset": "host=a,dc=y,"}}
+	post, err := utils.ParseSelector(`{dc=~"x|z"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := []string{}
+	err = readLabels(&sliceItemsCursor{items: items, index: -1}, post, 1000, func(lset utils.Labels) error {
+		list = append(list, lset.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, []string{`{__name__="cpu", host="a", dc="x"}`}) {
+		t.Fatalf("wrong series: %v", list)
+	}
+
+	set := NewLabelsSeriesSet([]utils.Labels{{{Name: "__name__", Value: "cpu"}}})
+	if !set.Next() || set.At().Labels().Get("__name__") != "cpu" || set.At().Iterator().Next() || set.Next() {
+		t.Fatal("wrong labels series set")
+	}
+	if keys := sortedKeys(map[string]bool{"b": true, "a": true}); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("wrong sorted keys: %v", keys)
+	}
+}
+
+func TestLabelIndex(t *testing.T) {
+	matchers, err := utils.ParseSelector(`cpu{host="a/1",dc="x",os!="win",host="a/1",rack=""}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := indexedPairs(matchers)
+	if !reflect.DeepEqual(pairs, []string{"host=a%2F1", "dc=x"}) {
+		t.Fatalf("wrong index pairs: %v", pairs)
+	}
+
+	item, ok := utils.IndexAttrToItem(utils.LabelIndexAttr(0xabc), "cpu")
+	if !ok || item != "cpu.0000000000000abc" {
+		t.Fatalf("wrong index attribute item: %s %v", item, ok)
+	}
+	if _, ok := utils.IndexAttrToItem("_lset", "cpu"); ok {
+		t.Fatal("non series attribute converted to an item")
+	}
+
+	counts := map[string]int{"cpu.0000000000000001": 2, "cpu.0000000000000002": 1, "mem.0000000000000003": 2}
+	if keys := intersectKeys(counts, 2, nil); !reflect.DeepEqual(keys, []string{"cpu.0000000000000001", "mem.0000000000000003"}) {
+		t.Fatalf("wrong intersection: %v", keys)
+	}
+	if keys := intersectKeys(counts, 2, []string{"mem"}); !reflect.DeepEqual(keys, []string{"mem.0000000000000003"}) {
+		t.Fatalf("wrong intersection with names: %v", keys)
+	}
+}
+
+func TestHeadMerge(t *testing.T) {
+	hour := int64(3600 * 1000)
+	head := map[string]*appender.HeadSeries{
+		// samples every 10 sec, replace the sample at 0 and add samples after the stored samples (2h)
+		headKey("cpu", "host=h1,"): {Name: "cpu", Key: "host=h1,", Item: "cpu.0000000000000001",
+			Times: []int64{0, 5000, 2 * hour}, Values: []float64{-1, -2, -3}},
+		headKey("cpu", "host=new"): {Name: "cpu", Key: "host=new", Item: "cpu.0000000000000002",
+			Times: []int64{hour}, Values: []float64{7}},
+	}
+
+	set := newTestSeriesSet(t, 3, "", 0, 0)
+	set.maxt = 2 * hour
+	set.head = head
+	set.iter = newHeadItemsCursor(set.iter, head, true)
+	result := readSeriesSet(t, set)
+
+	if len(result) != 4 {
+		t.Fatalf("expected 3 stored and 1 head series, got %d", len(result))
+	}
+	merged := result[`{__name__="cpu", host="h1"}`]
+	if !reflect.DeepEqual(merged[:6], []float64{0, -1, 5000, -2, 10000, 1 + 10000%7000}) {
+		t.Fatalf("wrong merged samples %v", merged[:6])
+	}
+	if last := merged[len(merged)-2:]; !reflect.DeepEqual(last, []float64{float64(2 * hour), -3}) {
+		t.Fatalf("wrong last merged sample %v", last)
+	}
+	if added := result[`{__name__="cpu", host="new"}`]; !reflect.DeepEqual(added, []float64{float64(hour), 7}) {
+		t.Fatalf("wrong head only series %v", added)
+	}
+
+	// aggregates, the head only series has no aggregation arrays
+	set = newTestSeriesSet(t, 3, "count", 600000, 0)
+	set.head = head
+	set.iter = newHeadItemsCursor(set.iter, head, false)
+	result = readSeriesSet(t, set)
+	if len(result) != 3 {
+		t.Fatalf("expected only the stored series with a raw filter, got %d", len(result))
+	}
+	if counts := result[`{__name__="cpu", host="h1", Aggregator="count"}`]; counts[1] != 61 {
+		t.Fatalf("wrong first step count (with the head sample) %v", counts)
+	}
+}
+
+func TestPlanRawFrom(t *testing.T) {
+	minute := int64(60 * 1000)
+	plan := newQueryPlan(0, 120*minute-1, 30*minute, 10*minute, true)
+	plan.resplit(0, 120*minute-1, 95*minute, true)
+
+	if !reflect.DeepEqual(plan.arrayRanges, []timeRange{{0, 90 * minute}}) {
+		t.Fatalf("wrong array ranges: %v", plan.arrayRanges)
+	}
+	if !reflect.DeepEqual(plan.rawRanges, []timeRange{{90 * minute, 120 * minute}}) {
+		t.Fatalf("wrong raw ranges: %v", plan.rawRanges)
+	}
+}
+
+func TestPlanStaleBuckets(t *testing.T) {
+	log, err := utils.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := int64(3600 * 1000)
+	partition := partmgr.NewPartitionMngr(
+		&config.DBPartConfig{DaysPerObj: 1, HrInChunk: 1, DefaultRollups: "count", RollupMin: 60}, "").GetHead()
+
+	// every bucket of the (24 hour) cyclic array is set, the buckets after _maxtime (26h) are of the previous cycle
+	counts := make([]byte, 16+8*24)
+	for i := 0; i < 24; i++ {
+		binary.LittleEndian.PutUint64(counts[16+8*i:], 360)
+	}
+	items := []map[string]interface{}{{"_name": "cpu", "_lset": "host=a,", "_maxtime": int(26*hour - 1), "_v_count": counts}}
+
+	set := &V3ioSeriesSet{mint: 22 * hour, maxt: 28*hour - 1, partition: partition, logger: log, interval: hour}
+	base, list, _, err := parseFunctions("count", hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.functions = list
+	set.aggrIdx = len(list) - 1
+	if set.aggrSeries, err = aggregate.NewAggregateSeries(base, "v", 24, hour, hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	set.itemAttrs()
+	if len(set.plan.arrayRanges) == 0 {
+		t.Fatal("expected the query to read the aggregation arrays")
+	}
+	set.iter = &sliceItemsCursor{items: items, index: -1}
+
+	result := readSeriesSet(t, set)
+	expected := []float64{float64(22 * hour), 360, float64(23 * hour), 360, float64(24 * hour), 360, float64(25 * hour), 360}
+	if values := result[`{__name__="cpu", host="a", Aggregator="count"}`]; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("wrong counts %v", values)
+	}
+}
+
+func TestPlanWithoutFirstArray(t *testing.T) {
+	// a DB created with "*" before the first aggregator, its items have no _v_first array
+	partition := partmgr.NewPartitionMngr(
+		&config.DBPartConfig{DaysPerObj: 1, HrInChunk: 1, DefaultRollups: "*", RollupMin: 10}, "").GetHead()
+	expected := readSeriesSet(t, newTestSeriesSet(t, 2, "rate", 1200000, 0))
+
+	set := newTestSeriesSet(t, 2, "rate", 1200000, 0)
+	set.partition = partition
+	set.itemAttrs()
+	if len(set.plan.arrayRanges) != 0 {
+		t.Fatalf("rate read from the arrays without a first array: %v", set.plan.arrayRanges)
+	}
+	if result := readSeriesSet(t, set); len(result) != 2 || !reflect.DeepEqual(result, expected) {
+		t.Fatalf("wrong rate from the raw chunks %v", result)
+	}
+}
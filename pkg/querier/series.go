@@ -24,13 +24,12 @@ import (
 	"github.com/v3io/v3io-tsdb/pkg/aggregate"
 	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
 	"github.com/v3io/v3io-tsdb/pkg/utils"
-	"strings"
 )
 
 // Create a new series from chunks
 func NewSeries(set *V3ioSeriesSet) Series {
 	newSeries := V3ioSeries{set: set}
-	newSeries.lset = initLabels(set)
+	newSeries.lset = initLabels(set.iter)
 	newSeries.initSeriesIter()
 	return &newSeries
 }
@@ -45,20 +44,11 @@ func (s *V3ioSeries) Labels() utils.Labels     { return s.lset }
 func (s *V3ioSeries) Iterator() SeriesIterator { return s.iter }
 
 // initialize the label set from _lset & name attributes
-func initLabels(set *V3ioSeriesSet) utils.Labels {
-	name := set.iter.GetField("_name").(string)
-	lsetAttr := set.iter.GetField("_lset").(string)
+func initLabels(iter utils.ItemsCursor) utils.Labels {
+	name := iter.GetField("_name").(string)
+	lsetAttr := iter.GetField("_lset").(string)
 	lset := utils.Labels{utils.Label{Name: "__name__", Value: name}}
-
-	splitLset := strings.Split(lsetAttr, ",")
-	for _, label := range splitLset {
-		kv := strings.Split(label, "=")
-		if len(kv) > 1 {
-			lset = append(lset, utils.Label{Name: kv[0], Value: kv[1]})
-		}
-	}
-
-	return lset
+	return append(lset, utils.KeyLabels(lsetAttr)...)
 }
 
 // initialize the series from value metadata & attributes
@@ -72,7 +62,7 @@ func (s *V3ioSeries) initSeriesIter() {
 
 	newIterator := v3ioSeriesIterator{
 		mint: s.set.mint, maxt: maxt, chunkTime: s.set.partition.HoursInChunk() * 3600 * 1000,
-		isCyclic: s.set.partition.IsCyclic()}
+		isCyclic: s.set.partition.IsCyclic(), limiter: s.set.limiter}
 	newIterator.chunks = []chunkenc.Chunk{}
 
 	// create and init chunk encoder per chunk blob
@@ -98,6 +88,16 @@ func (s *V3ioSeries) initSeriesIter() {
 		newIterator.iter = newIterator.chunks[0].Iterator()
 		s.iter = &newIterator
 	}
+
+	// merge the unflushed samples of the series
+	if head := s.set.headSeries(); head != nil {
+		s.iter = mergeHead(s.iter, head)
+	}
+
+	// raw query with a step and a fill policy, align the samples to the step grid
+	if s.set.aggrSeries == nil && s.set.interval != 0 && s.set.fill.Type != FillDefault {
+		s.iter = resample(s.iter, s.set.mint, s.set.maxt, s.set.interval, s.set.fill)
+	}
 }
 
 // chunk list series iterator
@@ -105,6 +105,7 @@ type v3ioSeriesIterator struct {
 	mint, maxt int64 // TBD per block
 	err        error
 	isCyclic   bool
+	limiter    *queryLimiter // counts the decoded samples
 
 	chunks     []chunkenc.Chunk
 	chunkIndex int
@@ -154,6 +155,17 @@ func (it *v3ioSeriesIterator) Seek(t int64) bool {
 
 // move to the next iterator item
 func (it *v3ioSeriesIterator) Next() bool {
+	if !it.next() {
+		return false
+	}
+	if err := it.limiter.addSample(); err != nil {
+		it.err = err
+		return false
+	}
+	return true
+}
+
+func (it *v3ioSeriesIterator) next() bool {
 	if it.iter.Next() {
 		t, _ := it.iter.At()
 		if t < it.mint {
@@ -181,52 +193,63 @@ func (it *v3ioSeriesIterator) Next() bool {
 
 	it.chunkIndex++
 	it.iter = it.chunks[it.chunkIndex].Iterator()
-	return it.Next()
+	return it.next()
 }
 
 // read the time & value at the current location
 func (it *v3ioSeriesIterator) At() (t int64, v float64) { return it.iter.At() }
 
-func (it *v3ioSeriesIterator) Err() error { return it.iter.Err() }
+func (it *v3ioSeriesIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Err()
+}
 
 // Aggregation (count, avg, sum, ..) series and iterator
 
-func NewAggrSeries(set *V3ioSeriesSet, aggr aggregate.AggrType) *V3ioSeries {
+func NewAggrSeries(set *V3ioSeriesSet, fn *seriesFunction) *V3ioSeries {
 	newSeries := V3ioSeries{set: set}
-	lset := append(initLabels(set), utils.Label{Name: "Aggregator", Value: aggr.String()})
+	lset := append(initLabels(set.iter), utils.Label{Name: "Aggregator", Value: fn.String()})
 	newSeries.lset = lset
 	if set.nullSeries {
 		newSeries.iter = &nullSeriesIterator{}
 	} else {
-		newSeries.iter = &aggrSeriesIterator{set: set, aggrType: aggr, index: -1}
+		newSeries.iter = newFunctionIterator(set.aggrSet, fn, set.baseTime, set.interval, set.fill, set.firstCell)
 	}
 	return &newSeries
 }
 
 type aggrSeriesIterator struct {
-	set      *V3ioSeriesSet
+	aggrSet  *aggregate.AggregateSet
 	aggrType aggregate.AggrType
+	baseTime int64
+	interval int64
 	index    int
 	err      error
 }
 
+func newAggrSeriesIterator(aggrSet *aggregate.AggregateSet, aggr aggregate.AggrType, baseTime, interval int64) *aggrSeriesIterator {
+	return &aggrSeriesIterator{aggrSet: aggrSet, aggrType: aggr, baseTime: baseTime, interval: interval, index: -1}
+}
+
 // advance iterator to time t
 func (s *aggrSeriesIterator) Seek(t int64) bool {
-	if t <= s.set.baseTime {
+	if t <= s.baseTime {
 		return true
 	}
 
-	if t > s.set.baseTime+int64(s.set.aggrSet.GetMaxCell())*s.set.interval {
+	if t > s.baseTime+int64(s.aggrSet.GetMaxCell())*s.interval {
 		return false
 	}
 
-	s.index = int((t - s.set.baseTime) / s.set.interval)
+	s.index = int((t - s.baseTime) / s.interval)
 	return true
 }
 
 // advance to the next time interval/bucket
 func (s *aggrSeriesIterator) Next() bool {
-	if s.index >= s.set.aggrSet.GetMaxCell() {
+	if s.index >= s.aggrSet.GetMaxCell() {
 		return false
 	}
 
@@ -236,8 +259,8 @@ func (s *aggrSeriesIterator) Next() bool {
 
 // return the time & value at the current bucket
 func (s *aggrSeriesIterator) At() (t int64, v float64) {
-	val := s.set.aggrSet.GetCellValue(s.aggrType, s.index)
-	return s.set.aggrSet.GetCellTime(s.set.baseTime, s.index), val
+	val := s.aggrSet.GetCellValue(s.aggrType, s.index)
+	return s.aggrSet.GetCellTime(s.baseTime, s.index), val
 }
 
 func (s *aggrSeriesIterator) Err() error { return s.err }
@@ -0,0 +1,205 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package querier
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"math"
+	"sort"
+	"strings"
+)
+
+// aggregates which can be used to rank series
+var rankAggregates = map[string]bool{"max": true, "min": true, "avg": true, "last": true, "sum": true, "count": true}
+
+// a ranked series (item), score is negated for bottom-k so the heap always keeps the highest scores
+type rankedItem struct {
+	key   string // item name
+	lset  string
+	score float64
+}
+
+// min heap of the k highest ranked items
+type rankHeap []*rankedItem
+
+func (h rankHeap) Len() int            { return len(h) }
+func (h rankHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h rankHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *rankHeap) Push(x interface{}) { *h = append(*h, x.(*rankedItem)) }
+func (h *rankHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// add an item, keep only the k highest scores
+func (h *rankHeap) add(item *rankedItem, k int) {
+	if h.Len() < k {
+		heap.Push(h, item)
+	} else if item.score > (*h)[0].score {
+		(*h)[0] = item
+		heap.Fix(h, 0)
+	}
+}
+
+// return the items from the highest to the lowest score
+func (h *rankHeap) sorted() []*rankedItem {
+	list := make([]*rankedItem, h.Len())
+	for i := len(list) - 1; i >= 0; i-- {
+		list[i] = heap.Pop(h).(*rankedItem)
+	}
+	return list
+}
+
+// Top-k (or bottom-k) query, rank the matching series by an aggregate over the query range and return only
+// the k highest (or lowest) ranked series, ordered by rank. the ranking reads the aggregation arrays (and the raw
+// chunks of the partial rollup buckets at the range edges), only the chunks of the winning series are decoded
+func (q *V3ioQuerier) selectTopK(params *selectParams, k int, bottom bool, rankBy string) (SeriesSet, error) {
+	if rankBy == "" {
+		rankBy = "avg"
+	}
+	if !rankAggregates[rankBy] {
+		return nil, fmt.Errorf("invalid rank aggregate %s, use max, min, avg, last, sum or count", rankBy)
+	}
+	if params.windows != nil {
+		return nil, fmt.Errorf("top-k query does not support overlapping windows")
+	}
+
+	if !q.partitionMngr.IsCyclic() {
+		return nullSeriesSet{}, nil
+	}
+
+	partition := q.partitionMngr.GetHead()
+	mint := partition.CyclicMinTime(q.mint, q.maxt)
+	maxt := q.maxt
+
+	// aggregate every series into a single cell [mint, maxt]
+	aggr, err := aggregate.AggrsFromString(rankBy)
+	if err != nil {
+		return nil, err
+	}
+	aggrSeries, err := aggregate.NewAggregateSeries(
+		rankBy, "v", partition.AggrBuckets(), maxt-mint+1, partition.RollupTime(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &V3ioSeriesSet{mint: mint, maxt: maxt, partition: partition, logger: q.logger, matchers: params.matchers,
+		aggrSeries: aggrSeries, functions: []*seriesFunction{{aggr: aggr}}, interval: maxt - mint + 1,
+		bounds: []int64{mint, maxt + 1}, withKeys: true, ctx: q.ctx, limiter: params.limiter, keys: params.keys,
+		workers: q.cfg.QryPipelineWorkers}
+
+	filter := strings.Replace(params.filter, "__name__", "_name", -1)
+	err = set.getItems(partition.GetPath(), params.names, filter, q.container, q.cfg.QryWorkers)
+	if err != nil {
+		return nil, err
+	}
+
+	ranks := rankHeap{}
+	for set.Next() {
+		score := set.aggrSet.GetCellValue(aggr, 0)
+		if !set.aggrSet.HasData(0) || math.IsNaN(score) {
+			continue
+		}
+		if bottom {
+			score = -score
+		}
+		key, _ := set.iter.GetField("__name").(string)
+		ranks.add(&rankedItem{key: key, lset: initLabels(set.iter).String(), score: score}, k)
+	}
+	if set.Err() != nil {
+		return nil, set.Err()
+	}
+
+	winners := ranks.sorted()
+	q.logger.DebugWith("Select top-k ranking", "k", k, "bottom", bottom, "rankBy", rankBy, "winners", len(winners))
+	if len(winners) == 0 {
+		return nullSeriesSet{}, nil
+	}
+
+	// read only the winning items
+	keys := []string{}
+	order := map[string]int{}
+	for i, item := range winners {
+		keys = append(keys, fmt.Sprintf("__name=='%s'", item.key))
+		order[item.lset] = i
+	}
+	keyFilter := "(" + strings.Join(keys, " or ") + ")"
+	if params.filter != "" {
+		keyFilter = "(" + params.filter + ") and " + keyFilter
+	}
+
+	winnersParams := *params
+	winnersParams.filter = keyFilter
+	if params.keys != nil {
+		// the series were resolved from the label index, read the winning items directly
+		winnersParams.keys = []string{}
+		for _, item := range winners {
+			winnersParams.keys = append(winnersParams.keys, item.key)
+		}
+	}
+	result, err := q.selectQry(&winnersParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rankedSeriesSet{set: result, order: order}, nil
+}
+
+// returns the series of a set ordered by rank (the set holds only the k winning series)
+type rankedSeriesSet struct {
+	set    SeriesSet
+	order  map[string]int // series labels to rank
+	series []Series
+	loaded bool
+	index  int
+}
+
+func (s *rankedSeriesSet) load() {
+	for s.set.Next() {
+		s.series = append(s.series, s.set.At())
+	}
+
+	rank := func(series Series) int {
+		lset := series.Labels()
+		if lset.Has("Aggregator") {
+			lset = lset[:len(lset)-1] // the aggregator label is appended last
+		}
+		return s.order[lset.String()]
+	}
+	sort.SliceStable(s.series, func(i, j int) bool { return rank(s.series[i]) < rank(s.series[j]) })
+	s.loaded = true
+	s.index = -1
+}
+
+func (s *rankedSeriesSet) Next() bool {
+	if !s.loaded {
+		s.load()
+	}
+	s.index++
+	return s.index < len(s.series)
+}
+
+func (s *rankedSeriesSet) At() Series { return s.series[s.index] }
+func (s *rankedSeriesSet) Err() error { return s.set.Err() }
@@ -0,0 +1,394 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package remote
+
+import (
+	"fmt"
+
+	"github.com/v3io/v3io-tsdb/pkg/protowire"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// the subset of the Prometheus remote protocol messages (prompb) we use, mapped on the wire by field number
+// (testdata holds messages encoded by the reference encoders)
+
+// Sample is a single time series sample, timestamp in milliseconds
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries is a label set with its samples
+type TimeSeries struct {
+	Labels  utils.Labels
+	Samples []Sample
+}
+
+// WriteRequest is the body of a remote write request
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// label matcher types
+const (
+	MatchEQ  = 0
+	MatchNEQ = 1
+	MatchRE  = 2
+	MatchNRE = 3
+)
+
+// LabelMatcher is a remote read label matcher
+type LabelMatcher struct {
+	Type  int
+	Name  string
+	Value string
+}
+
+// ReadHints are the PromQL context of the selector, times in milliseconds
+type ReadHints struct {
+	StepMs  int64  // query step
+	Func    string // function wrapping the selector, e.g. rate or max_over_time
+	StartMs int64
+	EndMs   int64
+	RangeMs int64 // range of a range selector
+}
+
+// Query is a remote read query
+type Query struct {
+	StartMs  int64
+	EndMs    int64
+	Matchers []LabelMatcher
+	Hints    *ReadHints
+}
+
+// response types of a remote read
+const (
+	ResponseSamples           = 0
+	ResponseStreamedXORChunks = 1
+)
+
+// ReadRequest is the body of a remote read request
+type ReadRequest struct {
+	Queries       []Query
+	ResponseTypes []int
+}
+
+// chunk encodings
+const (
+	encodingXOR = 1
+)
+
+// Chunk is an encoded chunk of samples
+type Chunk struct {
+	MinTimeMs int64
+	MaxTimeMs int64
+	Data      []byte
+}
+
+// ChunkedSeries is a label set with its chunks
+type ChunkedSeries struct {
+	Labels utils.Labels
+	Chunks []Chunk
+}
+
+// UnmarshalWriteRequest decodes a (decompressed) protobuf WriteRequest
+func UnmarshalWriteRequest(buf []byte) (*WriteRequest, error) {
+	req := &WriteRequest{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if field != 1 || wire != protowire.WireBytes {
+			// metadata and other fields are ignored
+			return r.Skip(wire)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		ts, err := unmarshalTimeSeries(b)
+		if err != nil {
+			return fmt.Errorf("time series %d: %v", len(req.Timeseries), err)
+		}
+		req.Timeseries = append(req.Timeseries, ts)
+		return nil
+	})
+	return req, err
+}
+
+// Marshal encodes the WriteRequest to protobuf
+func (req *WriteRequest) Marshal() []byte {
+	w := protowire.Writer{}
+	for _, ts := range req.Timeseries {
+		w.Message(1, ts.marshal)
+	}
+	return w.Buf
+}
+
+func unmarshalTimeSeries(buf []byte) (TimeSeries, error) {
+	ts := TimeSeries{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		if wire != protowire.WireBytes || field > 2 {
+			// exemplars, histograms, etc.
+			return r.Skip(wire)
+		}
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		if field == 1 {
+			lbl, err := unmarshalLabel(b)
+			ts.Labels = append(ts.Labels, lbl)
+			return err
+		}
+		sample, err := unmarshalSample(b)
+		ts.Samples = append(ts.Samples, sample)
+		return err
+	})
+	return ts, err
+}
+
+func (ts *TimeSeries) marshal(w *protowire.Writer) {
+	marshalLabels(w, ts.Labels)
+	for _, sample := range ts.Samples {
+		w.Message(2, func(w *protowire.Writer) {
+			w.Double(1, sample.Value)
+			w.Int64(2, sample.Timestamp)
+		})
+	}
+}
+
+func marshalLabels(w *protowire.Writer, lset utils.Labels) {
+	for _, lbl := range lset {
+		w.Message(1, func(w *protowire.Writer) {
+			w.String(1, lbl.Name)
+			w.String(2, lbl.Value)
+		})
+	}
+}
+
+func unmarshalLabel(buf []byte) (utils.Label, error) {
+	lbl := utils.Label{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) (err error) {
+		switch {
+		case field == 1 && wire == protowire.WireBytes:
+			lbl.Name, err = r.String()
+		case field == 2 && wire == protowire.WireBytes:
+			lbl.Value, err = r.String()
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return lbl, err
+}
+
+func unmarshalSample(buf []byte) (Sample, error) {
+	sample := Sample{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) (err error) {
+		switch {
+		case field == 1 && wire == protowire.WireFixed64:
+			sample.Value, err = r.Double()
+		case field == 2 && wire == protowire.WireVarint:
+			sample.Timestamp, err = r.Int64()
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return sample, err
+}
+
+// UnmarshalReadRequest decodes a (decompressed) protobuf ReadRequest
+func UnmarshalReadRequest(buf []byte) (*ReadRequest, error) {
+	req := &ReadRequest{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		switch {
+		case field == 1 && wire == protowire.WireBytes:
+			b, err := r.Bytes()
+			if err != nil {
+				return err
+			}
+			query, err := unmarshalQuery(b)
+			if err != nil {
+				return fmt.Errorf("query %d: %v", len(req.Queries), err)
+			}
+			req.Queries = append(req.Queries, query)
+		case field == 2 && wire == protowire.WireVarint:
+			t, err := r.Int64()
+			req.ResponseTypes = append(req.ResponseTypes, int(t))
+			return err
+		case field == 2 && wire == protowire.WireBytes:
+			// packed repeated enum
+			b, err := r.Bytes()
+			if err != nil {
+				return err
+			}
+			packed := protowire.NewReader(b)
+			for !packed.Done() {
+				t, err := packed.Int64()
+				if err != nil {
+					return err
+				}
+				req.ResponseTypes = append(req.ResponseTypes, int(t))
+			}
+		default:
+			return r.Skip(wire)
+		}
+		return nil
+	})
+	return req, err
+}
+
+// Marshal encodes the ReadRequest to protobuf
+func (req *ReadRequest) Marshal() []byte {
+	w := protowire.Writer{}
+	for _, query := range req.Queries {
+		w.Message(1, query.marshal)
+	}
+	for _, t := range req.ResponseTypes {
+		w.Tag(2, protowire.WireVarint)
+		w.Varint(uint64(t))
+	}
+	return w.Buf
+}
+
+func unmarshalQuery(buf []byte) (Query, error) {
+	query := Query{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) (err error) {
+		switch {
+		case field == 1 && wire == protowire.WireVarint:
+			query.StartMs, err = r.Int64()
+		case field == 2 && wire == protowire.WireVarint:
+			query.EndMs, err = r.Int64()
+		case field == 3 && wire == protowire.WireBytes:
+			var b []byte
+			if b, err = r.Bytes(); err == nil {
+				var matcher LabelMatcher
+				matcher, err = unmarshalMatcher(b)
+				query.Matchers = append(query.Matchers, matcher)
+			}
+		case field == 4 && wire == protowire.WireBytes:
+			var b []byte
+			if b, err = r.Bytes(); err == nil {
+				query.Hints, err = unmarshalHints(b)
+			}
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return query, err
+}
+
+func (query *Query) marshal(w *protowire.Writer) {
+	w.Int64(1, query.StartMs)
+	w.Int64(2, query.EndMs)
+	for _, matcher := range query.Matchers {
+		w.Message(3, func(w *protowire.Writer) {
+			w.Int64(1, int64(matcher.Type))
+			w.String(2, matcher.Name)
+			w.String(3, matcher.Value)
+		})
+	}
+	if hints := query.Hints; hints != nil {
+		w.Message(4, func(w *protowire.Writer) {
+			w.Int64(1, hints.StepMs)
+			w.String(2, hints.Func)
+			w.Int64(3, hints.StartMs)
+			w.Int64(4, hints.EndMs)
+			w.Int64(7, hints.RangeMs)
+		})
+	}
+}
+
+func unmarshalMatcher(buf []byte) (LabelMatcher, error) {
+	matcher := LabelMatcher{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) (err error) {
+		switch {
+		case field == 1 && wire == protowire.WireVarint:
+			var t int64
+			t, err = r.Int64()
+			matcher.Type = int(t)
+		case field == 2 && wire == protowire.WireBytes:
+			matcher.Name, err = r.String()
+		case field == 3 && wire == protowire.WireBytes:
+			matcher.Value, err = r.String()
+		default:
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return matcher, err
+}
+
+func unmarshalHints(buf []byte) (*ReadHints, error) {
+	hints := &ReadHints{}
+	err := protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) (err error) {
+		switch {
+		case field == 1 && wire == protowire.WireVarint:
+			hints.StepMs, err = r.Int64()
+		case field == 2 && wire == protowire.WireBytes:
+			hints.Func, err = r.String()
+		case field == 3 && wire == protowire.WireVarint:
+			hints.StartMs, err = r.Int64()
+		case field == 4 && wire == protowire.WireVarint:
+			hints.EndMs, err = r.Int64()
+		case field == 7 && wire == protowire.WireVarint:
+			hints.RangeMs, err = r.Int64()
+		default:
+			// grouping labels are not used
+			err = r.Skip(wire)
+		}
+		return err
+	})
+	return hints, err
+}
+
+// marshal a ReadResponse with the series of each query
+func marshalReadResponse(results [][]TimeSeries) []byte {
+	w := protowire.Writer{}
+	for _, result := range results {
+		w.Message(1, func(w *protowire.Writer) {
+			for _, ts := range result {
+				w.Message(1, ts.marshal)
+			}
+		})
+	}
+	return w.Buf
+}
+
+// marshal a ChunkedReadResponse (a single frame of a streamed response)
+func marshalChunkedResponse(series []ChunkedSeries, queryIndex int) []byte {
+	w := protowire.Writer{}
+	for _, cs := range series {
+		w.Message(1, func(w *protowire.Writer) {
+			marshalLabels(w, cs.Labels)
+			for _, chunk := range cs.Chunks {
+				w.Message(2, func(w *protowire.Writer) {
+					w.Int64(1, chunk.MinTimeMs)
+					w.Int64(2, chunk.MaxTimeMs)
+					w.Int64(3, encodingXOR)
+					w.Bytes(4, chunk.Data)
+				})
+			}
+		})
+	}
+	w.Int64(2, int64(queryIndex))
+	return w.Buf
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package remote
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const (
+	// max size of the chunks in a single frame of a streamed response
+	maxFrameBytes = 1 << 20
+
+	aggregatorLabel = "Aggregator"
+)
+
+// <aggr>_over_time functions which give the same result over the per step aggregates as over the raw samples,
+// when the range is a multiple of the step (avg only for a single step)
+var pushdownFunctions = map[string]string{
+	"max_over_time": "max", "min_over_time": "min", "sum_over_time": "sum", "last_over_time": "last",
+	"avg_over_time": "avg",
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ReadHandler is an http.Handler for the Prometheus remote read protocol, each query is translated to
+// a V3ioQuerier select (with aggregate pushdown when the hints allow it)
+type ReadHandler struct {
+	logger  logger.Logger
+	querier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)
+}
+
+// NewReadHandler creates a remote read handler, newQuerier creates a querier for a time range
+// (e.g. tsdb.V3ioAdapter.Querier)
+func NewReadHandler(logger logger.Logger,
+	newQuerier func(ctx context.Context, mint, maxt int64) (*querier.V3ioQuerier, error)) *ReadHandler {
+	return &ReadHandler{logger: logger, querier: func(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+		return newQuerier(ctx, mint, maxt)
+	}}
+}
+
+func (h *ReadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buf, status, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	req, err := UnmarshalReadRequest(buf)
+	if err != nil {
+		http.Error(w, "failed to decode the read request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, t := range req.ResponseTypes {
+		if t == ResponseStreamedXORChunks {
+			h.writeChunked(r.Context(), w, req.Queries)
+			return
+		}
+	}
+
+	// the samples response is a single message, the size of each query is bounded by the querier limits
+	results := make([][]TimeSeries, len(req.Queries))
+	for i := range req.Queries {
+		results[i], err = h.query(r.Context(), &req.Queries[i])
+		if err != nil {
+			h.logger.WarnWith("Remote read query failed", "query", i, "err", err)
+			http.Error(w, fmt.Sprintf("query %d failed: %v", i, err), queryErrorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	w.Write(snappy.Encode(nil, marshalReadResponse(results)))
+}
+
+// a query which exceeds the querier limits is a client error (narrow the selectors or the time range)
+func queryErrorStatus(err error) int {
+	if querier.IsLimitError(err) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// run a query, the series are sorted by their labels (the Prometheus merge of the remote series expects it)
+func (h *ReadHandler) query(ctx context.Context, query *Query) ([]TimeSeries, error) {
+	matchers, err := toMatchers(query.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := h.querier(ctx, query.StartMs, query.EndMs)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	var result []TimeSeries
+	if query.Hints != nil && query.Hints.Func == "series" {
+		// metadata request, only the labels are needed
+		list, err := q.Series(matchers...)
+		if err != nil {
+			return nil, err
+		}
+		for _, lset := range list {
+			result = append(result, TimeSeries{Labels: lset})
+		}
+	} else {
+		params, shift := selectParams(query)
+		params.Matchers = matchers
+		set, err := q.SelectQry(params)
+		if err != nil {
+			return nil, err
+		}
+		for set.Next() {
+			series := set.At()
+			ts := TimeSeries{Labels: utils.NewBuilder(series.Labels()).Del(aggregatorLabel).Labels()}
+			iter := series.Iterator()
+			for iter.Next() {
+				t, v := iter.At()
+				ts.Samples = append(ts.Samples, Sample{Timestamp: t + shift, Value: v})
+			}
+			if err := iter.Err(); err != nil {
+				return nil, err
+			}
+			result = append(result, ts)
+		}
+		if err := set.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range result {
+		sort.Sort(result[i].Labels)
+	}
+	sort.Slice(result, func(i, j int) bool { return utils.Compare(result[i].Labels, result[j].Labels) < 0 })
+	return result, nil
+}
+
+// translate the query hints to select params, the aggregates are pushed down for <aggr>_over_time selectors
+// when the evaluation times are aligned to the (epoch aligned) aggregation steps, returns the shift of the
+// aggregate timestamps from the step start to the last millisecond of the step, so each aggregate falls in
+// the range of the evaluation at the end of its step (samples exactly on a step boundary are counted in the
+// following step)
+func selectParams(query *Query) (*querier.SelectParams, int64) {
+	params := &querier.SelectParams{}
+	hints := query.Hints
+	if hints == nil || hints.StepMs <= 0 || hints.RangeMs < hints.StepMs || hints.RangeMs%hints.StepMs != 0 {
+		return params, 0
+	}
+
+	aggr, ok := pushdownFunctions[hints.Func]
+	if !ok || aggr == "avg" && hints.RangeMs != hints.StepMs || (query.StartMs+hints.RangeMs)%hints.StepMs != 0 {
+		return params, 0
+	}
+
+	params.Functions = aggr
+	params.Step = hints.StepMs
+	return params, hints.StepMs - 1
+}
+
+func toMatchers(list []LabelMatcher) ([]*utils.LabelMatcher, error) {
+	matchers := []*utils.LabelMatcher{}
+	for _, m := range list {
+		var matchType utils.MatchType
+		switch m.Type {
+		case MatchEQ:
+			matchType = utils.MatchEqual
+		case MatchNEQ:
+			matchType = utils.MatchNotEqual
+		case MatchRE:
+			matchType = utils.MatchRegexp
+		case MatchNRE:
+			matchType = utils.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("unsupported matcher type %d", m.Type)
+		}
+		matcher, err := utils.NewLabelMatcher(matchType, m.Name, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// write a streamed response, each frame is a ChunkedReadResponse prefixed by its size (varint) and CRC32
+// (Castagnoli), a series is split across frames when its chunks exceed maxFrameBytes. the frames of a query are
+// written before the next query runs so only one query is held in memory, an error after the first frame can't
+// change the status and ends the stream (the client fails on the missing frames)
+func (h *ReadHandler) writeChunked(ctx context.Context, w http.ResponseWriter, queries []Query) {
+	flusher, _ := w.(http.Flusher)
+	started := false
+
+	for i := range queries {
+		result, err := h.query(ctx, &queries[i])
+		if err != nil {
+			h.logger.WarnWith("Remote read query failed", "query", i, "err", err)
+			if !started {
+				http.Error(w, fmt.Sprintf("query %d failed: %v", i, err), queryErrorStatus(err))
+			}
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+			started = true
+		}
+
+		for _, ts := range result {
+			chunks := encodeChunks(ts.Samples)
+			for first := true; first || len(chunks) > 0; first = false {
+				size, n := 0, 0
+				for n < len(chunks) && (n == 0 || size+len(chunks[n].Data) <= maxFrameBytes) {
+					size += len(chunks[n].Data)
+					n++
+				}
+				frame := marshalChunkedResponse([]ChunkedSeries{{Labels: ts.Labels, Chunks: chunks[:n]}}, i)
+				chunks = chunks[n:]
+				if err := writeFrame(w, frame); err != nil {
+					h.logger.WarnWith("Failed to write a remote read frame", "err", err)
+					return
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeFrame(w http.ResponseWriter, frame []byte) error {
+	var hdr [binary.MaxVarintLen64 + 4]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(frame)))
+	binary.BigEndian.PutUint32(hdr[n:], crc32.Checksum(frame, castagnoli))
+	if _, err := w.Write(hdr[:n+4]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
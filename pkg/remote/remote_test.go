@@ -0,0 +1,485 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/v3io/v3io-tsdb/pkg/protowire"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// the testdata vectors are encoded by the reference encoders (prompb and snappy), see testdata/generate.go
+func TestGoldenVectors(t *testing.T) {
+	read := func(name string, compressed bool) []byte {
+		buf, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed {
+			if buf, err = snappy.Decode(nil, buf); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		return buf
+	}
+
+	// the exemplars, histograms and metadata are skipped
+	wreq, err := UnmarshalWriteRequest(read("write_request.snappy", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wreq.Timeseries) != 2 || len(wreq.Timeseries[0].Samples) != 3 {
+		t.Fatalf("wrong write request %+v", wreq)
+	}
+	stale := wreq.Timeseries[0].Samples[2]
+	if math.Float64bits(stale.Value) != 0x7ff0000000000002 || stale.Timestamp != 3000 {
+		t.Fatalf("wrong stale marker %+v", stale)
+	}
+	wreq.Timeseries[0].Samples = wreq.Timeseries[0].Samples[:2]
+	expectedWrite := &WriteRequest{Timeseries: []TimeSeries{
+		{
+			Labels:  utils.Labels{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1000}, {Value: 2.5, Timestamp: 2000}},
+		},
+		{
+			Labels:  utils.Labels{{Name: "__name__", Value: "temperature"}},
+			Samples: []Sample{{Value: -3, Timestamp: -1000}, {Value: 0, Timestamp: 0}},
+		},
+	}}
+	if !reflect.DeepEqual(wreq, expectedWrite) {
+		t.Fatalf("wrong write request\n%+v\nexpected\n%+v", wreq, expectedWrite)
+	}
+
+	rreq, err := UnmarshalReadRequest(read("read_request.snappy", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRead := &ReadRequest{
+		Queries: []Query{
+			{
+				StartMs: 1000,
+				EndMs:   61000,
+				Matchers: []LabelMatcher{
+					{Type: MatchEQ, Name: "__name__", Value: "cpu"},
+					{Type: MatchNEQ, Name: "host", Value: "a"},
+					{Type: MatchRE, Name: "dc", Value: "eu.*"},
+					{Type: MatchNRE, Name: "env", Value: "dev|test"},
+				},
+				Hints: &ReadHints{StepMs: 15000, Func: "rate", StartMs: 1000, EndMs: 61000, RangeMs: 300000},
+			},
+			{EndMs: 1000, Matchers: []LabelMatcher{{Type: MatchEQ, Name: "__name__", Value: "mem"}}},
+		},
+		ResponseTypes: []int{ResponseStreamedXORChunks, ResponseSamples},
+	}
+	if !reflect.DeepEqual(rreq, expectedRead) {
+		t.Fatalf("wrong read request\n%+v\nexpected\n%+v", rreq, expectedRead)
+	}
+
+	lset := utils.Labels{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}}
+	response := marshalReadResponse([][]TimeSeries{
+		{{Labels: lset, Samples: []Sample{
+			{Value: 1, Timestamp: 1000}, {Value: 0, Timestamp: 2000}, {Value: -1.5, Timestamp: 3000}}}},
+		{},
+	})
+	if expected := read("read_response.pb", false); !bytes.Equal(response, expected) {
+		t.Fatalf("wrong read response\n%x\nexpected\n%x", response, expected)
+	}
+
+	chunked := marshalChunkedResponse([]ChunkedSeries{{
+		Labels: lset,
+		Chunks: []Chunk{
+			{MinTimeMs: 1000, MaxTimeMs: 3000, Data: []byte{0, 3, 1, 2, 3}},
+			{MinTimeMs: 4000, MaxTimeMs: 4000, Data: []byte{0, 1, 4}},
+		},
+	}}, 1)
+	if expected := read("chunked_response.pb", false); !bytes.Equal(chunked, expected) {
+		t.Fatalf("wrong chunked response\n%x\nexpected\n%x", chunked, expected)
+	}
+}
+
+func postWrite(handler http.Handler, req *WriteRequest) *httptest.ResponseRecorder {
+	body := snappy.Encode(nil, req.Marshal())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body)))
+	return rec
+}
+
+func TestWriteHandler(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &tsdbtest.Appender{}
+	full := false
+	handler := NewWriteHandler(logger, app, func() bool { return full })
+
+	req := &WriteRequest{Timeseries: []TimeSeries{
+		{
+			Labels:  utils.Labels{{Name: "os", Value: "linux"}, {Name: "__name__", Value: "cpu"}, {Name: "dc", Value: ""}},
+			Samples: []Sample{{Value: 1.5, Timestamp: 1000}, {Value: -2, Timestamp: 2000}},
+		},
+		{
+			Labels:  utils.Labels{{Name: "__name__", Value: "mem"}},
+			Samples: []Sample{{Value: 0, Timestamp: -5}},
+		},
+	}}
+
+	// the request survives the protobuf round trip
+	decoded, err := UnmarshalWriteRequest(req.Marshal())
+	if err != nil || !reflect.DeepEqual(decoded, req) {
+		t.Fatalf("wrong protobuf round trip %+v %v", decoded, err)
+	}
+
+	if rec := postWrite(handler, req); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	cpu := utils.FromStrings("__name__", "cpu", "os", "linux")
+	expected := []tsdbtest.Sample{{Lset: cpu, Ref: 1, T: 1000, V: 1.5}, {Lset: cpu, Ref: 1, T: 2000, V: -2},
+		{Lset: utils.FromStrings("__name__", "mem"), Ref: 2, T: -5, V: 0}}
+	if samples := app.Samples(); !reflect.DeepEqual(samples, expected) {
+		t.Fatalf("wrong samples %v", samples)
+	}
+
+	// invalid series are reported, the valid ones are still appended
+	app.Reset()
+	req.Timeseries[0].Labels = utils.Labels{{Name: "os", Value: "linux"}}
+	rec := postWrite(handler, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "1 of 2 series failed") {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if samples := app.Samples(); len(samples) != 1 {
+		t.Fatalf("wrong samples %v", samples)
+	}
+
+	full = true
+	if rec := postWrite(handler, req); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected backpressure, got %d", rec.Code)
+	}
+
+	full = false
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", strings.NewReader("junk")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a decode error, got %d", rec.Code)
+	}
+}
+
+// bit reader for decoding the XOR chunks in the tests
+type bitReader struct {
+	stream []byte
+	pos    int // bit position
+}
+
+func (b *bitReader) readBits(nbits int) uint64 {
+	var u uint64
+	for ; nbits > 0; nbits-- {
+		bit := b.stream[b.pos/8] >> (7 - uint(b.pos%8)) & 1
+		u = u<<1 | uint64(bit)
+		b.pos++
+	}
+	return u
+}
+
+func (b *bitReader) readVarint(signed bool) int64 {
+	buf := b.stream[b.pos/8:]
+	if signed {
+		v, n := binary.Varint(buf)
+		b.pos += n * 8
+		return v
+	}
+	v, n := binary.Uvarint(buf)
+	b.pos += n * 8
+	return int64(v)
+}
+
+// decode a Prometheus XOR chunk
+func decodeXOR(data []byte) []Sample {
+	num := int(binary.BigEndian.Uint16(data))
+	r := &bitReader{stream: data, pos: 16}
+	var samples []Sample
+	var t, tDelta int64
+	var v uint64
+	var leading, sigbits int
+	for i := 0; i < num; i++ {
+		switch i {
+		case 0:
+			t = r.readVarint(true)
+			v = r.readBits(64)
+			samples = append(samples, Sample{Timestamp: t, Value: math.Float64frombits(v)})
+			continue
+		case 1:
+			tDelta = r.readVarint(false)
+		default:
+			// '0', '10', '110', '1110' or '1111' prefix followed by the delta of delta
+			d := 0
+			for d < 4 && r.readBits(1) == 1 {
+				d++
+			}
+			size := []int{0, 14, 17, 20, 64}[d]
+			dod := int64(r.readBits(size))
+			if size > 0 && size < 64 && dod > 1<<uint(size-1) {
+				dod -= 1 << uint(size)
+			}
+			tDelta += dod
+		}
+		t += tDelta
+		if r.readBits(1) == 1 {
+			if r.readBits(1) == 1 {
+				leading = int(r.readBits(5))
+				if sigbits = int(r.readBits(6)); sigbits == 0 {
+					sigbits = 64
+				}
+			}
+			v ^= r.readBits(sigbits) << uint(64-leading-sigbits)
+		}
+		samples = append(samples, Sample{Timestamp: t, Value: math.Float64frombits(v)})
+	}
+	return samples
+}
+
+func TestXORChunk(t *testing.T) {
+	// 2 byte count, varint time, 64 bit value, uvarint delta and a '0' bit for the same value
+	c := newXORChunk()
+	c.Append(1000, 1)
+	c.Append(2000, 1)
+	expected := []byte{0, 2, 0xd0, 0x0f, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0xe8, 0x07, 0}
+	if !bytes.Equal(c.Bytes(), expected) {
+		t.Fatalf("wrong chunk encoding %x", c.Bytes())
+	}
+
+	r := rand.New(rand.NewSource(1))
+	samples := []Sample{}
+	ts := int64(1500000000000)
+	for i := 0; i < 300; i++ {
+		// regular, jittered and large time gaps, repeated and random values
+		ts += []int64{10000, 10000 + r.Int63n(100), 1 << 30, 1 << 15, 1 << 18}[i%5]
+		value := float64(r.Intn(3))
+		if i%7 == 0 {
+			value = r.NormFloat64() * 1e6
+		}
+		samples = append(samples, Sample{Value: value, Timestamp: ts})
+	}
+
+	chunks := encodeChunks(samples)
+	if len(chunks) != 3 || chunks[2].MinTimeMs != samples[240].Timestamp || chunks[2].MaxTimeMs != ts {
+		t.Fatalf("wrong chunks %d", len(chunks))
+	}
+	decoded := []Sample{}
+	for _, chunk := range chunks {
+		decoded = append(decoded, decodeXOR(chunk.Data)...)
+	}
+	if !reflect.DeepEqual(decoded, samples) {
+		t.Fatal("wrong chunk round trip")
+	}
+}
+
+func TestSelectParams(t *testing.T) {
+	const minute = 60000
+	for _, test := range []struct {
+		hints     *ReadHints
+		start     int64
+		functions string
+		shift     int64
+	}{
+		{nil, 0, "", 0},
+		{&ReadHints{Func: "max_over_time", StepMs: minute, RangeMs: 5 * minute}, 10 * minute, "max", minute - 1},
+		{&ReadHints{Func: "avg_over_time", StepMs: minute, RangeMs: minute}, 10 * minute, "avg", minute - 1},
+		// avg of averages is only right for a single step
+		{&ReadHints{Func: "avg_over_time", StepMs: minute, RangeMs: 5 * minute}, 10 * minute, "", 0},
+		// evaluation times which are not aligned to the steps
+		{&ReadHints{Func: "max_over_time", StepMs: minute, RangeMs: 5 * minute}, 10*minute + 1, "", 0},
+		{&ReadHints{Func: "sum_over_time", StepMs: minute, RangeMs: 90000}, 10 * minute, "", 0},
+		{&ReadHints{Func: "rate", StepMs: minute, RangeMs: 5 * minute}, 10 * minute, "", 0},
+	} {
+		params, shift := selectParams(&Query{StartMs: test.start, Hints: test.hints})
+		if params.Functions != test.functions || shift != test.shift || test.functions != "" && params.Step != minute {
+			t.Fatalf("wrong params for %+v: %+v %d", test.hints, params, shift)
+		}
+	}
+}
+
+// the points of the remote read samples
+func toPoints(samples []Sample) []tsdbtest.Point {
+	points := []tsdbtest.Point{}
+	for _, s := range samples {
+		points = append(points, tsdbtest.Point{T: s.Timestamp, V: s.Value})
+	}
+	return points
+}
+
+func TestReadHandler(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := []Sample{}
+	for i := int64(0); i < 500; i++ {
+		samples = append(samples, Sample{Value: float64(i), Timestamp: i * 1000})
+	}
+	q := &tsdbtest.Querier{Raw: []*tsdbtest.Series{
+		{Lset: utils.FromStrings("__name__", "cpu", "host", "b"), Points: toPoints(samples[:10])},
+		{Lset: utils.Labels{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}, {Name: "Aggregator", Value: "max"}}, Points: toPoints(samples)},
+		{Lset: utils.FromStrings("__name__", "mem", "host", "a"), Points: toPoints(samples[:1])},
+	}}
+	handler := &ReadHandler{logger: logger, querier: q.New}
+
+	read := func(req *ReadRequest) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := bytes.NewReader(snappy.Encode(nil, req.Marshal()))
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/read", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+		}
+		return rec
+	}
+
+	req := &ReadRequest{Queries: []Query{
+		{StartMs: 0, EndMs: 500000, Matchers: []LabelMatcher{{Type: MatchEQ, Name: "__name__", Value: "cpu"}}},
+		{StartMs: 0, EndMs: 500000, Matchers: []LabelMatcher{{Type: MatchRE, Name: "host", Value: "a"}},
+			Hints: &ReadHints{Func: "series"}},
+	}}
+
+	// the request survives the protobuf round trip
+	decodedReq, err := UnmarshalReadRequest(req.Marshal())
+	if err != nil || !reflect.DeepEqual(decodedReq, req) {
+		t.Fatalf("wrong protobuf round trip %+v %v", decodedReq, err)
+	}
+
+	// samples response, the series are sorted and the Aggregator label is dropped
+	rec := read(req)
+	buf, err := snappy.Decode(nil, rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := [][]TimeSeries{}
+	err = protowire.DecodeMessage(buf, func(r *protowire.Reader, field, wire int) error {
+		b, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		result, err := UnmarshalWriteRequest(b)
+		results = append(results, result.Timeseries)
+		return err
+	})
+	if err != nil || len(results) != 2 || len(results[0]) != 2 || len(results[1]) != 2 {
+		t.Fatalf("wrong results %v %v", results, err)
+	}
+	if !reflect.DeepEqual(results[0][0], TimeSeries{Labels: utils.FromStrings("__name__", "cpu", "host", "a"), Samples: samples}) ||
+		results[0][1].Labels.Get("host") != "b" || len(results[0][1].Samples) != 10 {
+		t.Fatalf("wrong series %v", results[0])
+	}
+	if results[1][1].Labels.Get("__name__") != "mem" || len(results[1][1].Samples) != 0 {
+		t.Fatalf("wrong labels only series %v", results[1])
+	}
+
+	// streamed response
+	req.ResponseTypes = []int{ResponseStreamedXORChunks}
+	rec = read(req)
+	body := rec.Body.Bytes()
+	frames := 0
+	series := map[string][]Sample{}
+	for len(body) > 0 {
+		size, n := binary.Uvarint(body)
+		frame := body[n+4 : n+4+int(size)]
+		if crc32.Checksum(frame, castagnoli) != binary.BigEndian.Uint32(body[n:]) {
+			t.Fatal("wrong frame checksum")
+		}
+		body = body[n+4+int(size):]
+		frames++
+
+		err := protowire.DecodeMessage(frame, func(r *protowire.Reader, field, wire int) error {
+			if field != 1 {
+				return r.Skip(wire)
+			}
+			b, err := r.Bytes()
+			if err != nil {
+				return err
+			}
+			var lset utils.Labels
+			return protowire.DecodeMessage(b, func(r *protowire.Reader, field, wire int) error {
+				b, err := r.Bytes()
+				if err != nil {
+					return err
+				}
+				if field == 1 {
+					lbl, err := unmarshalLabel(b)
+					lset = append(lset, lbl)
+					return err
+				}
+				return protowire.DecodeMessage(b, func(r *protowire.Reader, field, wire int) error {
+					if field != 4 {
+						return r.Skip(wire)
+					}
+					data, err := r.Bytes()
+					series[lset.String()] = append(series[lset.String()], decodeXOR(data)...)
+					return err
+				})
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if frames != 4 || len(series) != 2 || !reflect.DeepEqual(series[utils.FromStrings("__name__", "cpu", "host", "a").String()], samples) {
+		t.Fatalf("wrong streamed response, %d frames %d series", frames, len(series))
+	}
+
+	// a query over the limits is a client error, in a stream the frames of the previous queries are kept
+	limited := &ReadHandler{logger: logger, querier: func(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+		if mint > 0 {
+			return nil, &querier.LimitError{Limit: querier.LimitSamples, Max: 100}
+		}
+		return q, nil
+	}}
+	req.Queries[1].StartMs = 1000
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/read", bytes.NewReader(snappy.Encode(nil, req.Marshal()))))
+	frames = 0
+	for body = rec.Body.Bytes(); len(body) > 0; frames++ {
+		size, n := binary.Uvarint(body)
+		body = body[n+4+int(size):]
+	}
+	if rec.Code != http.StatusOK || frames != 2 {
+		t.Fatalf("wrong truncated stream %d, %d frames", rec.Code, frames)
+	}
+	req.ResponseTypes = nil
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/read", bytes.NewReader(snappy.Encode(nil, req.Marshal()))))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a limit error, got %d", rec.Code)
+	}
+}
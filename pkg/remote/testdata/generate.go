@@ -0,0 +1,120 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+// generates the golden remote protocol messages with the Prometheus prompb (gogo protobuf) encoder and the
+// reference snappy encoder, run from this directory with: go run generate.go
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+func write(name string, msg marshaler, compress bool) {
+	b, err := msg.Marshal()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if compress {
+		b = snappy.Encode(nil, b)
+	}
+	if err := ioutil.WriteFile(name, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	// a stale marker sample, an exemplar, a native histogram and the metadata are not used (skipped)
+	write("write_request.snappy", &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 2.5, Timestamp: 2000},
+					{Value: math.Float64frombits(0x7ff0000000000002), Timestamp: 3000}},
+				Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1,
+					Timestamp: 1000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "temperature"}},
+				Samples: []prompb.Sample{{Value: -3, Timestamp: -1000}, {Value: 0, Timestamp: 0}},
+				Histograms: []prompb.Histogram{{Count: &prompb.Histogram_CountInt{CountInt: 2}, Sum: 1.5,
+					PositiveSpans: []prompb.BucketSpan{{Offset: 0, Length: 1}}, PositiveDeltas: []int64{2},
+					Timestamp: 4000}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total", Help: "requests"}},
+	}, true)
+
+	write("read_request.snappy", &prompb.ReadRequest{
+		Queries: []*prompb.Query{
+			{
+				StartTimestampMs: 1000,
+				EndTimestampMs:   61000,
+				Matchers: []*prompb.LabelMatcher{
+					{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "cpu"},
+					{Type: prompb.LabelMatcher_NEQ, Name: "host", Value: "a"},
+					{Type: prompb.LabelMatcher_RE, Name: "dc", Value: "eu.*"},
+					{Type: prompb.LabelMatcher_NRE, Name: "env", Value: "dev|test"},
+				},
+				Hints: &prompb.ReadHints{StepMs: 15000, Func: "rate", StartMs: 1000, EndMs: 61000,
+					Grouping: []string{"dc"}, By: true, RangeMs: 300000},
+			},
+			{
+				EndTimestampMs: 1000,
+				Matchers:       []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "mem"}},
+			},
+		},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{
+			prompb.ReadRequest_STREAMED_XOR_CHUNKS, prompb.ReadRequest_SAMPLES},
+	}, true)
+
+	write("read_response.pb", &prompb.ReadResponse{
+		Results: []*prompb.QueryResult{
+			{Timeseries: []*prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 0, Timestamp: 2000}, {Value: -1.5, Timestamp: 3000}},
+			}}},
+			{},
+		},
+	}, false)
+
+	write("chunked_response.pb", &prompb.ChunkedReadResponse{
+		ChunkedSeries: []*prompb.ChunkedSeries{{
+			Labels: []prompb.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}},
+			Chunks: []prompb.Chunk{
+				{MinTimeMs: 1000, MaxTimeMs: 3000, Type: prompb.Chunk_XOR, Data: []byte{0, 3, 1, 2, 3}},
+				{MinTimeMs: 4000, MaxTimeMs: 4000, Type: prompb.Chunk_XOR, Data: []byte{0, 1, 4}},
+			},
+		}},
+		QueryIndex: 1,
+	}, false)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const (
+	// max size of a (compressed) request body
+	maxBodySize = 32 << 20
+	// max decoded size of a request body, protects against corrupt or malicious snappy length headers
+	maxDecodedLen = 256 << 20
+	// max number of series errors listed in a response
+	maxReportedErrors = 10
+)
+
+// WriteHandler is an http.Handler for the Prometheus remote write protocol, the samples are
+// appended through the TSDB Appender (into the MetricsCache)
+type WriteHandler struct {
+	logger   logger.Logger
+	appender tsdb.Appender
+	full     func() bool
+}
+
+// NewWriteHandler creates a remote write handler, full() reports when the appender queue is full
+// so the request is rejected with 429 (Too Many Requests) and retried by the sender
+func NewWriteHandler(logger logger.Logger, appender tsdb.Appender, full func() bool) *WriteHandler {
+	return &WriteHandler{logger: logger, appender: appender, full: full}
+}
+
+func (h *WriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if utils.QueueFull(w, h.full) {
+		http.Error(w, "append queue is full", http.StatusTooManyRequests)
+		return
+	}
+
+	buf, status, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	req, err := UnmarshalWriteRequest(buf)
+	if err != nil {
+		http.Error(w, "failed to decode the write request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var errs []string
+	var writeStatus utils.WriteStatus
+	for i := range req.Timeseries {
+		invalid, err := h.write(&req.Timeseries[i])
+		if err == nil {
+			continue
+		}
+		writeStatus.Fail(invalid)
+		errs = append(errs, err.Error())
+	}
+
+	status = writeStatus.Code(http.StatusNoContent)
+	if len(errs) == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	h.logger.WarnWith("Remote write failed for some series", "failed", len(errs),
+		"series", len(req.Timeseries), "first", errs[0])
+	msg := fmt.Sprintf("%d of %d series failed:\n", len(errs), len(req.Timeseries))
+	if len(errs) > maxReportedErrors {
+		errs = append(errs[:maxReportedErrors], "...")
+	}
+	http.Error(w, msg+strings.Join(errs, "\n"), status)
+}
+
+// append the samples of one series, returns true if the series itself is invalid
+func (h *WriteHandler) write(ts *TimeSeries) (bool, error) {
+	lset := normalizeLabels(ts.Labels)
+	if err := lset.Validate(); err != nil {
+		return true, err
+	}
+	if len(ts.Samples) == 0 {
+		return false, nil
+	}
+
+	ref, err := h.appender.Add(lset, ts.Samples[0].Timestamp, ts.Samples[0].Value)
+	if err != nil {
+		return false, fmt.Errorf("failed to append to %s: %v", lset, err)
+	}
+	for _, sample := range ts.Samples[1:] {
+		if err := h.appender.AddFast(lset, ref, sample.Timestamp, sample.Value); err != nil {
+			return false, fmt.Errorf("failed to append to %s: %v", lset, err)
+		}
+	}
+	return false, nil
+}
+
+// drop the empty labels (same as Prometheus) and sort the rest
+func normalizeLabels(lset utils.Labels) utils.Labels {
+	res := lset[:0]
+	for _, lbl := range lset {
+		if lbl.Value != "" {
+			res = append(res, lbl)
+		}
+	}
+	sort.Sort(res)
+	return res
+}
+
+// read and decompress a snappy encoded request body, returns the http status on error
+func readBody(r *http.Request) ([]byte, int, error) {
+	compressed, status, err := utils.ReadBody(r, maxBodySize)
+	if err != nil {
+		return nil, status, err
+	}
+
+	dlen, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if dlen > maxDecodedLen {
+		return nil, http.StatusBadRequest, fmt.Errorf("snappy: decoded length %d is too large", dlen)
+	}
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return buf, 0, nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package remote
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// max samples per chunk of a streamed response (same as the Prometheus TSDB)
+const samplesPerChunk = 120
+
+// bitWriter is an append only bit stream
+type bitWriter struct {
+	stream []byte
+	count  uint8 // free bits in the last byte
+}
+
+func (b *bitWriter) writeBit(bit bool) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+	if bit {
+		b.stream[len(b.stream)-1] |= 1 << (b.count - 1)
+	}
+	b.count--
+}
+
+func (b *bitWriter) writeByte(byt byte) {
+	if b.count == 0 {
+		b.stream = append(b.stream, 0)
+		b.count = 8
+	}
+	b.stream[len(b.stream)-1] |= byt >> (8 - b.count)
+	b.stream = append(b.stream, byt<<b.count)
+}
+
+func (b *bitWriter) writeBits(u uint64, nbits int) {
+	u <<= 64 - uint(nbits)
+	for ; nbits >= 8; nbits -= 8 {
+		b.writeByte(byte(u >> 56))
+		u <<= 8
+	}
+	for ; nbits > 0; nbits-- {
+		b.writeBit(u>>63 == 1)
+		u <<= 1
+	}
+}
+
+// xorChunk encodes samples in the Prometheus XOR chunk format (which differs from the v3io chunk format
+// in the timestamp encoding), a 2 byte sample count followed by the Gorilla style bit stream
+type xorChunk struct {
+	b        bitWriter
+	num      uint16
+	t        int64
+	v        float64
+	tDelta   uint64
+	leading  uint8
+	trailing uint8
+}
+
+func newXORChunk() *xorChunk {
+	return &xorChunk{b: bitWriter{stream: make([]byte, 2, 128)}, leading: 0xff}
+}
+
+// Bytes returns the encoded chunk
+func (c *xorChunk) Bytes() []byte {
+	return c.b.stream
+}
+
+func (c *xorChunk) Append(t int64, v float64) {
+	var buf [binary.MaxVarintLen64]byte
+	var tDelta uint64
+
+	switch c.num {
+	case 0:
+		for _, b := range buf[:binary.PutVarint(buf[:], t)] {
+			c.b.writeByte(b)
+		}
+		c.b.writeBits(math.Float64bits(v), 64)
+	case 1:
+		tDelta = uint64(t - c.t)
+		for _, b := range buf[:binary.PutUvarint(buf[:], tDelta)] {
+			c.b.writeByte(b)
+		}
+		c.writeVDelta(v)
+	default:
+		tDelta = uint64(t - c.t)
+		dod := int64(tDelta - c.tDelta)
+		switch {
+		case dod == 0:
+			c.b.writeBit(false)
+		case bitRange(dod, 14):
+			c.b.writeBits(0x02, 2) // '10'
+			c.b.writeBits(uint64(dod), 14)
+		case bitRange(dod, 17):
+			c.b.writeBits(0x06, 3) // '110'
+			c.b.writeBits(uint64(dod), 17)
+		case bitRange(dod, 20):
+			c.b.writeBits(0x0e, 4) // '1110'
+			c.b.writeBits(uint64(dod), 20)
+		default:
+			c.b.writeBits(0x0f, 4) // '1111'
+			c.b.writeBits(uint64(dod), 64)
+		}
+		c.writeVDelta(v)
+	}
+
+	c.t = t
+	c.v = v
+	c.tDelta = tDelta
+	c.num++
+	binary.BigEndian.PutUint16(c.b.stream, c.num)
+}
+
+func bitRange(x int64, nbits uint8) bool {
+	return -((1<<(nbits-1))-1) <= x && x <= 1<<(nbits-1)
+}
+
+func (c *xorChunk) writeVDelta(v float64) {
+	vDelta := math.Float64bits(v) ^ math.Float64bits(c.v)
+	if vDelta == 0 {
+		c.b.writeBit(false)
+		return
+	}
+	c.b.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(vDelta))
+	trailing := uint8(bits.TrailingZeros64(vDelta))
+	// the leading zeros are encoded in 5 bits
+	if leading >= 32 {
+		leading = 31
+	}
+
+	if c.leading != 0xff && leading >= c.leading && trailing >= c.trailing {
+		c.b.writeBit(false)
+		c.b.writeBits(vDelta>>c.trailing, 64-int(c.leading)-int(c.trailing))
+		return
+	}
+
+	c.leading, c.trailing = leading, trailing
+	c.b.writeBit(true)
+	c.b.writeBits(uint64(leading), 5)
+	// 64 significant bits are written as 0 (the 6 bits overflow), 0 bits can't happen (vDelta != 0)
+	sigbits := 64 - leading - trailing
+	c.b.writeBits(uint64(sigbits), 6)
+	c.b.writeBits(vDelta>>trailing, int(sigbits))
+}
+
+// encode the samples to XOR chunks of up to samplesPerChunk samples
+func encodeChunks(samples []Sample) []Chunk {
+	var chunks []Chunk
+	for len(samples) > 0 {
+		n := len(samples)
+		if n > samplesPerChunk {
+			n = samplesPerChunk
+		}
+		c := newXORChunk()
+		for _, sample := range samples[:n] {
+			c.Append(sample.Timestamp, sample.Value)
+		}
+		chunks = append(chunks, Chunk{MinTimeMs: samples[0].Timestamp, MaxTimeMs: samples[n-1].Timestamp, Data: c.Bytes()})
+		samples = samples[n:]
+	}
+	return chunks
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package scrape
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// Config is the scrape configuration, a subset of the Prometheus configuration file:
+//
+//	global:
+//	  scrape_interval: 15s
+//	scrape_configs:
+//	- job_name: node
+//	  static_configs:
+//	  - targets: [localhost:9100]
+//	    labels: {env: prod}
+type Config struct {
+	Global        GlobalConfig    `json:"global"`
+	ScrapeConfigs []*ScrapeConfig `json:"scrape_configs"`
+}
+
+type GlobalConfig struct {
+	// Default scrape interval and timeout (1m and 10s)
+	ScrapeInterval string `json:"scrape_interval,omitempty"`
+	ScrapeTimeout  string `json:"scrape_timeout,omitempty"`
+	// Labels added to all the scraped series
+	ExternalLabels map[string]string `json:"external_labels,omitempty"`
+}
+
+type ScrapeConfig struct {
+	// The job label of the targets
+	JobName        string `json:"job_name"`
+	ScrapeInterval string `json:"scrape_interval,omitempty"`
+	ScrapeTimeout  string `json:"scrape_timeout,omitempty"`
+	// URL path and scheme of the targets (/metrics and http by default)
+	MetricsPath string              `json:"metrics_path,omitempty"`
+	Scheme      string              `json:"scheme,omitempty"`
+	Params      map[string][]string `json:"params,omitempty"`
+	// Keep the exposed labels which conflict with the target labels (instead of renaming them to exported_<name>)
+	HonorLabels bool `json:"honor_labels,omitempty"`
+	// Use the exposed sample timestamps (true by default)
+	HonorTimestamps *bool           `json:"honor_timestamps,omitempty"`
+	StaticConfigs   []*StaticConfig `json:"static_configs"`
+
+	interval, timeout time.Duration
+}
+
+type StaticConfig struct {
+	// Target addresses (host:port), the instance label
+	Targets []string `json:"targets"`
+	// Labels added to the series of the targets
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// LoadConfig reads and validates a YAML scrape configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read the scrape configuration")
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates a YAML scrape configuration, and sets the defaults
+func ParseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the scrape configuration")
+	}
+
+	interval, err := parseDuration(cfg.Global.ScrapeInterval, time.Minute)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid global scrape_interval")
+	}
+	timeout, err := parseDuration(cfg.Global.ScrapeTimeout, 10*time.Second)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid global scrape_timeout")
+	}
+	for name := range cfg.Global.ExternalLabels {
+		if !utils.IsValidLabelName(name) {
+			return nil, fmt.Errorf("invalid external label name %q", name)
+		}
+	}
+
+	jobs := map[string]bool{}
+	for _, sc := range cfg.ScrapeConfigs {
+		if sc.JobName == "" || jobs[sc.JobName] {
+			return nil, fmt.Errorf("missing or duplicate job_name %q", sc.JobName)
+		}
+		jobs[sc.JobName] = true
+
+		if sc.interval, err = parseDuration(sc.ScrapeInterval, interval); err != nil {
+			return nil, errors.Wrapf(err, "invalid scrape_interval of job %s", sc.JobName)
+		}
+		// the default timeout is limited to the job interval
+		defTimeout := timeout
+		if defTimeout > sc.interval {
+			defTimeout = sc.interval
+		}
+		if sc.timeout, err = parseDuration(sc.ScrapeTimeout, defTimeout); err != nil {
+			return nil, errors.Wrapf(err, "invalid scrape_timeout of job %s", sc.JobName)
+		}
+		if sc.timeout > sc.interval {
+			return nil, fmt.Errorf("scrape_timeout of job %s is greater than its scrape_interval", sc.JobName)
+		}
+
+		if sc.MetricsPath == "" {
+			sc.MetricsPath = "/metrics"
+		}
+		if sc.Scheme == "" {
+			sc.Scheme = "http"
+		}
+		if sc.Scheme != "http" && sc.Scheme != "https" {
+			return nil, fmt.Errorf("invalid scheme %q of job %s", sc.Scheme, sc.JobName)
+		}
+		if sc.HonorTimestamps == nil {
+			honor := true
+			sc.HonorTimestamps = &honor
+		}
+
+		for _, static := range sc.StaticConfigs {
+			for _, target := range static.Targets {
+				if _, _, err := net.SplitHostPort(target); err != nil {
+					return nil, fmt.Errorf("invalid target %q of job %s, use host:port", target, sc.JobName)
+				}
+			}
+			for name := range static.Labels {
+				if !utils.IsValidLabelName(name) {
+					return nil, fmt.Errorf("invalid label name %q of job %s", name, sc.JobName)
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// parse a positive duration (e.g. 15s or 1m), def if empty
+func parseDuration(str string, def time.Duration) (time.Duration, error) {
+	if str == "" {
+		return def, nil
+	}
+	ms, err := utils.Str2duration(str)
+	if err != nil {
+		return 0, err
+	}
+	if ms <= 0 {
+		return 0, fmt.Errorf("duration %s must be positive", str)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
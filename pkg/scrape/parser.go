@@ -0,0 +1,157 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package scrape
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// an exposed sample, the time is in milliseconds (0 when the sample has no timestamp)
+type sample struct {
+	lset utils.Labels
+	t    int64
+	v    float64
+}
+
+// parse the Prometheus text (0.0.4) or OpenMetrics exposition format, comments (HELP, TYPE, EOF) and exemplars
+// are ignored. Prometheus timestamps are in milliseconds and OpenMetrics timestamps in (fractional) seconds
+func parseExposition(body string, openMetrics bool) ([]sample, error) {
+	var samples []sample
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		s, err := parseSample(line, openMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// parse a sample line, <name>[{<label>="<value>",...}] <value> [<timestamp>] [# <exemplar>]
+func parseSample(line string, openMetrics bool) (sample, error) {
+	s := sample{}
+	pos := 0
+	for pos < len(line) && isNameChar(line[pos], pos == 0) {
+		pos++
+	}
+	if pos == 0 {
+		return s, fmt.Errorf("invalid metric name in %q", line)
+	}
+	s.lset = utils.Labels{{Name: utils.MetricName, Value: line[:pos]}}
+
+	if pos < len(line) && line[pos] == '{' {
+		var err error
+		if pos, err = parseLabels(line, pos+1, &s.lset); err != nil {
+			return s, err
+		}
+	}
+
+	rest := line[pos:]
+	if hash := strings.Index(rest, " # "); hash >= 0 && openMetrics {
+		rest = rest[:hash]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 || !strings.HasPrefix(rest, " ") && !strings.HasPrefix(rest, "\t") {
+		return s, fmt.Errorf("expected a value (and timestamp) in %q", line)
+	}
+
+	var err error
+	if s.v, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return s, fmt.Errorf("invalid value %q", fields[0])
+	}
+	if len(fields) == 2 {
+		if openMetrics {
+			sec, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return s, fmt.Errorf("invalid timestamp %q", fields[1])
+			}
+			s.t = int64(math.Round(sec * 1000))
+		} else if s.t, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return s, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	return s, nil
+}
+
+// parse the labels after the {, returns the position after the closing }
+func parseLabels(line string, pos int, lset *utils.Labels) (int, error) {
+	for {
+		for pos < len(line) && (line[pos] == ' ' || line[pos] == '\t') {
+			pos++
+		}
+		if pos < len(line) && line[pos] == '}' {
+			return pos + 1, nil
+		}
+
+		start := pos
+		for pos < len(line) && isNameChar(line[pos], pos == start) {
+			pos++
+		}
+		name := line[start:pos]
+		if name == "" || pos+1 >= len(line) || line[pos] != '=' || line[pos+1] != '"' {
+			return 0, fmt.Errorf("invalid label at offset %d in %q", start, line)
+		}
+
+		var value strings.Builder
+		pos += 2
+		for ; pos < len(line) && line[pos] != '"'; pos++ {
+			c := line[pos]
+			if c == '\\' && pos+1 < len(line) {
+				pos++
+				switch line[pos] {
+				case 'n':
+					c = '\n'
+				default:
+					c = line[pos]
+				}
+			}
+			value.WriteByte(c)
+		}
+		if pos >= len(line) {
+			return 0, fmt.Errorf("unterminated label value in %q", line)
+		}
+		pos++
+		*lset = append(*lset, utils.Label{Name: name, Value: value.String()})
+
+		for pos < len(line) && (line[pos] == ' ' || line[pos] == '\t') {
+			pos++
+		}
+
+		if pos < len(line) && line[pos] == ',' {
+			pos++
+		} else if pos >= len(line) || line[pos] != '}' {
+			return 0, fmt.Errorf("expected , or } at offset %d in %q", pos, line)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+const (
+	// max size of a scrape response body
+	maxBodySize  = 256 << 20
+	acceptHeader = "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75," +
+		"text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+)
+
+// Scraper scrapes the configured targets (Prometheus or OpenMetrics text exposition over HTTP) and appends the
+// samples through the TSDB Appender, with the target labels (job, instance and the static labels), the
+// up/scrape_duration_seconds/scrape_samples_scraped series of every target and staleness markers for the series
+// which disappear (or all the series of a target which fails or stops being scraped)
+type Scraper struct {
+	logger logger.Logger
+	loops  []*loop
+}
+
+// a scrape loop of one target
+type loop struct {
+	logger          logger.Logger
+	appender        tsdb.Appender
+	client          *http.Client
+	url             string
+	interval        time.Duration
+	timeout         time.Duration
+	target          utils.Labels
+	honorLabels     bool
+	honorTimestamps bool
+
+	// series of the previous scrape (by key), the candidates for staleness markers
+	prev map[string]utils.Labels
+}
+
+// NewScraper creates the scrape loops of the configured targets
+func NewScraper(logger logger.Logger, appender tsdb.Appender, cfg *Config) *Scraper {
+	s := &Scraper{logger: logger}
+	client := &http.Client{}
+
+	for _, sc := range cfg.ScrapeConfigs {
+		for _, static := range sc.StaticConfigs {
+			for _, target := range static.Targets {
+				u := url.URL{Scheme: sc.Scheme, Host: target, Path: sc.MetricsPath, RawQuery: url.Values(sc.Params).Encode()}
+
+				labels := map[string]string{}
+				for name, value := range cfg.Global.ExternalLabels {
+					labels[name] = value
+				}
+				for name, value := range static.Labels {
+					labels[name] = value
+				}
+				labels["job"] = sc.JobName
+				labels["instance"] = target
+
+				s.loops = append(s.loops, &loop{
+					logger:          logger,
+					appender:        appender,
+					client:          client,
+					url:             u.String(),
+					interval:        sc.interval,
+					timeout:         sc.timeout,
+					target:          utils.FromMap(labels),
+					honorLabels:     sc.HonorLabels,
+					honorTimestamps: *sc.HonorTimestamps,
+					prev:            map[string]utils.Labels{},
+				})
+			}
+		}
+	}
+	return s
+}
+
+// Run scrapes the targets until the context is canceled, the series of the last scrapes are then marked stale
+func (s *Scraper) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, l := range s.loops {
+		wg.Add(1)
+		go func(l *loop) {
+			defer wg.Done()
+			l.run(ctx)
+		}(l)
+	}
+	s.logger.InfoWith("Scraping targets", "targets", len(s.loops))
+	wg.Wait()
+}
+
+func (l *loop) run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	l.scrape(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			l.markStale(time.Now(), nil)
+			return
+		case now := <-ticker.C:
+			l.scrape(ctx, now)
+		}
+	}
+}
+
+// scrape the target once, returns the scrape error
+func (l *loop) scrape(ctx context.Context, now time.Time) error {
+	t := now.UnixNano() / int64(time.Millisecond)
+	samples, err := l.fetch(ctx)
+	duration := time.Since(now).Seconds()
+	if err != nil {
+		l.logger.WarnWith("Scrape failed", "target", l.url, "err", err)
+	}
+
+	cur := map[string]utils.Labels{}
+	appendErrs := 0
+	for _, s := range samples {
+		lset := l.labels(s.lset)
+		if err := lset.Validate(); err != nil {
+			appendErrs++
+			continue
+		}
+		key := lset.String()
+		if _, ok := cur[key]; ok {
+			// duplicate series
+			continue
+		}
+
+		st := t
+		if s.t != 0 && l.honorTimestamps {
+			// series with explicit timestamps don't get staleness markers
+			st = s.t
+		} else {
+			cur[key] = lset
+		}
+		if _, err := l.appender.Add(lset, st, s.v); err != nil {
+			appendErrs++
+		}
+	}
+	if appendErrs > 0 {
+		l.logger.WarnWith("Failed to append some scraped samples", "target", l.url, "failed", appendErrs)
+	}
+
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	for _, report := range []struct {
+		name  string
+		value float64
+	}{{"up", up}, {"scrape_duration_seconds", duration}, {"scrape_samples_scraped", float64(len(samples))}} {
+		lset := l.labels(utils.Labels{{Name: utils.MetricName, Value: report.name}})
+		cur[lset.String()] = lset
+		if _, err := l.appender.Add(lset, t, report.value); err != nil {
+			l.logger.WarnWith("Failed to append the scrape report", "target", l.url, "err", err)
+		}
+	}
+
+	l.markStale(now, cur)
+	l.prev = cur
+	return err
+}
+
+// append staleness markers to the previous scrape series which are not in cur
+func (l *loop) markStale(now time.Time, cur map[string]utils.Labels) {
+	t := now.UnixNano() / int64(time.Millisecond)
+	for key, lset := range l.prev {
+		if _, ok := cur[key]; ok {
+			continue
+		}
+		if _, err := l.appender.Add(lset, t, utils.StaleNaN); err != nil {
+			l.logger.WarnWith("Failed to append a staleness marker", "labels", lset.String(), "err", err)
+		}
+	}
+	if cur == nil {
+		l.prev = map[string]utils.Labels{}
+	}
+}
+
+// get and parse the target exposition
+func (l *loop) fetch(ctx context.Context) ([]sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, l.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(l.timeout.Seconds(), 'f', -1, 64))
+	req.Header.Set("User-Agent", "v3io-tsdb-scraper")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("response body exceeds %d bytes", maxBodySize)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return parseExposition(string(body), mediaType == "application/openmetrics-text")
+}
+
+// the series labels, the exposed labels with the target labels. exposed labels which conflict with a target
+// label are kept with honor_labels or renamed to exported_<name>, empty labels are dropped
+func (l *loop) labels(exposed utils.Labels) utils.Labels {
+	res := make(utils.Labels, 0, len(exposed)+len(l.target))
+	for _, lbl := range exposed {
+		if lbl.Value == "" {
+			continue
+		}
+		if l.target.Has(lbl.Name) && !l.honorLabels {
+			name := "exported_" + lbl.Name
+			for l.target.Has(name) || exposed.Has(name) {
+				name = "exported_" + name
+			}
+			lbl.Name = name
+		}
+		res = append(res, lbl)
+	}
+	for _, lbl := range l.target {
+		if !res.Has(lbl.Name) && lbl.Value != "" {
+			res = append(res, lbl)
+		}
+	}
+	sort.Sort(res)
+	return res
+}
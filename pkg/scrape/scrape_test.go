@@ -0,0 +1,211 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParseExposition(t *testing.T) {
+	body := `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200",} 1027 1395066363000
+http_requests_total{ method="post", code="400" } 3
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+metric_without_labels +Inf
+`
+	samples, err := parseExposition(body, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 samples, got %d", len(samples))
+	}
+	if s := samples[0]; s.lset.String() != `{__name__="http_requests_total", method="post", code="200"}` || s.v != 1027 || s.t != 1395066363000 {
+		t.Fatalf("wrong sample %+v", s)
+	}
+	if s := samples[2]; s.lset.Get("path") != `C:\DIR\FILE.TXT` || s.lset.Get("error") != "Cannot find file:\n\"FILE.TXT\"" {
+		t.Fatalf("wrong escaped labels %s", s.lset)
+	}
+	if s := samples[3]; !math.IsInf(s.v, 1) || s.t != 0 {
+		t.Fatalf("wrong sample %+v", s)
+	}
+
+	// OpenMetrics timestamps are in seconds, exemplars are ignored
+	samples, err = parseExposition("# TYPE foo counter\nfoo_total 17.0 1520879607.789 # {trace_id=\"KOO5S4vxi0o\"} 0.67\n# EOF\n", true)
+	if err != nil || len(samples) != 1 || samples[0].t != 1520879607789 || samples[0].v != 17 {
+		t.Fatalf("wrong OpenMetrics samples %+v %v", samples, err)
+	}
+
+	for _, line := range []string{"{a=\"b\"} 1", "foo{a=b} 1", "foo{a=\"b\" 1", "foo", "foo x", "foo 1 x", "foo{a=\"b\"}1", "foo 1 2 3"} {
+		if _, err := parseExposition(line, false); err == nil {
+			t.Fatalf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+global:
+  scrape_interval: 30s
+scrape_configs:
+- job_name: node
+  static_configs:
+  - targets: [localhost:9100, "10.0.0.1:9100"]
+    labels: {env: prod}
+- job_name: app
+  scrape_interval: 5s
+  metrics_path: /custom
+  honor_timestamps: false
+  static_configs:
+  - targets: [localhost:8080]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, app := cfg.ScrapeConfigs[0], cfg.ScrapeConfigs[1]
+	if node.interval != 30*time.Second || node.timeout != 10*time.Second || node.MetricsPath != "/metrics" || !*node.HonorTimestamps {
+		t.Fatalf("wrong node job %+v", node)
+	}
+	if app.interval != 5*time.Second || app.timeout != 5*time.Second || app.MetricsPath != "/custom" || *app.HonorTimestamps {
+		t.Fatalf("wrong app job %+v", app)
+	}
+
+	for _, bad := range []string{
+		"scrape_configs: [{static_configs: []}]",
+		"scrape_configs: [{job_name: a}, {job_name: a}]",
+		"scrape_configs: [{job_name: a, scrape_interval: 5s, scrape_timeout: 10s}]",
+		"scrape_configs: [{job_name: a, static_configs: [{targets: [localhost]}]}]",
+		"scrape_configs: [{job_name: a, scheme: ftp}]",
+		"global: {scrape_interval: x}",
+	} {
+		if _, err := ParseConfig([]byte(bad)); err == nil {
+			t.Fatalf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestScrape(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	body, status := "", http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/metrics" || !strings.Contains(r.Header.Get("Accept"), "text/plain") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://")
+	respond := func(b string, s int) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, status = b, s
+	}
+
+	cfg, err := ParseConfig([]byte(fmt.Sprintf(`
+scrape_configs:
+- job_name: app
+  scrape_interval: 1h
+  static_configs:
+  - targets: ["%s"]
+    labels: {env: test}
+`, target)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &tsdbtest.Appender{}
+	scraper := NewScraper(logger, app, cfg)
+	l := scraper.loops[0]
+
+	series := func(name string, labels ...string) string {
+		lset := utils.FromStrings(append([]string{"__name__", name, "env", "test", "instance", target, "job", "app"}, labels...)...)
+		return lset.String()
+	}
+
+	// the exposed job label conflicts with the target label
+	respond("a{job=\"exposed\"} 1\nb 2\nc 3 1000\n", http.StatusOK)
+	if err := l.scrape(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got := app.Take()
+	if got[series("a", "exported_job", "exposed")] != 1 || got[series("b")] != 2 || got[series("up")] != 1 ||
+		got[series("scrape_samples_scraped")] != 3 || len(got) != 6 {
+		t.Fatalf("wrong first scrape %v", got)
+	}
+
+	// b disappears, c has an explicit timestamp (no staleness marker)
+	respond("a{job=\"exposed\"} 4\n", http.StatusOK)
+	l.scrape(context.Background(), time.Now())
+	got = app.Take()
+	if !utils.IsStaleNaN(got[series("b")]) || got[series("a", "exported_job", "exposed")] != 4 || len(got) != 5 {
+		t.Fatalf("wrong second scrape %v", got)
+	}
+
+	// a failed scrape marks all the series stale
+	respond("", http.StatusInternalServerError)
+	if err := l.scrape(context.Background(), time.Now()); err == nil {
+		t.Fatalf("expected a scrape error")
+	}
+	got = app.Take()
+	if !utils.IsStaleNaN(got[series("a", "exported_job", "exposed")]) || got[series("up")] != 0 {
+		t.Fatalf("wrong failed scrape %v", got)
+	}
+
+	// stopping the scraper marks the last series stale
+	respond("a 1\n", http.StatusOK)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scraper.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(app.Take()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	got = app.Take()
+	if !utils.IsStaleNaN(got[series("a")]) || !utils.IsStaleNaN(got[series("up")]) {
+		t.Fatalf("wrong end of run staleness markers %v", got)
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// max size of a request body
+const maxBodySize = 1 << 20
+
+// Handler serves the Grafana SimpleJSON datasource API (/, /search, /query, /annotations, /tag-keys and
+// /tag-values), targets are series selectors with optional aggregation functions (see parseTarget)
+type Handler struct {
+	logger  logger.Logger
+	querier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)
+	mux     *http.ServeMux
+}
+
+// NewHandler creates the datasource handler, newQuerier creates a querier for a time range
+// (e.g. tsdb.V3ioAdapter.Querier)
+func NewHandler(logger logger.Logger,
+	newQuerier func(ctx context.Context, mint, maxt int64) (*querier.V3ioQuerier, error)) *Handler {
+	return newHandler(logger, func(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+		return newQuerier(ctx, mint, maxt)
+	})
+}
+
+func newHandler(logger logger.Logger, newQuerier func(ctx context.Context, mint, maxt int64) (querier.Querier, error)) *Handler {
+	h := &Handler{logger: logger, querier: newQuerier, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/", h.test)
+	h.mux.HandleFunc("/search", h.wrap(h.search))
+	h.mux.HandleFunc("/query", h.wrap(h.query))
+	h.mux.HandleFunc("/annotations", h.wrap(h.annotations))
+	h.mux.HandleFunc("/tag-keys", h.wrap(h.tagKeys))
+	h.mux.HandleFunc("/tag-values", h.wrap(h.tagValues))
+	return h
+}
+
+// the headers allow direct (browser) access from Grafana
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "accept, content-type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+type apiError struct {
+	status int
+	err    error
+}
+
+func badRequest(err error) *apiError {
+	return &apiError{status: http.StatusBadRequest, err: err}
+}
+
+func internal(err error) *apiError {
+	return &apiError{status: http.StatusInternalServerError, err: err}
+}
+
+// the datasource test ("Save & Test" in Grafana)
+func (h *Handler) test(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// wrap an API function, decode the JSON request body and encode the JSON response (errors as {"message": "..."})
+func (h *Handler) wrap(fn func(ctx context.Context, body []byte) (interface{}, *apiError)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data interface{}
+		var apiErr *apiError
+		if r.Method != http.MethodPost {
+			apiErr = &apiError{status: http.StatusMethodNotAllowed, err: fmt.Errorf("method %s is not allowed", r.Method)}
+		} else if body, status, err := utils.ReadBody(r, maxBodySize); err != nil {
+			apiErr = &apiError{status: status, err: err}
+		} else {
+			data, apiErr = fn(r.Context(), body)
+		}
+
+		status := http.StatusOK
+		if apiErr != nil {
+			h.logger.DebugWith("SimpleJSON request failed", "url", r.URL.String(), "err", apiErr.err)
+			data = map[string]string{"message": apiErr.err.Error()}
+			status = apiErr.status
+		}
+
+		buf, err := json.Marshal(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(buf)
+	}
+}
+
+// decode a request body, an empty body is an empty request
+func decode(body []byte, v interface{}) *apiError {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return badRequest(fmt.Errorf("invalid request: %v", err))
+	}
+	return nil
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// the range in unix milliseconds, times are RFC3339 (as sent by Grafana)
+func (r timeRange) parse() (int64, int64, error) {
+	start, err := parseTime(r.From)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTime(r.To)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("range end %s is before the start %s", r.To, r.From)
+	}
+	return start, end, nil
+}
+
+func parseTime(str string) (int64, error) {
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use RFC3339", str)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+type searchRequest struct {
+	Target string `json:"target"`
+}
+
+// the metric names (from the names table) which contain the target string
+func (h *Handler) search(ctx context.Context, body []byte) (interface{}, *apiError) {
+	req := searchRequest{}
+	if apiErr := decode(body, &req); apiErr != nil {
+		return nil, apiErr
+	}
+
+	q, err := h.querier(ctx, 0, time.Now().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, internal(err)
+	}
+	defer q.Close()
+	names, err := q.LabelValues(utils.MetricName)
+	if err != nil {
+		return nil, internal(err)
+	}
+
+	result := []string{}
+	for _, name := range names {
+		if strings.Contains(name, req.Target) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+type adhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+type queryRequest struct {
+	Range         timeRange     `json:"range"`
+	IntervalMs    int64         `json:"intervalMs"`
+	MaxDataPoints int64         `json:"maxDataPoints"`
+	Targets       []target      `json:"targets"`
+	AdhocFilters  []adhocFilter `json:"adhocFilters"`
+}
+
+// the series of each target, as time series ({"target": "..", "datapoints": [[<value>, <unix ms>], ..]}) or
+// tables (target type "table")
+func (h *Handler) query(ctx context.Context, body []byte) (interface{}, *apiError) {
+	req := queryRequest{}
+	if apiErr := decode(body, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	start, end, err := req.Range.parse()
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	filters, err := adhocMatchers(req.AdhocFilters)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	step := queryStep(start, end, req.IntervalMs, req.MaxDataPoints)
+
+	result := []interface{}{}
+	for _, t := range req.Targets {
+		if t.Hide || strings.TrimSpace(t.Target) == "" {
+			continue
+		}
+		params, err := t.params(step, filters)
+		if err != nil {
+			return nil, badRequest(fmt.Errorf("invalid target %q: %v", t.Target, err))
+		}
+		list, err := h.selectSeries(ctx, params, start, end)
+		if err != nil {
+			return nil, internal(err)
+		}
+
+		if t.Type == "table" {
+			result = append(result, newTable(list))
+			continue
+		}
+		for _, s := range list {
+			result = append(result, timeSeries{Target: s.name(), Datapoints: s.points})
+		}
+	}
+	return result, nil
+}
+
+type annotationRequest struct {
+	Range      timeRange       `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type annotationQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+type annotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// the annotation query is a target, every sample with a non zero value is an annotation (titled with the metric
+// name and tagged with the labels)
+func (h *Handler) annotations(ctx context.Context, body []byte) (interface{}, *apiError) {
+	req := annotationRequest{}
+	if apiErr := decode(body, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	aq := annotationQuery{}
+	if len(req.Annotation) > 0 {
+		if err := json.Unmarshal(req.Annotation, &aq); err != nil {
+			return nil, badRequest(fmt.Errorf("invalid annotation: %v", err))
+		}
+	}
+	if strings.TrimSpace(aq.Query) == "" {
+		return nil, badRequest(fmt.Errorf("missing annotation query"))
+	}
+	start, end, err := req.Range.parse()
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	params, err := (&target{Target: aq.Query}).params(queryStep(start, end, 0, 0), nil)
+	if err != nil {
+		return nil, badRequest(fmt.Errorf("invalid annotation query %q: %v", aq.Query, err))
+	}
+	list, err := h.selectSeries(ctx, params, start, end)
+	if err != nil {
+		return nil, internal(err)
+	}
+
+	result := []annotation{}
+	for _, s := range list {
+		name, tags := s.nameAndTags()
+		for _, p := range s.points {
+			if p.v != 0 && !math.IsNaN(p.v) {
+				result = append(result, annotation{Annotation: req.Annotation, Time: p.t, Title: name,
+					Text: formatValue(p.v), Tags: tags})
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time < result[j].Time })
+	return result, nil
+}
+
+type tagKey struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// the label names (for ad hoc filters), all the labels are strings
+func (h *Handler) tagKeys(ctx context.Context, body []byte) (interface{}, *apiError) {
+	q, err := h.querier(ctx, 0, time.Now().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, internal(err)
+	}
+	defer q.Close()
+	names, err := q.LabelNames()
+	if err != nil {
+		return nil, internal(err)
+	}
+
+	result := []tagKey{}
+	for _, name := range names {
+		if name != utils.MetricName {
+			result = append(result, tagKey{Type: "string", Text: name})
+		}
+	}
+	return result, nil
+}
+
+type tagValuesRequest struct {
+	Key string `json:"key"`
+}
+
+type tagValue struct {
+	Text string `json:"text"`
+}
+
+// the values of a label
+func (h *Handler) tagValues(ctx context.Context, body []byte) (interface{}, *apiError) {
+	req := tagValuesRequest{}
+	if apiErr := decode(body, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if !utils.IsValidLabelName(req.Key) {
+		return nil, badRequest(fmt.Errorf("invalid tag key %q", req.Key))
+	}
+
+	q, err := h.querier(ctx, 0, time.Now().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, internal(err)
+	}
+	defer q.Close()
+	values, err := q.LabelValues(req.Key)
+	if err != nil {
+		return nil, internal(err)
+	}
+
+	result := []tagValue{}
+	for _, value := range values {
+		result = append(result, tagValue{Text: value})
+	}
+	return result, nil
+}
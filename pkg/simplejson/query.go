@@ -0,0 +1,319 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package simplejson
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/v3io/v3io-tsdb/pkg/aggregate"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// the number of points per series when the request doesn't set maxDataPoints
+const defaultMaxDataPoints = 1000
+
+// a query target, Data holds optional query options
+type target struct {
+	Target string      `json:"target"`
+	RefID  string      `json:"refId"`
+	Type   string      `json:"type"` // timeserie (default) or table
+	Hide   bool        `json:"hide"`
+	Data   *targetData `json:"data"`
+}
+
+type targetData struct {
+	Step string `json:"step"` // aggregation step, e.g. 5m (default from the range and maxDataPoints)
+	Fill string `json:"fill"` // fill policy of empty steps: none, null, zero, previous, linear or a number
+}
+
+// the select params of the target, the aggregation step is the target step or the query step
+func (t *target) params(step int64, filters []*utils.LabelMatcher) (*querier.SelectParams, error) {
+	params, err := parseTarget(t.Target)
+	if err != nil {
+		return nil, err
+	}
+	params.Matchers = append(params.Matchers, filters...)
+
+	if params.Functions != "" {
+		params.Step = step
+	}
+	if t.Data != nil {
+		if t.Data.Step != "" {
+			if params.Step, err = utils.Str2duration(t.Data.Step); err != nil {
+				return nil, err
+			}
+			if params.Step <= 0 {
+				return nil, fmt.Errorf("step must be positive")
+			}
+		}
+		if params.Fill, err = querier.ParseFill(t.Data.Fill); err != nil {
+			return nil, err
+		}
+		// a fill policy resamples raw series to the step grid
+		if params.Fill.Type != querier.FillDefault && params.Step == 0 {
+			params.Step = step
+		}
+	}
+	return params, nil
+}
+
+// parse a target: a series selector, optionally wrapped with comma separated aggregation functions and grouped
+// by labels, e.g. cpu{host=~"web.*"}, avg,max(cpu{host=~"web.*"}) or sum(cpu) by (dc)
+func parseTarget(str string) (*querier.SelectParams, error) {
+	str = strings.TrimSpace(str)
+	params := &querier.SelectParams{}
+
+	if strings.HasSuffix(str, ")") {
+		if open := strings.LastIndex(str, "("); open > 0 {
+			head := strings.TrimSpace(str[:open])
+			if strings.HasSuffix(head, " by") || strings.HasSuffix(head, ")by") {
+				for _, name := range strings.Split(str[open+1:len(str)-1], ",") {
+					name = strings.TrimSpace(name)
+					if !utils.IsValidLabelName(name) {
+						return nil, fmt.Errorf("invalid group by label %q", name)
+					}
+					params.GroupBy = append(params.GroupBy, name)
+				}
+				str = strings.TrimSpace(strings.TrimSuffix(head, "by"))
+			}
+		}
+	}
+
+	if strings.HasSuffix(str, ")") {
+		open := strings.Index(str, "(")
+		if brace := strings.Index(str, "{"); open <= 0 || brace >= 0 && brace < open {
+			return nil, fmt.Errorf("expected aggregation functions before (")
+		}
+		params.Functions = strings.Replace(str[:open], " ", "", -1)
+		if _, err := aggregate.AggrsFromString(params.Functions); err != nil {
+			return nil, err
+		}
+		str = str[open+1 : len(str)-1]
+	} else if params.GroupBy != nil {
+		return nil, fmt.Errorf("group by requires aggregation functions")
+	}
+
+	matchers, err := utils.ParseSelector(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	params.Matchers = matchers
+	return params, nil
+}
+
+var adhocOperators = map[string]utils.MatchType{
+	"=": utils.MatchEqual, "!=": utils.MatchNotEqual, "=~": utils.MatchRegexp, "!~": utils.MatchNotRegexp}
+
+// convert the ad hoc filters to label matchers
+func adhocMatchers(filters []adhocFilter) ([]*utils.LabelMatcher, error) {
+	var matchers []*utils.LabelMatcher
+	for _, f := range filters {
+		typ, ok := adhocOperators[f.Operator]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ad hoc filter operator %q, use =, !=, =~ or !~", f.Operator)
+		}
+		if !utils.IsValidLabelName(f.Key) {
+			return nil, fmt.Errorf("invalid ad hoc filter key %q", f.Key)
+		}
+		m, err := utils.NewLabelMatcher(typ, f.Key, f.Value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// the aggregation step, so a series has at most maxDataPoints points (and steps are not shorter than the panel
+// interval), rounded up to whole seconds
+func queryStep(start, end, intervalMs, maxDataPoints int64) int64 {
+	if maxDataPoints <= 0 {
+		maxDataPoints = defaultMaxDataPoints
+	}
+	step := (end - start + maxDataPoints - 1) / maxDataPoints
+	if step < intervalMs {
+		step = intervalMs
+	}
+	if step < 1000 {
+		return 1000
+	}
+	return (step + 999) / 1000 * 1000
+}
+
+type point struct {
+	t int64
+	v float64
+}
+
+type series struct {
+	lset   utils.Labels
+	points datapoints
+}
+
+// the series name, the metric name followed by the other labels (like the json formatter)
+func (s *series) name() string {
+	name, tags := s.nameAndTags()
+	if len(tags) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(tags, ",") + "}"
+}
+
+func (s *series) nameAndTags() (string, []string) {
+	var name string
+	tags := []string{}
+	for _, l := range s.lset {
+		if l.Name == utils.MetricName {
+			name = l.Value
+		} else {
+			tags = append(tags, l.Name+"="+l.Value)
+		}
+	}
+	return name, tags
+}
+
+// select the series points in [start, end], stale markers are dropped
+func (h *Handler) selectSeries(ctx context.Context, params *querier.SelectParams, start, end int64) ([]*series, error) {
+	q, err := h.querier(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	set, err := q.SelectQry(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*series
+	for set.Next() {
+		lset := set.At().Labels().Copy()
+		sort.Sort(lset)
+		s := &series{lset: lset, points: datapoints{}}
+		iter := set.At().Iterator()
+		for iter.Next() {
+			t, v := iter.At()
+			if t >= start && t <= end && !utils.IsStaleNaN(v) {
+				s.points = append(s.points, point{t: t, v: v})
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	if err := set.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return utils.Compare(list[i].lset, list[j].lset) < 0 })
+	return list, nil
+}
+
+type timeSeries struct {
+	Target     string     `json:"target"`
+	Datapoints datapoints `json:"datapoints"`
+}
+
+// data points are encoded as [[<value>, <unix ms>], ..], NaN values as null
+type datapoints []point
+
+func (d datapoints) MarshalJSON() ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, p := range d {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString("[" + jsonValue(p.v) + "," + strconv.FormatInt(p.t, 10) + "]")
+	}
+	sb.WriteByte(']')
+	return []byte(sb.String()), nil
+}
+
+func jsonValue(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "null"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+type column struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type table struct {
+	Type    string          `json:"type"`
+	Columns []column        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// a table with a row per sample, the columns are the time, metric name, each label and the value
+func newTable(list []*series) *table {
+	labels := map[string]bool{}
+	for _, s := range list {
+		for _, l := range s.lset {
+			if l.Name != utils.MetricName {
+				labels[l.Name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t := &table{Type: "table", Rows: [][]interface{}{}}
+	t.Columns = append(t.Columns, column{Text: "Time", Type: "time"}, column{Text: "Metric", Type: "string"})
+	for _, name := range names {
+		t.Columns = append(t.Columns, column{Text: name, Type: "string"})
+	}
+	t.Columns = append(t.Columns, column{Text: "Value", Type: "number"})
+
+	for _, s := range list {
+		for _, p := range s.points {
+			row := []interface{}{p.t, s.lset.Get(utils.MetricName)}
+			for _, name := range names {
+				row = append(row, s.lset.Get(name))
+			}
+			var v interface{}
+			if !math.IsNaN(p.v) && !math.IsInf(p.v, 0) {
+				v = p.v
+			}
+			t.Rows = append(t.Rows, append(row, v))
+		}
+	}
+	return t
+}
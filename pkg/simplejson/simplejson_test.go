@@ -0,0 +1,210 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package simplejson
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParseTarget(t *testing.T) {
+	for _, test := range []struct {
+		target    string
+		functions string
+		groupBy   []string
+		matchers  string
+	}{
+		{target: `cpu`, matchers: `[__name__="cpu"]`},
+		{target: ` cpu{host=~"web.*"} `, matchers: `[__name__="cpu" host=~"web.*"]`},
+		{target: `avg,max(cpu{host=~"(a|b)"})`, functions: "avg,max", matchers: `[__name__="cpu" host=~"(a|b)"]`},
+		{target: `sum(cpu) by (dc, host)`, functions: "sum", groupBy: []string{"dc", "host"}, matchers: `[__name__="cpu"]`},
+		{target: `sum(cpu)by(dc)`, functions: "sum", groupBy: []string{"dc"}, matchers: `[__name__="cpu"]`},
+	} {
+		params, err := parseTarget(test.target)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", test.target, err)
+		}
+		var matchers []string
+		for _, m := range params.Matchers {
+			matchers = append(matchers, m.String())
+		}
+		if params.Functions != test.functions || !reflect.DeepEqual(params.GroupBy, test.groupBy) ||
+			"["+strings.Join(matchers, " ")+"]" != test.matchers {
+			t.Fatalf("wrong params for %s: %+v %v", test.target, params, matchers)
+		}
+	}
+
+	for _, target := range []string{``, `foo(cpu)`, `cpu by (dc)`, `sum(cpu) by (a-b)`, `(cpu)`, `cpu{host="a"`} {
+		if _, err := parseTarget(target); err == nil {
+			t.Fatalf("expected an error for %q", target)
+		}
+	}
+}
+
+func TestQueryStep(t *testing.T) {
+	const hour = 3600 * 1000
+	for _, test := range []struct {
+		start, end, interval, maxDataPoints, expected int64
+	}{
+		{0, hour, 0, 60, 60 * 1000},
+		{0, hour, 0, 0, 4000},
+		{0, hour, 5 * 60 * 1000, 1000, 5 * 60 * 1000},
+		{0, 1000, 0, 100, 1000},
+		{0, hour, 0, 7, 515000},
+	} {
+		if step := queryStep(test.start, test.end, test.interval, test.maxDataPoints); step != test.expected {
+			t.Fatalf("wrong step for %+v: %d", test, step)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &tsdbtest.Querier{
+		Raw: []*tsdbtest.Series{
+			{Lset: utils.FromStrings("__name__", "cpu", "host", "b", "dc", "lga"),
+				Points: []tsdbtest.Point{{T: 1000, V: 10}, {T: 2000, V: math.NaN()}, {T: 3000, V: 0}, {T: 4000, V: utils.StaleNaN}}},
+			{Lset: utils.FromStrings("__name__", "cpu", "host", "a", "dc", "lga"), Points: []tsdbtest.Point{{T: 1000, V: 1}, {T: 3000, V: 3}}},
+			{Lset: utils.FromStrings("__name__", "cpu_idle", "host", "a", "dc", "lga"), Points: []tsdbtest.Point{{T: 1000, V: 99}}},
+			{Lset: utils.FromStrings("__name__", "mem", "host", "a", "dc", "lga"), Points: []tsdbtest.Point{{T: 1000, V: 50}}},
+		},
+		Aggr: []*tsdbtest.Series{
+			{Lset: utils.FromStrings("__name__", "cpu", "dc", "lga", "Aggregator", "sum"), Points: []tsdbtest.Point{{T: 0, V: 11}, {T: 60000, V: 33}}},
+		},
+	}
+	handler := newHandler(logger, q.New)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) interface{} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request failed %d %s", rec.Code, rec.Body)
+		}
+		var res interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if rec := do(http.MethodGet, "/", ""); rec.Code != http.StatusOK {
+		t.Fatalf("test request failed %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/foo", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, got %d", rec.Code)
+	}
+
+	// search
+	res := decode(do(http.MethodPost, "/search", `{"target":"cpu"}`))
+	if !reflect.DeepEqual(res, []interface{}{"cpu", "cpu_idle"}) {
+		t.Fatalf("wrong search result %v", res)
+	}
+
+	// query
+	rng := `"range":{"from":"1970-01-01T00:00:00.000Z","to":"1970-01-01T00:01:00Z"}`
+	res = decode(do(http.MethodPost, "/query", `{`+rng+`,"maxDataPoints":60,
+		"targets":[{"target":"cpu","refId":"A"},{"target":"sum(cpu) by (dc)","refId":"B"},{"target":"mem","hide":true}]}`))
+	expected := []interface{}{
+		map[string]interface{}{"target": "cpu{dc=lga,host=a}",
+			"datapoints": []interface{}{[]interface{}{1.0, 1000.0}, []interface{}{3.0, 3000.0}}},
+		map[string]interface{}{"target": "cpu{dc=lga,host=b}",
+			"datapoints": []interface{}{[]interface{}{10.0, 1000.0}, []interface{}{nil, 2000.0}, []interface{}{0.0, 3000.0}}},
+		map[string]interface{}{"target": "cpu{Aggregator=sum,dc=lga}",
+			"datapoints": []interface{}{[]interface{}{11.0, 0.0}, []interface{}{33.0, 60000.0}}},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("wrong query result %v", res)
+	}
+	if p := q.LastParams(); p.Functions != "sum" || p.Step != 1000 || !reflect.DeepEqual(p.GroupBy, []string{"dc"}) {
+		t.Fatalf("wrong select params %+v", p)
+	}
+
+	res = decode(do(http.MethodPost, "/query", `{`+rng+`,"targets":[{"target":"cpu","type":"table"}],
+		"adhocFilters":[{"key":"host","operator":"=","value":"a"}]}`))
+	expected = []interface{}{map[string]interface{}{"type": "table",
+		"columns": []interface{}{
+			map[string]interface{}{"text": "Time", "type": "time"}, map[string]interface{}{"text": "Metric", "type": "string"},
+			map[string]interface{}{"text": "dc", "type": "string"}, map[string]interface{}{"text": "host", "type": "string"},
+			map[string]interface{}{"text": "Value", "type": "number"}},
+		"rows": []interface{}{
+			[]interface{}{1000.0, "cpu", "lga", "a", 1.0}, []interface{}{3000.0, "cpu", "lga", "a", 3.0}},
+	}}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("wrong table result %v", res)
+	}
+
+	decode(do(http.MethodPost, "/query", `{`+rng+`,"targets":[{"target":"avg(cpu)","data":{"step":"5m","fill":"zero"}}]}`))
+	if p := q.LastParams(); p.Step != 5*60*1000 || p.Fill.Type != querier.FillZero {
+		t.Fatalf("wrong select params %+v", p)
+	}
+
+	// annotations
+	res = decode(do(http.MethodPost, "/annotations", `{`+rng+`,"annotation":{"name":"deploys","query":"cpu{host=\"b\"}"}}`))
+	expected = []interface{}{map[string]interface{}{
+		"annotation": map[string]interface{}{"name": "deploys", "query": `cpu{host="b"}`},
+		"time":       1000.0, "title": "cpu", "text": "10", "tags": []interface{}{"dc=lga", "host=b"},
+	}}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("wrong annotations %v", res)
+	}
+
+	// tags
+	res = decode(do(http.MethodPost, "/tag-keys", `{}`))
+	if !reflect.DeepEqual(res, []interface{}{
+		map[string]interface{}{"type": "string", "text": "dc"}, map[string]interface{}{"type": "string", "text": "host"}}) {
+		t.Fatalf("wrong tag keys %v", res)
+	}
+	res = decode(do(http.MethodPost, "/tag-values", `{"key":"host"}`))
+	if !reflect.DeepEqual(res, []interface{}{map[string]interface{}{"text": "a"}, map[string]interface{}{"text": "b"}}) {
+		t.Fatalf("wrong tag values %v", res)
+	}
+
+	for _, test := range []struct{ method, path, body string }{
+		{http.MethodGet, "/query", ""},
+		{http.MethodPost, "/query", `{"range":{"from":"yesterday","to":"now"},"targets":[]}`},
+		{http.MethodPost, "/query", `{` + rng + `,"targets":[{"target":"foo(cpu)"}]}`},
+		{http.MethodPost, "/query", `{` + rng + `,"targets":[{"target":"cpu"}],"adhocFilters":[{"key":"a","operator":"<","value":"1"}]}`},
+		{http.MethodPost, "/annotations", `{` + rng + `,"annotation":{"name":"x"}}`},
+		{http.MethodPost, "/tag-values", `{"key":"a-b"}`},
+	} {
+		rec := do(test.method, test.path, test.body)
+		if rec.Code < http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"message"`) {
+			t.Fatalf("expected an error for %+v, got %d %s", test, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// statsd metric types
+const (
+	typeCounter = "c"
+	typeGauge   = "g"
+	typeTimer   = "ms"
+	typeHisto   = "h"
+	typeSet     = "s"
+)
+
+// a single statsd sample, "<name>:<value>|<type>[|@<rate>][|#<tag>:<value>,...]"
+type sample struct {
+	name  string
+	typ   string
+	value float64
+	// raw value of a set member
+	member string
+	// gauge value with a +/- sign, applied to the previous value
+	delta bool
+	rate  float64
+	tags  map[string]string
+}
+
+// parse a statsd line, a line may carry several values of the same metric ("<name>:1:2|ms")
+func parseLine(line string) ([]sample, error) {
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return nil, fmt.Errorf("invalid line %q, expected <name>:<value>|<type>", line)
+	}
+	name := line[:colon]
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid line %q, missing the metric type", line)
+	}
+
+	s := sample{name: name, typ: parts[1], rate: 1}
+	switch s.typ {
+	case typeCounter, typeGauge, typeTimer, typeHisto, typeSet:
+	default:
+		return nil, fmt.Errorf("invalid metric type %q in line %q", s.typ, line)
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q in line %q", part, line)
+			}
+			s.rate = rate
+		case strings.HasPrefix(part, "#"):
+			s.tags = map[string]string{}
+			for _, tag := range strings.Split(part[1:], ",") {
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+					return nil, fmt.Errorf("invalid tag %q in line %q", tag, line)
+				}
+				s.tags[kv[0]] = kv[1]
+			}
+		default:
+			return nil, fmt.Errorf("invalid section %q in line %q", part, line)
+		}
+	}
+
+	var samples []sample
+	for _, raw := range strings.Split(parts[0], ":") {
+		sm := s
+		if s.typ == typeSet {
+			if raw == "" {
+				return nil, fmt.Errorf("empty set member in line %q", line)
+			}
+			sm.member = raw
+			samples = append(samples, sm)
+			continue
+		}
+
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q in line %q", raw, line)
+		}
+		sm.value = v
+		sm.delta = s.typ == typeGauge && (raw[0] == '+' || raw[0] == '-')
+		samples = append(samples, sm)
+	}
+	return samples, nil
+}
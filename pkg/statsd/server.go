@@ -0,0 +1,349 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package statsd
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// max size of a UDP packet
+const maxPacketSize = 64 * 1024
+
+type counter struct {
+	lset    utils.Labels
+	value   float64
+	updated bool
+	idle    int // flush intervals without an update
+}
+
+type gauge struct {
+	lset    utils.Labels
+	value   float64
+	updated bool
+	idle    int
+}
+
+// a counter or gauge value to append
+type pending struct {
+	lset  utils.Labels
+	value float64
+}
+
+type timer struct {
+	lset   utils.Labels
+	values []float64
+}
+
+type set struct {
+	lset    utils.Labels
+	members map[string]struct{}
+}
+
+// Server is a StatsD (UDP) listener, the samples are aggregated in memory and appended through the TSDB
+// Appender once per flush interval:
+//
+//	counters - the cumulative (rate adjusted) count as <name>
+//	gauges   - the last value as <name>
+//	timers   - <name>_count, <name>_sum, <name>_min, <name>_max and <name>{quantile="q"} of the interval values
+//	sets     - the number of unique members in the interval as <name>
+//
+// only the metrics updated in the interval are appended, counters and gauges which are not updated for the expire
+// intervals are dropped
+type Server struct {
+	logger   logger.Logger
+	appender tsdb.Appender
+	interval time.Duration
+	quantile []float64
+	prefix   string
+	expire   int
+
+	conn *net.UDPConn
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+	sets     map[string]*set
+}
+
+// NewServer creates a StatsD listener
+func NewServer(logger logger.Logger, appender tsdb.Appender, cfg *config.StatsdConfig) *Server {
+	s := &Server{
+		logger:   logger,
+		appender: appender,
+		interval: 10 * time.Second,
+		quantile: []float64{0.5, 0.9, 0.99},
+		expire:   60,
+		stop:     make(chan struct{}),
+		counters: map[string]*counter{},
+		gauges:   map[string]*gauge{},
+		timers:   map[string]*timer{},
+		sets:     map[string]*set{},
+	}
+	if cfg != nil {
+		if cfg.FlushIntervalSec > 0 {
+			s.interval = time.Duration(cfg.FlushIntervalSec) * time.Second
+		}
+		if cfg.Quantiles != nil {
+			s.quantile = cfg.Quantiles
+		}
+		s.prefix = cfg.Prefix
+		if cfg.ExpireIntervals > 0 {
+			s.expire = cfg.ExpireIntervals
+		}
+	}
+	return s
+}
+
+// Start listens on the UDP address, the packets are read and flushed in the background
+func (s *Server) Start(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid address %s", addr)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to listen on %s", addr)
+	}
+	s.conn = conn
+
+	s.wg.Add(2)
+	go s.read()
+	go s.flushLoop()
+	return nil
+}
+
+// Addr returns the listener address
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close stops the listener and flushes the pending aggregates
+func (s *Server) Close() error {
+	close(s.stop)
+	err := s.conn.Close()
+	s.wg.Wait()
+	s.Flush(time.Now())
+	return err
+}
+
+func (s *Server) read() {
+	defer s.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			s.logger.WarnWith("Failed to read a statsd packet", "err", err)
+			continue
+		}
+		s.handlePacket(string(buf[:n]))
+	}
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.Flush(now)
+		}
+	}
+}
+
+func (s *Server) handlePacket(packet string) {
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		samples, err := parseLine(line)
+		if err != nil {
+			s.logger.WarnWith("Invalid statsd line", "err", err)
+			continue
+		}
+		for _, sm := range samples {
+			s.add(sm)
+		}
+	}
+}
+
+// labels of a sample, the sanitized (and prefixed) name and the tags
+func (s *Server) labels(sm sample) utils.Labels {
+	lset := utils.Labels{{Name: utils.MetricName, Value: utils.SanitizeLabelName(s.prefix + sm.name)}}
+	for name, value := range sm.tags {
+		name = utils.SanitizeLabelName(name)
+		if name != utils.MetricName && !lset.Has(name) {
+			lset = append(lset, utils.Label{Name: name, Value: value})
+		}
+	}
+	sort.Sort(lset)
+	return lset
+}
+
+func (s *Server) add(sm sample) {
+	lset := s.labels(sm)
+	key := lset.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch sm.typ {
+	case typeCounter:
+		c, ok := s.counters[key]
+		if !ok {
+			c = &counter{lset: lset}
+			s.counters[key] = c
+		}
+		c.value += sm.value / sm.rate
+		c.updated = true
+	case typeGauge:
+		g, ok := s.gauges[key]
+		if !ok {
+			g = &gauge{lset: lset}
+			s.gauges[key] = g
+		}
+		if sm.delta {
+			g.value += sm.value
+		} else {
+			g.value = sm.value
+		}
+		g.updated = true
+	case typeTimer, typeHisto:
+		t, ok := s.timers[key]
+		if !ok {
+			t = &timer{lset: lset}
+			s.timers[key] = t
+		}
+		t.values = append(t.values, sm.value)
+	case typeSet:
+		st, ok := s.sets[key]
+		if !ok {
+			st = &set{lset: lset, members: map[string]struct{}{}}
+			s.sets[key] = st
+		}
+		st.members[sm.member] = struct{}{}
+	}
+}
+
+// Flush appends the aggregates of the interval at the given time and resets the interval state, the state is
+// swapped out under the lock and appended after it is released so the packets are not blocked by the appender
+func (s *Server) Flush(now time.Time) {
+	t := now.UnixNano() / int64(time.Millisecond)
+
+	s.mu.Lock()
+	var values []pending
+	for key, c := range s.counters {
+		if c.updated {
+			values = append(values, pending{c.lset, c.value})
+			c.updated, c.idle = false, 0
+		} else if c.idle++; c.idle >= s.expire {
+			delete(s.counters, key)
+		}
+	}
+	for key, g := range s.gauges {
+		if g.updated {
+			values = append(values, pending{g.lset, g.value})
+			g.updated, g.idle = false, 0
+		} else if g.idle++; g.idle >= s.expire {
+			delete(s.gauges, key)
+		}
+	}
+	timers, sets := s.timers, s.sets
+	s.timers, s.sets = map[string]*timer{}, map[string]*set{}
+	s.mu.Unlock()
+
+	for _, p := range values {
+		s.append(p.lset, t, p.value)
+	}
+
+	for _, tm := range timers {
+		sort.Float64s(tm.values)
+		sum := 0.0
+		for _, v := range tm.values {
+			sum += v
+		}
+		s.append(withSuffix(tm.lset, "_count"), t, float64(len(tm.values)))
+		s.append(withSuffix(tm.lset, "_sum"), t, sum)
+		s.append(withSuffix(tm.lset, "_min"), t, tm.values[0])
+		s.append(withSuffix(tm.lset, "_max"), t, tm.values[len(tm.values)-1])
+		for _, q := range s.quantile {
+			lset := append(tm.lset.Copy(), utils.Label{Name: "quantile", Value: strconv.FormatFloat(q, 'g', -1, 64)})
+			sort.Sort(lset)
+			s.append(lset, t, quantile(tm.values, q))
+		}
+	}
+
+	for _, st := range sets {
+		s.append(st.lset, t, float64(len(st.members)))
+	}
+}
+
+func (s *Server) append(lset utils.Labels, t int64, v float64) {
+	if _, err := s.appender.Add(lset, t, v); err != nil {
+		s.logger.ErrorWith("Failed to append statsd metric", "labels", lset.String(), "err", err)
+	}
+}
+
+// copy of the labels with a suffix added to the metric name
+func withSuffix(lset utils.Labels, suffix string) utils.Labels {
+	res := lset.Copy()
+	for i := range res {
+		if res[i].Name == utils.MetricName {
+			res[i].Value += suffix
+		}
+	}
+	return res
+}
+
+// nearest rank quantile of sorted values
+func quantile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package statsd
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/v3io/v3io-tsdb/config"
+	"github.com/v3io/v3io-tsdb/pkg/tsdb/tsdbtest"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+func TestParseLine(t *testing.T) {
+	samples, err := parseLine("api.requests:2|c|@0.5|#host:a,dc:eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].value != 2 || samples[0].rate != 0.5 || samples[0].tags["dc"] != "eu" {
+		t.Fatalf("wrong samples %+v", samples)
+	}
+
+	if samples, err = parseLine("temp:-3|g"); err != nil || !samples[0].delta || samples[0].value != -3 {
+		t.Fatalf("wrong gauge delta %+v %v", samples, err)
+	}
+	if samples, err = parseLine("latency:1:2.5|ms"); err != nil || len(samples) != 2 || samples[1].value != 2.5 {
+		t.Fatalf("wrong timer values %+v %v", samples, err)
+	}
+
+	for _, line := range []string{"a", ":1|c", "a:1", "a:1|x", "a:x|c", "a:1|c|@2", "a:1|c|#host", "a:1|c|x", "a:|s"} {
+		if _, err := parseLine(line); err == nil {
+			t.Fatalf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	logger, err := utils.NewLogger("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &tsdbtest.Appender{}
+	// a long interval, the test flushes explicitly
+	server := NewServer(logger, app, &config.StatsdConfig{FlushIntervalSec: 3600, Quantiles: []float64{0.5}, Prefix: "sd.",
+		ExpireIntervals: 2})
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, err := net.Dial("udp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	packets := []string{
+		"hits:1|c\nhits:1|c|@0.5\nbad line",
+		"temp:20|g\ntemp:+5|g",
+		"latency:30:10|ms\nlatency:20|ms|#route:home",
+		"users:a|s\nusers:b|s\nusers:a|s",
+		"done:1|c",
+	}
+	for _, p := range packets {
+		if _, err := conn.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// wait for the last packet
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.mu.Lock()
+		_, ok := server.counters[`{__name__="sd_done"}`]
+		server.mu.Unlock()
+		if ok || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server.Flush(time.Unix(1500000000, 0))
+	expected := map[string]float64{
+		`{__name__="sd_hits"}`:                                  3,
+		`{__name__="sd_done"}`:                                  1,
+		`{__name__="sd_temp"}`:                                  25,
+		`{__name__="sd_latency_count"}`:                         2,
+		`{__name__="sd_latency_sum"}`:                           40,
+		`{__name__="sd_latency_min"}`:                           10,
+		`{__name__="sd_latency_max"}`:                           30,
+		`{__name__="sd_latency", quantile="0.5"}`:               10,
+		`{__name__="sd_latency_count", route="home"}`:           1,
+		`{__name__="sd_latency_sum", route="home"}`:             20,
+		`{__name__="sd_latency_min", route="home"}`:             20,
+		`{__name__="sd_latency_max", route="home"}`:             20,
+		`{__name__="sd_latency", quantile="0.5", route="home"}`: 20,
+		`{__name__="sd_users"}`:                                 2,
+	}
+	got := app.Take()
+	if len(got) != len(expected) {
+		keys := []string{}
+		for k := range got {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		t.Fatalf("wrong series %v", keys)
+	}
+	for lset, v := range expected {
+		if got[lset] != v {
+			t.Fatalf("wrong value of %s: %f (expected %f)", lset, got[lset], v)
+		}
+	}
+
+	// only the updated metrics are appended, counters are cumulative
+	conn.Write([]byte("hits:2|c"))
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		server.mu.Lock()
+		updated := server.counters[`{__name__="sd_hits"}`].updated
+		server.mu.Unlock()
+		if updated || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.Flush(time.Unix(1500000010, 0))
+	if got = app.Take(); len(got) != 1 || got[`{__name__="sd_hits"}`] != 5 {
+		t.Fatalf("wrong second flush %v", got)
+	}
+
+	// the counters and gauges which were not updated for the expire intervals are dropped
+	server.Flush(time.Unix(1500000020, 0))
+	if got = app.Take(); len(got) != 0 {
+		t.Fatalf("wrong third flush %v", got)
+	}
+	server.mu.Lock()
+	counters, gauges := len(server.counters), len(server.gauges)
+	_, ok := server.counters[`{__name__="sd_hits"}`]
+	server.mu.Unlock()
+	if counters != 1 || gauges != 0 || !ok {
+		t.Fatalf("wrong state after expiry, %d counters and %d gauges", counters, gauges)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package tsdb
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-go-http"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"sort"
+	"strings"
+)
+
+// max series attributes set in a single label index update
+const indexAttrsPerUpdate = 200
+
+// label index consistency check result
+type LabelIndexReport struct {
+	Series  int      // number of series in the partition
+	Pairs   int      // number of label pairs (index items)
+	Missing []string // series entries missing from the index (pair/series item)
+	Stale   []string // index entries of series which don't exist or don't have the label pair (pair/series item)
+}
+
+func (r *LabelIndexReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0
+}
+
+// return the expected label index, label pair to series attribute to metric name, and the number of series
+func (a *V3ioAdapter) expectedLabelIndex() (map[string]map[string]string, int, error) {
+	path := a.partitionMngr.GetHead().GetPath()
+	input := v3io.GetItemsInput{Path: path, AttributeNames: []string{"__name", "_name", "_lset"}}
+	iter, err := utils.NewAsyncItemsCursor(a.container, &input, a.cfg.QryWorkers)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index := map[string]map[string]string{}
+	series := 0
+	for iter.Next() {
+		item, _ := iter.GetField("__name").(string)
+		name, _ := iter.GetField("_name").(string)
+		lset, _ := iter.GetField("_lset").(string)
+		dot := strings.LastIndex(item, ".")
+		if name == "" || dot < 0 {
+			continue
+		}
+
+		attr := "_s" + item[dot+1:]
+		for _, label := range utils.KeyLabels(lset) {
+			key := utils.LabelIndexKey(label.Name, label.Value)
+			if index[key] == nil {
+				index[key] = map[string]string{}
+			}
+			index[key][attr] = name
+		}
+		series++
+	}
+	if iter.Err() != nil {
+		return nil, 0, errors.Wrap(iter.Err(), "failed to read the series")
+	}
+
+	return index, series, nil
+}
+
+// CheckLabelIndex compares the label index with the series labels (of the head partition)
+func (a *V3ioAdapter) CheckLabelIndex() (*LabelIndexReport, error) {
+	expected, series, err := a.expectedLabelIndex()
+	if err != nil {
+		return nil, err
+	}
+	report := &LabelIndexReport{Series: series, Pairs: len(expected)}
+
+	input := v3io.GetItemsInput{Path: a.cfg.Path + utils.LabelIndexPath, AttributeNames: []string{"__name", "*"}}
+	iter, err := utils.NewAsyncItemsCursor(a.container, &input, a.cfg.QryWorkers)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	for iter.Next() {
+		key, _ := iter.GetField("__name").(string)
+		for attr, val := range iter.GetFields() {
+			item, ok := utils.IndexAttrToItem(attr, val)
+			if !ok {
+				continue
+			}
+			if expected[key][attr] != val {
+				report.Stale = append(report.Stale, key+"/"+item)
+			} else {
+				found[key+"/"+attr] = true
+			}
+		}
+	}
+	if iter.Err() != nil && !utils.IsNotFound(iter.Err()) {
+		return nil, errors.Wrap(iter.Err(), "failed to read the label index")
+	}
+
+	for key, attrs := range expected {
+		for attr, name := range attrs {
+			if !found[key+"/"+attr] {
+				item, _ := utils.IndexAttrToItem(attr, name)
+				report.Missing = append(report.Missing, key+"/"+item)
+			}
+		}
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Stale)
+	return report, nil
+}
+
+// RebuildLabelIndex deletes the label index and rebuilds it from the series labels (of the head partition)
+func (a *V3ioAdapter) RebuildLabelIndex() error {
+	expected, series, err := a.expectedLabelIndex()
+	if err != nil {
+		return err
+	}
+
+	path := a.cfg.Path + utils.LabelIndexPath
+	a.logger.InfoWith("Rebuild label index", "path", path, "series", series, "pairs", len(expected))
+	if err := utils.DeleteTable(a.container, path, "", a.cfg.QryWorkers); err != nil && !utils.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete the label index")
+	}
+
+	// one update per label pair (split if it has many series)
+	updates := []*v3io.UpdateItemInput{}
+	for key, attrs := range expected {
+		list := []string{}
+		for attr, name := range attrs {
+			list = append(list, fmt.Sprintf("%s='%s';", attr, name))
+		}
+		for start := 0; start < len(list); start += indexAttrsPerUpdate {
+			end := start + indexAttrsPerUpdate
+			if end > len(list) {
+				end = len(list)
+			}
+			expr := strings.Join(list[start:end], " ")
+			updates = append(updates, &v3io.UpdateItemInput{Path: path + key, Expression: &expr})
+		}
+	}
+
+	return a.updateItems(updates)
+}
+
+// issue the update requests (up to QryWorkers in flight), return the first failure
+func (a *V3ioAdapter) updateItems(updates []*v3io.UpdateItemInput) error {
+	workers := a.cfg.QryWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	responseChan := make(chan *v3io.Response, workers)
+
+	var firstErr error
+	inFlight := 0
+	for i := 0; i < len(updates) || inFlight > 0; {
+		if i < len(updates) && inFlight < workers && firstErr == nil {
+			if _, err := a.container.UpdateItem(updates[i], nil, responseChan); err != nil {
+				firstErr = errors.Wrap(err, "failed to update "+updates[i].Path)
+			} else {
+				inFlight++
+			}
+			i++
+			continue
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		resp := <-responseChan
+		inFlight--
+		if resp.Error != nil && firstErr == nil {
+			firstErr = errors.Wrap(resp.Error, "failed to update the label index")
+		}
+		resp.Release()
+	}
+
+	return firstErr
+}
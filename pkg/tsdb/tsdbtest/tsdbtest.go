@@ -0,0 +1,277 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+// Package tsdbtest has the appender and querier fixtures used by the tests of the ingestion and query APIs
+package tsdbtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
+	"github.com/v3io/v3io-tsdb/pkg/querier"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+)
+
+// Sample is an appended sample, H is set for native histograms
+type Sample struct {
+	Lset utils.Labels
+	Ref  uint64
+	T    int64
+	V    float64
+	H    *chunkenc.Histogram
+}
+
+// Appender is a tsdb.Appender which records the appended samples, the ref of a series is its index + 1
+type Appender struct {
+	// fails the append of a sample when it returns an error (e.g. to test append failures), can be nil
+	Reject func(s Sample) error
+
+	mu      sync.Mutex
+	samples []Sample
+	series  []utils.Labels
+	refs    map[string]uint64
+}
+
+func (a *Appender) Add(lset utils.Labels, t int64, v float64) (uint64, error) {
+	return a.add(lset, 0, t, v, nil)
+}
+
+func (a *Appender) AddFast(lset utils.Labels, ref uint64, t int64, v float64) error {
+	if ref == 0 {
+		return fmt.Errorf("unknown ref 0")
+	}
+	_, err := a.add(lset, ref, t, v, nil)
+	return err
+}
+
+func (a *Appender) AddHistogram(lset utils.Labels, t int64, h *chunkenc.Histogram) (uint64, error) {
+	return a.add(lset, 0, t, 0, h)
+}
+
+func (a *Appender) AddHistogramFast(lset utils.Labels, ref uint64, t int64, h *chunkenc.Histogram) error {
+	if ref == 0 {
+		return fmt.Errorf("unknown ref 0")
+	}
+	_, err := a.add(lset, ref, t, 0, h)
+	return err
+}
+
+func (a *Appender) WaitForReady(ref uint64) error { return nil }
+func (a *Appender) Commit() error                 { return nil }
+func (a *Appender) Rollback() error               { return nil }
+
+// Samples returns the appended samples
+func (a *Appender) Samples() []Sample {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Sample{}, a.samples...)
+}
+
+// Reset drops the appended samples (the series refs are kept)
+func (a *Appender) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.samples = nil
+}
+
+// Take drops the appended samples and returns their values by series (labels string), the last value of a series
+func (a *Appender) Take() map[string]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	res := map[string]float64{}
+	for _, s := range a.samples {
+		res[s.Lset.String()] = s.V
+	}
+	a.samples = nil
+	return res
+}
+
+// append a sample, a zero ref is resolved (or assigned) from the labels
+func (a *Appender) add(lset utils.Labels, ref uint64, t int64, v float64, h *chunkenc.Histogram) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ref > uint64(len(a.series)) {
+		return 0, fmt.Errorf("unknown ref %d", ref)
+	}
+	if ref == 0 {
+		if a.refs == nil {
+			a.refs = map[string]uint64{}
+		}
+		if ref = a.refs[lset.String()]; ref == 0 {
+			a.series = append(a.series, lset)
+			ref = uint64(len(a.series))
+			a.refs[lset.String()] = ref
+		}
+	}
+	sample := Sample{Lset: a.series[ref-1], Ref: ref, T: t, V: v, H: h}
+	if a.Reject != nil {
+		if err := a.Reject(sample); err != nil {
+			return 0, err
+		}
+	}
+	a.samples = append(a.samples, sample)
+	return ref, nil
+}
+
+// Point is a sample of a Series
+type Point struct {
+	T int64
+	V float64
+}
+
+// Series is a querier.Series with fixed points
+type Series struct {
+	Lset   utils.Labels
+	Points []Point
+}
+
+func (s *Series) Labels() utils.Labels { return s.Lset }
+func (s *Series) Iterator() querier.SeriesIterator {
+	return &iterator{points: s.Points, index: -1}
+}
+
+type iterator struct {
+	points []Point
+	index  int
+}
+
+func (it *iterator) Seek(t int64) bool {
+	for it.index < 0 || it.points[it.index].T < t {
+		if !it.Next() {
+			return false
+		}
+	}
+	return true
+}
+func (it *iterator) Next() bool           { it.index++; return it.index < len(it.points) }
+func (it *iterator) At() (int64, float64) { return it.points[it.index].T, it.points[it.index].V }
+func (it *iterator) Err() error           { return nil }
+
+// SeriesSet is a querier.SeriesSet over a list of series
+type SeriesSet struct {
+	series []*Series
+	index  int
+}
+
+func NewSeriesSet(series ...*Series) *SeriesSet {
+	return &SeriesSet{series: series}
+}
+
+func (s *SeriesSet) Next() bool         { s.index++; return s.index <= len(s.series) }
+func (s *SeriesSet) At() querier.Series { return s.series[s.index-1] }
+func (s *SeriesSet) Err() error         { return nil }
+
+// Querier is a querier.Querier over fixed series, selects with aggregation functions return the Aggr series
+// (Raw when there are none) and the metadata is taken from the Raw series, the query range and the params of
+// the last select are recorded
+type Querier struct {
+	Raw, Aggr []*Series
+
+	mu         sync.Mutex
+	mint, maxt int64
+	params     *querier.SelectParams
+}
+
+// New returns the querier for a time range, it is used as the querier factory of the APIs
+func (q *Querier) New(ctx context.Context, mint, maxt int64) (querier.Querier, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.mint, q.maxt = mint, maxt
+	return q, nil
+}
+
+// Range returns the time range of the last querier
+func (q *Querier) Range() (int64, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.mint, q.maxt
+}
+
+// LastParams returns the params of the last select
+func (q *Querier) LastParams() *querier.SelectParams {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.params
+}
+
+func (q *Querier) SelectQry(params *querier.SelectParams) (querier.SeriesSet, error) {
+	q.mu.Lock()
+	q.params = params
+	q.mu.Unlock()
+
+	list := q.Raw
+	if params.Functions != "" && q.Aggr != nil {
+		list = q.Aggr
+	}
+	set := NewSeriesSet()
+	for _, s := range list {
+		if utils.MatchLabels(s.Lset, params.Matchers) {
+			set.series = append(set.series, s)
+		}
+	}
+	return set, nil
+}
+
+func (q *Querier) Series(matchers ...*utils.LabelMatcher) ([]utils.Labels, error) {
+	list := []utils.Labels{}
+	for _, s := range q.Raw {
+		if utils.MatchLabels(s.Lset, matchers) {
+			list = append(list, s.Lset)
+		}
+	}
+	return list, nil
+}
+
+func (q *Querier) LabelNames(matchers ...*utils.LabelMatcher) ([]string, error) {
+	names := map[string]bool{}
+	list, _ := q.Series(matchers...)
+	for _, lset := range list {
+		for _, lbl := range lset {
+			names[lbl.Name] = true
+		}
+	}
+	return sortedKeys(names), nil
+}
+
+func (q *Querier) LabelValues(name string, matchers ...*utils.LabelMatcher) ([]string, error) {
+	values := map[string]bool{}
+	list, _ := q.Series(matchers...)
+	for _, lset := range list {
+		if lset.Has(name) {
+			values[lset.Get(name)] = true
+		}
+	}
+	return sortedKeys(values), nil
+}
+
+func (q *Querier) Close() error { return nil }
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
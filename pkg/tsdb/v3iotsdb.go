@@ -29,6 +29,7 @@ import (
 	"github.com/v3io/v3io-go-http"
 	"github.com/v3io/v3io-tsdb/config"
 	"github.com/v3io/v3io-tsdb/pkg/appender"
+	"github.com/v3io/v3io-tsdb/pkg/chunkenc"
 	"github.com/v3io/v3io-tsdb/pkg/partmgr"
 	"github.com/v3io/v3io-tsdb/pkg/querier"
 	"github.com/v3io/v3io-tsdb/pkg/utils"
@@ -171,8 +172,13 @@ func (a *V3ioAdapter) Close() error {
 }
 
 // create a querier interface, used for time series queries
-func (a *V3ioAdapter) Querier(_ context.Context, mint, maxt int64) (*querier.V3ioQuerier, error) {
-	return querier.NewV3ioQuerier(a.container, a.logger, mint, maxt, a.cfg, a.partitionMngr), nil
+func (a *V3ioAdapter) Querier(ctx context.Context, mint, maxt int64) (*querier.V3ioQuerier, error) {
+	qry := querier.NewV3ioQuerier(ctx, a.container, a.logger, mint, maxt, a.cfg, a.partitionMngr)
+	if a.cfg.ReadYourWrites {
+		// merge the samples which were not written yet
+		qry.SetHead(a.MetricsCache)
+	}
+	return qry, nil
 }
 
 func (a *V3ioAdapter) DeleteDB(config bool, force bool) error {
@@ -195,6 +201,17 @@ func (a *V3ioAdapter) DeleteDB(config bool, force bool) error {
 	// delete the Directory object
 	a.container.Sync.DeleteObject(&v3io.DeleteObjectInput{Path: path})
 
+	if a.cfg.LabelIndex {
+		path = a.cfg.Path + utils.LabelIndexPath
+		a.logger.Info("Delete label index in path %s", path)
+		err = utils.DeleteTable(a.container, path, "", a.cfg.QryWorkers)
+		if err != nil && !force {
+			return err
+		}
+		// delete the Directory object
+		a.container.Sync.DeleteObject(&v3io.DeleteObjectInput{Path: path})
+	}
+
 	if config {
 		a.logger.Info("Delete TSDB config in path %s", a.cfg.Path+DB_CONFIG_PATH)
 		err = a.container.Sync.DeleteObject(&v3io.DeleteObjectInput{Path: a.cfg.Path + DB_CONFIG_PATH})
@@ -242,6 +259,17 @@ func (a v3ioAppender) AddFast(lset utils.Labels, ref uint64, t int64, v float64)
 	return a.metricsCache.AddFast(ref, t, v)
 }
 
+// Add a native histogram sample (all the buckets of a histogram) to metric and return refID
+// the write is async, the histogram must not be modified after the call
+func (a v3ioAppender) AddHistogram(lset utils.Labels, t int64, h *chunkenc.Histogram) (uint64, error) {
+	return a.metricsCache.Add(lset, t, h)
+}
+
+// faster AddHistogram using refID obtained from AddHistogram
+func (a v3ioAppender) AddHistogramFast(lset utils.Labels, ref uint64, t int64, h *chunkenc.Histogram) error {
+	return a.metricsCache.AddFast(ref, t, h)
+}
+
 // faster Add using refID obtained from Add (avoid some hash/lookup overhead)
 func (a v3ioAppender) WaitForReady(ref uint64) error {
 	return a.metricsCache.WaitForReady(ref)
@@ -255,6 +283,8 @@ func (a v3ioAppender) Rollback() error { return nil }
 type Appender interface {
 	Add(l utils.Labels, t int64, v float64) (uint64, error)
 	AddFast(l utils.Labels, ref uint64, t int64, v float64) error
+	AddHistogram(l utils.Labels, t int64, h *chunkenc.Histogram) (uint64, error)
+	AddHistogramFast(l utils.Labels, ref uint64, t int64, h *chunkenc.Histogram) error
 	WaitForReady(ref uint64) error
 	Commit() error
 	Rollback() error
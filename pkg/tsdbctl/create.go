@@ -54,7 +54,7 @@ func newCreateCommandeer(rootCommandeer *RootCommandeer) *createCommandeer {
 	cmd.Flags().IntVarP(&commandeer.daysPerObj, "days", "d", 1, "number of days covered per partition")
 	cmd.Flags().IntVarP(&commandeer.hrInChunk, "chunk-hours", "t", 1, "number of hours in a single chunk")
 	cmd.Flags().StringVarP(&commandeer.defaultRollups, "rollups", "r", "",
-		"Default aggregation rollups, comma seperated: count,avg,sum,min,max,stddev")
+		"Default aggregation rollups, comma seperated: count,avg,sum,min,max,stddev,first,last,rate,p50,p95,p99")
 	cmd.Flags().IntVarP(&commandeer.rollupMin, "rollup-interval", "i", 60, "aggregation interval in minutes")
 
 	commandeer.cmd = cmd
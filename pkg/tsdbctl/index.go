@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package tsdbctl
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type indexCommandeer struct {
+	cmd            *cobra.Command
+	rootCommandeer *RootCommandeer
+	rebuild        bool
+	list           bool
+}
+
+func newIndexCommandeer(rootCommandeer *RootCommandeer) *indexCommandeer {
+	commandeer := &indexCommandeer{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "check (or rebuild) the label index",
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			// initialize params
+			return commandeer.index()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&commandeer.rebuild, "rebuild", "r", false, "delete and rebuild the label index")
+	cmd.Flags().BoolVarP(&commandeer.list, "list", "l", false, "list the missing and stale index entries")
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (ic *indexCommandeer) index() error {
+
+	if err := ic.rootCommandeer.initialize(); err != nil {
+		return err
+	}
+
+	if err := ic.rootCommandeer.startAdapter(); err != nil {
+		return err
+	}
+
+	adapter := ic.rootCommandeer.adapter
+	if ic.rebuild {
+		if err := adapter.RebuildLabelIndex(); err != nil {
+			return errors.Wrap(err, "Failed to rebuild the label index")
+		}
+	}
+
+	report, err := adapter.CheckLabelIndex()
+	if err != nil {
+		return errors.Wrap(err, "Failed to check the label index")
+	}
+
+	fmt.Printf("Series: %d, Label pairs: %d, Missing entries: %d, Stale entries: %d\n",
+		report.Series, report.Pairs, len(report.Missing), len(report.Stale))
+
+	if ic.list {
+		for _, entry := range report.Missing {
+			fmt.Println("missing:", entry)
+		}
+		for _, entry := range report.Stale {
+			fmt.Println("stale:", entry)
+		}
+	}
+
+	if !report.Consistent() {
+		return errors.New("the label index is not consistent, use --rebuild to rebuild it")
+	}
+	return nil
+}
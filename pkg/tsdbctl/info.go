@@ -25,6 +25,8 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
+	"time"
 )
 
 type infoCommandeer struct {
@@ -32,6 +34,11 @@ type infoCommandeer struct {
 	rootCommandeer *RootCommandeer
 	getNames       bool
 	getCount       bool
+	getLabels      bool
+	getSeries      bool
+	labelValues    string
+	selector       string
+	last           string
 }
 
 func newInfoCommandeer(rootCommandeer *RootCommandeer) *infoCommandeer {
@@ -51,6 +58,13 @@ func newInfoCommandeer(rootCommandeer *RootCommandeer) *infoCommandeer {
 
 	cmd.Flags().BoolVarP(&commandeer.getNames, "names", "n", false, "return metric names")
 	cmd.Flags().BoolVarP(&commandeer.getCount, "metrics", "m", false, "count number metric objects")
+	cmd.Flags().BoolVar(&commandeer.getLabels, "labels", false, "return the label names")
+	cmd.Flags().StringVar(&commandeer.labelValues, "label-values", "", "return the values of a label")
+	cmd.Flags().BoolVar(&commandeer.getSeries, "series", false, "return the series label sets")
+	cmd.Flags().StringVar(&commandeer.selector, "selector", "",
+		"limit the labels, values and series to a series selector e.g. cpu{host=~\"a.*\"}")
+	cmd.Flags().StringVarP(&commandeer.last, "last", "l", "",
+		"limit the labels, values and series to series with samples in the last min/hours/days e.g. 15m")
 
 	commandeer.cmd = cmd
 
@@ -76,15 +90,51 @@ func (ic *infoCommandeer) info() error {
 	fmt.Println("TSDB Configuration:")
 	fmt.Println(string(info))
 
-	if ic.getNames {
-		// create a querier
-		qry, err := ic.rootCommandeer.adapter.Querier(nil, 0, 0)
+	if ic.getNames || ic.getLabels || ic.getSeries || ic.labelValues != "" {
+		if err := ic.labelsInfo(); err != nil {
+			return err
+		}
+	}
+
+	if ic.getCount {
+		count, err := ic.rootCommandeer.adapter.CountMetrics("")
+		if err != nil {
+			return errors.Wrap(err, "Failed to count")
+		}
+
+		fmt.Println("Number of objects: ", count)
+	}
+
+	return nil
+}
+
+// print the metric names, label names, label values and series (optionally of a selector and time range)
+func (ic *infoCommandeer) labelsInfo() error {
+	var mint int64
+	maxt := time.Now().Unix() * 1000
+	if ic.last != "" {
+		last, err := utils.Str2duration(ic.last)
 		if err != nil {
-			return errors.Wrap(err, "Failed to create querier")
+			return err
 		}
+		mint = maxt - last
+	}
 
+	matchers, err := utils.ParseSelector(ic.selector)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the selector")
+	}
+
+	// create a querier
+	qry, err := ic.rootCommandeer.adapter.Querier(nil, mint, maxt)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create querier")
+	}
+	defer qry.Close()
+
+	if ic.getNames {
 		// get all metric names
-		names, err := qry.LabelValues("")
+		names, err := qry.LabelValues(utils.MetricName, matchers...)
 		if err != nil {
 			return errors.Wrap(err, "Failed to get labels")
 		}
@@ -95,13 +145,40 @@ func (ic *infoCommandeer) info() error {
 		}
 	}
 
-	if ic.getCount {
-		count, err := ic.rootCommandeer.adapter.CountMetrics("")
+	if ic.getLabels {
+		names, err := qry.LabelNames(matchers...)
 		if err != nil {
-			return errors.Wrap(err, "Failed to count")
+			return errors.Wrap(err, "Failed to get label names")
 		}
 
-		fmt.Println("Number of objects: ", count)
+		fmt.Println("Label Names:")
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+
+	if ic.labelValues != "" {
+		values, err := qry.LabelValues(ic.labelValues, matchers...)
+		if err != nil {
+			return errors.Wrap(err, "Failed to get label values")
+		}
+
+		fmt.Printf("Values of %s:\n", ic.labelValues)
+		for _, value := range values {
+			fmt.Println(value)
+		}
+	}
+
+	if ic.getSeries {
+		list, err := qry.Series(matchers...)
+		if err != nil {
+			return errors.Wrap(err, "Failed to get series")
+		}
+
+		fmt.Println("Series:")
+		for _, lset := range list {
+			fmt.Println(lset.String())
+		}
 	}
 
 	return nil
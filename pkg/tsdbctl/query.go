@@ -43,6 +43,13 @@ type queryCommandeer struct {
 	windows        string
 	functions      string
 	step           string
+	groupBy        string
+	without        string
+	groupAggr      string
+	fill           string
+	topK           int
+	bottomK        int
+	rankBy         string
 	output         string
 }
 
@@ -52,7 +59,7 @@ func newQueryCommandeer(rootCommandeer *RootCommandeer) *queryCommandeer {
 	}
 
 	cmd := &cobra.Command{
-		Use:     "query name [flags]",
+		Use:     "query [name|selector] [flags]",
 		Aliases: []string{"get"},
 		Short:   "query time series metrics",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -74,8 +81,24 @@ func newQueryCommandeer(rootCommandeer *RootCommandeer) *queryCommandeer {
 	cmd.Flags().StringVarP(&commandeer.last, "last", "l", "", "last min/hours/days e.g. 15m")
 	cmd.Flags().StringVarP(&commandeer.windows, "windows", "w", "", "comma separated list of overlapping windows")
 	cmd.Flags().StringVarP(&commandeer.functions, "aggregators", "a", "",
-		"comma separated list of aggregation functions, e.g. count,avg,sum,min,max,stddev,stdvar,last,rate")
-	cmd.Flags().StringVarP(&commandeer.step, "step", "i", "", "interval step for aggregation functions")
+		"comma separated list of aggregation functions, e.g. count,avg,sum,min,max,stddev,stdvar,first,last,"+
+			"rate,irate,increase,delta,p50,p75,p90,p95,p99,p999 and window functions moving_avg|sum|min|max|count(N), "+
+			"ewma(alpha), avg|sum|min|max|count|last_over_time(range)")
+	cmd.Flags().StringVarP(&commandeer.step, "step", "i", "", "interval step for aggregation functions, "+
+		"or a calendar step nn[d|w|M|y][@timezone] e.g. 1d@Europe/Berlin, 1M")
+	cmd.Flags().StringVarP(&commandeer.groupBy, "groupby", "g", "",
+		"comma separated list of labels to aggregate by (across series), e.g. service,host")
+	cmd.Flags().StringVar(&commandeer.without, "without", "",
+		"comma separated list of labels to aggregate without (group by all the other labels)")
+	cmd.Flags().StringVar(&commandeer.groupAggr, "group-aggr", "",
+		"aggregate the function values of the group series (sum,avg,min,max,count), required for functions "+
+			"which cannot be merged across series, e.g. count, last or rate")
+	cmd.Flags().IntVar(&commandeer.topK, "topk", 0, "return only the k highest ranked series (see --rank-by)")
+	cmd.Flags().IntVar(&commandeer.bottomK, "bottomk", 0, "return only the k lowest ranked series (see --rank-by)")
+	cmd.Flags().StringVar(&commandeer.rankBy, "rank-by", "avg",
+		"aggregate over the query range used to rank the series in topk/bottomk: max,min,avg,last,sum,count")
+	cmd.Flags().StringVar(&commandeer.fill, "fill", "",
+		"fill policy for empty steps: none,null,zero,previous,linear or a number (raw series are resampled to the step)")
 
 	commandeer.cmd = cmd
 
@@ -96,7 +119,14 @@ func (qc *queryCommandeer) query() error {
 		return err
 	}
 
-	step, err := utils.Str2duration(qc.step)
+	var step int64
+	var calendar *utils.CalendarStep
+	var err error
+	if utils.IsCalendarStep(qc.step) {
+		calendar, err = utils.ParseCalendarStep(qc.step)
+	} else {
+		step, err = utils.Str2duration(qc.step)
+	}
 	if err != nil {
 		return err
 	}
@@ -134,24 +164,45 @@ func (qc *queryCommandeer) query() error {
 		return errors.Wrap(err, "Failed to initialize Querier")
 	}
 
-	var set querier.SeriesSet
-	if qc.windows == "" {
-		set, err = qry.Select(qc.name, qc.functions, step, qc.filter)
-	} else {
+	if qc.groupBy != "" && qc.without != "" {
+		return errors.New("groupby and without cannot be used together")
+	}
+
+	fill, err := querier.ParseFill(qc.fill)
+	if err != nil {
+		return err
+	}
+	params := &querier.SelectParams{Name: qc.name, Functions: qc.functions, Step: step, Filter: qc.filter, Fill: fill,
+		Calendar: calendar, TopK: qc.topK, BottomK: qc.bottomK, RankBy: qc.rankBy, GroupAggr: qc.groupAggr}
+
+	if strings.Contains(qc.name, "{") {
+		// Prometheus style selector e.g. cpu{os=~"win|linux",node!="xyz"}
+		if qc.filter != "" || qc.windows != "" || qc.groupBy != "" || qc.without != "" {
+			return errors.New("a selector cannot be combined with filter, windows or group by")
+		}
+		matchers, perr := utils.ParseSelector(qc.name)
+		if perr != nil {
+			return errors.Wrap(perr, "failed to parse the selector")
+		}
+		params.Name = ""
+		params.Matchers = matchers
+	} else if qc.groupBy != "" {
+		params.GroupBy = strings.Split(qc.groupBy, ",")
+	} else if qc.without != "" {
+		params.GroupBy = strings.Split(qc.without, ",")
+		params.Without = true
+	} else if qc.windows != "" {
 		list := strings.Split(qc.windows, ",")
-		win := []int{}
 		for _, val := range list {
 			i, err := strconv.Atoi(val)
 			if err != nil {
 				return errors.Wrap(err, "not a valid window")
 			}
-			win = append(win, i)
-
+			params.Windows = append(params.Windows, i)
 		}
-
-		set, err = qry.SelectOverlap(qc.name, qc.functions, step, win, qc.filter)
 	}
 
+	set, err := qry.SelectQry(params)
 	if err != nil {
 		return errors.Wrap(err, "Select Failed")
 	}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package tsdbctl
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/v3io/v3io-tsdb/pkg/scrape"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type scrapeCommandeer struct {
+	cmd            *cobra.Command
+	rootCommandeer *RootCommandeer
+	scrapeConfig   string
+}
+
+func newScrapeCommandeer(rootCommandeer *RootCommandeer) *scrapeCommandeer {
+	commandeer := &scrapeCommandeer{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "scrape",
+		Short: "scrape Prometheus/OpenMetrics exposition targets into the TSDB (agent mode, without Prometheus)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			// initialize params
+			return commandeer.scrape()
+		},
+	}
+
+	cmd.Flags().StringVarP(&commandeer.scrapeConfig, "scrape-config", "f", "scrape.yml", "path to the scrape targets yaml file (Prometheus scrape_configs format)")
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (sc *scrapeCommandeer) scrape() error {
+
+	cfg, err := scrape.LoadConfig(sc.scrapeConfig)
+	if err != nil {
+		return err
+	}
+
+	if err := sc.rootCommandeer.initialize(); err != nil {
+		return err
+	}
+
+	if err := sc.rootCommandeer.startAdapter(); err != nil {
+		return err
+	}
+
+	adapter := sc.rootCommandeer.adapter
+	appender, err := adapter.Appender()
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the appender")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+
+	scrape.NewScraper(adapter.GetLogger("scrape"), appender, cfg).Run(ctx)
+	return nil
+}
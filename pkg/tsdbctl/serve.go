@@ -0,0 +1,208 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package tsdbctl
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/v3io/v3io-tsdb/pkg/graphite"
+	"github.com/v3io/v3io-tsdb/pkg/grpcapi"
+	"github.com/v3io/v3io-tsdb/pkg/influx"
+	"github.com/v3io/v3io-tsdb/pkg/opentsdb"
+	"github.com/v3io/v3io-tsdb/pkg/otlp"
+	"github.com/v3io/v3io-tsdb/pkg/promapi"
+	"github.com/v3io/v3io-tsdb/pkg/remote"
+	"github.com/v3io/v3io-tsdb/pkg/simplejson"
+	"github.com/v3io/v3io-tsdb/pkg/statsd"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type serveCommandeer struct {
+	cmd            *cobra.Command
+	rootCommandeer *RootCommandeer
+	listen         string
+	graphite       string
+	statsd         string
+	grpc           string
+}
+
+func newServeCommandeer(rootCommandeer *RootCommandeer) *serveCommandeer {
+	commandeer := &serveCommandeer{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "run an HTTP server for ingesting and querying the TSDB (Prometheus remote write/read and query API, InfluxDB write, OpenTSDB put/query, OTLP metrics, Grafana SimpleJSON datasource, gRPC API, Graphite and StatsD listeners)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			// initialize params
+			return commandeer.serve()
+		},
+	}
+
+	cmd.Flags().StringVarP(&commandeer.listen, "listen", "l", ":9201", "address to listen on")
+	cmd.Flags().StringVar(&commandeer.graphite, "graphite", "", "address of the Graphite plaintext (TCP) listener, e.g. :2003 (disabled by default)")
+	cmd.Flags().StringVar(&commandeer.statsd, "statsd", "", "address of the StatsD (UDP) listener, e.g. :8125 (disabled by default)")
+	cmd.Flags().StringVar(&commandeer.grpc, "grpc", "", "address of the gRPC API listener, e.g. :9202 (disabled by default)")
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (sc *serveCommandeer) serve() error {
+
+	if err := sc.rootCommandeer.initialize(); err != nil {
+		return err
+	}
+
+	if err := sc.rootCommandeer.startAdapter(); err != nil {
+		return err
+	}
+
+	mux, err := sc.handlers()
+	if err != nil {
+		return err
+	}
+
+	listeners, err := sc.listeners()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	server := &http.Server{Addr: sc.listen, Handler: mux}
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	sc.rootCommandeer.logger.InfoWith("Serving", "listen", sc.listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return errors.Wrap(err, "HTTP server failed")
+	}
+	return <-done
+}
+
+// register the HTTP endpoints
+func (sc *serveCommandeer) handlers() (*http.ServeMux, error) {
+	adapter := sc.rootCommandeer.adapter
+	logger := adapter.GetLogger("serve")
+
+	appender, err := adapter.Appender()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create the appender")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/write", remote.NewWriteHandler(logger, appender, adapter.MetricsCache.QueueFull))
+	mux.Handle("/api/v1/read", remote.NewReadHandler(logger, adapter.Querier))
+	mux.Handle("/api/v1/", promapi.NewHandler(logger, adapter.Querier))
+
+	influxWrite := influx.NewWriteHandler(logger, appender, adapter.MetricsCache.QueueFull, sc.rootCommandeer.v3iocfg.Influx)
+	mux.Handle("/write", influxWrite)
+	mux.Handle("/api/v2/write", influxWrite)
+
+	openTSDB := opentsdb.NewHandler(logger, appender, adapter.MetricsCache.QueueFull, adapter.Querier)
+	mux.Handle("/api/put", openTSDB)
+	mux.Handle("/api/query", openTSDB)
+
+	mux.Handle("/v1/metrics", otlp.NewWriteHandler(logger, appender, adapter.MetricsCache.QueueFull, sc.rootCommandeer.v3iocfg.Otlp))
+
+	// Grafana SimpleJSON datasource, the datasource URL is http://<host>:<port>/simplejson
+	mux.Handle("/simplejson/", http.StripPrefix("/simplejson", simplejson.NewHandler(logger, adapter.Querier)))
+	return mux, nil
+}
+
+// start the Graphite, StatsD and gRPC listeners which are enabled
+func (sc *serveCommandeer) listeners() ([]io.Closer, error) {
+	adapter := sc.rootCommandeer.adapter
+	cfg := sc.rootCommandeer.v3iocfg
+	var listeners []io.Closer
+
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	if sc.graphite != "" {
+		appender, err := adapter.Appender()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create the appender")
+		}
+		server, err := graphite.NewServer(adapter.GetLogger("graphite"), appender, cfg.Graphite)
+		if err != nil {
+			return nil, err
+		}
+		if err := server.Start(sc.graphite); err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, server)
+		sc.rootCommandeer.logger.InfoWith("Graphite listener started", "listen", server.Addr().String())
+	}
+
+	if sc.statsd != "" {
+		appender, err := adapter.Appender()
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrap(err, "Failed to create the appender")
+		}
+		server := statsd.NewServer(adapter.GetLogger("statsd"), appender, cfg.Statsd)
+		if err := server.Start(sc.statsd); err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, server)
+		sc.rootCommandeer.logger.InfoWith("StatsD listener started", "listen", server.Addr().String())
+	}
+
+	if sc.grpc != "" {
+		server, err := grpcapi.NewServer(adapter.GetLogger("grpc"), adapter)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if err := server.Start(sc.grpc); err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, server)
+		sc.rootCommandeer.logger.InfoWith("gRPC listener started", "listen", server.Addr().String())
+	}
+
+	return listeners, nil
+}
@@ -69,6 +69,9 @@ func NewRootCommandeer() *RootCommandeer {
 		newInfoCommandeer(commandeer).cmd,
 		newDeleteCommandeer(commandeer).cmd,
 		newCheckCommandeer(commandeer).cmd,
+		newIndexCommandeer(commandeer).cmd,
+		newServeCommandeer(commandeer).cmd,
+		newScrapeCommandeer(commandeer).cmd,
 	)
 
 	commandeer.cmd = cmd
@@ -21,6 +21,7 @@ such restriction.
 package utils
 
 import (
+	"context"
 	"github.com/nuclio/logger"
 	"github.com/pkg/errors"
 	"github.com/v3io/v3io-go-http"
@@ -41,6 +42,7 @@ type AsyncItemsCursor struct {
 	input        *v3io.GetItemsInput
 	container    *v3io.Container
 	logger       logger.Logger
+	ctx          context.Context
 
 	responseChan  chan *v3io.Response
 	workers       int
@@ -50,6 +52,16 @@ type AsyncItemsCursor struct {
 }
 
 func NewAsyncItemsCursor(container *v3io.Container, input *v3io.GetItemsInput, workers int) (*AsyncItemsCursor, error) {
+	return NewAsyncItemsCursorWithContext(context.Background(), container, input, workers)
+}
+
+// create an items cursor which stops requesting items (and returns the context error) when the context is done
+func NewAsyncItemsCursorWithContext(
+	ctx context.Context, container *v3io.Container, input *v3io.GetItemsInput, workers int) (*AsyncItemsCursor, error) {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	// TODO: use workers from Context.numWorkers (if no ShardingKey)
 	if workers == 0 || input.ShardingKey != "" {
@@ -61,6 +73,7 @@ func NewAsyncItemsCursor(container *v3io.Container, input *v3io.GetItemsInput, w
 		input:        input,
 		responseChan: make(chan *v3io.Response, 1000),
 		workers:      workers,
+		ctx:          ctx,
 	}
 
 	if input.ShardingKey != "" {
@@ -136,8 +149,13 @@ func (ic *AsyncItemsCursor) NextItem() (v3io.Item, error) {
 		return nil, nil
 	}
 
-	// Read response from channel
-	resp := <-ic.responseChan
+	// Read response from channel, stop if the query was canceled (outstanding responses are dropped)
+	var resp *v3io.Response
+	select {
+	case resp = <-ic.responseChan:
+	case <-ic.ctx.Done():
+		return nil, errors.Wrap(ic.ctx.Err(), "Items cursor canceled")
+	}
 	if resp.Error != nil {
 		return nil, errors.Wrap(resp.Error, "Failed to get next items")
 	}
@@ -153,6 +171,9 @@ func (ic *AsyncItemsCursor) NextItem() (v3io.Item, error) {
 
 	if !getItemsResp.Last {
 		// if not last, make a new request to that shard
+		if err := ic.ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "Items cursor canceled")
+		}
 
 		input := v3io.GetItemsInput{
 			Path: ic.input.Path, AttributeNames: ic.input.AttributeNames, Filter: ic.input.Filter,
@@ -207,3 +228,44 @@ func (ic *AsyncItemsCursor) GetFields() map[string]interface{} {
 func (ic *AsyncItemsCursor) GetItem() v3io.Item {
 	return ic.currentItem
 }
+
+// iterate over multiple items cursors one after the other
+type MultiItemsCursor struct {
+	cursors []ItemsCursor
+	index   int
+}
+
+func NewMultiItemsCursor(cursors ...ItemsCursor) ItemsCursor {
+	if len(cursors) == 1 {
+		return cursors[0]
+	}
+	return &MultiItemsCursor{cursors: cursors}
+}
+
+func (mc *MultiItemsCursor) Err() error {
+	if mc.index >= len(mc.cursors) {
+		return nil
+	}
+	return mc.cursors[mc.index].Err()
+}
+
+func (mc *MultiItemsCursor) Next() bool {
+	for mc.index < len(mc.cursors) {
+		if mc.cursors[mc.index].Next() {
+			return true
+		}
+		if mc.cursors[mc.index].Err() != nil {
+			return false
+		}
+		mc.index++
+	}
+	return false
+}
+
+func (mc *MultiItemsCursor) GetField(name string) interface{} {
+	return mc.cursors[mc.index].GetField(name)
+}
+
+func (mc *MultiItemsCursor) GetFields() map[string]interface{} {
+	return mc.cursors[mc.index].GetFields()
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarStep is an aggregation step aligned to the calendar in a timezone (local midnight, Monday, the first day of
+// the month or year), steps have a variable length (DST changes, month lengths)
+type CalendarStep struct {
+	Count    int
+	Unit     byte // d (day), w (week), M (month) or y (year)
+	Location *time.Location
+}
+
+// return true if the step string is a calendar step, i.e. months (1M) or a step with a timezone (1d@Europe/Berlin)
+func IsCalendarStep(step string) bool {
+	return strings.Contains(step, "@") || strings.HasSuffix(step, "M")
+}
+
+// parse a calendar step nn[d|w|M|y][@timezone], the default timezone is UTC
+func ParseCalendarStep(step string) (*CalendarStep, error) {
+	calendar := CalendarStep{Count: 1, Location: time.UTC}
+
+	if i := strings.Index(step, "@"); i >= 0 {
+		loc, err := time.LoadLocation(step[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid step timezone %s, %v", step[i+1:], err)
+		}
+		calendar.Location = loc
+		step = step[:i]
+	}
+
+	if len(step) == 0 || !strings.ContainsAny(step[len(step)-1:], "dwMy") {
+		return nil, fmt.Errorf("invalid calendar step %s, use nn[d|w|M|y][@timezone]", step)
+	}
+	calendar.Unit = step[len(step)-1]
+
+	if len(step) > 1 {
+		count, err := strconv.Atoi(step[:len(step)-1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid calendar step %s, use nn[d|w|M|y][@timezone]", step)
+		}
+		calendar.Count = count
+	}
+
+	return &calendar, nil
+}
+
+// nominal step length in milliseconds (the shortest step), used to verify the step against the rollup interval
+func (c *CalendarStep) Duration() int64 {
+	day := int64(23 * 3600 * 1000) // DST day
+	switch c.Unit {
+	case 'w':
+		return int64(c.Count) * 7 * day
+	case 'M':
+		return int64(c.Count) * 28 * day
+	case 'y':
+		return int64(c.Count) * 365 * day
+	}
+	return int64(c.Count) * day
+}
+
+// return the step boundaries (unix milliseconds) covering [mint, maxt], the first boundary is the start of the step
+// containing mint and the last is the end of the step containing maxt, step i is [bounds[i], bounds[i+1])
+func (c *CalendarStep) Boundaries(mint, maxt int64) []int64 {
+	start := c.truncate(time.Unix(mint/1000, (mint%1000)*int64(time.Millisecond)).In(c.Location))
+	bounds := []int64{}
+
+	for i := 0; ; i++ {
+		var t time.Time
+		switch c.Unit {
+		case 'd':
+			t = start.AddDate(0, 0, i*c.Count)
+		case 'w':
+			t = start.AddDate(0, 0, 7*i*c.Count)
+		case 'M':
+			t = start.AddDate(0, i*c.Count, 0)
+		case 'y':
+			t = start.AddDate(i*c.Count, 0, 0)
+		}
+
+		msec := t.UnixNano() / int64(time.Millisecond)
+		bounds = append(bounds, msec)
+		if msec > maxt {
+			return bounds
+		}
+	}
+}
+
+// return the start of the step containing t, months and years are aligned to multiples of the step count
+// (e.g. 3M steps start in January, April, July and October)
+func (c *CalendarStep) truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch c.Unit {
+	case 'w':
+		// weeks start on Monday
+		day -= (int(t.Weekday()) + 6) % 7
+	case 'M':
+		month -= time.Month((int(month) - 1) % c.Count)
+		day = 1
+	case 'y':
+		year -= year % c.Count
+		month, day = time.January, 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, c.Location)
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarStep(t *testing.T) {
+	step, err := ParseCalendarStep("1d@Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// DST starts on 2018-03-25 in Berlin, the day is 23 hours long
+	mint := time.Date(2018, 3, 24, 12, 0, 0, 0, time.UTC).Unix() * 1000
+	maxt := time.Date(2018, 3, 25, 12, 0, 0, 0, time.UTC).Unix() * 1000
+	bounds := step.Boundaries(mint, maxt)
+	if len(bounds) != 3 {
+		t.Fatalf("wrong number of boundaries %v", bounds)
+	}
+	if bounds[0] != time.Date(2018, 3, 23, 23, 0, 0, 0, time.UTC).Unix()*1000 {
+		t.Fatalf("first boundary is not the local midnight: %d", bounds[0])
+	}
+	if bounds[2]-bounds[1] != 23*3600*1000 {
+		t.Fatalf("wrong DST day length %d", bounds[2]-bounds[1])
+	}
+
+	// quarters in UTC
+	step, err = ParseCalendarStep("3M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mint = time.Date(2018, 2, 10, 0, 0, 0, 0, time.UTC).Unix() * 1000
+	maxt = time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC).Unix() * 1000
+	bounds = step.Boundaries(mint, maxt)
+	expected := []time.Month{time.January, time.April, time.July}
+	if len(bounds) != len(expected) {
+		t.Fatalf("wrong number of boundaries %v", bounds)
+	}
+	for i, month := range expected {
+		if bounds[i] != time.Date(2018, month, 1, 0, 0, 0, 0, time.UTC).Unix()*1000 {
+			t.Fatalf("wrong month boundary %d: %d", i, bounds[i])
+		}
+	}
+
+	if !IsCalendarStep("1w@UTC") || IsCalendarStep("1h") {
+		t.Fatal("wrong calendar step detection")
+	}
+	for _, bad := range []string{"1h@UTC", "xM", "1d@Mars/Base"} {
+		if _, err := ParseCalendarStep(bad); err == nil {
+			t.Fatalf("expected an error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// ReadBody reads a request body of up to maxSize bytes (after decompression when Content-Encoding is gzip),
+// returns the http status to reply with on error
+func ReadBody(r *http.Request, maxSize int) ([]byte, int, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid gzip body: %v", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to read the request body: %v", err)
+	}
+	if len(buf) > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", maxSize)
+	}
+	return buf, 0, nil
+}
+
+// QueueFull reports if a write request should be rejected with 429 (Too Many Requests) because the appender
+// queue is full (full may be nil), Retry-After is set so the sender backs off and resends the request
+func QueueFull(w http.ResponseWriter, full func() bool) bool {
+	if full == nil || !full() {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	return true
+}
+
+// WriteStatus is the http status of a write request made of several items (lines, series, data points), invalid
+// items are not retried by the sender (400) while append failures are (500) and take precedence
+type WriteStatus int
+
+// Fail records a failed item, invalid is true if the item itself is invalid (vs. a failed append)
+func (s *WriteStatus) Fail(invalid bool) {
+	if !invalid {
+		*s = http.StatusInternalServerError
+	} else if *s != http.StatusInternalServerError {
+		*s = http.StatusBadRequest
+	}
+}
+
+// Code returns the status of the request, ok if no item failed
+func (s WriteStatus) Code(ok int) int {
+	if s == 0 {
+		return ok
+	}
+	return int(s)
+}
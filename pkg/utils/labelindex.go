@@ -0,0 +1,159 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/v3io/v3io-go-http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// the label index table (under the TSDB path) maps label=value pairs to the series which have them, one item per
+// label pair with an attribute per series (_s<series hash>) which holds the metric name
+const LabelIndexPath = "/index/"
+
+// return the index item name of a label pair
+func LabelIndexKey(name, value string) string {
+	return name + "=" + url.PathEscape(value)
+}
+
+// return the index attribute of a series (by the label set hash)
+func LabelIndexAttr(hash uint64) string {
+	return fmt.Sprintf("_s%016x", hash)
+}
+
+// return the series item name (name.hash) of an index attribute, false if it is not a series attribute
+func IndexAttrToItem(attr string, name interface{}) (string, bool) {
+	metric, ok := name.(string)
+	if !ok || !strings.HasPrefix(attr, "_s") || len(attr) != 18 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(attr[2:], 16, 64); err != nil {
+		return "", false
+	}
+	return metric + "." + attr[2:], true
+}
+
+// return the labels of a series key (the _lset attribute, k1=v1,k2=v2,..)
+func KeyLabels(key string) Labels {
+	lset := Labels{}
+	for _, label := range strings.Split(key, ",") {
+		kv := strings.Split(label, "=")
+		if len(kv) > 1 {
+			lset = append(lset, Label{Name: kv[0], Value: kv[1]})
+		}
+	}
+	return lset
+}
+
+// items cursor over a list of items (by name), issues up to workers concurrent GetItem requests, the items are
+// returned in the response order and items which are not found are skipped
+type KeysItemsCursor struct {
+	ctx          context.Context
+	container    *v3io.Container
+	path         string
+	keys         []string
+	attrs        []string
+	responseChan chan *v3io.Response
+	next         int // next key to request
+	inFlight     int
+	currentItem  v3io.Item
+	currentError error
+}
+
+func NewKeysItemsCursor(ctx context.Context, container *v3io.Container, path string, keys, attrs []string,
+	workers int) (*KeysItemsCursor, error) {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	ic := &KeysItemsCursor{ctx: ctx, container: container, path: path, keys: keys, attrs: attrs,
+		responseChan: make(chan *v3io.Response, workers)}
+	for i := 0; i < workers && ic.next < len(keys); i++ {
+		if err := ic.request(); err != nil {
+			return nil, err
+		}
+	}
+	return ic, nil
+}
+
+func (ic *KeysItemsCursor) request() error {
+	input := v3io.GetItemInput{Path: ic.path + ic.keys[ic.next], AttributeNames: ic.attrs}
+	_, err := ic.container.GetItem(&input, ic.next, ic.responseChan)
+	if err != nil {
+		return errors.Wrap(err, "Failed to request item")
+	}
+	ic.next++
+	ic.inFlight++
+	return nil
+}
+
+func (ic *KeysItemsCursor) Next() bool {
+	for ic.inFlight > 0 {
+		var resp *v3io.Response
+		select {
+		case resp = <-ic.responseChan:
+		case <-ic.ctx.Done():
+			ic.currentError = errors.Wrap(ic.ctx.Err(), "Items cursor canceled")
+			return false
+		}
+		ic.inFlight--
+
+		if ic.next < len(ic.keys) {
+			if err := ic.request(); err != nil {
+				ic.currentError = err
+				return false
+			}
+		}
+
+		if resp.Error != nil {
+			resp.Release()
+			if IsNotFound(resp.Error) {
+				continue
+			}
+			ic.currentError = errors.Wrap(resp.Error, "Failed to get item")
+			return false
+		}
+
+		ic.currentItem = resp.Output.(*v3io.GetItemOutput).Item
+		resp.Release()
+		return true
+	}
+	return false
+}
+
+func (ic *KeysItemsCursor) Err() error                        { return ic.currentError }
+func (ic *KeysItemsCursor) GetField(name string) interface{}  { return ic.currentItem[name] }
+func (ic *KeysItemsCursor) GetFields() map[string]interface{} { return ic.currentItem }
+
+// return true if a v3io request failed because the item (or object) was not found
+func IsNotFound(err error) bool {
+	status, ok := errors.Cause(err).(interface{ StatusCode() int })
+	return ok && status.StatusCode() == 404
+}
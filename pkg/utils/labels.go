@@ -306,3 +306,19 @@ Outer:
 
 	return res
 }
+
+// Validate checks that a sorted label set has a metric name, valid label names and no duplicate names
+func (ls Labels) Validate() error {
+	if !IsValidLabelName(ls.Get(MetricName)) {
+		return fmt.Errorf("missing or invalid metric name in %s", ls)
+	}
+	for i, lbl := range ls {
+		if !IsValidLabelName(lbl.Name) {
+			return fmt.Errorf("invalid label name %q in %s", lbl.Name, ls)
+		}
+		if i > 0 && ls[i-1].Name == lbl.Name {
+			return fmt.Errorf("duplicate label %s in %s", lbl.Name, ls)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MatchType is the type of a label matcher (same as the Prometheus matchers)
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (m MatchType) String() string {
+	return [...]string{"=", "!=", "=~", "!~"}[m]
+}
+
+// LabelMatcher matches a label value, regular expressions are fully anchored (like Prometheus)
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewLabelMatcher(t MatchType, name, value string) (*LabelMatcher, error) {
+	m := LabelMatcher{Type: t, Name: name, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s for label %s: %v", value, name, err)
+		}
+		m.re = re
+	}
+	return &m, nil
+}
+
+func (m *LabelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// return true if the label value matches (a missing label has an empty value)
+func (m *LabelMatcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+// return true if the label set matches all the matchers
+func MatchLabels(lset Labels, matchers []*LabelMatcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// convert label matchers to metric names (sharding keys) and a v3io filter expression, matchers which cannot be
+// expressed as a v3io filter are returned in post and must be checked on the client side (against the label set).
+// equality and alternation (a|b|c) on the metric name are converted to names, an empty names list means all metrics
+func MatchersToFilter(matchers []*LabelMatcher) (names []string, filter string, post []*LabelMatcher) {
+	exprs := []string{}
+
+	for _, m := range matchers {
+		literals, isList := regexAlternation(m)
+
+		if m.Name == MetricName && names == nil && (m.Type == MatchEqual && m.Value != "" ||
+			m.Type == MatchRegexp && isList) {
+			if m.Type == MatchEqual {
+				names = []string{m.Value}
+			} else {
+				names = literals
+			}
+			continue
+		}
+
+		// values with quotes or backslashes can't be put in a filter string literal, match them on the client side
+		if strings.ContainsAny(m.Value, `'"\`) {
+			post = append(post, m)
+			continue
+		}
+
+		name := m.Name
+		if name == MetricName {
+			name = "_name"
+		}
+
+		switch {
+		case m.Type == MatchEqual && m.Value != "":
+			exprs = append(exprs, fmt.Sprintf("%s=='%s'", name, m.Value))
+		case m.Type == MatchNotEqual && m.Value != "":
+			exprs = append(exprs, fmt.Sprintf("%s!='%s'", name, m.Value))
+		case m.Type == MatchRegexp && m.Value == ".*", m.Type == MatchNotRegexp && m.Value == "":
+			// matches everything
+		case m.Type == MatchRegexp && isList:
+			list := []string{}
+			for _, lit := range literals {
+				list = append(list, fmt.Sprintf("%s=='%s'", name, lit))
+			}
+			exprs = append(exprs, "("+strings.Join(list, " or ")+")")
+		case m.Type == MatchNotRegexp && isList:
+			for _, lit := range literals {
+				exprs = append(exprs, fmt.Sprintf("%s!='%s'", name, lit))
+			}
+		case m.Type == MatchRegexp && m.Value == ".+":
+			exprs = append(exprs, fmt.Sprintf("exists(%s)", name))
+		case m.Type == MatchRegexp && isLiteralPrefix(m.Value):
+			exprs = append(exprs, fmt.Sprintf("starts(%s,'%s')", name, strings.TrimSuffix(m.Value, ".*")))
+		default:
+			post = append(post, m)
+		}
+	}
+
+	return names, strings.Join(exprs, " and "), post
+}
+
+// return the literal values of a regex made of non empty literal alternatives (e.g. get|post)
+func regexAlternation(m *LabelMatcher) ([]string, bool) {
+	if m.Type != MatchRegexp && m.Type != MatchNotRegexp {
+		return nil, false
+	}
+	list := strings.Split(m.Value, "|")
+	for _, lit := range list {
+		if lit == "" || regexp.QuoteMeta(lit) != lit {
+			return nil, false
+		}
+	}
+	return list, true
+}
+
+// is the regex a literal prefix followed by .* (e.g. us-east.*)
+func isLiteralPrefix(value string) bool {
+	prefix := strings.TrimSuffix(value, ".*")
+	return prefix != value && prefix != "" && regexp.QuoteMeta(prefix) == prefix
+}
+
+// parse a Prometheus style series selector e.g. http_req{method=~"get|post",code!="200"} to label matchers
+func ParseSelector(selector string) ([]*LabelMatcher, error) {
+	matchers := []*LabelMatcher{}
+	selector = strings.TrimSpace(selector)
+
+	name := selector
+	if idx := strings.Index(selector, "{"); idx >= 0 {
+		name = strings.TrimSpace(selector[:idx])
+		if !strings.HasSuffix(selector, "}") {
+			return nil, fmt.Errorf("selector %s is missing a closing }", selector)
+		}
+
+		list, err := parseMatcherList(selector[idx+1 : len(selector)-1])
+		if err != nil {
+			return nil, err
+		}
+		matchers = list
+	}
+
+	if name != "" {
+		if !IsValidLabelName(name) {
+			return nil, fmt.Errorf("invalid metric name %s", name)
+		}
+		matchers = append([]*LabelMatcher{{Type: MatchEqual, Name: MetricName, Value: name}}, matchers...)
+	}
+
+	return matchers, nil
+}
+
+// parse a comma separated list of label matchers, values are quoted with " or ' (\ escapes the next character)
+func parseMatcherList(str string) ([]*LabelMatcher, error) {
+	matchers := []*LabelMatcher{}
+
+	for {
+		str = strings.TrimLeft(str, " \t,")
+		if str == "" {
+			return matchers, nil
+		}
+
+		end := strings.IndexAny(str, "=!")
+		if end <= 0 {
+			return nil, fmt.Errorf("invalid label matcher %s", str)
+		}
+		name := strings.TrimSpace(str[:end])
+		if !IsValidLabelName(name) {
+			return nil, fmt.Errorf("invalid label name %s", name)
+		}
+		str = str[end:]
+
+		var t MatchType
+		switch {
+		case strings.HasPrefix(str, "=~"):
+			t = MatchRegexp
+		case strings.HasPrefix(str, "!~"):
+			t = MatchNotRegexp
+		case strings.HasPrefix(str, "!="):
+			t = MatchNotEqual
+		case strings.HasPrefix(str, "="):
+			t = MatchEqual
+		default:
+			return nil, fmt.Errorf("invalid match operator in %s", str)
+		}
+		str = strings.TrimSpace(str[len(t.String()):])
+
+		if str == "" || (str[0] != '"' && str[0] != '\'') {
+			return nil, fmt.Errorf("label %s value must be quoted", name)
+		}
+		quote := str[0]
+		value := []byte{}
+		i := 1
+		for ; i < len(str) && str[i] != quote; i++ {
+			if str[i] == '\\' && i+1 < len(str) {
+				i++
+			}
+			value = append(value, str[i])
+		}
+		if i == len(str) {
+			return nil, fmt.Errorf("label %s value is missing a closing quote", name)
+		}
+		str = str[i+1:]
+
+		m, err := NewLabelMatcher(t, name, string(value))
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+}
+
+// IsValidLabelName returns true for a valid Prometheus label (or metric) name
+func IsValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeLabelName replaces the characters which are not valid in label (and metric) names with _
+func SanitizeLabelName(name string) string {
+	if IsValidLabelName(name) {
+		return name
+	}
+	var sb strings.Builder
+	for i, c := range name {
+		if i == 0 && c >= '0' && c <= '9' {
+			sb.WriteByte('_')
+		}
+		if c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchersToFilter(t *testing.T) {
+	matchers, err := ParseSelector(`http_req{method=~"get|post", host=~"web.*", code!="200", path=~"/api/v[12]/.+"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, filter, post := MatchersToFilter(matchers)
+	if !reflect.DeepEqual(names, []string{"http_req"}) {
+		t.Fatalf("wrong names %v", names)
+	}
+	expFilter := "(method=='get' or method=='post') and starts(host,'web') and code!='200'"
+	if filter != expFilter {
+		t.Fatalf("wrong filter %s", filter)
+	}
+	if len(post) != 1 || post[0].Name != "path" {
+		t.Fatalf("wrong client side matchers %v", post)
+	}
+
+	lset := FromStrings("__name__", "http_req", "path", "/api/v2/users")
+	if !MatchLabels(lset, post) || MatchLabels(FromStrings("path", "/api/v3/users"), post) {
+		t.Fatal("wrong client side match")
+	}
+
+	// name alternation is converted to multiple sharding keys
+	matchers, err = ParseSelector(`{__name__=~"cpu|mem",os!~"win.*"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, filter, post = MatchersToFilter(matchers)
+	if !reflect.DeepEqual(names, []string{"cpu", "mem"}) || filter != "" || len(post) != 1 {
+		t.Fatalf("wrong name alternation conversion %v %s %v", names, filter, post)
+	}
+
+	// values with quotes are matched on the client side instead of breaking the filter string
+	quoted, _ := NewLabelMatcher(MatchEqual, "user", "o'neil")
+	prefix, _ := NewLabelMatcher(MatchRegexp, "path", `c:\\.*`)
+	names, filter, post = MatchersToFilter([]*LabelMatcher{quoted, prefix})
+	if names != nil || filter != "" || len(post) != 2 {
+		t.Fatalf("wrong quoted value conversion %v %s %v", names, filter, post)
+	}
+	if !MatchLabels(FromStrings("user", "o'neil", "path", `c:\data`), post) {
+		t.Fatal("wrong client side match of quoted values")
+	}
+
+	if _, err := ParseSelector(`cpu{os="win}`); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package utils
+
+import "math"
+
+// staleNaNBits is the Prometheus staleness marker, a NaN with a specific bit pattern appended when a series
+// disappears (it's not a sample, it ends the series until the next sample)
+const staleNaNBits = 0x7ff0000000000002
+
+// StaleNaN is the staleness marker value
+var StaleNaN = math.Float64frombits(staleNaNBits)
+
+// IsStaleNaN returns true if the value is a staleness marker (and not any other NaN)
+func IsStaleNaN(v float64) bool {
+	return math.Float64bits(v) == staleNaNBits
+}
@@ -33,9 +33,11 @@ func Str2duration(duration string) (int64, error) {
 	multiply := 3600 * 1000 // hour by default
 	if len(duration) > 0 {
 		last := duration[len(duration)-1:]
-		if last == "m" || last == "h" || last == "d" || last == "y" {
+		if last == "s" || last == "m" || last == "h" || last == "d" || last == "w" || last == "y" {
 			duration = duration[0 : len(duration)-1]
 			switch last {
+			case "s":
+				multiply = 1000
 			case "m":
 				multiply = 60 * 1000
 			case "h":
@@ -56,7 +58,7 @@ func Str2duration(duration string) (int64, error) {
 
 	i, err := strconv.Atoi(duration)
 	if err != nil {
-		return 0, errors.Wrap(err, "not a valid duration, use nn[s|h|m|d|y]")
+		return 0, errors.Wrap(err, "not a valid duration, use nn[s|m|h|d|w|y]")
 	}
 
 	return int64(i * multiply), nil
@@ -11,7 +11,7 @@ import (
 	"github.com/v3io/v3io-tsdb/pkg/appender"
 	"github.com/v3io/v3io-tsdb/pkg/querier"
 	"github.com/v3io/v3io-tsdb/pkg/tsdb"
-	"strings"
+	"github.com/v3io/v3io-tsdb/pkg/utils"
 )
 
 type V3ioPromAdapter struct {
@@ -53,13 +53,21 @@ type V3ioPromQuerier struct {
 	q *querier.V3ioQuerier
 }
 
-// Select returns a set of series that matches the given label matchers.
+// Select returns a set of series that matches the given label matchers, without params (series metadata
+// requests) only the series labels are returned
 func (q *V3ioPromQuerier) Select(params *storage.SelectParams, oms ...*labels.Matcher) (storage.SeriesSet, error) {
-	name, filter, functions := match2filter(oms)
+	matchers, functions, err := match2matchers(oms)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		list, err := q.q.Series(matchers...)
+		return &V3ioPromSeriesSet{s: querier.NewLabelsSeriesSet(list)}, err
+	}
 	if params.Func != "" {
 		functions = params.Func
 	}
-	set, err := q.q.Select(name, functions, params.Step, filter)
+	set, err := q.q.SelectMatchers(functions, params.Step, matchers...)
 	return &V3ioPromSeriesSet{s: set}, err
 }
 
@@ -68,32 +76,66 @@ func (q *V3ioPromQuerier) LabelValues(name string) ([]string, error) {
 	return q.q.LabelValues(name)
 }
 
+// LabelNames returns all the label names (sorted).
+func (q *V3ioPromQuerier) LabelNames() ([]string, error) {
+	return q.q.LabelNames()
+}
+
+// Series returns the label sets of the series that match the given label matchers.
+func (q *V3ioPromQuerier) Series(oms ...*labels.Matcher) ([]labels.Labels, error) {
+	matchers, _, err := match2matchers(oms)
+	if err != nil {
+		return nil, err
+	}
+	list, err := q.q.Series(matchers...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []labels.Labels{}
+	for _, lset := range list {
+		result = append(result, toPromLabels(lset))
+	}
+	return result, nil
+}
+
 // Close releases the resources of the Querier.
 func (q *V3ioPromQuerier) Close() error {
-	return nil
+	return q.q.Close()
 }
 
-func match2filter(oms []*labels.Matcher) (string, string, string) {
-	filter := []string{}
+// convert Prometheus label matchers to tsdb matchers, the Aggregator label holds the aggregation functions
+func match2matchers(oms []*labels.Matcher) ([]*utils.LabelMatcher, string, error) {
+	matchers := []*utils.LabelMatcher{}
 	aggregator := ""
-	name := ""
 
 	for _, matcher := range oms {
 		if matcher.Name == "Aggregator" {
 			aggregator = matcher.Value
-		} else if matcher.Name == "__name__" && matcher.Type == labels.MatchEqual {
-			name = matcher.Value
-		} else {
-			switch matcher.Type {
-			case labels.MatchEqual:
-				filter = append(filter, fmt.Sprintf("%s=='%s'", matcher.Name, matcher.Value))
-			case labels.MatchNotEqual:
-				filter = append(filter, fmt.Sprintf("%s!='%s'", matcher.Name, matcher.Value))
+			continue
+		}
+
+		var matchType utils.MatchType
+		switch matcher.Type {
+		case labels.MatchEqual:
+			matchType = utils.MatchEqual
+		case labels.MatchNotEqual:
+			matchType = utils.MatchNotEqual
+		case labels.MatchRegexp:
+			matchType = utils.MatchRegexp
+		case labels.MatchNotRegexp:
+			matchType = utils.MatchNotRegexp
+		default:
+			return nil, "", fmt.Errorf("unsupported matcher type %v", matcher.Type)
+		}
 
-			}
+		m, err := utils.NewLabelMatcher(matchType, matcher.Name, matcher.Value)
+		if err != nil {
+			return nil, "", err
 		}
+		matchers = append(matchers, m)
 	}
-	return name, strings.Join(filter, " and "), aggregator
+	return matchers, aggregator, nil
 }
 
 type V3ioPromSeriesSet struct {
@@ -114,8 +156,12 @@ type V3ioPromSeries struct {
 
 // Labels returns the complete set of labels identifying the series.
 func (s *V3ioPromSeries) Labels() labels.Labels {
+	return toPromLabels(s.s.Labels())
+}
+
+func toPromLabels(lset utils.Labels) labels.Labels {
 	lbls := labels.Labels{}
-	for _, l := range s.s.Labels() {
+	for _, l := range lset {
 		lbls = append(lbls, labels.Label{Name: l.Name, Value: l.Value})
 	}
 